@@ -1,6 +1,7 @@
 package ec2utils
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"sort"
@@ -9,9 +10,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 /* EC2 utils */
@@ -30,25 +29,10 @@ type kv struct {
 	Value int
 }
 
-// HandleError print the error and stop the program
-func HandleError(err error) {
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				log.Fatal(aerr.Error())
-			}
-		} else {
-			log.Fatal(err.Error())
-		}
-		return
-	}
-}
-
 /* Getters */
 
 // GetAvailableZones get zones matching specified parameters
-func GetAvailableZones(svc *ec2.EC2, zoneNames []*string, displayAll bool, filters []*ec2.Filter) []string {
+func GetAvailableZones(svc *ec2.EC2, zoneNames []*string, displayAll bool, filters []*ec2.Filter) ([]string, error) {
 	var res *ec2.DescribeAvailabilityZonesOutput
 	var err error
 	if displayAll {
@@ -62,18 +46,53 @@ func GetAvailableZones(svc *ec2.EC2, zoneNames []*string, displayAll bool, filte
 			Filters:              filters,
 		})
 	}
-	HandleError(err)
+	if err != nil {
+		return nil, err
+	}
 
 	zones := []string{}
 	for _, zone := range res.AvailabilityZones {
 		zones = append(zones, *zone.ZoneName)
 	}
 
-	return zones
+	return zones, nil
+}
+
+// InstanceToVMInfo convert an ec2 instance to vmInfo type
+func InstanceToVMInfo(instance *ec2.Instance) spdb.VMInfo {
+	info := spdb.VMInfo{
+		Type:   "aws",
+		ID:     *instance.InstanceId,
+		Status: *instance.State.Name,
+		Zone:   *instance.Placement.AvailabilityZone,
+		Name:   "N/A",
+		Ipv4:   "N/A",
+		DNS:    "N/A",
+	}
+
+	// fill in ipv4
+	if instance.PublicIpAddress != nil {
+		info.Ipv4 = *instance.PublicIpAddress
+	}
+
+	// fill in DNS
+	if instance.PublicDnsName != nil && len(*instance.PublicDnsName) > 0 {
+		info.DNS = *instance.PublicDnsName
+	}
+
+	// find name tag
+	tags := instance.Tags
+	for _, v := range tags {
+		if *v.Key == "Name" {
+			info.Name = *v.Value
+			break
+		}
+	}
+	return info
 }
 
 // GetAllInstancesInfo get all instance info
-func GetAllInstancesInfo(svc *ec2.EC2) []spdb.VMInfo {
+func GetAllInstancesInfo(svc *ec2.EC2) ([]spdb.VMInfo, error) {
 	continueToken := ""
 	vmInfoArr := []spdb.VMInfo{}
 
@@ -87,42 +106,15 @@ func GetAllInstancesInfo(svc *ec2.EC2) []spdb.VMInfo {
 		}
 
 		res, err := svc.DescribeInstances(input)
-		HandleError(err)
+		if err != nil {
+			return nil, err
+		}
 
 		// get all instance id
 		reservations := res.Reservations
 		for _, reservation := range reservations {
 			for _, instance := range reservation.Instances {
-				info := spdb.VMInfo{
-					Type:   "aws",
-					ID:     *instance.InstanceId,
-					Status: *instance.State.Name,
-					Zone:   *instance.Placement.AvailabilityZone,
-					Name:   "N/A",
-					Ipv4:   "N/A",
-					DNS:    "N/A",
-				}
-
-				// fill in ipv4
-				if instance.PublicIpAddress != nil {
-					info.Ipv4 = *instance.PublicIpAddress
-				}
-
-				// fill in DNS
-				if instance.PublicDnsName != nil {
-					info.DNS = *instance.PublicDnsName
-				}
-
-				// find name tag
-				tags := instance.Tags
-				for _, v := range tags {
-					if *v.Key == "Name" {
-						info.Name = *v.Value
-						break
-					}
-				}
-
-				vmInfoArr = append(vmInfoArr, info)
+				vmInfoArr = append(vmInfoArr, InstanceToVMInfo(instance))
 			}
 		}
 
@@ -132,11 +124,38 @@ func GetAllInstancesInfo(svc *ec2.EC2) []spdb.VMInfo {
 		continueToken = *res.NextToken
 	}
 
-	return vmInfoArr
+	return vmInfoArr, nil
+}
+
+// GetInstanceByName get the instance with the name tag, terminated instances are skipped.
+// returns nil if there is no such instance
+func GetInstanceByName(svc *ec2.EC2, name string) (*ec2.Instance, error) {
+	res, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name: aws.String("tag:Name"),
+				Values: []*string{
+					aws.String(name),
+				},
+			},
+		},
+		MaxResults: aws.Int64(1000),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, reservation := range res.Reservations {
+		for _, instance := range reservation.Instances {
+			if *instance.State.Name != ec2.InstanceStateNameTerminated {
+				return instance, nil
+			}
+		}
+	}
+	return nil, nil
 }
 
 // GetZoneCount Get count of zones in current user configered region
-func GetZoneCount(svc *ec2.EC2) map[string]int {
+func GetZoneCount(svc *ec2.EC2) (map[string]int, error) {
 	continueToken := ""
 	zoneCnt := make(map[string]int)
 
@@ -150,7 +169,9 @@ func GetZoneCount(svc *ec2.EC2) map[string]int {
 		}
 
 		res, err := svc.DescribeInstances(input)
-		HandleError(err)
+		if err != nil {
+			return nil, err
+		}
 
 		// get all instance id
 		reservations := res.Reservations
@@ -171,11 +192,11 @@ func GetZoneCount(svc *ec2.EC2) map[string]int {
 		continueToken = *res.NextToken
 	}
 
-	return zoneCnt
+	return zoneCnt, nil
 }
 
 // GetTagOfAllInstances get specified tag of all instances
-func GetTagOfAllInstances(svc *ec2.EC2, tag string) []ec2.TagDescription {
+func GetTagOfAllInstances(svc *ec2.EC2, tag string) ([]ec2.TagDescription, error) {
 	res := []ec2.TagDescription{}
 	continueToken := ""
 	for {
@@ -196,7 +217,9 @@ func GetTagOfAllInstances(svc *ec2.EC2, tag string) []ec2.TagDescription {
 		}
 
 		result, err := svc.DescribeTags(input)
-		HandleError(err)
+		if err != nil {
+			return nil, err
+		}
 
 		// append tags of instances to res array
 		for _, tag := range result.Tags {
@@ -210,13 +233,19 @@ func GetTagOfAllInstances(svc *ec2.EC2, tag string) []ec2.TagDescription {
 		continueToken = *result.NextToken
 	}
 
-	return res
+	return res, nil
 }
 
-// get next zone for newly created vm, make sure number of vms at each region is balanced
-func getNextAvailableZone(svc *ec2.EC2) string {
-	zoneCnt := GetZoneCount(svc)
-	zones := GetAvailableZones(svc, []*string{}, true, []*ec2.Filter{})
+// GetNextAvailableZone get next zone for newly created vm, make sure number of vms at each region is balanced
+func GetNextAvailableZone(svc *ec2.EC2) (string, error) {
+	zoneCnt, err := GetZoneCount(svc)
+	if err != nil {
+		return "", err
+	}
+	zones, err := GetAvailableZones(svc, []*string{}, true, []*ec2.Filter{})
+	if err != nil {
+		return "", err
+	}
 
 	// add zones availble but not used to zone cnt
 	for _, zone := range zones {
@@ -224,6 +253,9 @@ func getNextAvailableZone(svc *ec2.EC2) string {
 			zoneCnt[zone] = 0
 		}
 	}
+	if len(zoneCnt) == 0 {
+		return "", fmt.Errorf("no availability zone found")
+	}
 
 	// sort zones by cnt
 	var sortedZones []kv
@@ -232,17 +264,20 @@ func getNextAvailableZone(svc *ec2.EC2) string {
 	}
 
 	sort.Slice(sortedZones, func(i, j int) bool {
-		return sortedZones[i].Value < sortedZones[j].Value
+		if sortedZones[i].Value != sortedZones[j].Value {
+			return sortedZones[i].Value < sortedZones[j].Value
+		}
+		return sortedZones[i].Key < sortedZones[j].Key
 	})
 
-	fmt.Println(zoneCnt)
-	return sortedZones[0].Key
+	log.Println(zoneCnt)
+	return sortedZones[0].Key, nil
 }
 
 /* Setters */
 
 // StopInstance stop instance with provided instanceID
-func StopInstance(svc *ec2.EC2, instanceID string) {
+func StopInstance(svc *ec2.EC2, instanceID string) error {
 	input := &ec2.StopInstancesInput{
 		InstanceIds: []*string{
 			aws.String(instanceID),
@@ -256,15 +291,17 @@ func StopInstance(svc *ec2.EC2, instanceID string) {
 	if ok && awsErr.Code() == "DryRunOperation" {
 		input.DryRun = aws.Bool(false)
 		result, err = svc.StopInstances(input)
-		HandleError(err)
+		if err != nil {
+			return err
+		}
 		log.Println("Success", result.StoppingInstances)
-	} else {
-		log.Fatal("Error", err)
+		return nil
 	}
+	return err
 }
 
 // StartInstance start instance with provided insrtance ID
-func StartInstance(svc *ec2.EC2, instanceID string) {
+func StartInstance(svc *ec2.EC2, instanceID string) error {
 	// We set DryRun to true to check permission/existence
 	input := &ec2.StartInstancesInput{
 		InstanceIds: []*string{
@@ -278,84 +315,72 @@ func StartInstance(svc *ec2.EC2, instanceID string) {
 	if ok && awsErr.Code() == "DryRunOperation" {
 		input.DryRun = aws.Bool(false)
 		result, err = svc.StartInstances(input)
-		HandleError(err)
+		if err != nil {
+			return err
+		}
 		log.Println("Success", result.StartingInstances)
-	} else {
-		log.Fatal("Error", err)
+		return nil
 	}
+	return err
 }
 
 // DeleteInstance Delete vm with specified id
-func DeleteInstance(svc *ec2.EC2, id string) {
+func DeleteInstance(svc *ec2.EC2, id string) error {
 	res, err := svc.TerminateInstances(&ec2.TerminateInstancesInput{
 		DryRun: aws.Bool(false),
 		InstanceIds: []*string{
 			aws.String(id),
 		},
 	})
-	HandleError(err)
+	if err != nil {
+		return err
+	}
 
 	log.Printf("Successfully toggle instance %s from %s to %s",
 		*res.TerminatingInstances[0].InstanceId,
 		res.TerminatingInstances[0].PreviousState,
 		res.TerminatingInstances[0].CurrentState)
+	return nil
 }
 
-// CreateEC2Instance create an instance with provided config info
-// and update local vm database information
-func CreateEC2Instance(sess *session.Session, config CreateVMInput, pathToDBConfig string) {
-	// Create EC2 service client
-	svc := ec2.New(sess)
-	zone := getNextAvailableZone(svc)
-	createRes := createInstance(svc, config, zone)
+// CreateInstance create an instance with provided config info in zone,
+// and wait till it has a public ip
+func CreateInstance(ctx context.Context, svc *ec2.EC2, config CreateVMInput, zone string) (*spdb.VMInfo, error) {
+	createRes, err := createInstance(svc, config, zone)
+	if err != nil {
+		return nil, err
+	}
 	addTagToInstance(svc, createRes, config)
+	instance := *createRes.Instances[0]
 
-	if pathToDBConfig != "None" {
-		instance := *createRes.Instances[0]
-
-		// block till received a valid network interface
-		nicInfoChan := make(chan *ec2.InstanceNetworkInterfaceAssociation)
-
-		// get ip/dns
-		go func() {
-			for {
-				time.Sleep(1 * time.Second)
-				descRes, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{
-					InstanceIds: []*string{
-						instance.InstanceId,
-					},
-				})
-				HandleError(err)
+	// block till received a valid network interface
+	for {
+		descRes, err := svc.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
+			InstanceIds: []*string{
+				instance.InstanceId,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
 
-				nicAssociation := descRes.Reservations[0].
-					Instances[0].
-					NetworkInterfaces[0].
-					Association
+		described := descRes.Reservations[0].Instances[0]
+		if len(described.NetworkInterfaces) > 0 && described.NetworkInterfaces[0].Association != nil {
+			vm := InstanceToVMInfo(described)
+			vm.Name = config.Name
+			return &vm, nil
+		}
 
-				if nicAssociation != nil {
-					nicInfoChan <- nicAssociation
-					break
-				}
-			}
-		}()
-
-		nicAssociation := <-nicInfoChan
-		vms := []spdb.VMInfo{spdb.VMInfo{
-			Type:   "aws",
-			Name:   config.Name,
-			ID:     *instance.InstanceId,
-			Ipv4:   *nicAssociation.PublicIp,
-			DNS:    *nicAssociation.PublicDnsName,
-			Zone:   zone,
-			Status: *instance.State.Name}}
-
-		db := spdb.InitMongoDB(pathToDBConfig)
-		spdb.InsertInstanceIDName(db, vms, spdb.VMCollection)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(1 * time.Second):
+		}
 	}
 }
 
 /* Helper Functions & DB functions */
-func createInstance(svc *ec2.EC2, config CreateVMInput, zone string) *ec2.Reservation {
+func createInstance(svc *ec2.EC2, config CreateVMInput, zone string) (*ec2.Reservation, error) {
 	// Specify the details of the instance that you want to create.
 	runResult, err := svc.RunInstances(&ec2.RunInstancesInput{
 		// An Amazon Linux AMI ID for t2.micro instances in the us-west-2 region
@@ -373,11 +398,11 @@ func createInstance(svc *ec2.EC2, config CreateVMInput, zone string) *ec2.Reserv
 	})
 
 	if err != nil {
-		log.Fatal("Could not create instance", err)
+		return nil, fmt.Errorf("could not create instance: %v", err)
 	}
 
 	log.Println("Created instance", *runResult.Instances[0].InstanceId)
-	return runResult
+	return runResult, nil
 }
 
 func addTagToInstance(svc *ec2.EC2, runResult *ec2.Reservation, config CreateVMInput) {
@@ -412,11 +437,21 @@ func CreateMapWithTagDesc(tags []ec2.TagDescription) map[string]string {
 
 // UpdateLocalVMInfo update local vm database with the most
 // recent instance information on EC2 console and ensure provided index
-func UpdateLocalVMInfo(svc *ec2.EC2, dbConfigPath string) {
-	db := spdb.InitMongoDB(dbConfigPath)
-	filter := bson.D{{"type", "aws"}}
-	spdb.ClearCollection(*db.Database.Collection(spdb.VMCollection), filter)
-	vms := GetAllInstancesInfo(svc)
-	spdb.CreateIndex(*db.Database.Collection(spdb.VMCollection), "id")
-	spdb.InsertInstanceIDName(db, vms, spdb.VMCollection)
+func UpdateLocalVMInfo(ctx context.Context, svc *ec2.EC2, dbConfigPath string) error {
+	vms, err := GetAllInstancesInfo(svc)
+	if err != nil {
+		return fmt.Errorf("list ec2 instances: %w", err)
+	}
+	db, err := spdb.NewMongoDB(dbConfigPath, "speedtest")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.ClearVMs(ctx, spdb.VMCollection, "aws"); err != nil {
+		return err
+	}
+	if err := spdb.CreateIndex(ctx, *db.Database.Collection(spdb.VMCollection), "id"); err != nil {
+		return err
+	}
+	return db.UpsertVMs(ctx, spdb.VMCollection, vms)
 }
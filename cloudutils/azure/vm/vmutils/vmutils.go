@@ -5,6 +5,7 @@ import (
 	"cloudutils/azure/vm/iam"
 	"cloudutils/azure/vm/network"
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -17,7 +18,6 @@ import (
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/to"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 type kv struct {
@@ -27,13 +27,6 @@ type kv struct {
 
 var wg1 sync.WaitGroup
 
-// AzureHandleErr stop the program and print the error if the error is not nil
-func AzureHandleErr(err error) {
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
 /* Getter */
 
 // GetVMClient get client for VM management
@@ -55,33 +48,37 @@ func GetDiskClient() compute.DisksClient {
 }
 
 // GetVM gets the specified VM info
-func GetVM(ctx context.Context, vmClient compute.VirtualMachinesClient, vmName string) compute.VirtualMachine {
-	res, err := vmClient.Get(ctx, config.GroupName(), vmName, compute.InstanceView)
-	AzureHandleErr(err)
-	return res
+func GetVM(ctx context.Context, vmClient compute.VirtualMachinesClient, vmName string) (compute.VirtualMachine, error) {
+	return vmClient.Get(ctx, config.GroupName(), vmName, compute.InstanceView)
 }
 
 // ListVM gets all the vm information for a specified resource group
-func ListVM(ctx context.Context, vmClient compute.VirtualMachinesClient, resourceGroupName string) []compute.VirtualMachine {
+func ListVM(ctx context.Context, vmClient compute.VirtualMachinesClient, resourceGroupName string) ([]compute.VirtualMachine, error) {
 	res, err := vmClient.List(ctx, resourceGroupName)
-	AzureHandleErr(err)
+	if err != nil {
+		return nil, err
+	}
 	vmList := []compute.VirtualMachine{}
 
 	for res.NotDone() {
 		vmList = append(vmList, res.Values()...)
-		res.NextWithContext(ctx)
+		if err := res.NextWithContext(ctx); err != nil {
+			return nil, err
+		}
 	}
 
-	return vmList
+	return vmList, nil
 }
 
 // GetAvailableZones get available zones under user's default config region
-func GetAvailableZones(subscriptionID, locationFilter, machineType string) []string {
+func GetAvailableZones(subscriptionID, locationFilter, machineType string) ([]string, error) {
 	client := compute.NewResourceSkusClient(subscriptionID)
 	auth := iam.GetAuthFromEnv()
 	client.Authorizer = auth
 	res, err := client.List(context.Background(), locationFilter)
-	AzureHandleErr(err)
+	if err != nil {
+		return nil, err
+	}
 	var zones []string = []string{}
 
 	for _, ele := range res.Values() {
@@ -93,7 +90,9 @@ func GetAvailableZones(subscriptionID, locationFilter, machineType string) []str
 	for {
 		if res.NotDone() {
 			err := res.Next()
-			AzureHandleErr(err)
+			if err != nil {
+				return nil, err
+			}
 
 			for _, ele := range res.Values() {
 				if *ele.Name == machineType {
@@ -106,12 +105,15 @@ func GetAvailableZones(subscriptionID, locationFilter, machineType string) []str
 		break
 	}
 
-	return zones
+	return zones, nil
 }
 
 // GetZoneCount get (loaction->vm count) mapping of current resource group
-func GetZoneCount(resourceGroupName, location string, zones []string) map[string]int {
-	vms := ListVM(context.Background(), GetVMClient(), resourceGroupName)
+func GetZoneCount(resourceGroupName, location string, zones []string) (map[string]int, error) {
+	vms, err := ListVM(context.Background(), GetVMClient(), resourceGroupName)
+	if err != nil {
+		return nil, err
+	}
 	zoneMap := make(map[string]int)
 
 	// init zone count
@@ -127,11 +129,11 @@ func GetZoneCount(resourceGroupName, location string, zones []string) map[string
 		}
 	}
 
-	return zoneMap
+	return zoneMap, nil
 }
 
 // GetIPDNS Get all ip configuration of a vm
-func GetIPDNS(ctx context.Context, vm compute.VirtualMachine) (string, string) {
+func GetIPDNS(ctx context.Context, vm compute.VirtualMachine) (string, string, error) {
 	// assign ip and dns
 	nicRef := *vm.VirtualMachineProperties.NetworkProfile.NetworkInterfaces
 	IPAddr := "N/A"
@@ -141,8 +143,10 @@ func GetIPDNS(ctx context.Context, vm compute.VirtualMachine) (string, string) {
 	if len(nicRef) > 0 {
 		temp := strings.Split(*nicRef[0].ID, "/")
 		nicName := temp[len(temp)-1]
-		nicIf, err := network.GetNic(context.Background(), nicName)
-		AzureHandleErr(err)
+		nicIf, err := network.GetNic(ctx, nicName)
+		if err != nil {
+			return IPAddr, DNS, err
+		}
 
 		IPConfig := (*(*nicIf.InterfacePropertiesFormat).IPConfigurations)
 		if len(IPConfig) > 0 {
@@ -152,7 +156,9 @@ func GetIPDNS(ctx context.Context, vm compute.VirtualMachine) (string, string) {
 				temp := strings.Split(publicIPID, "/")
 				ipName := temp[len(temp)-1]
 				res, err := network.GetPublicIP(ctx, ipName)
-				AzureHandleErr(err)
+				if err != nil {
+					return IPAddr, DNS, err
+				}
 
 				if res.PublicIPAddressPropertiesFormat != nil &&
 					res.PublicIPAddressPropertiesFormat.IPAddress != nil {
@@ -166,31 +172,76 @@ func GetIPDNS(ctx context.Context, vm compute.VirtualMachine) (string, string) {
 			}
 		}
 	}
-	return IPAddr, DNS
+	return IPAddr, DNS, nil
 }
 
 // GetPowerStatus get power status of vm
-func GetPowerStatus(ctx context.Context, vm compute.VirtualMachine) string {
+func GetPowerStatus(ctx context.Context, vm compute.VirtualMachine) (string, error) {
 	// assign status
-	statuses := GetVM(context.Background(), GetVMClient(), *vm.Name).
-		VirtualMachineProperties.InstanceView.Statuses
+	vmView, err := GetVM(ctx, GetVMClient(), *vm.Name)
+	if err != nil {
+		return "N/A", err
+	}
 
 	var res string = "N/A"
-	for _, status := range *statuses {
+	if vmView.VirtualMachineProperties == nil || vmView.VirtualMachineProperties.InstanceView == nil ||
+		vmView.VirtualMachineProperties.InstanceView.Statuses == nil {
+		return res, nil
+	}
+	for _, status := range *vmView.VirtualMachineProperties.InstanceView.Statuses {
 		if strings.HasPrefix(*status.Code, "PowerState") {
 			res = strings.Split(*status.Code, "/")[1]
 		}
 	}
 
-	return res
+	return res, nil
+}
+
+// VMToVMInfo convert vm to vmInfo type, looking up its power status, ip and dns
+func VMToVMInfo(ctx context.Context, vm compute.VirtualMachine) (spdb.VMInfo, error) {
+	vmInfo := spdb.VMInfo{
+		Type: "azure",
+		Name: *vm.Name,
+		ID:   *vm.ID,
+		Zone: *(vm.Location),
+		Ipv4: "N/A",
+		DNS:  "N/A",
+	}
+	// get zones
+	if vm.Zones != nil && len(*vm.Zones) > 0 {
+		vmInfo.Zone += ("-" + (*vm.Zones)[0])
+	}
+
+	status, err := GetPowerStatus(ctx, vm)
+	if err != nil {
+		return vmInfo, err
+	}
+	vmInfo.Status = status
+
+	// get IP/DNS
+	vmView, err := GetVM(ctx, GetVMClient(), *vm.Name)
+	if err != nil {
+		return vmInfo, err
+	}
+	vmInfo.Ipv4, vmInfo.DNS, err = GetIPDNS(ctx, vmView)
+	return vmInfo, err
 }
 
 // GetNextZone get next location for newly created vm
-func GetNextZone(resourceGroup, location, subscriptionID string) string {
-	allZones := GetAvailableZones(subscriptionID,
+func GetNextZone(resourceGroup, location, subscriptionID string) (string, error) {
+	allZones, err := GetAvailableZones(subscriptionID,
 		"location eq "+"'"+location+"'", "Standard_D2s_v3")
+	if err != nil {
+		return "", err
+	}
 
-	zoneCnt := GetZoneCount(resourceGroup, location, allZones)
+	zoneCnt, err := GetZoneCount(resourceGroup, location, allZones)
+	if err != nil {
+		return "", err
+	}
+	if len(zoneCnt) == 0 {
+		return "", fmt.Errorf("no zone found in %s", location)
+	}
 
 	// sort zones by cnt
 	var sortedZones []kv
@@ -199,41 +250,50 @@ func GetNextZone(resourceGroup, location, subscriptionID string) string {
 	}
 
 	sort.Slice(sortedZones, func(i, j int) bool {
-		return sortedZones[i].Value < sortedZones[j].Value
+		if sortedZones[i].Value != sortedZones[j].Value {
+			return sortedZones[i].Value < sortedZones[j].Value
+		}
+		return sortedZones[i].Key < sortedZones[j].Key
 	})
 
-	fmt.Println(zoneCnt)
-	return sortedZones[0].Key
+	log.Println(zoneCnt)
+	return sortedZones[0].Key, nil
 }
 
 /* Setter */
 
 // StartInstance starts the selected VM
-func StartInstance(ctx context.Context, vmName string) (osr string) {
+func StartInstance(ctx context.Context, vmName string) (osr string, err error) {
 	vmClient := GetVMClient()
 	future, err := vmClient.Start(ctx, config.GroupName(), vmName)
-	AzureHandleErr(err)
+	if err != nil {
+		return "", fmt.Errorf("cannot start vm: %v", err)
+	}
 
 	err = future.WaitForCompletionRef(ctx, vmClient.Client)
-	AzureHandleErr(err)
+	if err != nil {
+		return "", fmt.Errorf("cannot get the vm start future response: %v", err)
+	}
 
 	res, err := future.Result(vmClient)
-	AzureHandleErr(err)
-	return res.Status
+	return res.Status, err
 }
 
 // StopInstance stops the selected VM
-func StopInstance(ctx context.Context, vmName string) (osr string) {
+func StopInstance(ctx context.Context, vmName string) (osr string, err error) {
 	vmClient := GetVMClient()
 	future, err := vmClient.PowerOff(ctx, config.GroupName(), vmName, nil)
-	AzureHandleErr(err)
+	if err != nil {
+		return "", fmt.Errorf("cannot stop vm: %v", err)
+	}
 
 	err = future.WaitForCompletionRef(ctx, vmClient.Client)
-	AzureHandleErr(err)
+	if err != nil {
+		return "", fmt.Errorf("cannot get the vm stop future response: %v", err)
+	}
 
 	res, err := future.Result(vmClient)
-	AzureHandleErr(err)
-	return res.Status
+	return res.Status, err
 }
 
 // CreateInstance creates a new virtual machine with the specified name using the specified NIC.
@@ -241,14 +301,16 @@ func StopInstance(ctx context.Context, vmName string) (osr string) {
 func CreateInstance(ctx context.Context, vmName, nicName, sshPublicKeyPath, zone string) (vm compute.VirtualMachine, err error) {
 	// see the network samples for how to create and get a NIC resource
 	nic, err := network.GetNic(ctx, nicName)
-	AzureHandleErr(err)
+	if err != nil {
+		return vm, fmt.Errorf("cannot get nic %s: %v", nicName, err)
+	}
 
 	// read ssh data
 	var sshKeyData string
 	if _, err = os.Stat(sshPublicKeyPath); err == nil {
 		sshBytes, err := ioutil.ReadFile(sshPublicKeyPath)
 		if err != nil {
-			log.Fatalf("failed to read SSH key data: %v", err)
+			return vm, fmt.Errorf("failed to read SSH key data: %v", err)
 		}
 		sshKeyData = string(sshBytes)
 	} else {
@@ -336,31 +398,46 @@ func DeallocateInstance(ctx context.Context, vmName string) (osr autorest.Respon
 }
 
 // DeleteDisk Delete disk with specified name
-func DeleteDisk(ctx context.Context, diskName string) {
+func DeleteDisk(ctx context.Context, diskName string) error {
 	client := GetDiskClient()
-	fmt.Println(client)
 	future, err := client.Delete(ctx, config.GroupName(), diskName)
-	AzureHandleErr(err)
+	if err != nil {
+		return fmt.Errorf("cannot delete disk: %v", err)
+	}
 	err = future.WaitForCompletionRef(ctx, client.Client)
-	AzureHandleErr(err)
+	if err != nil {
+		return fmt.Errorf("cannot get the disk delete future response: %v", err)
+	}
 	log.Println("Successfully deleted disk", diskName)
+	return nil
 }
 
 // DeleteInstance delete vm and its network interface, ip and disk
-func DeleteInstance(ctx context.Context, resourceGroupName, vmName string) {
+func DeleteInstance(ctx context.Context, resourceGroupName, vmName string) error {
 	client := GetVMClient()
-	vm := GetVM(ctx, client, vmName)
-	DeallocateInstance(ctx, vmName)
+	vm, err := GetVM(ctx, client, vmName)
+	if err != nil {
+		return err
+	}
+	if _, err := DeallocateInstance(ctx, vmName); err != nil {
+		return err
+	}
 
 	// delete vm
 	vmDeletefuture, err := client.Delete(ctx, resourceGroupName, vmName)
-	AzureHandleErr(err)
+	if err != nil {
+		return fmt.Errorf("cannot delete vm: %v", err)
+	}
 	err = vmDeletefuture.WaitForCompletionRef(ctx, client.Client)
-	AzureHandleErr(err)
+	if err != nil {
+		return fmt.Errorf("cannot get the vm delete future response: %v", err)
+	}
 
 	// delete os disk
 	osDisk := *vm.VirtualMachineProperties.StorageProfile.OsDisk.Name
-	DeleteDisk(ctx, osDisk)
+	if err := DeleteDisk(ctx, osDisk); err != nil {
+		return err
+	}
 
 	// delete nic
 	nics := vm.VirtualMachineProperties.NetworkProfile.NetworkInterfaces
@@ -368,11 +445,17 @@ func DeleteInstance(ctx context.Context, resourceGroupName, vmName string) {
 		temp := strings.Split(*nicRef.ID, "/")
 		nicName := temp[len(temp)-1]
 		nic, err := network.GetNic(ctx, nicName)
-		AzureHandleErr(err)
+		if err != nil {
+			return err
+		}
 		nicDeleteFuture, err := network.DeleteNic(ctx, nicName)
-		AzureHandleErr(err)
+		if err != nil {
+			return err
+		}
 		err = nicDeleteFuture.WaitForCompletionRef(ctx, client.Client)
-		AzureHandleErr(err)
+		if err != nil {
+			return err
+		}
 
 		// delete virtual network
 		subnet := (*(nic.InterfacePropertiesFormat.
@@ -391,9 +474,13 @@ func DeleteInstance(ctx context.Context, resourceGroupName, vmName string) {
 		}
 		virtualNetwork = temp[idx+1]
 		vnetDeleteFuture, err := network.DeleteVirtualNetwork(ctx, virtualNetwork)
-		AzureHandleErr(err)
+		if err != nil {
+			return err
+		}
 		err = vnetDeleteFuture.WaitForCompletionRef(ctx, client.Client)
-		AzureHandleErr(err)
+		if err != nil {
+			return err
+		}
 
 		// delete ip addr
 		publicIPID := (*(nic.InterfacePropertiesFormat.
@@ -405,84 +492,92 @@ func DeleteInstance(ctx context.Context, resourceGroupName, vmName string) {
 			temp = strings.Split(*publicIPID, "/")
 			ip := temp[len(temp)-1]
 			ipDeleteFuture, err := network.DeletePublicIP(ctx, ip)
-			AzureHandleErr(err)
+			if err != nil {
+				return err
+			}
 			err = ipDeleteFuture.WaitForCompletionRef(ctx, client.Client)
-			AzureHandleErr(err)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
 	log.Println("Successfully deleted", vmName, "and all its associated resources")
+	return nil
 }
 
 // UpdateLocalVMInfo store/udpate azure vm information locally
-func UpdateLocalVMInfo(dbConfigPath string, index string) {
-	db := spdb.InitMongoDB(dbConfigPath)
-
-	filter := bson.D{{"type", "azure"}}
-	spdb.ClearCollection(*db.Database.Collection(spdb.VMCollection), filter)
-
-	res := ListVM(context.Background(), GetVMClient(), "ricky_speedtest")
+func UpdateLocalVMInfo(ctx context.Context, dbConfigPath string, index string) error {
+	res, err := ListVM(ctx, GetVMClient(), "ricky_speedtest")
+	if err != nil {
+		return fmt.Errorf("list azure vms: %w", err)
+	}
 	vms := []spdb.VMInfo{}
+	errs := []error{}
+	var vmsLock sync.Mutex
 	for _, vm := range res {
 		wg1.Add(1)
 		go func(vm compute.VirtualMachine) {
 			defer wg1.Done()
-			vmInfo := spdb.VMInfo{
-				Type: "azure",
-				Name: *vm.Name,
-				ID:   *vm.ID,
-				Zone: *(vm.Location),
-				Ipv4: "N/A",
-				DNS:  "N/A",
-			}
-			// get zones
-			if vm.Zones != nil {
-				vmInfo.Zone += ("-" + (*vm.Zones)[0])
-			}
-
-			// assign status
-			statuses := GetVM(context.Background(), GetVMClient(), *vm.Name).
-				VirtualMachineProperties.InstanceView.Statuses
-
-			for _, status := range *statuses {
-				if strings.HasPrefix(*status.Code, "PowerState") {
-					vmInfo.Status = strings.Split(*status.Code, "/")[1]
-				}
+			vmInfo, err := VMToVMInfo(ctx, vm)
+			vmsLock.Lock()
+			defer vmsLock.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
 			}
-
-			// get IP/DNS
-			ipAddr, DNS := GetIPDNS(context.Background(), GetVM(context.Background(), GetVMClient(), *vm.Name))
-			vmInfo.Ipv4, vmInfo.DNS = ipAddr, DNS
 			fmt.Println(vmInfo)
 			vms = append(vms, vmInfo)
 		}(vm)
 	}
 	wg1.Wait()
-	spdb.CreateIndex(*db.Database.Collection(spdb.VMCollection), index)
-	spdb.InsertInstanceIDName(db, vms, spdb.VMCollection)
+	//keep the stored vms if any vm could not be read
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	db, err := spdb.NewMongoDB(dbConfigPath, "speedtest")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.ClearVMs(ctx, spdb.VMCollection, "azure"); err != nil {
+		return err
+	}
+	if err := spdb.CreateIndex(ctx, *db.Database.Collection(spdb.VMCollection), index); err != nil {
+		return err
+	}
+	return db.UpsertVMs(ctx, spdb.VMCollection, vms)
 }
 
 // GenerateNicForVMWithNewIP generate a nic based on vmName, with a new ip adddress
-func GenerateNicForVMWithNewIP(vmName, region, zone string) string {
+func GenerateNicForVMWithNewIP(ctx context.Context, vmName, region, zone string) (string, error) {
 	// generae ip address
-	ipRes, err := network.CreatePublicIP(context.Background(), vmName+"ip", zone)
-	AzureHandleErr(err)
+	ipRes, err := network.CreatePublicIP(ctx, vmName+"ip", zone)
+	if err != nil {
+		return "", err
+	}
 	ipName := *ipRes.Name
 
 	// generate vnet
-	vnetRes, err := network.CreateVirtualNetwork(context.Background(), vmName+"vent")
-	AzureHandleErr(err)
+	vnetRes, err := network.CreateVirtualNetwork(ctx, vmName+"vent")
+	if err != nil {
+		return "", err
+	}
 	vnetName := *vnetRes.Name
 
 	// generate subnet for vnet
-	subnet, err := network.CreateVirtualNetworkSubnet(context.Background(), vnetName, "default")
-	AzureHandleErr(err)
+	subnet, err := network.CreateVirtualNetworkSubnet(ctx, vnetName, "default")
+	if err != nil {
+		return "", err
+	}
 	subnetName := *subnet.Name
 
 	// generate network interface
-	nic, err := network.CreateNIC(context.Background(), vmName, vnetName, subnetName,
+	nic, err := network.CreateNIC(ctx, vmName, vnetName, subnetName,
 		"speedtestnsg-"+region, ipName, vmName+"nic")
-	AzureHandleErr(err)
+	if err != nil {
+		return "", err
+	}
 
-	return *nic.Name
+	return *nic.Name, nil
 }
@@ -0,0 +1,117 @@
+package cloud
+
+import (
+	"cloudutils/aws/ec2/ec2utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"spservers/spdb"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// AWS manages the ec2 instances of one region
+type AWS struct {
+	svc *ec2.EC2
+	// VM is the template of created instances, Name is set per instance
+	VM ec2utils.CreateVMInput
+}
+
+// NewAWS returns the aws provider. an empty region is the default region of
+// the user's aws config. vmconfig is only needed to create instances
+func NewAWS(region string, vmconfig string) (*AWS, error) {
+	cfg := aws.NewConfig()
+	if len(region) > 0 {
+		cfg = cfg.WithRegion(region)
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p := &AWS{svc: ec2.New(sess)}
+	if len(vmconfig) > 0 {
+		body, err := ioutil.ReadFile(vmconfig)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &p.VM); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *AWS) Type() string {
+	return "aws"
+}
+
+func (p *AWS) NextZone(ctx context.Context) (string, error) {
+	return ec2utils.GetNextAvailableZone(p.svc)
+}
+
+func (p *AWS) List(ctx context.Context) ([]spdb.VMInfo, error) {
+	return ec2utils.GetAllInstancesInfo(p.svc)
+}
+
+func (p *AWS) Create(ctx context.Context, name, zone string) (*spdb.VMInfo, error) {
+	if len(zone) == 0 {
+		var err error
+		if zone, err = p.NextZone(ctx); err != nil {
+			return nil, err
+		}
+	}
+	input := p.VM
+	input.Name = name
+	return ec2utils.CreateInstance(ctx, p.svc, input, zone)
+}
+
+func (p *AWS) Start(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	vm, err := p.find(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := ec2utils.StartInstance(p.svc, vm.ID); err != nil {
+		return nil, err
+	}
+	vm.Status = StatusRunning
+	return vm, nil
+}
+
+func (p *AWS) Stop(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	vm, err := p.find(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := ec2utils.StopInstance(p.svc, vm.ID); err != nil {
+		return nil, err
+	}
+	vm.Status = StatusStopped
+	return vm, nil
+}
+
+func (p *AWS) Delete(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	vm, err := p.find(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := ec2utils.DeleteInstance(p.svc, vm.ID); err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+// instances are addressed by id, the name is the Name tag
+func (p *AWS) find(name string) (*spdb.VMInfo, error) {
+	instance, err := ec2utils.GetInstanceByName(p.svc, name)
+	if err != nil {
+		return nil, err
+	}
+	if instance == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	vm := ec2utils.InstanceToVMInfo(instance)
+	return &vm, nil
+}
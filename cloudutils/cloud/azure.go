@@ -0,0 +1,125 @@
+package cloud
+
+import (
+	"cloudutils/azure/vm/config"
+	"cloudutils/azure/vm/vmutils"
+	"context"
+	"fmt"
+	"spservers/spdb"
+)
+
+// Azure manages the vms of the resource group in AZURE_BASE_GROUP_NAME.
+// the azure utilities keep the location in a global, so there is one azure
+// location per process
+type Azure struct {
+	SSHKey string
+}
+
+// NewAzure loads the azure config from the environment, requires clientID,
+// SubscriptionID, GroupName. a non empty location replaces AZURE_LOCATION_DEFAULT
+func NewAzure(location string, sshkey string) *Azure {
+	config.Environment()
+	if len(location) > 0 {
+		config.SetLocation(location)
+	}
+	return &Azure{SSHKey: sshkey}
+}
+
+func (p *Azure) Type() string {
+	return "azure"
+}
+
+func (p *Azure) NextZone(ctx context.Context) (string, error) {
+	return vmutils.GetNextZone(config.GroupName(), config.Location(), config.SubscriptionID())
+}
+
+func (p *Azure) List(ctx context.Context) ([]spdb.VMInfo, error) {
+	res, err := vmutils.ListVM(ctx, vmutils.GetVMClient(), config.GroupName())
+	if err != nil {
+		return nil, err
+	}
+	vms := []spdb.VMInfo{}
+	for _, vm := range res {
+		if *vm.Location != config.Location() {
+			continue
+		}
+		vmInfo, err := vmutils.VMToVMInfo(ctx, vm)
+		if err != nil {
+			return nil, err
+		}
+		vms = append(vms, vmInfo)
+	}
+	return vms, nil
+}
+
+func (p *Azure) Create(ctx context.Context, name, zone string) (*spdb.VMInfo, error) {
+	if len(zone) == 0 {
+		var err error
+		if zone, err = p.NextZone(ctx); err != nil {
+			return nil, err
+		}
+	}
+	nicName, err := vmutils.GenerateNicForVMWithNewIP(ctx, name, config.Location(), zone)
+	if err != nil {
+		return nil, err
+	}
+	res, err := vmutils.CreateInstance(ctx, name, nicName, p.SSHKey, zone)
+	if err != nil {
+		return nil, err
+	}
+	vm, err := vmutils.VMToVMInfo(ctx, res)
+	if err != nil {
+		return nil, err
+	}
+	return &vm, nil
+}
+
+func (p *Azure) Start(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	vm, err := p.get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := vmutils.StartInstance(ctx, name); err != nil {
+		return nil, err
+	}
+	vm.Status = StatusRunning
+	return vm, nil
+}
+
+func (p *Azure) Stop(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	vm, err := p.get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := vmutils.StopInstance(ctx, name); err != nil {
+		return nil, err
+	}
+	vm.Status = StatusStopped
+	return vm, nil
+}
+
+func (p *Azure) Delete(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	vm, err := p.get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := vmutils.DeleteInstance(ctx, config.GroupName(), name); err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+func (p *Azure) get(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	res, err := vmutils.GetVM(ctx, vmutils.GetVMClient(), name)
+	if err != nil {
+		if res.Response.Response != nil && res.StatusCode == 404 {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+		return nil, err
+	}
+	vm, err := vmutils.VMToVMInfo(ctx, res)
+	if err != nil {
+		return nil, err
+	}
+	return &vm, nil
+}
@@ -0,0 +1,154 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"spservers/spdb"
+	"strconv"
+	"sync"
+)
+
+// Fake is an in-memory provider, for tests and dry runs. it records every
+// call that changed a vm in Calls
+type Fake struct {
+	sync.Mutex
+	VMType string
+	Zones  []string
+	// Calls are "create <name>", "start <name>", "stop <name>" and "delete <name>"
+	Calls []string
+	// Fail makes the operation ("create", "start", ...) return the error
+	Fail   map[string]error
+	vms    map[string]*spdb.VMInfo
+	nextid int
+}
+
+// NewFake returns an empty fake provider of vm type vmtype, with vms spread over zones
+func NewFake(vmtype string, zones ...string) *Fake {
+	if len(zones) == 0 {
+		zones = []string{vmtype + "-zone-a"}
+	}
+	return &Fake{VMType: vmtype, Zones: zones, Fail: make(map[string]error), vms: make(map[string]*spdb.VMInfo)}
+}
+
+// Add puts an existing vm in the fake inventory
+func (p *Fake) Add(name, zone, status string) *spdb.VMInfo {
+	p.Lock()
+	defer p.Unlock()
+	return p.add(name, zone, status)
+}
+
+func (p *Fake) add(name, zone, status string) *spdb.VMInfo {
+	p.nextid++
+	vm := &spdb.VMInfo{
+		Type:   p.VMType,
+		Name:   name,
+		ID:     strconv.Itoa(p.nextid),
+		Status: status,
+		Zone:   zone,
+		Ipv4:   "10.0.0." + strconv.Itoa(p.nextid),
+		DNS:    "N/A",
+	}
+	p.vms[name] = vm
+	return vm
+}
+
+func (p *Fake) Type() string {
+	return p.VMType
+}
+
+func (p *Fake) NextZone(ctx context.Context) (string, error) {
+	p.Lock()
+	defer p.Unlock()
+	return p.nextZone()
+}
+
+func (p *Fake) nextZone() (string, error) {
+	if err := p.Fail["zone"]; err != nil {
+		return "", err
+	}
+	zonecnt := make(map[string]int)
+	for _, vm := range p.vms {
+		zonecnt[vm.Zone]++
+	}
+	next := p.Zones[0]
+	for _, zone := range p.Zones[1:] {
+		if zonecnt[zone] < zonecnt[next] {
+			next = zone
+		}
+	}
+	return next, nil
+}
+
+func (p *Fake) List(ctx context.Context) ([]spdb.VMInfo, error) {
+	p.Lock()
+	defer p.Unlock()
+	if err := p.Fail["list"]; err != nil {
+		return nil, err
+	}
+	vms := make([]spdb.VMInfo, 0, len(p.vms))
+	for _, vm := range p.vms {
+		vms = append(vms, *vm)
+	}
+	sort.Slice(vms, func(i, j int) bool { return vms[i].Name < vms[j].Name })
+	return vms, nil
+}
+
+func (p *Fake) Create(ctx context.Context, name, zone string) (*spdb.VMInfo, error) {
+	p.Lock()
+	defer p.Unlock()
+	if err := p.Fail["create"]; err != nil {
+		return nil, err
+	}
+	if _, vexist := p.vms[name]; vexist {
+		return nil, fmt.Errorf("vm %s already exists", name)
+	}
+	if len(zone) == 0 {
+		var err error
+		if zone, err = p.nextZone(); err != nil {
+			return nil, err
+		}
+	}
+	p.Calls = append(p.Calls, "create "+name)
+	vm := *p.add(name, zone, StatusRunning)
+	return &vm, nil
+}
+
+func (p *Fake) Start(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	return p.setStatus("start", name, StatusRunning)
+}
+
+func (p *Fake) Stop(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	return p.setStatus("stop", name, StatusStopped)
+}
+
+func (p *Fake) Delete(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	p.Lock()
+	defer p.Unlock()
+	if err := p.Fail["delete"]; err != nil {
+		return nil, err
+	}
+	vm, vexist := p.vms[name]
+	if !vexist {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	p.Calls = append(p.Calls, "delete "+name)
+	delete(p.vms, name)
+	return vm, nil
+}
+
+func (p *Fake) setStatus(op, name, status string) (*spdb.VMInfo, error) {
+	p.Lock()
+	defer p.Unlock()
+	if err := p.Fail[op]; err != nil {
+		return nil, err
+	}
+	vm, vexist := p.vms[name]
+	if !vexist {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	p.Calls = append(p.Calls, op+" "+name)
+	vm.Status = status
+	res := *vm
+	return &res, nil
+}
@@ -0,0 +1,111 @@
+package cloud
+
+import (
+	"cloudutils/gcp/vm/vmutils"
+	"context"
+	"path"
+	"spservers/spdb"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// GCP manages the compute engine vms of a project in one region
+type GCP struct {
+	Project string
+	Region  string
+}
+
+// NewGCP returns the gcp provider, an empty region means all zones of the project
+func NewGCP(project, region string) *GCP {
+	return &GCP{Project: project, Region: region}
+}
+
+func (p *GCP) Type() string {
+	return "gcp"
+}
+
+func (p *GCP) NextZone(ctx context.Context) (string, error) {
+	return vmutils.GetNextAvailableZone(ctx, p.Project, p.Region)
+}
+
+func (p *GCP) List(ctx context.Context) ([]spdb.VMInfo, error) {
+	zones, err := vmutils.GetRegionZones(ctx, p.Project, p.Region)
+	if err != nil {
+		return nil, err
+	}
+	vms := []spdb.VMInfo{}
+	for _, zone := range zones {
+		zonevms, err := vmutils.GetInstanceInfo(ctx, zone, p.Project)
+		if err != nil {
+			return nil, err
+		}
+		vms = append(vms, zonevms...)
+	}
+	return vms, nil
+}
+
+func (p *GCP) Create(ctx context.Context, name, zone string) (*spdb.VMInfo, error) {
+	if len(zone) == 0 {
+		var err error
+		if zone, err = p.NextZone(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := vmutils.CreateInstance(ctx, p.Project, zone, name); err != nil {
+		return nil, err
+	}
+	return p.get(ctx, name, zone)
+}
+
+func (p *GCP) Start(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	vm, err := p.find(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := vmutils.StartInstance(ctx, name, path.Base(vm.Zone), p.Project); err != nil {
+		return nil, err
+	}
+	vm.Status = StatusRunning
+	return vm, nil
+}
+
+func (p *GCP) Stop(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	vm, err := p.find(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := vmutils.StopInstance(ctx, name, path.Base(vm.Zone), p.Project); err != nil {
+		return nil, err
+	}
+	vm.Status = StatusStopped
+	return vm, nil
+}
+
+func (p *GCP) Delete(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	vm, err := p.find(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := vmutils.DeleteInstance(ctx, name, path.Base(vm.Zone), p.Project); err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+func (p *GCP) get(ctx context.Context, name, zone string) (*spdb.VMInfo, error) {
+	instance, err := vmutils.GetInstance(ctx, p.Project, zone, name)
+	if err != nil {
+		return nil, err
+	}
+	vm := vmutils.InstanceToVMInfo([]*compute.Instance{instance})[0]
+	return &vm, nil
+}
+
+// instances are addressed by zone and name, look the zone up in the region
+func (p *GCP) find(ctx context.Context, name string) (*spdb.VMInfo, error) {
+	vms, err := p.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return findVM(vms, name)
+}
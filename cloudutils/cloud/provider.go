@@ -0,0 +1,103 @@
+// Package cloud puts the aws, gcp and azure vm utilities behind one Provider
+// interface, so vms can be managed the same way whatever cloud they run in.
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"spservers/spdb"
+)
+
+// status stored in vmInfo after a vm was started or stopped
+const (
+	StatusRunning = "running"
+	StatusStopped = "stopped"
+)
+
+// ErrNotFound is returned when no vm has the requested name
+var ErrNotFound = errors.New("vm not found")
+
+// Provider manages the vms of one cloud region. vms are addressed by name,
+// which is unique within the region
+type Provider interface {
+	// Type of the vms in vmInfo: aws, gcp or azure
+	Type() string
+	// Create creates vm name in zone, or in NextZone if zone is empty
+	Create(ctx context.Context, name, zone string) (*spdb.VMInfo, error)
+	Start(ctx context.Context, name string) (*spdb.VMInfo, error)
+	Stop(ctx context.Context, name string) (*spdb.VMInfo, error)
+	// Delete returns the info of the deleted vm, so it can be removed from vmInfo
+	Delete(ctx context.Context, name string) (*spdb.VMInfo, error)
+	List(ctx context.Context) ([]spdb.VMInfo, error)
+	// NextZone is the zone of the region with the fewest vms
+	NextZone(ctx context.Context) (string, error)
+}
+
+// Options of NewProvider. Fields a provider does not use are ignored
+type Options struct {
+	// Region is the cloud region, e.g. us-west1 for gcp, us-west-2 for aws or
+	// westus2 for azure. empty means the default of the sdk environment
+	Region string
+	// Project is the gcp project
+	Project string
+	// VMConfig is the path to the aws json vm config (ImageID, Type, KeyName, SecurityGroup)
+	VMConfig string
+	// SSHKey is the path to the ssh public key of azure vms
+	SSHKey string
+}
+
+// NewProvider returns the provider named name: aws, gcp or azure
+func NewProvider(name string, opts Options) (Provider, error) {
+	switch name {
+	case "gcp":
+		return NewGCP(opts.Project, opts.Region), nil
+	case "aws":
+		return NewAWS(opts.Region, opts.VMConfig)
+	case "azure":
+		return NewAzure(opts.Region, opts.SSHKey), nil
+	}
+	return nil, fmt.Errorf("unknown provider %s", name)
+}
+
+// Do runs op on vm name: create (in zone, or NextZone if zone is empty),
+// start, stop or delete
+func Do(ctx context.Context, p Provider, op, name, zone string) (*spdb.VMInfo, error) {
+	switch op {
+	case "create":
+		return p.Create(ctx, name, zone)
+	case "start":
+		return p.Start(ctx, name)
+	case "stop":
+		return p.Stop(ctx, name)
+	case "delete":
+		return p.Delete(ctx, name)
+	}
+	return nil, fmt.Errorf("unknown vm operation %q", op)
+}
+
+// Record brings the vmInfo of db up to date after op ran on vm
+func Record(ctx context.Context, db spdb.Store, op string, vm *spdb.VMInfo) error {
+	var err error
+	switch op {
+	case "create":
+		err = db.UpsertVMs(ctx, spdb.VMCollection, []spdb.VMInfo{*vm})
+	case "start", "stop":
+		err = db.UpdateVMState(ctx, spdb.VMCollection, vm.Status, vm.ID)
+	case "delete":
+		err = db.DeleteVM(ctx, spdb.VMCollection, vm.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("record %s of vm %s: %w", op, vm.Name, err)
+	}
+	return nil
+}
+
+func findVM(vms []spdb.VMInfo, name string) (*spdb.VMInfo, error) {
+	for idx := range vms {
+		if vms[idx].Name == name {
+			return &vms[idx], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+}
@@ -0,0 +1,59 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"spservers/spdb"
+	"testing"
+)
+
+// failStore fails every write of vmInfo
+type failStore struct {
+	*spdb.MemStore
+}
+
+var errWrite = errors.New("write failed")
+
+func (failStore) UpsertVMs(ctx context.Context, collection string, VMs []spdb.VMInfo) error {
+	return errWrite
+}
+
+func TestRecord(t *testing.T) {
+	ctx := context.Background()
+	p := NewFake("gcp")
+	db := spdb.NewMemStore()
+	for _, op := range []string{"create", "stop", "start"} {
+		vm, err := Do(ctx, p, op, "gcp-uswest1-1", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Record(ctx, db, op, vm); err != nil {
+			t.Fatalf("record %s: %v", op, err)
+		}
+	}
+	vms, err := db.QueryVMs(ctx, spdb.VMCollection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vms) != 1 || vms[0].Name != "gcp-uswest1-1" || vms[0].Status != StatusRunning {
+		t.Fatalf("vms after create, stop and start = %+v", vms)
+	}
+	vm, err := Do(ctx, p, "delete", "gcp-uswest1-1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Record(ctx, db, "delete", vm); err != nil {
+		t.Fatal(err)
+	}
+	if vms, _ := db.QueryVMs(ctx, spdb.VMCollection); len(vms) != 0 {
+		t.Errorf("vms after delete = %+v", vms)
+	}
+	// the vm exists, the caller hears that it is not recorded
+	vm, err = Do(ctx, p, "create", "gcp-uswest1-2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Record(ctx, failStore{db}, "create", vm); !errors.Is(err, errWrite) {
+		t.Errorf("record with a failed write = %v, want %v", err, errWrite)
+	}
+}
@@ -2,13 +2,17 @@ package common
 
 import (
 	"log"
-	"mmbot"
 	"os"
+	"spservers/notify"
 )
 
-func HandleError(mm *mmbot.MMBot, desc string, e error) {
+// HandleError notifies n and stops the program if e is not nil
+func HandleError(n notify.Notifier, desc string, e error) {
 	if e != nil {
-		mm.SendPanic(desc, e.Error())
+		notify.Error(n, desc, notify.Err(e))
+		if n != nil {
+			n.Close()
+		}
 		log.Fatal(e)
 	}
 }
@@ -16,9 +16,18 @@ func main() {
 	log.Println("Updating gcp/azure/ec2 vm informations")
 	config.Environment()
 	sess, err := session.NewSession()
-	ec2utils.HandleError(err)
+	if err != nil {
+		log.Fatal(err)
+	}
 	svc := ec2.New(sess)
-	azureutils.UpdateLocalVMInfo("./dbconfig.json", "id")
-	ec2utils.UpdateLocalVMInfo(svc, "./dbconfig.json")
-	gcputils.UpdateLocalVMInfo(context.Background(), "./dbconfig.json", "webspeedtest-caida")
+	ctx := context.Background()
+	if err := azureutils.UpdateLocalVMInfo(ctx, "./dbconfig.json", "id"); err != nil {
+		log.Fatal(err)
+	}
+	if err := ec2utils.UpdateLocalVMInfo(ctx, svc, "./dbconfig.json"); err != nil {
+		log.Fatal(err)
+	}
+	if err := gcputils.UpdateLocalVMInfo(ctx, "./dbconfig.json", "webspeedtest-caida"); err != nil {
+		log.Fatal(err)
+	}
 }
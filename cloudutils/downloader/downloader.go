@@ -0,0 +1,180 @@
+package main
+
+import (
+	"cloudutils/objstore"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"spservers/common"
+	"spservers/notify"
+	"strings"
+	"syscall"
+)
+
+type DownloadConfig struct {
+	Backend  string
+	DestDir  string
+	Bucket   string
+	Region   string
+	Endpoint string
+	Prefix   string
+	Results  string
+	Types    []string
+	Workers  int
+	Manifest string
+	Notify   string
+	Notifier notify.Notifier
+	Archive  bool
+}
+
+func handleError(n notify.Notifier, desc string, e error) {
+	if e != nil {
+		notify.Error(n, desc, notify.Err(e))
+		n.Close()
+		log.Fatal(desc, e)
+	}
+}
+
+func ParseDownloadConfig() *DownloadConfig {
+	shared, err := common.LoadClaspConfig("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	dlcfg := &DownloadConfig{}
+	var types string
+	prefix := flag.String("prefix", "None", "Prefix of the vm folders, by default aws for s3 and empty otherwise")
+	results := flag.String("results", "None", "Folder between the vm folder and the result files, by default results/ for gcs and empty otherwise")
+	flag.StringVar(&dlcfg.Backend, "backend", shared.Download.Backend, "Object store backend: s3/gcs/azure/local")
+	flag.StringVar(&dlcfg.DestDir, "d", shared.Download.DestDir, "Local directory for storing result files")
+	flag.StringVar(&dlcfg.Bucket, "b", shared.Download.Bucket, "Bucket (s3/gcs), container (azure) or root directory (local), by default the bucket of the backend")
+	flag.StringVar(&dlcfg.Region, "r", shared.Download.Region, "Region where the s3 bucket located")
+	flag.StringVar(&dlcfg.Endpoint, "endpoint", shared.Download.Endpoint, "S3 compatible endpoint, e.g. http://localhost:9000 for MinIO")
+	flag.StringVar(&types, "types", strings.Join(shared.Download.Types, ","), "Comma separated data types to download")
+	flag.IntVar(&dlcfg.Workers, "w", shared.Download.Workers, "Number of concurrent downloads")
+	flag.StringVar(&dlcfg.Notify, "notify", shared.Notify, "Where to send notifications: path to the mattermost bot config file, slack:<webhook url>, smtp:<smtp.json>, stdout or none")
+	flag.StringVar(&dlcfg.Manifest, "manifest", shared.Download.Manifest, "Path to the download manifest, by default <d>/manifest.jsonl")
+	flag.BoolVar(&dlcfg.Archive, "archive", shared.Download.Archive, "Move verified files into archive directory")
+	flag.Parse()
+
+	def, ok := objstore.Defaults[dlcfg.Backend]
+	if !ok {
+		log.Fatal("Unknown backend ", dlcfg.Backend)
+	}
+	if dlcfg.Bucket == "" {
+		dlcfg.Bucket = def.Bucket
+	}
+	if dlcfg.Bucket == "" {
+		log.Fatal("Please provide the root directory of the local backend with -b")
+	}
+	dlcfg.Prefix = def.Prefix
+	if *prefix != "None" {
+		dlcfg.Prefix = *prefix
+	}
+	dlcfg.Results = def.Results
+	if *results != "None" {
+		dlcfg.Results = *results
+	}
+	for _, t := range strings.Split(types, ",") {
+		if t != objstore.Bdrmap && t != objstore.Trace {
+			log.Fatal("Unknown data type ", t)
+		}
+		dlcfg.Types = append(dlcfg.Types, t)
+	}
+
+	if dlcfg.Manifest == "" {
+		dlcfg.Manifest = filepath.Join(dlcfg.DestDir, "manifest.jsonl")
+	}
+
+	dlcfg.Notifier, err = notify.Open(dlcfg.Notify, "Result Downloader")
+	if err != nil {
+		log.Fatal("Failed to open notifier ", err)
+	}
+	if err := os.MkdirAll(dlcfg.DestDir, 0755); err != nil {
+		handleError(dlcfg.Notifier, "Failed to create DestDir "+dlcfg.DestDir, err)
+	}
+	return dlcfg
+}
+
+// verify audit the local result tree against the manifest
+func verify(args []string) {
+	shared, err := common.LoadClaspConfig("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	destDir := fs.String("d", shared.Download.DestDir, "Local directory of the result files")
+	manifestPath := fs.String("manifest", "", "Path to the download manifest, by default <d>/manifest.jsonl")
+	fs.Parse(args)
+	if *manifestPath == "" {
+		*manifestPath = filepath.Join(*destDir, "manifest.jsonl")
+	}
+	if _, err := os.Stat(*manifestPath); err != nil {
+		log.Fatal(err)
+	}
+
+	manifest, err := objstore.OpenManifest(*manifestPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer manifest.Close()
+	problems, err := manifest.Audit(*destDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range problems {
+		fmt.Println(p.Path, p.Key, p.Err)
+	}
+	log.Println("Checked", len(manifest.Entries()), "manifest entries,", len(problems), "problems")
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		verify(os.Args[2:])
+		return
+	}
+
+	dlcfg := ParseDownloadConfig()
+	// an interrupted download keeps its .part file and resumes on the next run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	defer dlcfg.Notifier.Close()
+
+	manifest, err := objstore.OpenManifest(dlcfg.Manifest)
+	handleError(dlcfg.Notifier, "Failed to open manifest "+dlcfg.Manifest, err)
+	defer manifest.Close()
+
+	store, err := objstore.New(ctx, dlcfg.Backend, objstore.Options{
+		Bucket:   dlcfg.Bucket,
+		Region:   dlcfg.Region,
+		Endpoint: dlcfg.Endpoint,
+	})
+	handleError(dlcfg.Notifier, "Failed to open "+dlcfg.Backend+" bucket "+dlcfg.Bucket, err)
+
+	d := &objstore.Downloader{
+		Store:    store,
+		DestDir:  dlcfg.DestDir,
+		Prefix:   dlcfg.Prefix,
+		Results:  dlcfg.Results,
+		Archive:  dlcfg.Archive,
+		Workers:  dlcfg.Workers,
+		Manifest: manifest,
+	}
+	for _, t := range dlcfg.Types {
+		report, err := d.Download(ctx, t)
+		handleError(dlcfg.Notifier, "Failed to download "+t+" from "+dlcfg.Bucket, err)
+		msg := fmt.Sprintf("Downloaded %d %s files (%d bytes) from %s %s", report.Files, t, report.Bytes, dlcfg.Backend, dlcfg.Bucket)
+		log.Println(msg, report.Dirs)
+		if len(report.Failed) > 0 {
+			notify.Report(dlcfg.Notifier, notify.SeverityError, msg, append([]string{"failed:"}, report.Failed...)...)
+		} else {
+			notify.Report(dlcfg.Notifier, notify.SeverityInfo, msg, append([]string{"from"}, report.Dirs...)...)
+		}
+	}
+}
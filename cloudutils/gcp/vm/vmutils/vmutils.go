@@ -2,6 +2,7 @@ package vmutils
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sort"
@@ -10,7 +11,6 @@ import (
 	"strings"
 	"sync"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/compute/v1"
 )
@@ -22,36 +22,24 @@ type kv struct {
 	Value int
 }
 
-// HandleGCPErr log the err and stop the program
-func HandleGCPErr(err error) {
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
 /* Getter */
 
 // GetInstance get instance info
-func GetInstance(ctx context.Context, project, zone, instance string) *compute.Instance {
-	c, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
-	HandleGCPErr(err)
-
-	computeService, err := compute.New(c)
-	HandleGCPErr(err)
-
-	resp, err := computeService.Instances.Get(project, zone, instance).Context(ctx).Do()
-	HandleGCPErr(err)
+func GetInstance(ctx context.Context, project, zone, instance string) (*compute.Instance, error) {
+	computeService, err := newComputeService(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	return resp
+	return computeService.Instances.Get(project, zone, instance).Context(ctx).Do()
 }
 
 // ListInstances list all the vms under zone
-func ListInstances(ctx context.Context, zone, project string) []*compute.Instance {
-	c, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
-	HandleGCPErr(err)
-
-	computeService, err := compute.New(c)
-	HandleGCPErr(err)
+func ListInstances(ctx context.Context, zone, project string) ([]*compute.Instance, error) {
+	computeService, err := newComputeService(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	res := []*compute.Instance{}
 	req := computeService.Instances.List(project, zone)
@@ -61,18 +49,19 @@ func ListInstances(ctx context.Context, zone, project string) []*compute.Instanc
 		}
 		return nil
 	})
-	HandleGCPErr(err)
+	if err != nil {
+		return nil, err
+	}
 
-	return res
+	return res, nil
 }
 
 // GetAvailableMachineType get available machine type under the zone
-func GetAvailableMachineType(ctx context.Context, zone, project string) []*compute.MachineType {
-	c, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
-	HandleGCPErr(err)
-
-	computeService, err := compute.New(c)
-	HandleGCPErr(err)
+func GetAvailableMachineType(ctx context.Context, zone, project string) ([]*compute.MachineType, error) {
+	computeService, err := newComputeService(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	res := []*compute.MachineType{}
 	// fetch machine types
@@ -83,18 +72,19 @@ func GetAvailableMachineType(ctx context.Context, zone, project string) []*compu
 		}
 		return nil
 	})
-	HandleGCPErr(err)
+	if err != nil {
+		return nil, err
+	}
 
-	return res
+	return res, nil
 }
 
 // GetZones get available zones
-func GetZones(ctx context.Context, project string) []*compute.Zone {
-	c, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
-	HandleGCPErr(err)
-
-	computeService, err := compute.New(c)
-	HandleGCPErr(err)
+func GetZones(ctx context.Context, project string) ([]*compute.Zone, error) {
+	computeService, err := newComputeService(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	res := []*compute.Zone{}
 	req := computeService.Zones.List(project)
@@ -104,13 +94,15 @@ func GetZones(ctx context.Context, project string) []*compute.Zone {
 		}
 		return nil
 	})
-	HandleGCPErr(err)
+	if err != nil {
+		return nil, err
+	}
 
-	return res
+	return res, nil
 }
 
 // GetZoneCount count zones of current region return (zone -> number of vms in this zone)
-func GetZoneCount(ctx context.Context, zones []string, project string) map[string]int {
+func GetZoneCount(ctx context.Context, zones []string, project string) (map[string]int, error) {
 	// init zone count
 	zoneMap := make(map[string]int)
 	for _, val := range zones {
@@ -119,16 +111,22 @@ func GetZoneCount(ctx context.Context, zones []string, project string) map[strin
 
 	// count zones
 	for _, zone := range zones {
-		vms := ListInstances(ctx, zone, project)
+		vms, err := ListInstances(ctx, zone, project)
+		if err != nil {
+			return nil, err
+		}
 		zoneMap[zone] += len(vms)
 	}
 
-	return zoneMap
+	return zoneMap, nil
 }
 
-// GetNextAvailableZone get next available zone and assigned it to the vm
-func GetNextAvailableZone(ctx context.Context, project, region string) string {
-	allZones := GetZones(ctx, project)
+// GetRegionZones get names of the zones in region
+func GetRegionZones(ctx context.Context, project, region string) ([]string, error) {
+	allZones, err := GetZones(ctx, project)
+	if err != nil {
+		return nil, err
+	}
 	zoneNames := []string{}
 	for _, ele := range allZones {
 		zoneNames = append(zoneNames, ele.Name)
@@ -137,8 +135,23 @@ func GetNextAvailableZone(ctx context.Context, project, region string) string {
 	zoneNames = Filter(zoneNames, region, func(name, region string) bool {
 		return strings.HasPrefix(name, region)
 	})
+	return zoneNames, nil
+}
+
+// GetNextAvailableZone get next available zone and assigned it to the vm
+func GetNextAvailableZone(ctx context.Context, project, region string) (string, error) {
+	zoneNames, err := GetRegionZones(ctx, project, region)
+	if err != nil {
+		return "", err
+	}
+	if len(zoneNames) == 0 {
+		return "", fmt.Errorf("no zone found in region %s", region)
+	}
 
-	zoneCnt := GetZoneCount(ctx, zoneNames, project)
+	zoneCnt, err := GetZoneCount(ctx, zoneNames, project)
+	if err != nil {
+		return "", err
+	}
 
 	// sort zones by cnt
 	var sortedZones []kv
@@ -147,11 +160,14 @@ func GetNextAvailableZone(ctx context.Context, project, region string) string {
 	}
 
 	sort.Slice(sortedZones, func(i, j int) bool {
-		return sortedZones[i].Value < sortedZones[j].Value
+		if sortedZones[i].Value != sortedZones[j].Value {
+			return sortedZones[i].Value < sortedZones[j].Value
+		}
+		return sortedZones[i].Key < sortedZones[j].Key
 	})
 
-	fmt.Println(zoneCnt)
-	return sortedZones[0].Key
+	log.Println(zoneCnt)
+	return sortedZones[0].Key, nil
 }
 
 // InstanceToVMInfo convert instance type to vmInfo type
@@ -161,12 +177,17 @@ func InstanceToVMInfo(instances []*compute.Instance) []spdb.VMInfo {
 		vm := spdb.VMInfo{
 			Type:   "gcp",
 			Name:   instance.Name,
-			ID:     strconv.Itoa(int(instance.Id)),
+			ID:     strconv.FormatUint(instance.Id, 10),
 			Status: instance.Status,
 			Zone:   instance.Zone,
-			Ipv4:   instance.NetworkInterfaces[0].AccessConfigs[0].NatIP,
+			Ipv4:   "N/A",
 			DNS:    "N/A",
 		}
+		// stopped instances have no external ip
+		if len(instance.NetworkInterfaces) > 0 && len(instance.NetworkInterfaces[0].AccessConfigs) > 0 &&
+			len(instance.NetworkInterfaces[0].AccessConfigs[0].NatIP) > 0 {
+			vm.Ipv4 = instance.NetworkInterfaces[0].AccessConfigs[0].NatIP
+		}
 		vms = append(vms, vm)
 	}
 
@@ -174,59 +195,65 @@ func InstanceToVMInfo(instances []*compute.Instance) []spdb.VMInfo {
 }
 
 // GetInstanceInfo return instances information in spdb.VMInfo format
-func GetInstanceInfo(ctx context.Context, zone, project string) []spdb.VMInfo {
-	instances := ListInstances(ctx, zone, project)
-	vmInfoArr := InstanceToVMInfo(instances)
-	return vmInfoArr
+func GetInstanceInfo(ctx context.Context, zone, project string) ([]spdb.VMInfo, error) {
+	instances, err := ListInstances(ctx, zone, project)
+	if err != nil {
+		return nil, err
+	}
+	return InstanceToVMInfo(instances), nil
 }
 
 /* Setter */
 
 // StartInstance start instance with given name and give zone, return the instance id
-func StartInstance(ctx context.Context, instance, zone, project string) string {
-	c, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
-	HandleGCPErr(err)
-
-	computeService, err := compute.New(c)
-	HandleGCPErr(err)
+func StartInstance(ctx context.Context, instance, zone, project string) (string, error) {
+	computeService, err := newComputeService(ctx)
+	if err != nil {
+		return "", err
+	}
 
 	// launch op
 	resp, err := computeService.Instances.Start(project, zone, instance).Context(ctx).Do()
-	HandleGCPErr(err)
+	if err != nil {
+		return "", err
+	}
 
 	log.Println("Start Instance", resp.TargetId, "Status Code:", resp.ServerResponse.HTTPStatusCode,
 		"Current Operation Status:", resp.Status)
-	return strconv.Itoa(int(resp.TargetId))
+	return strconv.FormatUint(resp.TargetId, 10), nil
 }
 
 // StopInstance start instance with given name and give zone, return the instance id
-func StopInstance(ctx context.Context, instance, zone, project string) string {
-	c, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
-	HandleGCPErr(err)
-
-	computeService, err := compute.New(c)
-	HandleGCPErr(err)
+func StopInstance(ctx context.Context, instance, zone, project string) (string, error) {
+	computeService, err := newComputeService(ctx)
+	if err != nil {
+		return "", err
+	}
 
 	// launch op
 	resp, err := computeService.Instances.Stop(project, zone, instance).Context(ctx).Do()
-	HandleGCPErr(err)
+	if err != nil {
+		return "", err
+	}
 
 	log.Println("Stop Instance", resp.TargetId, "Status Code:", resp.ServerResponse.HTTPStatusCode,
 		"Current Operation Status:", resp.Status)
-	return strconv.Itoa(int(resp.TargetId))
+	return strconv.FormatUint(resp.TargetId, 10), nil
 }
 
 // CreateInstance create vm with specified parameters, by default, machine type will be n1-standard-2
-// or n2-standard-2
-func CreateInstance(ctx context.Context, project, zone, name string) {
-	c, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
-	HandleGCPErr(err)
-
-	computeService, err := compute.New(c)
-	HandleGCPErr(err)
+// or n2-standard-2. returns the instance id
+func CreateInstance(ctx context.Context, project, zone, name string) (string, error) {
+	computeService, err := newComputeService(ctx)
+	if err != nil {
+		return "", err
+	}
 
 	// find next machine
-	machineTypes := GetAvailableMachineType(ctx, zone, project)
+	machineTypes, err := GetAvailableMachineType(ctx, zone, project)
+	if err != nil {
+		return "", err
+	}
 	targetType := "n1-standard-2"
 	for _, ele := range machineTypes {
 		if ele.Name == "n2-standard-2" {
@@ -268,46 +295,73 @@ func CreateInstance(ctx context.Context, project, zone, name string) {
 	}
 
 	resp, err := computeService.Instances.Insert(project, zone, rb).Context(ctx).Do()
-	HandleGCPErr(err)
+	if err != nil {
+		return "", err
+	}
 
 	log.Println("Created Instance", resp.TargetId, "Status Code:", resp.ServerResponse.HTTPStatusCode,
 		"Current Operation Status:", resp.Status)
+	return strconv.FormatUint(resp.TargetId, 10), nil
 }
 
 // DeleteInstance start instance with given name and give zone, return the instance id
 // disk will be deleted since autodelete enabled
-func DeleteInstance(ctx context.Context, instance, zone, project string) string {
-	c, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
-	HandleGCPErr(err)
-
-	computeService, err := compute.New(c)
-	HandleGCPErr(err)
+func DeleteInstance(ctx context.Context, instance, zone, project string) (string, error) {
+	computeService, err := newComputeService(ctx)
+	if err != nil {
+		return "", err
+	}
 
 	// launch op
 	resp, err := computeService.Instances.Delete(project, zone, instance).Context(ctx).Do()
-	HandleGCPErr(err)
+	if err != nil {
+		return "", err
+	}
 
 	log.Println("Delete Instance", resp.TargetId, "Status Code:", resp.ServerResponse.HTTPStatusCode,
 		"Current Operation Status:", resp.Status)
-	return strconv.Itoa(int(resp.TargetId))
+	return strconv.FormatUint(resp.TargetId, 10), nil
 }
 
 /* DB helper functions */
 
 // UpdateLocalVMInfo update local vm information
-func UpdateLocalVMInfo(ctx context.Context, dbConfigPath, project string) {
-	db := spdb.InitMongoDB(dbConfigPath)
-	filter := bson.D{{"type", "gcp"}}
-	spdb.ClearCollection(*db.Database.Collection(spdb.VMCollection), filter)
-	for _, zone := range GetZones(ctx, project) {
+func UpdateLocalVMInfo(ctx context.Context, dbConfigPath, project string) error {
+	zones, err := GetZones(ctx, project)
+	if err != nil {
+		return fmt.Errorf("list zones of %s: %w", project, err)
+	}
+	vms := []spdb.VMInfo{}
+	errs := []error{}
+	var vmsLock sync.Mutex
+	for _, zone := range zones {
 		wg.Add(1)
 		go func(zoneName string) {
 			defer wg.Done()
-			vmInfoArr := GetInstanceInfo(ctx, zoneName, project)
-			spdb.InsertInstanceIDName(db, vmInfoArr, spdb.VMCollection)
+			vmInfoArr, err := GetInstanceInfo(ctx, zoneName, project)
+			vmsLock.Lock()
+			defer vmsLock.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("list instances of %s: %w", zoneName, err))
+				return
+			}
+			vms = append(vms, vmInfoArr...)
 		}(zone.Name)
 	}
 	wg.Wait()
+	//keep the stored vms if any zone could not be listed
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	db, err := spdb.NewMongoDB(dbConfigPath, "speedtest")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.ClearVMs(ctx, spdb.VMCollection, "gcp"); err != nil {
+		return err
+	}
+	return db.UpsertVMs(ctx, spdb.VMCollection, vms)
 }
 
 // Filter filtering string matching the condition
@@ -320,3 +374,11 @@ func Filter(vs []string, b string, f func(a, b string) bool) []string {
 	}
 	return vsf
 }
+
+func newComputeService(ctx context.Context) (*compute.Service, error) {
+	c, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+	return compute.New(c)
+}
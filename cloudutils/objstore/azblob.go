@@ -0,0 +1,133 @@
+package objstore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// AzureBlob is an azure storage container, credentials are read from
+// AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_ACCESS_KEY
+type AzureBlob struct {
+	Container string
+	// Tier of archived blobs
+	Tier      azblob.AccessTierType
+	container azblob.ContainerURL
+}
+
+// NewAzureBlob create the AzureBlob store of container
+func NewAzureBlob(container string) (*AzureBlob, error) {
+	accountName, accountKey := os.Getenv("AZURE_STORAGE_ACCOUNT"), os.Getenv("AZURE_STORAGE_ACCESS_KEY")
+	if len(accountName) == 0 || len(accountKey) == 0 {
+		return nil, errors.New("Either the AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_ACCESS_KEY environment variable is not set")
+	}
+
+	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return nil, err
+	}
+	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+	u, err := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", accountName, container))
+	if err != nil {
+		return nil, err
+	}
+	return &AzureBlob{Container: container, Tier: azblob.AccessTierCool, container: azblob.NewContainerURL(*u, p)}, nil
+}
+
+// Dirs get top level folders of the container
+func (b *AzureBlob) Dirs(ctx context.Context, prefix string) ([]string, error) {
+	dirs := []string{}
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		list, err := b.container.ListBlobsHierarchySegment(ctx, marker, "/", azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, err
+		}
+		marker = list.NextMarker
+		for _, p := range list.Segment.BlobPrefixes {
+			if !isArchive(p.Name) {
+				dirs = append(dirs, p.Name)
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// List list all blobs under prefix
+func (b *AzureBlob) List(ctx context.Context, prefix string) ([]Object, error) {
+	objs := []Object{}
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		list, err := b.container.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, err
+		}
+		marker = list.NextMarker
+		for _, blob := range list.Segment.BlobItems {
+			obj := Object{
+				Key:      blob.Name,
+				ETag:     strings.Trim(string(blob.Properties.Etag), `"`),
+				MD5:      hex.EncodeToString(blob.Properties.ContentMD5),
+				Modified: blob.Properties.LastModified,
+			}
+			if blob.Properties.ContentLength != nil {
+				obj.Size = *blob.Properties.ContentLength
+			}
+			objs = append(objs, obj)
+		}
+	}
+	return objs, nil
+}
+
+// Get download key from offset into w
+func (b *AzureBlob) Get(ctx context.Context, key string, offset int64, w io.Writer) (int64, error) {
+	resp, err := b.container.NewBlobURL(key).Download(ctx, offset, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		return 0, err
+	}
+	// NOTE: automatically retries are performed if the connection fails
+	body := resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: 20})
+	defer body.Close()
+	return io.Copy(w, body)
+}
+
+// Archive copy key into the archive folder, set its access tier and delete the original
+func (b *AzureBlob) Archive(ctx context.Context, key string) error {
+	src := b.container.NewBlobURL(key)
+	dst := b.container.NewBlobURL(ArchiveKey(key))
+	resp, err := dst.StartCopyFromURL(ctx, src.URL(), azblob.Metadata{},
+		azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{})
+	if err != nil {
+		return err
+	}
+
+	// the copy is asynchronous, wait for it before deleting the source
+	status := resp.CopyStatus()
+	for status == azblob.CopyStatusPending {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+		props, err := dst.GetProperties(ctx, azblob.BlobAccessConditions{})
+		if err != nil {
+			return err
+		}
+		status = props.CopyStatus()
+	}
+	if status != azblob.CopyStatusSuccess {
+		return fmt.Errorf("copy of %s to archive ended with status %s", key, status)
+	}
+
+	if _, err := dst.SetTier(ctx, b.Tier, azblob.LeaseAccessConditions{}); err != nil {
+		return err
+	}
+	_, err = src.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+	return err
+}
@@ -0,0 +1,237 @@
+package objstore
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Data types of the result files
+const (
+	Bdrmap = "bdrmap"
+	Trace  = "trace"
+)
+
+// PartSuffix is appended to the name of files being downloaded
+const PartSuffix = ".part"
+
+// ETagSuffix is appended to the name of a part file to record the etag of the
+// object it holds the beginning of
+const ETagSuffix = ".etag"
+
+// Downloader fetch result files of every vm folder of a store into
+// DestDir/bdrmap/<vm> and DestDir/trace/<vm>/<year>/<month>
+type Downloader struct {
+	Store ObjectStore
+	// DestDir is the local result directory
+	DestDir string
+	// Prefix selects the vm folders, e.g. "aws"
+	Prefix string
+	// Results is the folder between the vm folder and the result files, e.g. "results/"
+	Results string
+	// Archive moves downloaded files into the archive folder of the store
+	Archive bool
+	// Workers is the number of concurrent downloads
+	Workers int
+	// Manifest records the downloaded objects, optional
+	Manifest *Manifest
+}
+
+// Report summarize the download of one data type
+type Report struct {
+	DataType string
+	Dirs     []string
+	Files    int
+	Bytes    int64
+	Failed   []string
+}
+
+// LocalPath return where obj of dataType is stored, bdrmap files stay flat under
+// the vm directory, traces are split by the year and month they were uploaded
+func (d *Downloader) LocalPath(dataType string, vm string, obj Object) string {
+	if dataType == Trace {
+		return filepath.Join(d.DestDir, dataType, vm, strconv.Itoa(obj.Modified.Year()),
+			strconv.Itoa(int(obj.Modified.Month())), path.Base(obj.Key))
+	}
+	return filepath.Join(d.DestDir, dataType, vm, path.Base(obj.Key))
+}
+
+// Download fetch all files of dataType, failed files are logged, kept in the store and returned in the report
+func (d *Downloader) Download(ctx context.Context, dataType string) (*Report, error) {
+	dirs, err := d.Store.Dirs(ctx, d.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{DataType: dataType, Dirs: dirs}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	type job struct {
+		vm  string
+		obj Object
+	}
+	jobs := make(chan job)
+	workers := d.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				n, err := d.fetch(ctx, dataType, j.vm, j.obj)
+				mu.Lock()
+				if err != nil {
+					log.Println("Failed to download", j.obj.Key, err)
+					report.Failed = append(report.Failed, j.obj.Key)
+				} else {
+					report.Files++
+					report.Bytes += n
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, dir := range dirs {
+		objs, err := d.Store.List(ctx, dir+d.Results+dataType)
+		if err != nil {
+			log.Println("Failed to list", dir, err)
+			mu.Lock()
+			report.Failed = append(report.Failed, dir)
+			mu.Unlock()
+			continue
+		}
+		vm := path.Clean(dir)
+		for _, obj := range objs {
+			select {
+			case jobs <- job{vm: vm, obj: obj}:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return report, ctx.Err()
+}
+
+// fetch download one object, resuming the partial file of an earlier run if it
+// holds the same etag, and archive it once the local copy matches the size and
+// md5 of the object
+func (d *Downloader) fetch(ctx context.Context, dataType string, vm string, obj Object) (int64, error) {
+	fileName := d.LocalPath(dataType, vm, obj)
+
+	// downloaded by an earlier run that stopped before archiving
+	if e, ok := d.manifestEntry(obj.Key); ok && e.ETag == obj.ETag && e.Path == fileName {
+		if _, err := VerifyFile(fileName, obj.Size, obj.MD5); err == nil {
+			return 0, d.archive(ctx, e)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return 0, err
+	}
+	part := fileName + PartSuffix
+	etagFile := part + ETagSuffix
+	file, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := file.Seek(0, io.SeekEnd)
+	// multipart objects have no md5 to catch a file spliced from two versions,
+	// so only resume a part file of the same etag
+	if err == nil && offset > 0 && (offset > obj.Size || obj.ETag == "" || partETag(etagFile) != obj.ETag) {
+		log.Println("Restarting", fileName, "the object changed since the partial download")
+		offset = 0
+		err = file.Truncate(0)
+		if err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+	}
+	if err == nil && offset == 0 {
+		err = ioutil.WriteFile(etagFile, []byte(obj.ETag), 0644)
+	}
+	var n int64
+	if err == nil && offset < obj.Size {
+		if offset > 0 {
+			log.Println("Resuming", fileName, "at", offset, "bytes")
+		}
+		n, err = d.Store.Get(ctx, obj.Key, offset, file)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, err
+	}
+
+	sum, err := VerifyFile(part, obj.Size, obj.MD5)
+	if err != nil {
+		// the partial file cannot be trusted anymore, download it again on the next run
+		os.Remove(part)
+		os.Remove(etagFile)
+		return n, err
+	}
+	if err := os.Rename(part, fileName); err != nil {
+		return n, err
+	}
+	os.Remove(etagFile)
+	log.Println("Downloaded", fileName, n, "bytes")
+
+	e := ManifestEntry{
+		Key:          obj.Key,
+		Size:         obj.Size,
+		ETag:         obj.ETag,
+		MD5:          sum,
+		Path:         fileName,
+		DownloadedAt: time.Now(),
+	}
+	if d.Manifest != nil {
+		if err := d.Manifest.Record(e); err != nil {
+			return n, err
+		}
+	}
+	return n, d.archive(ctx, e)
+}
+
+// archive move a verified object into the archive folder and record it
+func (d *Downloader) archive(ctx context.Context, e ManifestEntry) error {
+	if !d.Archive {
+		return nil
+	}
+	if err := d.Store.Archive(ctx, e.Key); err != nil {
+		return err
+	}
+	if d.Manifest == nil {
+		return nil
+	}
+	e.ArchivedAt = time.Now()
+	return d.Manifest.Record(e)
+}
+
+// partETag return the etag recorded next to a part file, empty if there is none
+func partETag(etagFile string) string {
+	etag, err := ioutil.ReadFile(etagFile)
+	if err != nil {
+		return ""
+	}
+	return string(etag)
+}
+
+func (d *Downloader) manifestEntry(key string) (ManifestEntry, bool) {
+	if d.Manifest == nil {
+		return ManifestEntry{}, false
+	}
+	return d.Manifest.Get(key)
+}
@@ -0,0 +1,265 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeObjects create the files of a Local store, keys map to their content,
+// all uploaded at modified
+func writeObjects(t *testing.T, root string, objs map[string]string, modified time.Time) {
+	for key, content := range objs {
+		path := filepath.Join(root, filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkFile fail unless path holds content
+func checkFile(t *testing.T, path, content string) {
+	t.Helper()
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("read %s: %v", path, err)
+	} else if string(got) != content {
+		t.Errorf("%s = %q, want %q", path, got, content)
+	}
+}
+
+// traces are split by the year and month they were uploaded, bdrmap results
+// stay flat, and downloaded objects move into the archive folder of the store
+func TestDownloadLayout(t *testing.T) {
+	ctx := context.Background()
+	root, dest := t.TempDir(), t.TempDir()
+	modified := time.Date(2020, 9, 13, 12, 0, 0, 0, time.Local)
+	writeObjects(t, root, map[string]string{
+		"aws-1/trace/trace_1600000000.warts.gz":         "trace 1",
+		"aws-1/trace/trace_1600003600.warts.gz":         "trace 2",
+		"aws-1/bdrmap/aws-1.1600000000.tar.bz2":         "bdrmap 1",
+		"aws-2/trace/trace_1600000000.warts.gz":         "trace 3",
+		"gcp-1/trace/trace_1600000000.warts.gz":         "other prefix",
+		"archive/aws-1/trace/trace_1599000000.warts.gz": "archived",
+	}, modified)
+	store, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := OpenManifest(filepath.Join(dest, "manifest.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manifest.Close()
+	d := &Downloader{Store: store, DestDir: dest, Prefix: "aws", Archive: true, Workers: 2, Manifest: manifest}
+
+	for _, tt := range []struct {
+		dataType string
+		files    int
+	}{{Trace, 3}, {Bdrmap, 1}} {
+		report, err := d.Download(ctx, tt.dataType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Files != tt.files || len(report.Failed) != 0 || len(report.Dirs) != 2 {
+			t.Errorf("%s report = %+v, want %d files of 2 dirs", tt.dataType, report, tt.files)
+		}
+	}
+
+	checkFile(t, filepath.Join(dest, Trace, "aws-1", "2020", "9", "trace_1600000000.warts.gz"), "trace 1")
+	checkFile(t, filepath.Join(dest, Trace, "aws-1", "2020", "9", "trace_1600003600.warts.gz"), "trace 2")
+	checkFile(t, filepath.Join(dest, Trace, "aws-2", "2020", "9", "trace_1600000000.warts.gz"), "trace 3")
+	checkFile(t, filepath.Join(dest, Bdrmap, "aws-1", "aws-1.1600000000.tar.bz2"), "bdrmap 1")
+	if _, err := os.Stat(filepath.Join(dest, Trace, "gcp-1")); !os.IsNotExist(err) {
+		t.Errorf("vm folder of another prefix downloaded")
+	}
+
+	// the objects moved into the archive folder
+	for key, content := range map[string]string{
+		"aws-1/trace/trace_1600000000.warts.gz": "trace 1",
+		"aws-1/bdrmap/aws-1.1600000000.tar.bz2": "bdrmap 1",
+		"aws-2/trace/trace_1600000000.warts.gz": "trace 3",
+	} {
+		if _, err := os.Stat(store.path(key)); !os.IsNotExist(err) {
+			t.Errorf("%s still in the store", key)
+		}
+		checkFile(t, store.path(ArchiveKey(key)), content)
+		if e, ok := manifest.Get(key); !ok || e.ArchivedAt.IsZero() {
+			t.Errorf("manifest entry of %s = %+v", key, e)
+		}
+	}
+	checkFile(t, store.path("gcp-1/trace/trace_1600000000.warts.gz"), "other prefix")
+	if problems, err := manifest.Audit(dest); err != nil || len(problems) != 0 {
+		t.Errorf("audit = %v, %v", problems, err)
+	}
+
+	// nothing is left to download
+	report, err := d.Download(ctx, Trace)
+	if err != nil || report.Files != 0 {
+		t.Errorf("second run report = %+v, %v", report, err)
+	}
+}
+
+// a file downloaded by a run that did not archive is archived by the next run
+// without downloading it again
+func TestDownloadArchiveLater(t *testing.T) {
+	ctx := context.Background()
+	root, dest := t.TempDir(), t.TempDir()
+	key := "aws-1/trace/trace_1600000000.warts.gz"
+	writeObjects(t, root, map[string]string{key: "trace 1"}, time.Date(2020, 9, 13, 12, 0, 0, 0, time.Local))
+	store, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := OpenManifest(filepath.Join(dest, "manifest.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manifest.Close()
+	d := &Downloader{Store: store, DestDir: dest, Manifest: manifest}
+
+	if report, err := d.Download(ctx, Trace); err != nil || report.Files != 1 || report.Bytes != 7 {
+		t.Fatalf("report = %+v, %v", report, err)
+	}
+	checkFile(t, store.path(key), "trace 1")
+
+	d.Archive = true
+	if report, err := d.Download(ctx, Trace); err != nil || report.Files != 1 || report.Bytes != 0 {
+		t.Errorf("archive run report = %+v, %v", report, err)
+	}
+	if _, err := os.Stat(store.path(key)); !os.IsNotExist(err) {
+		t.Errorf("%s not archived", key)
+	}
+	checkFile(t, store.path(ArchiveKey(key)), "trace 1")
+}
+
+// newTestStore create a Local store holding one trace of vm aws-1
+func newTestStore(t *testing.T, content []byte) (*Local, Object) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "aws-1", Trace), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "aws-1", Trace, "trace_1600000000.warts.gz"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs, err := store.List(context.Background(), "aws-1/")
+	if err != nil || len(objs) != 1 {
+		t.Fatalf("List = %v, %v", objs, err)
+	}
+	return store, objs[0]
+}
+
+// writePart leave a partial download of data, with etag recorded next to it if not empty
+func writePart(t *testing.T, fileName string, data []byte, etag string) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fileName+PartSuffix, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if etag != "" {
+		if err := ioutil.WriteFile(fileName+PartSuffix+ETagSuffix, []byte(etag), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	other := bytes.Repeat([]byte("abcdefghij"), 100)
+	tests := []struct {
+		name  string
+		part  []byte
+		etag  string
+		bytes int64
+	}{
+		{"same etag", content[:400], "", 600},
+		{"changed object", other[:400], "0000", 1000},
+		{"no etag recorded", other[:400], "-", 1000},
+		{"part longer than object", append(append([]byte{}, content...), '!'), "", 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, obj := newTestStore(t, content)
+			d := &Downloader{Store: store, DestDir: t.TempDir(), Archive: true}
+			fileName := d.LocalPath(Trace, "aws-1", obj)
+			etag := tt.etag
+			switch etag {
+			case "":
+				etag = obj.ETag
+			case "-":
+				etag = ""
+			}
+			writePart(t, fileName, tt.part, etag)
+
+			report, err := d.Download(context.Background(), Trace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Files != 1 || report.Bytes != tt.bytes || len(report.Failed) != 0 {
+				t.Errorf("report = %+v, want 1 file of %d bytes", report, tt.bytes)
+			}
+			got, err := ioutil.ReadFile(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("downloaded file differs from the object")
+			}
+			for _, left := range []string{fileName + PartSuffix, fileName + PartSuffix + ETagSuffix} {
+				if _, err := os.Stat(left); !os.IsNotExist(err) {
+					t.Errorf("%s left after the download", left)
+				}
+			}
+		})
+	}
+}
+
+// a resumed file that does not match the md5 of the object is dropped and the
+// object stays in the store
+func TestDownloadVerify(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	store, obj := newTestStore(t, content)
+	d := &Downloader{Store: store, DestDir: t.TempDir(), Archive: true}
+	fileName := d.LocalPath(Trace, "aws-1", obj)
+	writePart(t, fileName, bytes.Repeat([]byte("x"), 400), obj.ETag)
+
+	report, err := d.Download(context.Background(), Trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Files != 0 || len(report.Failed) != 1 || report.Failed[0] != obj.Key {
+		t.Errorf("report = %+v, want %s failed", report, obj.Key)
+	}
+	for _, left := range []string{fileName, fileName + PartSuffix, fileName + PartSuffix + ETagSuffix} {
+		if _, err := os.Stat(left); !os.IsNotExist(err) {
+			t.Errorf("%s kept after a failed verification", left)
+		}
+	}
+	if _, err := os.Stat(store.path(obj.Key)); err != nil {
+		t.Errorf("object archived after a failed verification: %v", err)
+	}
+
+	// the next run downloads it from the start
+	report, err = d.Download(context.Background(), Trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Files != 1 || report.Bytes != int64(len(content)) {
+		t.Errorf("report = %+v, want the whole object", report)
+	}
+}
@@ -0,0 +1,91 @@
+package objstore
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// GCS is a google cloud storage bucket, credentials are read from GOOGLE_APPLICATION_CREDENTIALS
+type GCS struct {
+	Bucket string
+	// StorageClass of archived objects
+	StorageClass string
+	client       *storage.Client
+}
+
+// NewGCS create the GCS store of bucket
+func NewGCS(ctx context.Context, bucket string) (*GCS, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &GCS{Bucket: bucket, StorageClass: "COLDLINE", client: client}, nil
+}
+
+// Dirs get top level folders of the bucket
+func (b *GCS) Dirs(ctx context.Context, prefix string) ([]string, error) {
+	it := b.client.Bucket(b.Bucket).Objects(ctx, &storage.Query{Prefix: prefix, Delimiter: "/"})
+	dirs := []string{}
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attrs.Prefix != "" && !isArchive(attrs.Prefix) {
+			dirs = append(dirs, attrs.Prefix)
+		}
+	}
+	return dirs, nil
+}
+
+// List list all objects under prefix
+func (b *GCS) List(ctx context.Context, prefix string) ([]Object, error) {
+	it := b.client.Bucket(b.Bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	objs := []Object{}
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, Object{
+			Key:      attrs.Name,
+			Size:     attrs.Size,
+			ETag:     attrs.Etag,
+			MD5:      hex.EncodeToString(attrs.MD5),
+			Modified: attrs.Created,
+		})
+	}
+	return objs, nil
+}
+
+// Get download key from offset into w
+func (b *GCS) Get(ctx context.Context, key string, offset int64, w io.Writer) (int64, error) {
+	rc, err := b.client.Bucket(b.Bucket).Object(key).NewRangeReader(ctx, offset, -1)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(w, rc)
+}
+
+// Archive copy key into the archive folder and delete the original
+func (b *GCS) Archive(ctx context.Context, key string) error {
+	src := b.client.Bucket(b.Bucket).Object(key)
+	dst := b.client.Bucket(b.Bucket).Object(ArchiveKey(key))
+	copier := dst.CopierFrom(src)
+	copier.ObjectAttrs = storage.ObjectAttrs{StorageClass: b.StorageClass}
+	if _, err := copier.Run(ctx); err != nil {
+		return err
+	}
+	return src.Delete(ctx)
+}
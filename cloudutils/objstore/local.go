@@ -0,0 +1,113 @@
+package objstore
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local is a directory laid out like a bucket, it stands in for the cloud
+// buckets when testing the download and archive flow offline
+type Local struct {
+	Root string
+}
+
+// NewLocal create the Local store rooted at root
+func NewLocal(root string) (*Local, error) {
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+	return &Local{Root: root}, nil
+}
+
+// Dirs get top level folders of root
+func (b *Local) Dirs(ctx context.Context, prefix string) ([]string, error) {
+	infos, err := ioutil.ReadDir(b.Root)
+	if err != nil {
+		return nil, err
+	}
+	dirs := []string{}
+	for _, info := range infos {
+		dir := info.Name() + "/"
+		if info.IsDir() && strings.HasPrefix(dir, prefix) && !isArchive(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
+
+// List list all files under prefix, the etag is the md5 of the file like for
+// s3 objects uploaded in one part
+func (b *Local) List(ctx context.Context, prefix string) ([]Object, error) {
+	objs := []Object{}
+	err := filepath.Walk(b.Root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		key, err := filepath.Rel(b.Root, path)
+		if err != nil {
+			return err
+		}
+		key = filepath.ToSlash(key)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		sum, err := md5File(path)
+		if err != nil {
+			return err
+		}
+		objs = append(objs, Object{Key: key, Size: info.Size(), ETag: sum, MD5: sum, Modified: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objs, nil
+}
+
+// Get copy key from offset into w
+func (b *Local) Get(ctx context.Context, key string, offset int64, w io.Writer) (int64, error) {
+	f, err := os.Open(b.path(key))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, f)
+}
+
+// Archive move key into the archive folder
+func (b *Local) Archive(ctx context.Context, key string) error {
+	dst := b.path(ArchiveKey(key))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.Rename(b.path(key), dst)
+}
+
+func (b *Local) path(key string) string {
+	return filepath.Join(b.Root, filepath.FromSlash(key))
+}
+
+func md5File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,188 @@
+package objstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSizeMismatch is returned when a downloaded file is not as large as the object
+	ErrSizeMismatch = errors.New("size mismatch")
+	// ErrChecksumMismatch is returned when the md5 of a downloaded file differs from the object
+	ErrChecksumMismatch = errors.New("md5 mismatch")
+)
+
+// ManifestEntry records one downloaded object
+type ManifestEntry struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	MD5          string    `json:"md5"`
+	Path         string    `json:"path"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+	ArchivedAt   time.Time `json:"archived_at"`
+}
+
+// Manifest is the append only log of downloaded objects, one json entry per
+// line, a later line of a key replaces the earlier ones
+type Manifest struct {
+	Path    string
+	mu      sync.Mutex
+	entries map[string]ManifestEntry
+	file    *os.File
+}
+
+// OpenManifest load the manifest at path and open it for appending, the file is
+// created if it does not exist
+func OpenManifest(path string) (*Manifest, error) {
+	m := &Manifest{Path: path, entries: make(map[string]ManifestEntry)}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			var e ManifestEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				// a crash can leave the last line half written
+				log.Println("Skip bad manifest entry", path, line, err)
+				continue
+			}
+			m.entries[e.Key] = e
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	m.file = f
+	return m, nil
+}
+
+// Get return the entry of key
+func (m *Manifest) Get(key string) (ManifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	return e, ok
+}
+
+// Record append e to the manifest
+func (m *Manifest) Record(e ManifestEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	m.entries[e.Key] = e
+	return nil
+}
+
+// Entries return all entries sorted by key
+func (m *Manifest) Entries() []ManifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]ManifestEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		res = append(res, e)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+// Close close the manifest file
+func (m *Manifest) Close() error {
+	return m.file.Close()
+}
+
+// VerifyFile check the file at path has size bytes and, when md5 is set, the
+// same md5, it returns the md5 of the file
+func VerifyFile(path string, size int64, md5 string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() != size {
+		return "", fmt.Errorf("%w: %d bytes, want %d", ErrSizeMismatch, info.Size(), size)
+	}
+	sum, err := md5File(path)
+	if err != nil {
+		return "", err
+	}
+	if md5 != "" && sum != md5 {
+		return sum, fmt.Errorf("%w: %s, want %s", ErrChecksumMismatch, sum, md5)
+	}
+	return sum, nil
+}
+
+// Problem is a difference between the manifest and the local tree
+type Problem struct {
+	Path string
+	Key  string
+	Err  error
+}
+
+// Audit check every manifest entry against its local file and list the files
+// under the bdrmap and trace directories of destDir the manifest does not know
+func (m *Manifest) Audit(destDir string) ([]Problem, error) {
+	problems := []Problem{}
+	known := make(map[string]bool)
+	for _, e := range m.Entries() {
+		known[filepath.Clean(e.Path)] = true
+		if _, err := VerifyFile(e.Path, e.Size, e.MD5); err != nil {
+			problems = append(problems, Problem{Path: e.Path, Key: e.Key, Err: err})
+		}
+	}
+
+	for _, dataType := range []string{Bdrmap, Trace} {
+		root := filepath.Join(destDir, dataType)
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == root {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() || known[filepath.Clean(path)] {
+				return nil
+			}
+			if strings.HasSuffix(path, PartSuffix+ETagSuffix) {
+				// recorded etag of a partial download, the part file is reported
+				return nil
+			}
+			if strings.HasSuffix(path, PartSuffix) {
+				problems = append(problems, Problem{Path: path, Err: errors.New("partial download")})
+			} else {
+				problems = append(problems, Problem{Path: path, Err: errors.New("not in manifest")})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return problems, nil
+}
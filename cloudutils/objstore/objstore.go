@@ -0,0 +1,88 @@
+// Package objstore gives the result buckets of aws/gcp/azure a common interface
+// so one downloader can fetch bdrmap and traceroute results from any of them.
+package objstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ArchiveDir is the folder of the bucket downloaded objects are moved into
+const ArchiveDir = "archive/"
+
+// Object is the metadata of a stored result file
+type Object struct {
+	Key  string
+	Size int64
+	ETag string
+	// MD5 is the hex md5 of the content, empty when the backend does not know it
+	MD5      string
+	Modified time.Time
+}
+
+// ObjectStore is implemented by each bucket backend
+type ObjectStore interface {
+	// Dirs returns the top level folders starting with prefix, each ending with "/"
+	Dirs(ctx context.Context, prefix string) ([]string, error)
+	// List returns all objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Get writes the content of key starting at byte offset into w and returns the number of bytes written
+	Get(ctx context.Context, key string, offset int64, w io.Writer) (int64, error)
+	// Archive moves key into ArchiveDir using the cold storage class of the backend
+	Archive(ctx context.Context, key string) error
+}
+
+// Options selects the bucket of a backend
+type Options struct {
+	// Bucket is the bucket (s3/gcs), the container (azure) or the root directory (local)
+	Bucket string
+	// Region is the region of an s3 bucket
+	Region string
+	// Endpoint overrides the s3 endpoint, e.g. a local MinIO server
+	Endpoint string
+}
+
+// Layout is where the results of a backend are by default
+type Layout struct {
+	Bucket string
+	// Prefix starts the names of the vm folders
+	Prefix string
+	// Results is the folder between the vm folder and the result files
+	Results string
+}
+
+// Defaults are the layouts of the existing buckets of each backend
+var Defaults = map[string]Layout{
+	"s3":    {Bucket: "cloudspeedtest", Prefix: "aws"},
+	"gcs":   {Bucket: "cloudspeedtest", Results: "results/"},
+	"azure": {Bucket: "cloudspeedtestcontainer"},
+	"local": {},
+}
+
+// New create the ObjectStore of backend s3, gcs, azure or local
+func New(ctx context.Context, backend string, opts Options) (ObjectStore, error) {
+	switch backend {
+	case "s3":
+		return NewS3(opts.Bucket, opts.Region, opts.Endpoint)
+	case "gcs":
+		return NewGCS(ctx, opts.Bucket)
+	case "azure":
+		return NewAzureBlob(opts.Bucket)
+	case "local":
+		return NewLocal(opts.Bucket)
+	}
+	return nil, fmt.Errorf("unknown object store backend %q", backend)
+}
+
+// ArchiveKey return the key of the archived copy of key
+func ArchiveKey(key string) string {
+	return ArchiveDir + key
+}
+
+// isArchive report whether dir is the archive folder
+func isArchive(dir string) bool {
+	return strings.TrimSuffix(dir, "/")+"/" == ArchiveDir
+}
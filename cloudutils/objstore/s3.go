@@ -0,0 +1,134 @@
+package objstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3 is an aws s3 bucket, or a bucket of an s3 compatible server such as MinIO
+type S3 struct {
+	Bucket string
+	// StorageClass of archived objects
+	StorageClass string
+	svc          *s3.S3
+}
+
+// NewS3 create the S3 store of bucket, endpoint is only set for s3 compatible servers
+func NewS3(bucket, region, endpoint string) (*S3, error) {
+	cfg := &aws.Config{Region: aws.String(region)}
+	if endpoint != "" {
+		cfg.Endpoint = aws.String(endpoint)
+		cfg.S3ForcePathStyle = aws.Bool(true)
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &S3{Bucket: bucket, StorageClass: s3.StorageClassOnezoneIa, svc: s3.New(sess)}, nil
+}
+
+// Dirs get top level folders of the bucket
+func (b *S3) Dirs(ctx context.Context, prefix string) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(b.Bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}
+
+	dirs := []string{}
+	err := b.svc.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, p := range page.CommonPrefixes {
+			if !isArchive(*p.Prefix) {
+				dirs = append(dirs, *p.Prefix)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// List list all objects under prefix
+func (b *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.Bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	objs := []Object{}
+	err := b.svc.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			etag := strings.Trim(aws.StringValue(o.ETag), `"`)
+			obj := Object{
+				Key:      aws.StringValue(o.Key),
+				Size:     aws.Int64Value(o.Size),
+				ETag:     etag,
+				Modified: aws.TimeValue(o.LastModified),
+			}
+			// the etag of objects uploaded in one part is their md5, multipart etags end with -<parts>
+			if len(etag) == 32 && !strings.Contains(etag, "-") {
+				obj.MD5 = etag
+			}
+			objs = append(objs, obj)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objs, nil
+}
+
+// Get download key from offset into w
+func (b *S3) Get(ctx context.Context, key string, offset int64, w io.Writer) (int64, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(key),
+	}
+	if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+	out, err := b.svc.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Body.Close()
+	return io.Copy(w, out.Body)
+}
+
+// Archive copy key into the archive folder and delete the original
+func (b *S3) Archive(ctx context.Context, key string) error {
+	dest := ArchiveKey(key)
+	_, err := b.svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:       aws.String(b.Bucket),
+		CopySource:   aws.String(b.Bucket + "/" + key),
+		Key:          aws.String(dest),
+		StorageClass: aws.String(b.StorageClass),
+	})
+	if err != nil {
+		return err
+	}
+
+	// wait for the copy before removing the source
+	err = b.svc.WaitUntilObjectExistsWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(dest),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = b.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"cloudutils/cloud"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"spservers/common"
+	"spservers/spdb"
+)
+
+func main() {
+	shared, err := common.LoadClaspConfig("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// parse arguments
+	providerPtr := flag.String("provider", "None", "required: gcp/aws/azure")
+	opPtr := flag.String("o", "None", "required: create/start/stop/delete/list/zone")
+	vmNamePtr := flag.String("v", "None", "required for create/start/stop/delete: virtual machine name (must be unique in the region)")
+	zonePtr := flag.String("z", "", "optional: zone of the new vm, by default the zone with the fewest vms")
+	regionPtr := flag.String("r", "", "optional: cloud region, by default CLOUDSDK_COMPUTE_REGION (gcp), the aws config region (aws) or AZURE_LOCATION_DEFAULT (azure)")
+	projectPtr := flag.String("p", shared.VM.Project, "optional: gcp project")
+	vmConfigPtr := flag.String("c", "", "required for aws create: path to vm config json file")
+	sshPathPtr := flag.String("s", "", "required for azure create: path to ssh public key file")
+	dbConfigPtr := flag.String("d", "None", "optional: local mongo db config (or memory[:snapshot.json]) to update local vm info")
+	flag.Parse()
+
+	op := *opPtr
+	vmName := *vmNamePtr
+	region := *regionPtr
+	if len(region) == 0 && *providerPtr == "gcp" {
+		region = os.Getenv("CLOUDSDK_COMPUTE_REGION")
+	}
+
+	provider, err := cloud.NewProvider(*providerPtr, cloud.Options{
+		Region:   region,
+		Project:  *projectPtr,
+		VMConfig: *vmConfigPtr,
+		SSHKey:   *sshPathPtr,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	switch op {
+	case "list":
+		vms, err := provider.List(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, vm := range vms {
+			fmt.Println(vm.Name, vm.ID, vm.Status, vm.Zone, vm.Ipv4, vm.DNS)
+		}
+		return
+	case "zone":
+		zone, err := provider.NextZone(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(zone)
+		return
+	case "create", "start", "stop", "delete":
+		if vmName == "None" {
+			log.Fatal("Please provide the virtual machine name with -v")
+		}
+	default:
+		log.Fatal("Please provide create/start/stop/delete/list/zone as the operation command")
+	}
+
+	vm, err := cloud.Do(ctx, provider, op, vmName, *zonePtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println(op, provider.Type(), vm.Name, vm.ID, vm.Status, vm.Zone, vm.Ipv4)
+
+	// update local db
+	if *dbConfigPtr != "None" {
+		db, err := spdb.OpenStore(*dbConfigPtr, "speedtest")
+		if err != nil {
+			log.Fatal("Connect to mongodb failed ", err)
+		}
+		defer db.Close()
+		if err := cloud.Record(ctx, db, op, vm); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
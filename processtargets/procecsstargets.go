@@ -6,6 +6,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"spservers/common"
 	"spservers/spdb"
 	"strings"
 )
@@ -40,17 +41,19 @@ func main() {
 	vmcntmap["Asia-Northeast1-b"] = &Vmcount{0, 0, 0}
 	vmcntmap["Europe-West1-b"] = &Vmcount{0, 0, 0}
 
-	mdb := spdb.NewMongoDB(mongodbcfg, "speedtest")
-	if mdb == nil {
-		log.Fatal("Connect to mongodb failed")
+	mdb, err := spdb.OpenStore(mongodbcfg, "speedtest")
+	if err != nil {
+		log.Fatal("Connect to mongodb failed ", err)
 	}
 	defer mdb.Close()
+	ctx, cancel := common.Context(0)
+	defer cancel()
 	scanner := bufio.NewScanner(csvfile)
 	for scanner.Scan() {
 		line := scanner.Text()
 		data := strings.Split(line, ",")
 		if sip := net.ParseIP(data[1]); sip != nil {
-			spservers, err := mdb.QueryServersbyIPv4(sip)
+			spservers, err := mdb.QueryServersbyIPv4(ctx, sip)
 			if err != nil {
 				log.Fatal("db error", err)
 			}
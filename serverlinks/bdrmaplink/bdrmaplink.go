@@ -1,79 +1,73 @@
 package bdrmaplink
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"serverlinks/config"
+	"serverlinks/fileutils"
 	"spservers/spdb"
 	"strconv"
-	"strings"
 )
 
-func GenerateLinks(GlbParam *config.BdrConfig, Param *config.BdrResult, linkmap map[string]*spdb.Link, faripmap map[string][]*spdb.Link) {
+//GenerateLinks infers the routers of the bdrmap result with sc_bdrmap and adds the interdomain links
+//seen in its traceroutes to linkmap, it returns the routers with their aliased interfaces.
+//A sibling file that cannot be read gives an error wrapping fileutils.ErrMissingAuxFile
+func GenerateLinks(GlbParam *config.BdrConfig, Param *config.BdrResult, linkmap map[string]*spdb.Link, faripmap map[string][]*spdb.Link) ([]*Router, error) {
 	log.Println("GenerateRouterFile start")
-	if len(Param.LinkFile) == 0 {
+	if len(Param.RTRFile) == 0 {
 		scbdrmapcmd := exec.Command(filepath.Join(GlbParam.ScamperBin, "sc_bdrmap"), "-d", "routers", "-a", Param.Prefix2ASFile, "-g", Param.DelegationFile, "-r", Param.ASRelFile, "-v", Param.SiblingFile, "-x", Param.PeeringFile, Param.BdrWartsFile)
 		log.Println("Wart file", Param.BdrWartsFile)
 		log.Println(scbdrmapcmd)
 		var out bytes.Buffer
 		scbdrmapcmd.Stdout = &out
 
-		err := scbdrmapcmd.Run()
-		if err != nil {
-			log.Panic(err)
+		if err := scbdrmapcmd.Run(); err != nil {
+			return nil, fmt.Errorf("sc_bdrmap %s: %w", Param.BdrWartsFile, err)
 		}
 		// convert from /results/bdrmap/xxx.warts to tmpdir/xxx.router.txt
 		Param.RTRFile = filepath.Join(Param.Tmpdir, filepath.Base(Param.BdrWartsFile[:len(Param.BdrWartsFile)-len(filepath.Ext(Param.BdrWartsFile))])) + ".router.txt"
 
-		f, err := os.Create(Param.RTRFile)
-		if err != nil {
-			log.Panic(err)
+		if err := ioutil.WriteFile(Param.RTRFile, out.Bytes(), 0644); err != nil {
+			return nil, err
 		}
-		_, err = f.Write(out.Bytes())
-		if err != nil {
-			log.Panic(err)
-		}
-		f.Sync()
 		log.Println("Writing Router file", Param.RTRFile)
-		defer f.Close()
-
-		//run the script to extract links and aliases
-		getlnkcmd := exec.Command("python", config.GETLINKSCRIPT, "-w", Param.BdrWartsFile, "-b", Param.RTRFile, "-s", Param.SiblingFile)
-		log.Println(getlnkcmd)
-		err = getlnkcmd.Run()
-		if err != nil {
-			log.Panic(err)
-		}
-		Param.LinkFile = filepath.Join(Param.Tmpdir, filepath.Base(Param.BdrWartsFile[:len(Param.BdrWartsFile)-len(filepath.Ext(Param.BdrWartsFile))])) + ".links.out"
-		Param.AliasesFile = filepath.Join(Param.Tmpdir, filepath.Base(Param.BdrWartsFile[:len(Param.BdrWartsFile)-len(filepath.Ext(Param.BdrWartsFile))])) + ".aliases.out"
 	} else {
-		log.Println("Use previous link file:", Param.LinkFile)
+		log.Println("Use previous router file:", Param.RTRFile)
 	}
-	GenerateLinkmap(Param.LinkFile, linkmap, faripmap)
-	log.Println("Get link completed")
-}
 
-func GenerateLinkmap(LinkFile string, linkmap map[string]*spdb.Link, faripmap map[string][]*spdb.Link) {
-	linkfile, err := os.Open(LinkFile)
+	rtrfile, err := os.Open(Param.RTRFile)
+	if err != nil {
+		return nil, err
+	}
+	routers, err := ParseRouters(rtrfile)
+	rtrfile.Close()
 	if err != nil {
-		log.Panic(err)
+		return nil, fmt.Errorf("parse router file %s: %w", Param.RTRFile, err)
 	}
-	defer linkfile.Close()
+	siblings, err := ReadSiblings(Param.SiblingFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", fileutils.ErrMissingAuxFile, err)
+	}
+	links, err := ExtractLinks(Param.BdrWartsFile, routers, VPASes(routers, siblings))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", fileutils.ErrIncompleteArchive, Param.BdrWartsFile, err)
+	}
+	GenerateLinkmap(links, linkmap, faripmap)
+	log.Println("Get link completed, routers:", len(routers), "links:", len(links))
+	return routers, nil
+}
 
-	scanner := bufio.NewScanner(linkfile)
-	for scanner.Scan() {
-		tmplnk := strings.Split(scanner.Text(), "|")
-		if len(tmplnk) == 7 {
-			lkey := tmplnk[0] + "-" + tmplnk[1]
-			if _, exist := linkmap[lkey]; !exist {
-				linkmap[lkey] = &spdb.Link{NearIP: tmplnk[0], FarIP: tmplnk[1], FarAS: tmplnk[2], Covered: false}
-			}
+//GenerateLinkmap adds links to linkmap and indexes them by far ip, keeping one link per far AS
+func GenerateLinkmap(links map[string]*spdb.Link, linkmap map[string]*spdb.Link, faripmap map[string][]*spdb.Link) {
+	for lkey, link := range links {
+		if _, exist := linkmap[lkey]; !exist {
+			linkmap[lkey] = link
 		}
 	}
 	for _, linkobj := range linkmap {
@@ -0,0 +1,225 @@
+package bdrmaplink
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"serverlinks/warts"
+	"sort"
+	"spservers/spdb"
+	"strconv"
+	"strings"
+)
+
+//Router is a router inferred by sc_bdrmap, Addrs are the interfaces aliased to it
+type Router struct {
+	ID     int
+	Owner  string
+	Reason string
+	Addrs  []string
+}
+
+//Key identifies the router across bdrmap runs by its lowest interface address
+func (r *Router) Key() string {
+	key := ""
+	for _, a := range r.Addrs {
+		if key == "" || addrLess(a, key) {
+			key = a
+		}
+	}
+	return key
+}
+
+func addrLess(a, b string) bool {
+	ia, ib := net.ParseIP(a), net.ParseIP(b)
+	if ia4, ib4 := ia.To4(), ib.To4(); ia4 != nil && ib4 != nil {
+		ia, ib = ia4, ib4
+	} else if (ia4 == nil) != (ib4 == nil) {
+		//v4 before v6
+		return ia4 != nil
+	}
+	return bytes.Compare(ia, ib) < 0
+}
+
+//DBRouters converts the routers to their database records, routers without address are dropped
+func DBRouters(routers []*Router) []*spdb.Router {
+	dbrouters := make([]*spdb.Router, 0, len(routers))
+	for _, r := range routers {
+		if len(r.Addrs) == 0 {
+			continue
+		}
+		dbrouters = append(dbrouters, &spdb.Router{RouterKey: r.Key(), Owner: r.Owner, Reason: r.Reason, Addrs: r.Addrs})
+	}
+	return dbrouters
+}
+
+//ParseRouters reads the output of sc_bdrmap -d routers. Each router starts
+//with an unindented line holding its interface addresses, optionally
+//prefixed with its id ("12:") and suffixed with flags ("1.2.3.4*").
+//Indented lines that follow belong to the same router, "owner <asn> <reason>"
+//gives the owner AS ("none" when unknown) and any further addresses are
+//added as interfaces.
+func ParseRouters(r io.Reader) ([]*Router, error) {
+	routers := []*Router{}
+	var cur *Router
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		indented := line[0] == ' ' || line[0] == '\t'
+		if !indented || cur == nil {
+			cur = &Router{ID: len(routers) + 1}
+			if id, err := strconv.Atoi(strings.TrimSuffix(fields[0], ":")); err == nil && strings.HasSuffix(fields[0], ":") {
+				cur.ID = id
+				fields = fields[1:]
+			}
+			routers = append(routers, cur)
+		}
+		for i := 0; i < len(fields); i++ {
+			if fields[i] == "owner" {
+				if i+1 < len(fields) && fields[i+1] != "none" {
+					cur.Owner = fields[i+1]
+				}
+				if i+2 < len(fields) {
+					cur.Reason = fields[i+2]
+				}
+				i += 2
+				continue
+			}
+			if addr := parseAddr(fields[i]); addr != "" {
+				cur.Addrs = append(cur.Addrs, addr)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return routers, nil
+}
+
+//parseAddr strips the flags sc_bdrmap appends to an address, returns "" if tok is not an address
+func parseAddr(tok string) string {
+	tok = strings.TrimRight(tok, "*+!,;")
+	if ip := net.ParseIP(tok); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+//ReadSiblings reads the ASNs of a sibling file, one or more per line
+func ReadSiblings(siblingfile string) (map[string]bool, error) {
+	f, err := os.Open(siblingfile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	siblings := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		for _, tok := range strings.FieldsFunc(line, func(c rune) bool { return c == ' ' || c == ',' || c == '\t' || c == '|' }) {
+			if _, err := strconv.ParseUint(tok, 10, 32); err == nil {
+				siblings[tok] = true
+			}
+		}
+	}
+	return siblings, scanner.Err()
+}
+
+//RouterMap maps every interface address to its router
+func RouterMap(routers []*Router) map[string]*Router {
+	rtrmap := make(map[string]*Router)
+	for _, r := range routers {
+		for _, a := range r.Addrs {
+			rtrmap[a] = r
+		}
+	}
+	return rtrmap
+}
+
+//VPASes returns the ASes of the vantage point network: the siblings plus the
+//owners sc_bdrmap inferred as the vp
+func VPASes(routers []*Router, siblings map[string]bool) map[string]bool {
+	vpases := make(map[string]bool)
+	for as := range siblings {
+		vpases[as] = true
+	}
+	for _, r := range routers {
+		if r.Reason == "vp" && r.Owner != "" {
+			vpases[r.Owner] = true
+		}
+	}
+	return vpases
+}
+
+//ExtractLinks walks the bdrmap traceroutes and returns one link per pair of
+//adjacent hops where the trace leaves a router of the vp network for a router
+//owned by another AS. Links are keyed by "<nearip>-<farip>".
+func ExtractLinks(wartsfile string, routers []*Router, vpases map[string]bool) (map[string]*spdb.Link, error) {
+	rtrmap := RouterMap(routers)
+	links := make(map[string]*spdb.Link)
+
+	reader, err := warts.Open(wartsfile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		wtrace, istrace := rec.(*warts.Trace)
+		if !istrace {
+			continue
+		}
+		hops := firstReplies(wtrace)
+		for i := 1; i < len(hops); i++ {
+			if hops[i].ProbeTTL != hops[i-1].ProbeTTL+1 {
+				continue
+			}
+			nearip, farip := hops[i-1].Addr.String(), hops[i].Addr.String()
+			near, far := rtrmap[nearip], rtrmap[farip]
+			if near == nil || far == nil || !vpases[near.Owner] {
+				continue
+			}
+			if far.Owner == "" || vpases[far.Owner] {
+				continue
+			}
+			lkey := nearip + "-" + farip
+			if _, exist := links[lkey]; !exist {
+				links[lkey] = &spdb.Link{Linkkey: lkey, NearIP: nearip, FarIP: farip, FarAS: far.Owner, NearRtr: near.Key(), FarRtr: far.Key(), Covered: false}
+			}
+			//the trace left the vp network
+			break
+		}
+	}
+	return links, nil
+}
+
+//firstReplies returns the first reply of each ttl in ttl order
+func firstReplies(wtrace *warts.Trace) []warts.TraceHop {
+	seen := make(map[uint8]bool)
+	hops := []warts.TraceHop{}
+	for _, h := range wtrace.Hops {
+		if h.Addr == nil || seen[h.ProbeTTL] {
+			continue
+		}
+		seen[h.ProbeTTL] = true
+		hops = append(hops, h)
+	}
+	sort.Slice(hops, func(i, j int) bool { return hops[i].ProbeTTL < hops[j].ProbeTTL })
+	return hops
+}
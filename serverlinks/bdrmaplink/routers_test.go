@@ -0,0 +1,147 @@
+package bdrmaplink
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"serverlinks/config"
+	"sort"
+	"spservers/spdb"
+	"strings"
+	"testing"
+)
+
+//the fixture is a bdrmap run of aws-oh-1 from AS64496, with AS64497 a sibling
+const fixture = "aws-oh-1.1600000000"
+
+func readRouters(t *testing.T) []*Router {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", fixture+".router.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	routers, err := ParseRouters(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return routers
+}
+
+//readLinksOut reads the near|far|far AS lines of a links.out file
+func readLinksOut(t *testing.T) map[string]string {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", fixture+".links.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "#") {
+			continue
+		}
+		lnk := strings.Split(scanner.Text(), "|")
+		if len(lnk) < 3 {
+			t.Fatalf("bad links.out line %q", scanner.Text())
+		}
+		want[lnk[0]+"-"+lnk[1]] = lnk[2]
+	}
+	return want
+}
+
+func TestParseRouters(t *testing.T) {
+	routers := readRouters(t)
+	want := []Router{
+		{ID: 1, Owner: "64496", Reason: "vp", Addrs: []string{"10.0.0.254"}},
+		{ID: 2, Owner: "64496", Reason: "vp", Addrs: []string{"198.51.100.1", "198.51.100.5", "198.51.100.9"}},
+		{ID: 3, Owner: "64497", Reason: "vp", Addrs: []string{"100.64.0.1"}},
+		{ID: 4, Owner: "64511", Reason: "firsthop", Addrs: []string{"203.0.113.9", "203.0.113.1"}},
+		{ID: 5, Owner: "64500", Reason: "noip2as", Addrs: []string{"192.0.2.1"}},
+		//no id and no owner
+		{ID: 6, Owner: "", Reason: "silent", Addrs: []string{"198.51.100.20"}},
+	}
+	if len(routers) != len(want) {
+		t.Fatalf("got %d routers, want %d", len(routers), len(want))
+	}
+	for i, w := range want {
+		if !reflect.DeepEqual(*routers[i], w) {
+			t.Errorf("router %d = %+v, want %+v", i, *routers[i], w)
+		}
+	}
+	if key := routers[3].Key(); key != "203.0.113.1" {
+		t.Errorf("router key = %s, want the lowest address 203.0.113.1", key)
+	}
+}
+
+func TestVPASes(t *testing.T) {
+	siblings, err := ReadSiblings(filepath.Join("testdata", "siblings.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vpases := VPASes(readRouters(t), siblings)
+	if want := map[string]bool{"64496": true, "64497": true}; !reflect.DeepEqual(vpases, want) {
+		t.Errorf("vp ASes = %v, want %v", vpases, want)
+	}
+}
+
+//the links match links.out, written in the format get_rtr_links_bdrmap.py gave
+func TestExtractLinks(t *testing.T) {
+	routers := readRouters(t)
+	links, err := ExtractLinks(filepath.Join("testdata", fixture+".warts"), routers, map[string]bool{"64496": true, "64497": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := readLinksOut(t)
+	got := make(map[string]string)
+	for lkey, link := range links {
+		got[lkey] = link.FarAS
+		if link.Linkkey != lkey || lkey != link.NearIP+"-"+link.FarIP {
+			t.Errorf("link %s = %+v", lkey, *link)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("links = %v, want %v", got, want)
+	}
+	//both interfaces of the far router of AS64511 give the same router key
+	for _, lkey := range []string{"198.51.100.1-203.0.113.1", "198.51.100.5-203.0.113.9"} {
+		if link, lexist := links[lkey]; lexist && (link.NearRtr != "198.51.100.1" || link.FarRtr != "203.0.113.1") {
+			t.Errorf("link %s routers %s %s", lkey, link.NearRtr, link.FarRtr)
+		}
+	}
+}
+
+//a previous router file skips sc_bdrmap
+func TestGenerateLinks(t *testing.T) {
+	param := &config.BdrResult{
+		RTRFile:      filepath.Join("testdata", fixture+".router.txt"),
+		SiblingFile:  filepath.Join("testdata", "siblings.txt"),
+		BdrWartsFile: filepath.Join("testdata", fixture+".warts"),
+	}
+	linkmap := make(map[string]*spdb.Link)
+	faripmap := make(map[string][]*spdb.Link)
+	routers, err := GenerateLinks(&config.BdrConfig{}, param, linkmap, faripmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routers) != 6 {
+		t.Errorf("got %d routers, want 6", len(routers))
+	}
+	lkeys := []string{}
+	for lkey := range linkmap {
+		lkeys = append(lkeys, lkey)
+	}
+	sort.Strings(lkeys)
+	if want := []string{"100.64.0.1-192.0.2.1", "198.51.100.1-203.0.113.1", "198.51.100.5-203.0.113.9"}; !reflect.DeepEqual(lkeys, want) {
+		t.Errorf("links = %v, want %v", lkeys, want)
+	}
+	if len(faripmap) != 3 {
+		t.Errorf("got %d far ips, want 3", len(faripmap))
+	}
+	param.SiblingFile = filepath.Join("testdata", "missing.txt")
+	if _, err := GenerateLinks(&config.BdrConfig{}, param, linkmap, faripmap); err == nil {
+		t.Errorf("no error for a missing sibling file")
+	}
+}
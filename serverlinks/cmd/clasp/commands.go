@@ -0,0 +1,326 @@
+package main
+
+import (
+	"cloudutils/cloud"
+	"cloudutils/objstore"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"serverlinks/config"
+	"serverlinks/pipeline"
+	"sort"
+	"spservers/common"
+	"spservers/crawl"
+	"spservers/notify"
+	"spservers/spdb"
+	"strings"
+	"time"
+)
+
+func crawlServers(shared *common.ClaspConfig, args []string) error {
+	cfg := shared.CrawlConfig()
+	fs := commandFlags("crawl")
+	quiet := fs.Bool("q", false, "Disable notifications")
+	fs.StringVar(&cfg.MongoConfigFile, "db", cfg.MongoConfigFile, "path to mongodb info, or memory[:snapshot.json]")
+	fs.StringVar(&cfg.NotifyConfig, "notify", cfg.NotifyConfig, config.NotifyUsage)
+	fs.StringVar(&cfg.CreateFilePrefix, "d", cfg.CreateFilePrefix, "directory the crawled server lists are written to, none if empty")
+	fs.IntVar(&cfg.Workers, "w", cfg.Workers, "Number of workers")
+	fs.DurationVar(&cfg.Deadline, "deadline", cfg.Deadline, "Stop the run cleanly after this long, e.g. 2h, 0 for no deadline")
+	fs.Parse(args)
+	cfg.EnableMM = !*quiet
+	return crawl.Run(cfg)
+}
+
+func importFiles(shared *common.ClaspConfig, args []string) error {
+	if len(args) == 0 || (args[0] != "servers" && args[0] != "assignments") {
+		return errors.New("usage: clasp import servers|assignments [flags] <file>...")
+	}
+	what := args[0]
+	cfg := shared.CrawlConfig()
+	fs := commandFlags("import " + what)
+	fs.StringVar(&cfg.MongoConfigFile, "db", cfg.MongoConfigFile, "path to mongodb info, or memory[:snapshot.json]")
+	if what == "servers" {
+		fs.Var(config.UnixFlag(&cfg.StartTime), "ts", "Unix timestamp the servers were crawled at")
+	} else {
+		cfg.StartTime = time.Unix(pipeline.GlobalStart, 0)
+		fs.Var(config.UnixFlag(&cfg.StartTime), "ts", "Unix timestamp the assignments are active from")
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() == 0 || (what == "assignments" && fs.NArg() != 1) {
+		return fmt.Errorf("usage: clasp import %s [flags] <file>", what)
+	}
+	ctx, cancel := common.Context(cfg.Deadline)
+	defer cancel()
+	db, err := spdb.OpenStore(cfg.MongoConfigFile, "speedtest")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if what == "assignments" {
+		n, err := crawl.ImportAssignments(ctx, db, fs.Arg(0), cfg.StartTime)
+		log.Println("Inserted", n, "assignments")
+		return err
+	}
+	errs := []error{}
+	for _, serverlistfile := range fs.Args() {
+		n, err := crawl.ImportServerFile(ctx, cfg, db, serverlistfile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", serverlistfile, err))
+		}
+		log.Println("Inserted", n, "servers of", serverlistfile)
+	}
+	return errors.Join(errs...)
+}
+
+func updateBdrmap(shared *common.ClaspConfig, args []string) error {
+	cfg := config.NewBdrConfig(shared)
+	fs := commandFlags("bdrmap")
+	cfg.Flags(fs)
+	fs.Parse(args)
+	if err := cfg.Check(); err != nil {
+		return err
+	}
+	if err := cfg.Setup(); err != nil {
+		return err
+	}
+	return pipeline.UpdateBdrmap(cfg)
+}
+
+func updateTrace(shared *common.ClaspConfig, args []string) error {
+	cfg := config.NewTrConfig(shared)
+	fs := commandFlags("trace")
+	cfg.Flags(fs)
+	fs.Parse(args)
+	if err := cfg.Check(); err != nil {
+		return err
+	}
+	if err := cfg.Setup(); err != nil {
+		return err
+	}
+	return pipeline.UpdateTraceroute(cfg)
+}
+
+func selectServers(shared *common.ClaspConfig, args []string) error {
+	cfg := config.NewSsConfig(shared)
+	fs := commandFlags("select")
+	cfg.Flags(fs)
+	fs.Parse(args)
+	if err := cfg.Check(); err != nil {
+		return err
+	}
+	if err := cfg.Setup(); err != nil {
+		return err
+	}
+	return pipeline.SelectServers(cfg)
+}
+
+func exportServerlist(shared *common.ClaspConfig, args []string) error {
+	fs := commandFlags("export")
+	outputdir := fs.String("o", shared.Export.OutputDir, "directory the server lists are written to")
+	dbconfig := fs.String("db", shared.DB, "path to mongodb info, or memory[:snapshot.json]")
+	fs.Parse(args)
+	if _, err := os.Stat(*outputdir); err != nil {
+		return err
+	}
+	ctx, cancel := common.Context(shared.Deadline)
+	defer cancel()
+	db, err := spdb.OpenStore(*dbconfig, "speedtest")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	logmap, err := config.OutputServerlist(ctx, db, *outputdir)
+	if err != nil {
+		return err
+	}
+	vms := make([]string, 0, len(logmap))
+	for vm := range logmap {
+		vms = append(vms, vm)
+	}
+	sort.Strings(vms)
+	for _, vm := range vms {
+		fmt.Println(vm, logmap[vm])
+	}
+	return nil
+}
+
+func manageVM(shared *common.ClaspConfig, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: clasp vm create|start|stop|delete|list|zone [flags]")
+	}
+	op := args[0]
+	fs := commandFlags("vm " + op)
+	provider := fs.String("provider", shared.VM.Provider, "gcp/aws/azure")
+	region := fs.String("r", shared.VM.Region, "cloud region, by default CLOUDSDK_COMPUTE_REGION (gcp), the aws config region (aws) or AZURE_LOCATION_DEFAULT (azure)")
+	project := fs.String("p", shared.VM.Project, "gcp project")
+	vmconfig := fs.String("c", shared.VM.VMConfig, "path to vm config json file, needed to create aws vms")
+	sshkey := fs.String("s", shared.VM.SSHKey, "path to ssh public key file, needed to create azure vms")
+	vmname := fs.String("v", "", "virtual machine name (must be unique in the region), needed by create/start/stop/delete")
+	zone := fs.String("z", "", "zone of the new vm, by default the zone with the fewest vms")
+	dbconfig := fs.String("db", shared.DB, "mongodb info (or memory[:snapshot.json]) to update the vm info in, none to leave it")
+	fs.Parse(args[1:])
+	if len(*region) == 0 && *provider == "gcp" {
+		*region = os.Getenv("CLOUDSDK_COMPUTE_REGION")
+	}
+	p, err := cloud.NewProvider(*provider, cloud.Options{Region: *region, Project: *project, VMConfig: *vmconfig, SSHKey: *sshkey})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := common.Context(shared.Deadline)
+	defer cancel()
+	switch op {
+	case "list":
+		vms, err := p.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, vm := range vms {
+			fmt.Println(vm.Name, vm.ID, vm.Status, vm.Zone, vm.Ipv4, vm.DNS)
+		}
+		return nil
+	case "zone":
+		z, err := p.NextZone(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println(z)
+		return nil
+	}
+	if len(*vmname) == 0 {
+		return errors.New("please provide the virtual machine name with -v")
+	}
+	vm, err := cloud.Do(ctx, p, op, *vmname, *zone)
+	if err != nil {
+		return err
+	}
+	log.Println(op, p.Type(), vm.Name, vm.ID, vm.Status, vm.Zone, vm.Ipv4)
+	if *dbconfig != "none" {
+		db, err := spdb.OpenStore(*dbconfig, "speedtest")
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return cloud.Record(ctx, db, op, vm)
+	}
+	return nil
+}
+
+func downloadResults(shared *common.ClaspConfig, args []string) error {
+	if len(args) > 0 && args[0] == "verify" {
+		return verifyDownloads(shared, args[1:])
+	}
+	dl := shared.Download
+	var types string
+	fs := commandFlags("download")
+	prefix := fs.String("prefix", "None", "Prefix of the vm folders, by default aws for s3 and empty otherwise")
+	results := fs.String("results", "None", "Folder between the vm folder and the result files, by default results/ for gcs and empty otherwise")
+	fs.StringVar(&dl.Backend, "backend", dl.Backend, "Object store backend: s3/gcs/azure/local")
+	fs.StringVar(&dl.DestDir, "d", dl.DestDir, "Local directory for storing result files")
+	fs.StringVar(&dl.Bucket, "b", dl.Bucket, "Bucket (s3/gcs), container (azure) or root directory (local), by default the bucket of the backend")
+	fs.StringVar(&dl.Region, "r", dl.Region, "Region where the s3 bucket located")
+	fs.StringVar(&dl.Endpoint, "endpoint", dl.Endpoint, "S3 compatible endpoint, e.g. http://localhost:9000 for MinIO")
+	fs.StringVar(&types, "types", strings.Join(dl.Types, ","), "Comma separated data types to download")
+	fs.IntVar(&dl.Workers, "w", dl.Workers, "Number of concurrent downloads")
+	fs.StringVar(&dl.Manifest, "manifest", dl.Manifest, "Path to the download manifest, by default <d>/manifest.jsonl")
+	fs.BoolVar(&dl.Archive, "archive", dl.Archive, "Move verified files into archive directory")
+	notifyconfig := fs.String("notify", shared.Notify, config.NotifyUsage)
+	fs.Parse(args)
+	layout, lexist := objstore.Defaults[dl.Backend]
+	if !lexist {
+		return fmt.Errorf("unknown backend %s", dl.Backend)
+	}
+	if len(dl.Bucket) == 0 {
+		dl.Bucket = layout.Bucket
+	}
+	if len(dl.Bucket) == 0 {
+		return errors.New("please provide the root directory of the local backend with -b")
+	}
+	if *prefix != "None" {
+		layout.Prefix = *prefix
+	}
+	if *results != "None" {
+		layout.Results = *results
+	}
+	if len(dl.Manifest) == 0 {
+		dl.Manifest = filepath.Join(dl.DestDir, "manifest.jsonl")
+	}
+	if err := os.MkdirAll(dl.DestDir, 0755); err != nil {
+		return err
+	}
+	notifier, err := config.OpenNotifier(*notifyconfig, "Result Downloader", false)
+	if err != nil {
+		return err
+	}
+	defer notifier.Close()
+	// an interrupted download keeps its .part file and resumes on the next run
+	ctx, cancel := common.Context(shared.Deadline)
+	defer cancel()
+	manifest, err := objstore.OpenManifest(dl.Manifest)
+	if err != nil {
+		notify.Error(notifier, "Failed to open manifest", notify.F("manifest", dl.Manifest), notify.Err(err))
+		return err
+	}
+	defer manifest.Close()
+	store, err := objstore.New(ctx, dl.Backend, objstore.Options{Bucket: dl.Bucket, Region: dl.Region, Endpoint: dl.Endpoint})
+	if err != nil {
+		notify.Error(notifier, "Failed to open "+dl.Backend+" bucket", notify.F("bucket", dl.Bucket), notify.Err(err))
+		return err
+	}
+	d := &objstore.Downloader{
+		Store:    store,
+		DestDir:  dl.DestDir,
+		Prefix:   layout.Prefix,
+		Results:  layout.Results,
+		Archive:  dl.Archive,
+		Workers:  dl.Workers,
+		Manifest: manifest,
+	}
+	for _, t := range strings.Split(types, ",") {
+		report, err := d.Download(ctx, t)
+		if err != nil {
+			notify.Error(notifier, "Failed to download "+t, notify.F("bucket", dl.Bucket), notify.Err(err))
+			return err
+		}
+		msg := fmt.Sprintf("Downloaded %d %s files (%d bytes) from %s %s", report.Files, t, report.Bytes, dl.Backend, dl.Bucket)
+		log.Println(msg, report.Dirs)
+		if len(report.Failed) > 0 {
+			notify.Report(notifier, notify.SeverityError, msg, append([]string{"failed:"}, report.Failed...)...)
+		} else {
+			notify.Report(notifier, notify.SeverityInfo, msg, append([]string{"from"}, report.Dirs...)...)
+		}
+	}
+	return nil
+}
+
+//verifyDownloads audits the local result tree against the manifest
+func verifyDownloads(shared *common.ClaspConfig, args []string) error {
+	fs := commandFlags("download verify")
+	destdir := fs.String("d", shared.Download.DestDir, "Local directory of the result files")
+	manifestpath := fs.String("manifest", shared.Download.Manifest, "Path to the download manifest, by default <d>/manifest.jsonl")
+	fs.Parse(args)
+	if len(*manifestpath) == 0 {
+		*manifestpath = filepath.Join(*destdir, "manifest.jsonl")
+	}
+	if _, err := os.Stat(*manifestpath); err != nil {
+		return err
+	}
+	manifest, err := objstore.OpenManifest(*manifestpath)
+	if err != nil {
+		return err
+	}
+	defer manifest.Close()
+	problems, err := manifest.Audit(*destdir)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Println(p.Path, p.Key, p.Err)
+	}
+	log.Println("Checked", len(manifest.Entries()), "manifest entries,", len(problems), "problems")
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems", len(problems))
+	}
+	return nil
+}
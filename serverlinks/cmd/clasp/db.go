@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"spservers/common"
+	"spservers/spdb"
+	"text/tabwriter"
+)
+
+//manageDB migrates the schema of the database, or shows its versions and the
+//steps a migration would take
+func manageDB(shared *common.ClaspConfig, args []string) error {
+	if len(args) == 0 || (args[0] != "migrate" && args[0] != "status") {
+		return errors.New("usage: clasp db migrate|status [flags]")
+	}
+	op := args[0]
+	fs := commandFlags("db " + op)
+	dbconfig := fs.String("db", shared.DB, "path to mongodb info, or memory[:snapshot.json]")
+	deadline := fs.Duration("deadline", 0, "Stop after this long, e.g. 2h, 0 for no deadline")
+	dryrun := false
+	if op == "migrate" {
+		fs.BoolVar(&dryrun, "dry-run", false, "List the backfills and indexes without applying them")
+	}
+	fs.Parse(args[1:])
+	ctx, cancel := common.Context(*deadline)
+	defer cancel()
+	db, err := spdb.OpenStoreNoMigrate(*dbconfig, "speedtest")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if op == "status" {
+		versions, err := db.SchemaVersions(ctx)
+		if err != nil {
+			return err
+		}
+		current := spdb.CurrentSchema()
+		collections := make([]string, 0, len(current))
+		for collection := range current {
+			collections = append(collections, collection)
+		}
+		sort.Strings(collections)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "COLLECTION\tVERSION\tCURRENT")
+		for _, collection := range collections {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", collection, versions[collection], current[collection])
+		}
+		w.Flush()
+		dryrun = true
+	}
+	steps, err := db.Migrate(ctx, dryrun)
+	if len(steps) == 0 && err == nil {
+		fmt.Println("Database is up to date")
+	}
+	for _, step := range steps {
+		switch {
+		case step.Applied:
+			fmt.Println("applied", step)
+		case dryrun:
+			fmt.Println("pending", step)
+		default:
+			fmt.Println("failed ", step)
+		}
+	}
+	return err
+}
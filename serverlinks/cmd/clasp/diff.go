@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"spservers/common"
+	"spservers/platform"
+	"spservers/serverhistory"
+	"spservers/spdb"
+	"time"
+)
+
+//serverDiff compares two server lists saved by crawls, or lists the changes
+//the crawls recorded in the server history
+func serverDiff(shared *common.ClaspConfig, args []string) error {
+	ets := time.Now().Unix()
+	fs := commandFlags("diff")
+	stype := fs.String("type", "", "only list changes of this platform, e.g. ookla. all platforms if empty")
+	days := fs.Int("days", 7, "Number of days before the end time to list")
+	fs.Int64Var(&ets, "te", ets, "Unix timestamp of end time")
+	dbconfig := fs.String("db", shared.DB, "path to mongodb info, or memory[:snapshot.json]")
+	fs.Parse(args)
+	var changes []*spdb.ServerChange
+	switch fs.NArg() {
+	case 2:
+		cfg := shared.CrawlConfig()
+		old, err := platform.ImportFile(cfg, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		servers, err := platform.ImportFile(cfg, fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		changes = serverhistory.Diff(0, 0, old, servers)
+	case 0:
+		if *days <= 0 {
+			*days = 1
+		}
+		ctx, cancel := common.Context(shared.Deadline)
+		defer cancel()
+		db, err := spdb.OpenStore(*dbconfig, "speedtest")
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		endts := time.Unix(ets, 0)
+		changes, err = db.QueryServerChanges(ctx, platform.ServerType(*stype), endts.AddDate(0, 0, -*days).Unix(), endts.Unix())
+		if err != nil {
+			return fmt.Errorf("query server changes: %w", err)
+		}
+	default:
+		return errors.New("usage: clasp diff [-type platform] [-days n] [-te ts] | <old.json> <new.json>")
+	}
+	lastts := int64(-1)
+	for _, sc := range changes {
+		if sc.Ts != lastts && sc.Ts > 0 {
+			fmt.Println("crawl of", time.Unix(sc.Ts, 0).Local().Format("2006-01-02 15:04:05"))
+			lastts = sc.Ts
+		}
+		fmt.Println(serverhistory.Line(sc))
+	}
+	for _, line := range serverhistory.Summary(changes) {
+		fmt.Println(line)
+	}
+	if len(changes) == 0 {
+		fmt.Println("no changes")
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"serverlinks/config"
+	"spservers/common"
+	"spservers/notify"
+	"strings"
+)
+
+const claspUsage = `usage: clasp [-config file] <command> [flags]
+
+The config file is yaml, or toml if its name ends in .toml. By default it is
+$CLASP_CONFIG or bin/clasp.yaml of the project directory. CLASP_<KEY> and
+CLASP_<SECTION>_<KEY> environment variables override it, e.g. CLASP_DB or
+CLASP_BDRMAP_RESULTDIR, and the flags of a command override both.
+
+commands:
+  crawl         crawl the servers of every platform into the database
+  import servers [-ts ts] <serverlist.json>...
+                insert the servers of saved crawls
+  import assignments [-ts ts] <serverlist>
+                insert the assignments of a server list
+  bdrmap        load new bdrmap results and extract the interdomain links
+  trace         load new traceroutes towards the speed test servers
+  select        select the speed test servers of each region
+  export        write the server lists of the vms
+  diff [-type platform] [-days n] [-te ts] | <old.json> <new.json>
+                list the server changes the crawls recorded, or compare two
+                saved server lists
+  db <migrate [-dry-run]|status>
+                apply the pending backfills and indexes of the database, or
+                list them with the schema version of each collection
+  vm <create|start|stop|delete|list|zone> [flags]
+                manage the vms of a cloud region
+  download [verify] [flags]
+                download the results from the object store, or check them
+  runs [-cmd name] [-days n] [-te ts] [-json] [runid]
+                list the pipeline runs, or show one run with its inputs and vms
+  validate      check the config and the files and directories it names
+  print-config [-format yaml|toml] [-env]
+                print the config after the file and the environment are applied
+
+Run clasp <command> -h for the flags of a command.
+`
+
+var commands = map[string]func(*common.ClaspConfig, []string) error{
+	"crawl":        crawlServers,
+	"import":       importFiles,
+	"bdrmap":       updateBdrmap,
+	"trace":        updateTrace,
+	"select":       selectServers,
+	"export":       exportServerlist,
+	"diff":         serverDiff,
+	"db":           manageDB,
+	"vm":           manageVM,
+	"download":     downloadResults,
+	"runs":         runs,
+	"validate":     validate,
+	"print-config": printConfig,
+}
+
+func main() {
+	fs := flag.NewFlagSet("clasp", flag.ExitOnError)
+	configfile := fs.String("config", "", "path to the config file")
+	fs.Usage = func() { fmt.Fprint(os.Stderr, claspUsage) }
+	fs.Parse(os.Args[1:])
+	if fs.NArg() < 1 {
+		fmt.Fprint(os.Stderr, claspUsage)
+		os.Exit(2)
+	}
+	name, args := fs.Arg(0), fs.Args()[1:]
+	if name == "help" {
+		fmt.Print(claspUsage)
+		return
+	}
+	command, cexist := commands[name]
+	if !cexist {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s", name, claspUsage)
+		os.Exit(2)
+	}
+	shared, err := common.LoadClaspConfig(*configfile)
+	if err != nil {
+		log.Fatal("Load config failed ", err)
+	}
+	if err := command(shared, args); err != nil {
+		log.Fatal("clasp ", name, " failed: ", err)
+	}
+}
+
+//commandFlags makes a flag set for command the flags of the process, so the
+//run record of the command lists them
+func commandFlags(command string) *flag.FlagSet {
+	flag.CommandLine = flag.NewFlagSet("clasp "+command, flag.ExitOnError)
+	return flag.CommandLine
+}
+
+//validate reports every problem of the config at once
+func validate(shared *common.ClaspConfig, args []string) error {
+	commandFlags("validate").Parse(args)
+	errs := []error{shared.Check()}
+	errs = append(errs, config.NewBdrConfig(shared).Check())
+	errs = append(errs, config.NewTrConfig(shared).Check())
+	errs = append(errs, config.NewSsConfig(shared).Check())
+	if n, err := notify.Open(shared.Notify, "clasp"); err != nil {
+		errs = append(errs, fmt.Errorf("notify: %w", err))
+	} else {
+		n.Close()
+	}
+	for name, path := range map[string]string{"vm.vmconfig": shared.VM.VMConfig, "vm.sshkey": shared.VM.SSHKey} {
+		if _, err := os.Stat(path); len(path) > 0 && err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	if shared.Download.Backend == "local" && len(shared.Download.Bucket) == 0 {
+		errs = append(errs, errors.New("download.bucket: the local backend needs the root directory"))
+	}
+	source := shared.File
+	if len(source) == 0 {
+		source = "defaults"
+	}
+	if err := errors.Join(errs...); err != nil {
+		fmt.Println("Config of", source, "has problems:")
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Println("  " + line)
+		}
+		return errors.New("invalid config")
+	}
+	fmt.Println("Config of", source, "is valid")
+	return nil
+}
+
+func printConfig(shared *common.ClaspConfig, args []string) error {
+	fs := commandFlags("print-config")
+	format := fs.String("format", "yaml", "yaml or toml")
+	env := fs.Bool("env", false, "list the environment variables of the settings instead")
+	fs.Parse(args)
+	if *env {
+		for _, name := range shared.EnvNames() {
+			fmt.Println(name)
+		}
+		return nil
+	}
+	if len(shared.File) > 0 {
+		fmt.Println("# from", shared.File)
+	}
+	return shared.Write(os.Stdout, *format)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"serverlinks/config"
+	"sort"
+	"spservers/common"
+	"spservers/runlog"
+	"spservers/spdb"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func runs(shared *common.ClaspConfig, args []string) error {
+	cfg, err := config.ReadRunsConfig(shared, args)
+	if err != nil {
+		return err
+	}
+	defer cfg.MongoClient.Close()
+	ctx, cancel := common.Context(0)
+	defer cancel()
+	if len(cfg.RunId) > 0 {
+		runid, err := primitive.ObjectIDFromHex(cfg.RunId)
+		if err != nil {
+			return fmt.Errorf("invalid run id %s", cfg.RunId)
+		}
+		run, err := cfg.MongoClient.QueryRunbyId(ctx, runid)
+		if err != nil {
+			return fmt.Errorf("query run: %w", err)
+		}
+		if cfg.JSON {
+			printJSON(run)
+		} else {
+			printRun(run)
+		}
+		return nil
+	}
+	allruns, err := cfg.MongoClient.QueryRuns(ctx, cfg.Command, cfg.StartDate, cfg.EndDate)
+	if err != nil {
+		return fmt.Errorf("query runs: %w", err)
+	}
+	if cfg.JSON {
+		printJSON(allruns)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCOMMAND\tHOST\tSTART\tDURATION\tSTATUS\tVMS\tCOUNTS")
+	for _, run := range allruns {
+		failed := 0
+		for _, vm := range run.VMs {
+			if vm.Status == runlog.StatusFailed {
+				failed++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d/%d\t%s\n", run.RunId.Hex(), run.Command, run.Host,
+			run.Start.Local().Format("2006-01-02 15:04:05"), runDuration(run), run.Status,
+			len(run.VMs)-failed, len(run.VMs), formatCounts(run.Counts))
+	}
+	w.Flush()
+	return nil
+}
+
+func printRun(run *spdb.Run) {
+	fmt.Printf("Run %s\n", run.RunId.Hex())
+	fmt.Printf("  command: %s %s\n", run.Command, strings.Join(run.Args, " "))
+	fmt.Printf("  host:    %s\n", run.Host)
+	fmt.Printf("  start:   %s\n", run.Start.Local().Format(time.RFC3339))
+	if !run.End.IsZero() {
+		fmt.Printf("  end:     %s (%s)\n", run.End.Local().Format(time.RFC3339), runDuration(run))
+	}
+	fmt.Printf("  status:  %s\n", run.Status)
+	if len(run.Err) > 0 {
+		fmt.Printf("  error:   %s\n", run.Err)
+	}
+	fmt.Printf("  counts:  %s\n", formatCounts(run.Counts))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\nFlags")
+	flagnames := make([]string, 0, len(run.Flags))
+	for name := range run.Flags {
+		flagnames = append(flagnames, name)
+	}
+	sort.Strings(flagnames)
+	for _, name := range flagnames {
+		fmt.Fprintf(w, "  -%s\t%s\n", name, run.Flags[name])
+	}
+	fmt.Fprintln(w, "\nInputs")
+	for _, in := range run.Inputs {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%d\t%s\n", in.VM, in.Kind, in.Path, in.Size, in.SHA256)
+	}
+	fmt.Fprintln(w, "\nVMs")
+	for _, vm := range run.VMs {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", vm.VM, vm.Status, formatCounts(vm.Counts), vm.Err)
+	}
+	w.Flush()
+}
+
+func runDuration(run *spdb.Run) string {
+	if run.End.IsZero() {
+		return "-"
+	}
+	return run.End.Sub(run.Start).Round(time.Second).String()
+}
+
+func formatCounts(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	strs := make([]string, len(names))
+	for nidx, name := range names {
+		strs[nidx] = fmt.Sprintf("%s=%d", name, counts[name])
+	}
+	return strings.Join(strs, " ")
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatal(err)
+	}
+}
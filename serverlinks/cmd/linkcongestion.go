@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"serverlinks/config"
+	"serverlinks/congestion"
+	"spservers/common"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func main() {
+	cfg, err := config.ReadCongestionConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cfg.MongoClient.Close()
+	ctx, cancel := common.Context(cfg.Deadline)
+	defer cancel()
+	params := congestion.Params{PeakStart: cfg.PeakStart, PeakEnd: cfg.PeakEnd, MinSamples: cfg.MinSamples, DropThreshold: cfg.DropThreshold, ElevThreshold: cfg.ElevThreshold, RecurringFraction: cfg.RecurringFraction, MinDays: cfg.MinDays}
+	for _, region := range cfg.Regions {
+		if ctx.Err() != nil {
+			log.Println("Stopped before", region, ctx.Err())
+			break
+		}
+		//peak hours are local to the region, ScoreDays and Flag split days in its time zone
+		loc, err := cfg.RegionLocation(region)
+		if err != nil {
+			log.Println("Skip region", region, err, "set -tz to analyze it")
+			continue
+		}
+		p := params
+		p.Loc = loc
+		if _, err := congestion.AnalyzeRegion(ctx, cfg.MongoClient, region, cfg.StartDate, cfg.EndDate, &p); err != nil {
+			log.Println("Analyze region failed", region, err)
+			continue
+		}
+		//summarize what is stored, so earlier runs over the same days are included
+		lcs, err := cfg.MongoClient.QueryLinkCongestion(ctx, region, p.Day(cfg.StartDate), cfg.EndDate)
+		if err != nil {
+			log.Println("Query link congestion failed", region, err)
+			continue
+		}
+		flagged := congestion.Flag(lcs, &p)
+		var lds map[primitive.ObjectID]*congestion.LinkData
+		if cfg.Profile && len(flagged) > 0 {
+			if lds, err = congestion.LoadRegion(ctx, cfg.MongoClient, region, cfg.StartDate, cfg.EndDate); err != nil {
+				log.Println("Load region failed", region, err)
+			}
+		}
+		fmt.Printf("== %s: %d links flagged out of %d link-days\n", region, len(flagged), len(lcs))
+		for _, fl := range flagged {
+			link, err := cfg.MongoClient.QueryLinkbyId(ctx, fl.Link)
+			if err != nil {
+				log.Println("Query link failed", fl.Link.Hex(), err)
+				continue
+			}
+			fmt.Printf("%s\tAS%s\t%s\t%d/%d days\tmax score %.2f\n", link.Linkkey, fl.FarAS, fl.Link.Hex(), fl.CongestedDays, fl.Days, fl.MaxScore)
+			if ld, lexist := lds[fl.Link]; lexist {
+				printProfile(congestion.DiurnalProfile(ld, &p))
+			}
+		}
+		fmt.Println("far AS\tlinks\tflagged\tcongested days")
+		for _, as := range congestion.SummarizeAS(lcs, flagged) {
+			fmt.Printf("AS%s\t%d\t%d\t%d/%d\n", as.FarAS, as.Links, as.FlaggedLinks, as.CongestedDays, as.Days)
+		}
+	}
+}
+
+func printProfile(prof *congestion.Profile) {
+	down := make([]string, 24)
+	delta := make([]string, 24)
+	for h := 0; h < 24; h++ {
+		down[h] = fmt.Sprintf("%.0f", prof.Download[h])
+		delta[h] = fmt.Sprintf("%.1f", prof.RttDelta[h])
+	}
+	fmt.Println("\tdownload(Mbps) by hour:", strings.Join(down, " "))
+	fmt.Println("\trtt delta(ms) by hour:", strings.Join(delta, " "))
+}
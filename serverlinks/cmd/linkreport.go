@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"serverlinks/config"
+	"serverlinks/linkevents"
+	"spservers/common"
+	"spservers/notify"
+	"strings"
+	"time"
+)
+
+//report the interconnects that appeared, disappeared or flapped per cloud and region, run it from cron
+func main() {
+	cfg, err := config.ReadLinkReportConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cfg.MongoClient.Close()
+	defer cfg.Notifier.Close()
+	ctx, cancel := common.Context(cfg.Deadline)
+	defer cancel()
+	events, err := cfg.MongoClient.QueryLinkEvents(ctx, "", cfg.StartDate.Unix(), cfg.EndDate.Unix())
+	if err != nil {
+		log.Panic(err)
+	}
+	if len(cfg.Regions) > 0 {
+		wanted := make(map[string]bool)
+		for _, region := range cfg.Regions {
+			wanted[region] = true
+		}
+		kept := events[:0]
+		for _, ev := range events {
+			if wanted[config.VMNametoRegion(ev.Region)] {
+				kept = append(kept, ev)
+			}
+		}
+		events = kept
+	}
+
+	reports := linkevents.Summarize(events)
+	title := fmt.Sprintf("Link changes %s to %s", cfg.StartDate.Format(time.RFC3339), cfg.EndDate.Format(time.RFC3339))
+	msg := []string{}
+	if len(reports) == 0 {
+		msg = append(msg, "no link changes")
+	}
+	for _, r := range reports {
+		msg = append(msg, r.String())
+	}
+	fmt.Println(title + "\n" + strings.Join(msg, "\n"))
+	notify.Report(cfg.Notifier, notify.SeverityInfo, title, msg...)
+}
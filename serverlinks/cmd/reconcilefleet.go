@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"serverlinks/config"
+	"serverlinks/fleet"
+	"spservers/common"
+	"spservers/notify"
+)
+
+func main() {
+	cfg, err := config.ReadFleetConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cfg.MongoClient.Close()
+	defer cfg.Notifier.Close()
+	ctx, cancel := common.Context(cfg.Deadline)
+	defer cancel()
+	smeasmap, err := cfg.MongoClient.QueryMapSpeedMeas(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	desired := fleet.DesiredVMs(smeasmap, cfg.TargetperVM, cfg.MaxVMperRegion)
+	for region := range desired {
+		if _, cexist := cfg.CloudRegion[region]; !cexist {
+			log.Println("Region", region, "has targets but no cloud region configured")
+		}
+	}
+	report := []string{}
+	//regions run one at a time, the azure utilities keep their location in a global
+	for _, region := range cfg.Regions {
+		if ctx.Err() != nil {
+			log.Println("Stopped before", region, ctx.Err())
+			break
+		}
+		cr, cexist := cfg.CloudRegion[region]
+		if !cexist {
+			log.Println("No cloud region configured for", region)
+			continue
+		}
+		provider, err := fleet.NewProvider(cr)
+		if err != nil {
+			log.Println("Create provider failed", region, err)
+			notify.Error(cfg.Notifier, "create provider failed", notify.F("region", region), notify.Err(err))
+			continue
+		}
+		steps, actions, err := fleet.Reconcile(ctx, cfg.MongoClient, provider, region, desired[region], cfg.DeleteSurplus, cfg.DryRun)
+		if err != nil {
+			log.Println("Reconcile failed", region, err)
+			notify.Error(cfg.Notifier, "reconcile failed", notify.F("region", region), notify.Err(err))
+			continue
+		}
+		if cfg.DryRun {
+			fmt.Printf("== %s: needs %d VMs, %d steps\n", region, desired[region], len(steps))
+			for _, step := range steps {
+				fmt.Println(step)
+			}
+			continue
+		}
+		failed := 0
+		for _, action := range actions {
+			if len(action.Err) > 0 {
+				failed++
+			}
+		}
+		if len(actions) > 0 {
+			report = append(report, fmt.Sprintf(" %s: needs %d VMs, Steps: %d, Failed: %d", region, desired[region], len(actions), failed))
+		}
+	}
+	if !cfg.DryRun && len(report) > 0 {
+		notify.Report(cfg.Notifier, notify.SeverityInfo, "Fleet reconcile report:", report...)
+	}
+}
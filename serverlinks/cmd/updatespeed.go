@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"serverlinks/config"
+	"serverlinks/fileutils"
+	"serverlinks/speedresult"
+	"spservers/common"
+	"spservers/notify"
+	"spservers/spdb"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	GlobalStart = 1588291200
+)
+
+func main() {
+	speedconfig, err := config.ReadSpeedConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer speedconfig.MongoClient.Close()
+	defer speedconfig.Notifier.Close()
+	ctx, cancel := common.Context(speedconfig.Deadline)
+	defer cancel()
+	var wg sync.WaitGroup
+	workerchan := make(chan int, speedconfig.VMWorker)
+	vmnamere := regexp.MustCompile(`(\w+-\w+-\w+)`)
+	resultfolder, err := ioutil.ReadDir(speedconfig.ResultDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	vmlist := []string{}
+	for _, f := range resultfolder {
+		if f.IsDir() {
+			nameslice := vmnamere.FindStringSubmatch(f.Name())
+			if len(nameslice) > 0 {
+				vmlist = append(vmlist, nameslice[1])
+				wg.Add(1)
+				go func() {
+					workerchan <- 1
+					processVMSpeed(ctx, nameslice[1], speedconfig)
+					<-workerchan
+					wg.Done()
+				}()
+			}
+		}
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		log.Println("Update speed tests stopped, the remaining files are loaded by the next run", err)
+	}
+	ReportSpeedStatus(context.WithoutCancel(ctx), speedconfig, vmlist)
+}
+
+//processVMSpeed loads the speed tests of vmname a month at a time, a month that is not
+//fully read when ctx is done is left for the next run
+func processVMSpeed(ctx context.Context, vmname string, config *config.SpeedConfig) {
+	if ctx.Err() != nil {
+		return
+	}
+	vmpath := filepath.Join(config.ResultDir, vmname)
+	log.Println("working on", vmpath)
+	monvmstatus, err := config.MongoClient.QueryDataStatus(ctx, vmname)
+	if err != nil {
+		notify.Error(config.Notifier, "failed to query data status", notify.VM(vmname), notify.Err(err))
+		log.Println("query data status failed", vmname, err)
+		return
+	}
+	var lastts time.Time
+	if len(monvmstatus.SpeedFile) > 0 {
+		lastts = time.Unix(speedresult.ParseSpeedFileTs(monvmstatus.SpeedFile), 0)
+	} else {
+		lastts = time.Unix(int64(GlobalStart), 0)
+	}
+	linker, err := speedresult.NewLinker(ctx, config.MongoClient)
+	if err != nil {
+		notify.Error(config.Notifier, "failed to query speedmeas", notify.VM(vmname), notify.Err(err))
+		log.Println("query speedmeas failed", vmname, err)
+		return
+	}
+	today := time.Now()
+	//walk from the first day of the month, so short months are not skipped
+	for curtime := time.Date(lastts.Year(), lastts.Month(), 1, 0, 0, 0, 0, time.UTC); curtime.Before(today); curtime = curtime.AddDate(0, 1, 0) {
+		monthdir := filepath.Join(vmpath, strconv.Itoa(curtime.Year()), strconv.Itoa(int(curtime.Month())))
+		if _, err := os.Stat(monthdir); os.IsNotExist(err) {
+			continue
+		}
+		speedfiles, err := fileutils.FindResultFiles(monthdir, speedresult.SpeedFileRe, speedresult.ParseSpeedFileTs, 0)
+		if err != nil {
+			log.Println("List result files error", err, monthdir)
+			continue
+		}
+		results := []*spdb.SpeedResult{}
+		unparsed := 0
+		lastfile := ""
+		for _, speedfile := range speedfiles {
+			filets := speedresult.ParseSpeedFileTs(speedfile)
+			//files of the same second as the watermark may have been missed, reloading
+			//the watermark file itself replaces its record
+			if filets > 0 && filets >= lastts.Unix() {
+				result, err := speedresult.ProcessSpeedFile(ctx, vmname, speedfile, linker)
+				if err != nil {
+					log.Println("Parse speed test metadata failed", speedfile, err)
+				} else {
+					if len(result.ParseError) > 0 {
+						log.Println("No throughput in speed test", speedfile, result.ParseError)
+						unparsed++
+					}
+					results = append(results, result)
+				}
+				lastfile = filepath.Base(speedfile)
+			}
+		}
+		if len(lastfile) == 0 {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		dbctx := context.WithoutCancel(ctx)
+		if _, err := config.MongoClient.InsertManySpeedResults(dbctx, results); err != nil {
+			//keep the watermark so the month is retried on the next run
+			notify.Error(config.Notifier, "failed to insert speed test results", notify.VM(vmname), notify.Count("results", len(results)), notify.Err(err))
+			log.Println("insert speed results failed", vmname, err)
+			return
+		}
+		if err := config.MongoClient.UpdateDataStatus(dbctx, &spdb.VMDataStatus{Mon: vmname, SpeedFile: lastfile}); err != nil {
+			notify.Error(config.Notifier, "failed to update data status", notify.VM(vmname), notify.File(lastfile), notify.Err(err))
+			log.Println("update data status failed", vmname, err)
+			return
+		}
+		if unparsed > 0 {
+			notify.Warn(config.Notifier, "speed tests without throughput", notify.VM(vmname), notify.Count("results", len(results)), notify.Count("unparsed", unparsed))
+		}
+		log.Println(vmname, "updated to", lastfile, len(results), "results", unparsed, "unparsed")
+	}
+}
+
+func ReportSpeedStatus(ctx context.Context, config *config.SpeedConfig, vmlist []string) {
+	outstr := []string{}
+	for _, vm := range vmlist {
+		monstatus, _ := config.MongoClient.QueryDataStatus(ctx, vm)
+		outstr = append(outstr, vm+" "+monstatus.SpeedFile)
+	}
+	notify.Report(config.Notifier, notify.SeverityInfo, "I updated speed tests from these VMs:", outstr...)
+}
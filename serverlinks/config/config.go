@@ -1,125 +1,158 @@
 package config
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"mmbot"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"serverlinks/fileutils"
+	"spservers/common"
+	"spservers/notify"
+	"spservers/platform"
+	_ "spservers/platform/all"
 	"spservers/spdb"
 	"strings"
+	"time"
 )
 
 type BdrConfig struct {
-	ScamperBin       string
-	ResultDir        string
-	SiblingDir       string
-	Prefix2ASDir     string
-	PeeringDir       string
-	DelegationDir    string
-	ASRelDir         string
-	MongoConfig      string
-	MattermostConfig string
+	ScamperBin    string
+	ResultDir     string
+	SiblingDir    string
+	Prefix2ASDir  string
+	PeeringDir    string
+	DelegationDir string
+	ASRelDir      string
+	MongoConfig   string
+	NotifyConfig  string
 	//	SpeedTestServersDir string
 	Quiet       bool
 	Cleanup     bool
 	Clean       bool
 	AddResult   bool
-	MMclient    *mmbot.MMBot
-	MongoClient *spdb.SpeedtestMongo
+	Notifier    notify.Notifier
+	Deadline    time.Duration
+	MongoClient spdb.Store
 }
 
 type BdrResult struct {
-	MetaFile         string
-	BdrWartsFile     string
-	NDTWartsFile     string
-	OoklaWartsFile   string
-	ComcastWartsFile string
-	SiblingFile      string
-	Prefix2ASFile    string
-	PeeringFile      string
-	DelegationFile   string
-	ASRelFile        string
+	MetaFile     string
+	BdrWartsFile string
+	//traceroutes towards the servers of each platform, by platform name
+	WartsFiles     map[string]string
+	SiblingFile    string
+	Prefix2ASFile  string
+	PeeringFile    string
+	DelegationFile string
+	ASRelFile      string
 	//	OoklaServerFile     string
 	//	NDTServerFile       string
 	//	ComcastServerFile   string
-	RTRFile     string
-	LinkFile    string
-	AliasesFile string
-	Tmpdir      string
+	RTRFile string
+	Tmpdir  string
 }
 
-const PROJECTDIR = "/scratch/cloudspeedtest"
-const GETLINKSCRIPT = PROJECTDIR + "/analysis/scripts/get_rtr_links_bdrmap.py"
+//NewBdrConfig returns the bdrmap update settings of the shared config
+func NewBdrConfig(shared *common.ClaspConfig) *BdrConfig {
+	return &BdrConfig{
+		ScamperBin:    shared.Bdrmap.ScamperBin,
+		ResultDir:     shared.Bdrmap.ResultDir,
+		SiblingDir:    shared.Bdrmap.SiblingDir,
+		Prefix2ASDir:  shared.Bdrmap.Prefix2ASDir,
+		PeeringDir:    shared.Bdrmap.PeeringDir,
+		DelegationDir: shared.Bdrmap.DelegationDir,
+		ASRelDir:      shared.Bdrmap.ASRelDir,
+		MongoConfig:   shared.DB,
+		NotifyConfig:  shared.Notify,
+		Cleanup:       true,
+		AddResult:     true,
+		Deadline:      shared.Deadline,
+	}
+}
 
-func ReadBdrConfig() (*BdrConfig, bool) {
-	Param := &BdrConfig{}
-	help := false
-	flag.StringVar(&Param.ScamperBin, "scamper", filepath.Join(PROJECTDIR, "bin/scamper/bin/"), "path to scamper util binaries")
-	flag.StringVar(&Param.ResultDir, "r", filepath.Join(PROJECTDIR, "result/bdrmap"), "path to result file to be analyze (assume in tar.bz format)")
-	flag.StringVar(&Param.SiblingDir, "sb", filepath.Join(PROJECTDIR, "analysis/sibling"), "directory that contains sibling files")
-	flag.StringVar(&Param.Prefix2ASDir, "pfx", filepath.Join(PROJECTDIR, "analysis/prefix2as"), "directory that contains prefix2as files")
-	flag.StringVar(&Param.PeeringDir, "ixp", filepath.Join(PROJECTDIR, "analysis/peering"), "directory that contains peering (ixp) files")
-	flag.StringVar(&Param.DelegationDir, "d", filepath.Join(PROJECTDIR, "analysis/delegation/"), "directory that contains delegation files")
-	flag.StringVar(&Param.ASRelDir, "a", filepath.Join(PROJECTDIR, "analysis/as-rel/"), "directory that contains AS relationship files")
-	flag.StringVar(&Param.MongoConfig, "db", filepath.Join(PROJECTDIR, "bin/beamermongosp.json"), "path to mongodb information")
-	flag.StringVar(&Param.MattermostConfig, "mm", filepath.Join(PROJECTDIR, "bin/mattermostbot.json"), "path to mattermost bot config file")
+//Flags adds the flags of the bdrmap update to fs, the current settings are the defaults
+func (Param *BdrConfig) Flags(fs *flag.FlagSet) {
+	fs.StringVar(&Param.ScamperBin, "scamper", Param.ScamperBin, "path to scamper util binaries")
+	fs.StringVar(&Param.ResultDir, "r", Param.ResultDir, "path to result file to be analyze (assume in tar.bz format)")
+	fs.StringVar(&Param.SiblingDir, "sb", Param.SiblingDir, "directory that contains sibling files")
+	fs.StringVar(&Param.Prefix2ASDir, "pfx", Param.Prefix2ASDir, "directory that contains prefix2as files")
+	fs.StringVar(&Param.PeeringDir, "ixp", Param.PeeringDir, "directory that contains peering (ixp) files")
+	fs.StringVar(&Param.DelegationDir, "d", Param.DelegationDir, "directory that contains delegation files")
+	fs.StringVar(&Param.ASRelDir, "a", Param.ASRelDir, "directory that contains AS relationship files")
+	fs.StringVar(&Param.MongoConfig, "db", Param.MongoConfig, "path to mongodb information, or memory[:snapshot.json]")
+	fs.StringVar(&Param.NotifyConfig, "notify", Param.NotifyConfig, NotifyUsage)
 	//flag.StringVar(&Param.SpeedTestServersDir, "st", filepath.Join(PROJECTDIR, "result/spservers"), "directory that contains Speedtest servers information")
-	flag.BoolVar(&Param.Cleanup, "x", true, "Delete tmp directory after analysis")
-	flag.BoolVar(&Param.Quiet, "q", false, "Disable mattermost posting")
-	flag.BoolVar(&Param.Clean, "c", false, "Force to regenerate router/link/alias files")
-	flag.BoolVar(&Param.AddResult, "A", true, "Add router/link/alias files into original result archive. Note that original file will be replaced")
-	flag.BoolVar(&help, "h", false, "Print this help")
-	flag.Parse()
-	if help {
-		fmt.Println("This script extracts interdomain links from bdrmap runs and select speedtest servers.")
-		flag.PrintDefaults()
-		return nil, help
-	}
-	if _, err := os.Stat(Param.ResultDir); os.IsNotExist(err) {
-		log.Panic("Result dir does not exist.", Param.ResultDir)
-	}
-	if _, err := os.Stat(Param.DelegationDir); os.IsNotExist(err) {
-		log.Panic("Delegation Directory does not exist.", Param.DelegationDir)
-	}
-	if _, err := os.Stat(Param.ASRelDir); os.IsNotExist(err) {
-		log.Panic("AS Relationship Directory does not exist.", Param.ASRelDir)
+	fs.BoolVar(&Param.Cleanup, "x", Param.Cleanup, "Delete tmp directory after analysis")
+	fs.BoolVar(&Param.Quiet, "q", Param.Quiet, "Disable notifications")
+	fs.BoolVar(&Param.Clean, "c", Param.Clean, "Force to regenerate router/link/alias files")
+	fs.BoolVar(&Param.AddResult, "A", Param.AddResult, "Add router/link/alias files into original result archive. Note that original file will be replaced")
+	fs.DurationVar(&Param.Deadline, "deadline", Param.Deadline, "Stop the run cleanly after this long, e.g. 90m, 0 for no deadline")
+}
+
+//Check reports the directories and files of the settings that do not exist
+func (Param *BdrConfig) Check() error {
+	errs := []error{
+		checkExist("Result dir", Param.ResultDir),
+		checkExist("Delegation Directory", Param.DelegationDir),
+		checkExist("AS Relationship Directory", Param.ASRelDir),
+		checkExist("Sibling Directory", Param.SiblingDir),
+		checkExist("Prefix2AS Directory", Param.Prefix2ASDir),
+		checkExist("Peering Directory", Param.PeeringDir),
 	}
 	/*if _, err := os.Stat(Param.SpeedTestServersDir); os.IsNotExist(err) {
 		log.Panic("SpeedTest Server Directory does not exist.", Param.SpeedTestServersDir)
 	}*/
-	if _, err := os.Stat(Param.SiblingDir); os.IsNotExist(err) {
-		log.Panic("Sibling Directory does not exist.", Param.SiblingDir)
+	if !spdb.IsMemoryStore(Param.MongoConfig) {
+		errs = append(errs, checkExist("Mongodb config file", Param.MongoConfig))
 	}
-	if _, err := os.Stat(Param.Prefix2ASDir); os.IsNotExist(err) {
-		log.Panic("Prefix2AS Directory does not exist.", Param.Prefix2ASDir)
+	return errors.Join(errs...)
+}
+
+//Setup opens the notifier and the database
+func (Param *BdrConfig) Setup() error {
+	var err error
+	Param.Notifier, Param.MongoClient, err = openServices(Param.NotifyConfig, "Bdrmap Updater", Param.Quiet, Param.MongoConfig)
+	return err
+}
+
+func ReadBdrConfig() (*BdrConfig, bool, error) {
+	shared, err := SharedConfig()
+	if err != nil {
+		return nil, false, err
 	}
-	if _, err := os.Stat(Param.PeeringDir); os.IsNotExist(err) {
-		log.Panic("Peering Directory does not exist.", Param.PeeringDir)
+	Param := NewBdrConfig(shared)
+	help := false
+	Param.Flags(flag.CommandLine)
+	flag.BoolVar(&help, "h", false, "Print this help")
+	flag.Parse()
+	if help {
+		fmt.Println("This script extracts interdomain links from bdrmap runs and select speedtest servers.")
+		flag.PrintDefaults()
+		return nil, help, nil
 	}
-	if _, err := os.Stat(Param.MongoConfig); os.IsNotExist(err) {
-		log.Panic("Mongodb config file doest not exist", Param.MongoConfig)
+	if err := Param.Check(); err != nil {
+		return nil, false, err
 	}
-	if _, err := os.Stat(Param.MattermostConfig); os.IsNotExist(err) {
-		log.Panic("Mattermost config file does not exist", Param.MattermostConfig)
+	//setup notifications
+	if err := Param.Setup(); err != nil {
+		return nil, false, err
 	}
 
-	//setup Mattermost
-	Param.MMclient = mmbot.NewMMBot(Param.MattermostConfig)
-	Param.MongoClient = spdb.NewMongoDB(Param.MongoConfig, "speedtest")
-
 	//infer other file locations
-	return Param, false
+	return Param, false, nil
 }
 
-func (Param *BdrConfig) PrepareData(resultfile string) *BdrResult {
-	bresult := &BdrResult{}
+//PrepareData extracts a bdrmap result tarball into a temporary directory and locates the
+//auxiliary files named by its meta file. Errors wrap fileutils.ErrIncompleteArchive when the
+//tarball is broken and fileutils.ErrMissingAuxFile when an auxiliary file is missing
+func (Param *BdrConfig) PrepareData(resultfile string) (*BdrResult, error) {
+	bresult := &BdrResult{WartsFiles: make(map[string]string)}
 	tmpdir, err := ioutil.TempDir("./", "csp")
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	bresult.Tmpdir, _ = filepath.Abs(tmpdir)
@@ -127,15 +160,14 @@ func (Param *BdrConfig) PrepareData(resultfile string) *BdrResult {
 	cmd := exec.Command("tar", "xjf", resultfile, "-C", bresult.Tmpdir)
 	err = cmd.Run()
 	if err != nil {
-		log.Println("Decompress result file failed", err, resultfile)
-		return nil
+		bresult.CleanupTmp()
+		return nil, fmt.Errorf("%w: decompress %s: %v", fileutils.ErrIncompleteArchive, resultfile, err)
 	}
 	resultdir := filepath.Join(bresult.Tmpdir, "results/bdrmap")
 	files, err := ioutil.ReadDir(resultdir)
 	if err != nil {
 		bresult.CleanupTmp()
-		log.Println(err)
-		return nil
+		return nil, fmt.Errorf("%w: %s: %v", fileutils.ErrIncompleteArchive, resultfile, err)
 	}
 	for _, f := range files {
 		if strings.Contains(f.Name(), "bdrmap.meta") {
@@ -146,41 +178,23 @@ func (Param *BdrConfig) PrepareData(resultfile string) *BdrResult {
 			bresult.BdrWartsFile = filepath.Join(resultdir, f.Name())
 			continue
 		}
-		if strings.Contains(f.Name(), "comcast") {
-			bresult.ComcastWartsFile = filepath.Join(resultdir, f.Name())
-			continue
-		}
-		if strings.Contains(f.Name(), "ndt") {
-			bresult.NDTWartsFile = filepath.Join(resultdir, f.Name())
-			continue
-		}
-		if strings.Contains(f.Name(), "ookla") {
-			bresult.OoklaWartsFile = filepath.Join(resultdir, f.Name())
-			continue
+		if p, pexist := platform.ByFile(f.Name()); pexist {
+			bresult.WartsFiles[p.Name()] = filepath.Join(resultdir, f.Name())
 		}
 	}
 
-	if _, err := os.Stat(bresult.BdrWartsFile); os.IsNotExist(err) {
-		log.Println("Warts File does not exist.", bresult.BdrWartsFile)
+	if len(bresult.BdrWartsFile) == 0 || len(bresult.MetaFile) == 0 {
 		bresult.CleanupTmp()
-		return nil
+		return nil, fmt.Errorf("%w: %s has no bdrmap warts or meta file", fileutils.ErrIncompleteArchive, resultfile)
 	}
 	if !Param.Clean {
-		//Check for router/link/alias files from previous runs
+		//Check for router files from previous runs
 		rootfiles, err := ioutil.ReadDir(bresult.Tmpdir)
 		if err != nil {
-			log.Panic(err)
-			return nil
+			bresult.CleanupTmp()
+			return nil, err
 		}
 		for _, f := range rootfiles {
-			if strings.Contains(f.Name(), "bdrmap.aliases.out") {
-				bresult.AliasesFile = filepath.Join(bresult.Tmpdir, f.Name())
-				continue
-			}
-			if strings.Contains(f.Name(), "bdrmap.links.out") {
-				bresult.LinkFile = filepath.Join(bresult.Tmpdir, f.Name())
-				continue
-			}
 			if strings.Contains(f.Name(), "bdrmap.router.txt") {
 				bresult.RTRFile = filepath.Join(bresult.Tmpdir, f.Name())
 				continue
@@ -219,56 +233,54 @@ func (Param *BdrConfig) PrepareData(resultfile string) *BdrResult {
 	*/
 	mdata, err := ioutil.ReadFile(bresult.MetaFile)
 	if err != nil {
-		log.Panic("Failed to read Meta file:", bresult.MetaFile, err)
 		bresult.CleanupTmp()
-		return nil
+		return nil, fmt.Errorf("%w: read meta file: %v", fileutils.ErrIncompleteArchive, err)
 	}
 	mdataarr := strings.Split(strings.TrimSuffix(string(mdata), "\n"), ",")
 	//expect: <unix timestamp>,<peering file>,<sibling file>,<prefix2as file>
 	//example: 1586944209,/home/ubuntu/outdir/datafiles/202001.v4.peering,/home/ubuntu/outdir/datafiles/amazon.sibling.active,/home/ubuntu/outdir/datafiles/20200401.prefix2as
 	if len(mdataarr) != 4 {
-		log.Panic("Incorrect format in meta file")
 		bresult.CleanupTmp()
-		return nil
+		return nil, fmt.Errorf("%w: incorrect format in meta file %s", fileutils.ErrIncompleteArchive, filepath.Base(bresult.MetaFile))
 	}
 	bresult.PeeringFile = filepath.Join(Param.PeeringDir, filepath.Base(mdataarr[1]))
-
-	if _, err := os.Stat(bresult.PeeringFile); os.IsNotExist(err) {
-		log.Panic("Peering File does not exist.", bresult.PeeringFile)
+	if err := bresult.checkAuxFile("peering", bresult.PeeringFile); err != nil {
+		return nil, err
 	}
 	//remove active suffix, replace with txt
 	tmpsib := filepath.Base(mdataarr[2])
 	siblingext := filepath.Ext(mdataarr[2])
 	bresult.SiblingFile = filepath.Join(Param.SiblingDir, tmpsib[:len(tmpsib)-len(siblingext)]+".txt")
-	if _, err := os.Stat(bresult.SiblingFile); os.IsNotExist(err) {
-		log.Panic("Sibling File does not exist.", bresult.SiblingFile)
-		bresult.CleanupTmp()
-		return nil
+	if err := bresult.checkAuxFile("sibling", bresult.SiblingFile); err != nil {
+		return nil, err
 	}
 	ip2as := filepath.Base(mdataarr[3])
 	bresult.Prefix2ASFile = filepath.Join(Param.Prefix2ASDir, ip2as)
-	if _, err := os.Stat(bresult.Prefix2ASFile); os.IsNotExist(err) {
-		log.Panic("Prefix2AS File does not exist.", bresult.Prefix2ASFile)
-		bresult.CleanupTmp()
-		return nil
+	if err := bresult.checkAuxFile("prefix2as", bresult.Prefix2ASFile); err != nil {
+		return nil, err
 	}
 	//find the AS relationship of the same month as Prefix2AS
 	//assume yyyymmdd.prefix2as->yyyymmdd.as-rel.txt
 	yrmondd := ip2as[:len(ip2as)-len(filepath.Ext(ip2as))]
 	bresult.ASRelFile = filepath.Join(Param.ASRelDir, yrmondd+".as-rel.txt")
-	if _, err := os.Stat(bresult.ASRelFile); os.IsNotExist(err) {
-		log.Panic("AS relationship file does not exist.", bresult.ASRelFile)
-		bresult.CleanupTmp()
-		return nil
+	if err := bresult.checkAuxFile("AS relationship", bresult.ASRelFile); err != nil {
+		return nil, err
 	}
 	yrmon := yrmondd[:len(yrmondd)-2]
 	bresult.DelegationFile = filepath.Join(Param.DelegationDir, "delegated-ipv4-"+yrmon+".txt")
-	if _, err := os.Stat(bresult.DelegationFile); os.IsNotExist(err) {
-		log.Panic("Delegation file does not exist.", bresult.DelegationFile)
-		bresult.CleanupTmp()
-		return nil
+	if err := bresult.checkAuxFile("delegation", bresult.DelegationFile); err != nil {
+		return nil, err
+	}
+	return bresult, nil
+}
+
+//checkAuxFile cleans up the extracted result if the auxiliary file does not exist
+func (b *BdrResult) checkAuxFile(kind, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		b.CleanupTmp()
+		return fmt.Errorf("%w: %s file %s", fileutils.ErrMissingAuxFile, kind, path)
 	}
-	return bresult
+	return nil
 }
 
 func (b *BdrResult) CleanupTmp() {
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"spservers/spdb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CongestionConfig struct {
+	MongoConfig       string
+	Regions           []string
+	Location          *time.Location
+	StartDate         time.Time
+	EndDate           time.Time
+	PeakStart         int
+	PeakEnd           int
+	MinSamples        int
+	DropThreshold     float64
+	ElevThreshold     float64
+	RecurringFraction float64
+	MinDays           int
+	Profile           bool
+	Deadline          time.Duration
+	MongoClient       spdb.Store
+}
+
+func ReadCongestionConfig() (*CongestionConfig, error) {
+	shared, err := SharedConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &CongestionConfig{}
+	var regions, tz, peak string
+	sts := time.Now().AddDate(0, 0, -30).Unix()
+	ets := time.Now().Unix()
+	flag.StringVar(&cfg.MongoConfig, "db", shared.DB, "path to mongodb info, or memory[:snapshot.json]")
+	flag.StringVar(&regions, "region", "", "comma separated regions, e.g. gcp-east1. all regions if empty")
+	flag.StringVar(&tz, "tz", "", "time zone of the peak hours for all regions, the time zone of each region if empty")
+	flag.StringVar(&peak, "peak", "19-23", "peak hours in local time, start-end")
+	flag.Int64Var(&sts, "ts", sts, "Unix timestamp of start time")
+	flag.Int64Var(&ets, "te", ets, "Unix timestamp of end time")
+	flag.IntVar(&cfg.MinSamples, "n", 2, "Minimum samples in peak and off-peak hours of a day")
+	flag.Float64Var(&cfg.DropThreshold, "drop", 0.2, "Relative peak-hour throughput drop considered congested")
+	flag.Float64Var(&cfg.ElevThreshold, "elev", 10.0, "Peak-hour far-near rtt elevation (ms) considered congested")
+	flag.Float64Var(&cfg.RecurringFraction, "frac", 0.3, "Fraction of congested days to flag a link")
+	flag.IntVar(&cfg.MinDays, "days", 3, "Minimum congested days to flag a link")
+	flag.BoolVar(&cfg.Profile, "profile", false, "Print the diurnal profile of flagged links")
+	flag.DurationVar(&cfg.Deadline, "deadline", shared.Deadline, "Stop the run cleanly after this long, e.g. 90m, 0 for no deadline")
+	flag.Parse()
+	if len(tz) > 0 {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("Unknown time zone %s", tz)
+		}
+		cfg.Location = loc
+	}
+	peakarr := strings.Split(peak, "-")
+	if len(peakarr) != 2 {
+		return nil, errors.New("Peak hours should be start-end")
+	}
+	if cfg.PeakStart, err = strconv.Atoi(peakarr[0]); err != nil || cfg.PeakStart < 0 || cfg.PeakStart > 23 {
+		return nil, fmt.Errorf("Invalid peak start %s", peakarr[0])
+	}
+	if cfg.PeakEnd, err = strconv.Atoi(peakarr[1]); err != nil || cfg.PeakEnd < 0 || cfg.PeakEnd > 24 {
+		return nil, fmt.Errorf("Invalid peak end %s", peakarr[1])
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 1
+	}
+	if cfg.MinDays <= 0 {
+		cfg.MinDays = 1
+	}
+	cfg.StartDate = time.Unix(sts, 0)
+	cfg.EndDate = time.Unix(ets, 0)
+	if cfg.MongoClient, err = spdb.OpenStore(cfg.MongoConfig, "speedtest"); err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	if len(regions) > 0 {
+		cfg.Regions = strings.Split(regions, ",")
+	} else {
+		//traceroutes are stored per vm, collapse them to regions
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		vms, err := cfg.MongoClient.ListRegions(ctx)
+		cancel()
+		if err != nil {
+			cfg.MongoClient.Close()
+			return nil, fmt.Errorf("list regions: %w", err)
+		}
+		seen := make(map[string]bool)
+		for _, vm := range vms {
+			if region := VMNametoRegion(vm); len(region) > 0 && !seen[region] {
+				seen[region] = true
+				cfg.Regions = append(cfg.Regions, region)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+//RegionLocation is the time zone the peak hours of region are in, Location
+//is only set by -tz and overrides the time zone of every region
+func (cfg *CongestionConfig) RegionLocation(region string) (*time.Location, error) {
+	if cfg.Location != nil {
+		return cfg.Location, nil
+	}
+	return RegionTimeZone(region)
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"spservers/notify"
+	"spservers/spdb"
+	"strings"
+	"time"
+)
+
+type FleetConfig struct {
+	MongoConfig    string
+	NotifyConfig   string
+	RegionConfig   string
+	TargetperVM    int
+	MaxVMperRegion int
+	DryRun         bool
+	DeleteSurplus  bool
+	//regions to reconcile, all regions of RegionConfig if empty
+	Regions     []string
+	CloudRegion map[string]*CloudRegion
+	Notifier    notify.Notifier
+	Deadline    time.Duration
+	MongoClient spdb.Store
+}
+
+//where the vms of a region run, e.g. "gcp-west1": {"provider": "gcp", "region": "us-west1"}
+type CloudRegion struct {
+	Provider string `json:"provider"`
+	Region   string `json:"region"`
+	//gcp project
+	Project string `json:"project"`
+	//aws vm config json, needed to create aws vms
+	VMConfig string `json:"vmconfig"`
+	//ssh public key, needed to create azure vms
+	SSHKey string `json:"sshkey"`
+}
+
+func ReadFleetConfig() (*FleetConfig, error) {
+	shared, err := SharedConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &FleetConfig{}
+	var regions string
+	flag.StringVar(&cfg.MongoConfig, "db", shared.DB, "path to mongodb info, or memory[:snapshot.json]")
+	flag.StringVar(&cfg.NotifyConfig, "notify", shared.Notify, NotifyUsage)
+	flag.StringVar(&cfg.RegionConfig, "regions", filepath.Join(shared.ProjectDir, "bin/fleetregions.json"), "path to the json map of region to cloud provider and region")
+	flag.StringVar(&regions, "region", "", "comma separated regions, e.g. gcp-west1. all configured regions if empty")
+	flag.IntVar(&cfg.TargetperVM, "t", 20, "measurement targets per VM")
+	flag.IntVar(&cfg.MaxVMperRegion, "x", 9, "Maximum number of VM per region")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "Print the plan without changing any VM")
+	flag.BoolVar(&cfg.DeleteSurplus, "delete", false, "Delete surplus VMs instead of stopping them")
+	flag.DurationVar(&cfg.Deadline, "deadline", shared.Deadline, "Stop the run cleanly after this long, e.g. 90m, 0 for no deadline")
+	flag.Parse()
+	if cfg.TargetperVM <= 0 {
+		cfg.TargetperVM = 1
+	}
+	if cfg.MaxVMperRegion <= 0 {
+		cfg.MaxVMperRegion = 1
+	}
+	rfile, err := os.Open(cfg.RegionConfig)
+	if err != nil {
+		return nil, fmt.Errorf("read fleet regions: %w", err)
+	}
+	defer rfile.Close()
+	cfg.CloudRegion = make(map[string]*CloudRegion)
+	if err := json.NewDecoder(rfile).Decode(&cfg.CloudRegion); err != nil {
+		return nil, fmt.Errorf("decode fleet regions %s: %w", cfg.RegionConfig, err)
+	}
+	if len(regions) > 0 {
+		cfg.Regions = strings.Split(regions, ",")
+	} else {
+		for region := range cfg.CloudRegion {
+			cfg.Regions = append(cfg.Regions, region)
+		}
+		sort.Strings(cfg.Regions)
+	}
+	cfg.Notifier, cfg.MongoClient, err = openServices(cfg.NotifyConfig, "Fleet Reconciler", false, cfg.MongoConfig)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"flag"
+	"spservers/notify"
+	"spservers/spdb"
+	"strings"
+	"time"
+)
+
+type LinkReportConfig struct {
+	MongoConfig  string
+	NotifyConfig string
+	Regions      []string
+	StartDate    time.Time
+	EndDate      time.Time
+	Quiet        bool
+	Notifier     notify.Notifier
+	Deadline     time.Duration
+	MongoClient  spdb.Store
+}
+
+func ReadLinkReportConfig() (*LinkReportConfig, error) {
+	shared, err := SharedConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &LinkReportConfig{}
+	var regions string
+	var days int
+	ets := time.Now().Unix()
+	flag.StringVar(&cfg.MongoConfig, "db", shared.DB, "path to mongodb info, or memory[:snapshot.json]")
+	flag.StringVar(&cfg.NotifyConfig, "notify", shared.Notify, NotifyUsage)
+	flag.StringVar(&regions, "region", "", "comma separated regions, e.g. gcp-east1. all regions if empty")
+	flag.IntVar(&days, "days", 7, "Number of days before the end time to report")
+	flag.Int64Var(&ets, "te", ets, "Unix timestamp of end time")
+	flag.BoolVar(&cfg.Quiet, "q", false, "Print the report only, do not send it")
+	flag.DurationVar(&cfg.Deadline, "deadline", shared.Deadline, "Stop the run cleanly after this long, e.g. 90m, 0 for no deadline")
+	flag.Parse()
+	if days <= 0 {
+		days = 1
+	}
+	cfg.EndDate = time.Unix(ets, 0)
+	cfg.StartDate = cfg.EndDate.AddDate(0, 0, -days)
+	if len(regions) > 0 {
+		cfg.Regions = strings.Split(regions, ",")
+	}
+	cfg.Notifier, cfg.MongoClient, err = openServices(cfg.NotifyConfig, "Link Report", cfg.Quiet, cfg.MongoConfig)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"spservers/geo"
+	"spservers/spdb"
+	"time"
+)
+
+//where the data center of a vm region is, the cloud providers publish the
+//city only, coordinates are those of the city. Tz is the time zone of the
+//users the region mostly serves, peak hours are local to it
+type RegionGeo struct {
+	Provider    string
+	CloudRegion string
+	City        string
+	Country     string
+	Lon         float64
+	Lat         float64
+	Tz          string
+}
+
+//the regions the vms are deployed in, named <provider>-<region> as in the vm
+//names of the server lists, e.g. aws-oh-1
+var regionGeo = map[string]RegionGeo{
+	"gcp-west1":          {"gcp", "us-west1", "The Dalles", "US", -121.18, 45.60, "America/Los_Angeles"},
+	"gcp-west2":          {"gcp", "us-west2", "Los Angeles", "US", -118.24, 34.05, "America/Los_Angeles"},
+	"gcp-west3":          {"gcp", "us-west3", "Salt Lake City", "US", -111.89, 40.76, "America/Denver"},
+	"gcp-west4":          {"gcp", "us-west4", "Las Vegas", "US", -115.14, 36.17, "America/Los_Angeles"},
+	"gcp-central1":       {"gcp", "us-central1", "Council Bluffs", "US", -95.86, 41.26, "America/Chicago"},
+	"gcp-east1":          {"gcp", "us-east1", "Moncks Corner", "US", -80.01, 33.20, "America/New_York"},
+	"gcp-east4":          {"gcp", "us-east4", "Ashburn", "US", -77.49, 39.04, "America/New_York"},
+	"gcp-euwest1":        {"gcp", "europe-west1", "St. Ghislain", "BE", 3.82, 50.47, "Europe/Brussels"},
+	"gcp-asianortheast1": {"gcp", "asia-northeast1", "Tokyo", "JP", 139.69, 35.69, "Asia/Tokyo"},
+	"aws-vg":             {"aws", "us-east-1", "Ashburn", "US", -77.49, 39.04, "America/New_York"},
+	"aws-oh":             {"aws", "us-east-2", "Columbus", "US", -82.99, 39.96, "America/New_York"},
+	"aws-ca":             {"aws", "us-west-1", "San Jose", "US", -121.89, 37.34, "America/Los_Angeles"},
+	"aws-og":             {"aws", "us-west-2", "Boardman", "US", -119.70, 45.84, "America/Los_Angeles"},
+	"ms-east2":           {"ms", "eastus2", "Boydton", "US", -78.39, 36.67, "America/New_York"},
+	"ms-west2":           {"ms", "westus2", "Quincy", "US", -119.85, 47.23, "America/Los_Angeles"},
+	"ms-central1":        {"ms", "centralus", "Des Moines", "US", -93.62, 41.59, "America/Chicago"},
+}
+
+//LookupRegionGeo takes a region, e.g. gcp-west1, or the name of one of its vms
+func LookupRegionGeo(name string) (RegionGeo, bool) {
+	if region := VMNametoRegion(name); len(region) > 0 {
+		name = region
+	}
+	rg, rexist := regionGeo[name]
+	return rg, rexist
+}
+
+//RegionLocation of a region or vm, false if the region is not known
+func RegionLocation(name string) (spdb.JSONPoint, bool) {
+	rg, rexist := LookupRegionGeo(name)
+	if !rexist {
+		return spdb.JSONPoint{}, false
+	}
+	return spdb.NewPoint(rg.Lon, rg.Lat), true
+}
+
+//RegionContinent of a region or vm, empty if the region is not known
+func RegionContinent(name string) string {
+	if rg, rexist := LookupRegionGeo(name); rexist {
+		return geo.Continent(rg.Country)
+	}
+	return ""
+}
+
+//RegionTimeZone of a region or vm, an error if the region is not known
+func RegionTimeZone(name string) (*time.Location, error) {
+	rg, rexist := LookupRegionGeo(name)
+	if !rexist {
+		return nil, fmt.Errorf("No time zone for region %s", name)
+	}
+	return time.LoadLocation(rg.Tz)
+}
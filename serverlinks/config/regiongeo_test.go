@@ -0,0 +1,26 @@
+package config
+
+import "testing"
+
+//vm names of the deployed regions, as in the server lists
+func TestLookupRegionGeo(t *testing.T) {
+	for _, vm := range []string{"aws-oh-1", "aws-vg-7", "aws-ca-2", "aws-og-8", "gcp-central1-3", "gcp-east1-10", "gcp-euwest1-1", "gcp-asianortheast1-1", "ms-east2-4", "ms-west2-1", "ms-central1-2"} {
+		rg, rexist := LookupRegionGeo(vm)
+		if !rexist {
+			t.Errorf("no region for %s", vm)
+			continue
+		}
+		if _, err := RegionTimeZone(vm); err != nil {
+			t.Errorf("time zone of %s: %v", vm, err)
+		}
+		if RegionContinent(vm) == "" || rg.Provider != VMNametoProvider(vm) {
+			t.Errorf("%s = %+v", vm, rg)
+		}
+	}
+	if _, rexist := RegionLocation("aws-east1-1"); rexist {
+		t.Errorf("unknown region has a location")
+	}
+	if _, err := RegionTimeZone("aws-east1"); err == nil {
+		t.Errorf("unknown region has a time zone")
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"spservers/common"
+	"spservers/spdb"
+	"time"
+)
+
+type RunsConfig struct {
+	MongoConfig string
+	Command     string
+	RunId       string
+	StartDate   time.Time
+	EndDate     time.Time
+	JSON        bool
+	MongoClient spdb.Store
+}
+
+//ReadRunsConfig parses the flags of clasp runs from args, a run id may follow the flags
+func ReadRunsConfig(shared *common.ClaspConfig, args []string) (*RunsConfig, error) {
+	cfg := &RunsConfig{}
+	var days int
+	ets := time.Now().Unix()
+	fs := flag.NewFlagSet("runs", flag.ExitOnError)
+	fs.StringVar(&cfg.MongoConfig, "db", shared.DB, "path to mongodb info, or memory[:snapshot.json]")
+	fs.StringVar(&cfg.Command, "cmd", "", "only list runs of this command, e.g. updatebdrmap. all commands if empty")
+	fs.IntVar(&days, "days", 7, "Number of days before the end time to list")
+	fs.Int64Var(&ets, "te", ets, "Unix timestamp of end time")
+	fs.BoolVar(&cfg.JSON, "json", false, "Print the run records as json")
+	fs.Parse(args)
+	if days <= 0 {
+		days = 1
+	}
+	cfg.EndDate = time.Unix(ets, 0)
+	cfg.StartDate = cfg.EndDate.AddDate(0, 0, -days)
+	cfg.RunId = fs.Arg(0)
+	db, err := spdb.OpenStore(cfg.MongoConfig, "speedtest")
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	cfg.MongoClient = db
+	return cfg, nil
+}
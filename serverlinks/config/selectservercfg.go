@@ -1,11 +1,11 @@
 package config
 
 import (
+	"errors"
 	"flag"
-	"log"
-	"mmbot"
-	"os"
-	"path/filepath"
+	"fmt"
+	"spservers/common"
+	"spservers/notify"
 	"spservers/spdb"
 	"time"
 
@@ -13,18 +13,21 @@ import (
 )
 
 type SsConfig struct {
-	OutputDir        string
-	MongoConfig      string
-	MattermostConfig string
-	Worker           int
-	TargetperVM      int
-	MaxVMperRegion   int
-	MinTrThreshold   int
-	RttThreshold     float64
-	StartDate        time.Time
-	EnableDate       time.Time
-	MMclient         *mmbot.MMBot
-	MongoClient      *spdb.SpeedtestMongo
+	OutputDir      string
+	MongoConfig    string
+	NotifyConfig   string
+	Worker         int
+	TargetperVM    int
+	MaxVMperRegion int
+	MinTrThreshold int
+	RttThreshold   float64
+	MaxDistance    float64 //farthest server from the region in km, 0 for no limit
+	LinkGroup      string
+	StartDate      time.Time
+	EnableDate     time.Time
+	Notifier       notify.Notifier
+	Deadline       time.Duration
+	MongoClient    spdb.Store
 }
 
 type SsResult struct {
@@ -39,25 +42,56 @@ type SsResult struct {
 	AvgRtt float64
 }
 
-func ReadSsConfig() *SsConfig {
-	cfg := &SsConfig{}
-	sttime := time.Now().AddDate(0, 0, -7)
-	sts := sttime.Unix()
-	ets := time.Now().Unix()
-	flag.StringVar(&cfg.OutputDir, "o", "./", "path to output results")
-	flag.StringVar(&cfg.MongoConfig, "db", filepath.Join(PROJECTDIR, "bin/beamermongosp.json"), "path to mongodb info")
-	flag.StringVar(&cfg.MattermostConfig, "mm", filepath.Join(PROJECTDIR, "bin/mattermostbot.json"), "path to mattermost bot config file")
-	flag.IntVar(&cfg.Worker, "w", 10, "Number of workers")
-	flag.IntVar(&cfg.TargetperVM, "t", 20, "measurement targets per VM")
-	flag.IntVar(&cfg.MaxVMperRegion, "x", 9, "Maximum number of VM per region")
-	flag.IntVar(&cfg.MinTrThreshold, "tr", 10, "Minimum number of traceroute required to be observed")
-	flag.Float64Var(&cfg.RttThreshold, "rtt", 150.0, "Maximum RTT to be considered as a target")
-	flag.Int64Var(&sts, "ts", sts, "Unix timestamp of start time")
-	flag.Int64Var(&ets, "ets", ets, "Unix timestamp of enable time")
-	flag.Parse()
-	if _, err := os.Stat(cfg.OutputDir); os.IsNotExist(err) {
-		log.Panic("Output directory does not exist")
+//NewSsConfig returns the server selection settings of the shared config, the
+//traceroutes of the last 7 days are used and the targets are enabled now
+func NewSsConfig(shared *common.ClaspConfig) *SsConfig {
+	return &SsConfig{
+		OutputDir:      shared.Select.OutputDir,
+		MongoConfig:    shared.DB,
+		NotifyConfig:   shared.Notify,
+		Worker:         shared.Select.Workers,
+		TargetperVM:    shared.Select.TargetsPerVM,
+		MaxVMperRegion: shared.Select.MaxVMsPerRegion,
+		MinTrThreshold: shared.Select.MinTraceroutes,
+		RttThreshold:   shared.Select.MaxRTT,
+		MaxDistance:    shared.Select.MaxDistance,
+		LinkGroup:      shared.Select.LinkGroup,
+		StartDate:      time.Now().AddDate(0, 0, -7),
+		EnableDate:     time.Now(),
+		Deadline:       shared.Deadline,
+	}
+}
+
+//Flags adds the flags of the server selection to fs, the current settings are the defaults
+func (cfg *SsConfig) Flags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.OutputDir, "o", cfg.OutputDir, "path to output results")
+	fs.StringVar(&cfg.MongoConfig, "db", cfg.MongoConfig, "path to mongodb info, or memory[:snapshot.json]")
+	fs.StringVar(&cfg.NotifyConfig, "notify", cfg.NotifyConfig, NotifyUsage)
+	fs.IntVar(&cfg.Worker, "w", cfg.Worker, "Number of workers")
+	fs.IntVar(&cfg.TargetperVM, "t", cfg.TargetperVM, "measurement targets per VM")
+	fs.IntVar(&cfg.MaxVMperRegion, "x", cfg.MaxVMperRegion, "Maximum number of VM per region")
+	fs.IntVar(&cfg.MinTrThreshold, "tr", cfg.MinTrThreshold, "Minimum number of traceroute required to be observed")
+	fs.Float64Var(&cfg.RttThreshold, "rtt", cfg.RttThreshold, "Maximum RTT to be considered as a target")
+	fs.Float64Var(&cfg.MaxDistance, "maxkm", cfg.MaxDistance, "Maximum distance in km between the region and a target, 0 for no limit")
+	fs.StringVar(&cfg.LinkGroup, "group", cfg.LinkGroup, "select one server per link group: interface/router/as")
+	fs.Var(unixFlag{&cfg.StartDate}, "ts", "Unix timestamp of start time")
+	fs.Var(unixFlag{&cfg.EnableDate}, "ets", "Unix timestamp of enable time")
+	fs.DurationVar(&cfg.Deadline, "deadline", cfg.Deadline, "Stop the run cleanly after this long, e.g. 90m, 0 for no deadline")
+}
+
+//Check reports the settings that can not work
+func (cfg *SsConfig) Check() error {
+	errs := []error{checkExist("Output directory", cfg.OutputDir)}
+	switch cfg.LinkGroup {
+	case spdb.LinkGroupInterface, spdb.LinkGroupRouter, spdb.LinkGroupAS:
+	default:
+		errs = append(errs, fmt.Errorf("Unknown link group %s", cfg.LinkGroup))
 	}
+	return errors.Join(errs...)
+}
+
+//Setup opens the notifier and the database
+func (cfg *SsConfig) Setup() error {
 	if cfg.Worker <= 0 {
 		cfg.Worker = 1
 	}
@@ -73,10 +107,27 @@ func ReadSsConfig() *SsConfig {
 	if cfg.RttThreshold < 0 {
 		cfg.RttThreshold = 1
 	}
-	cfg.StartDate = time.Unix(sts, 0)
-	cfg.EnableDate = time.Unix(ets, 0)
-	cfg.MMclient = mmbot.NewMMBot(cfg.MattermostConfig)
-	cfg.MMclient.Username = "SelectServer Process"
-	cfg.MongoClient = spdb.NewMongoDB(cfg.MongoConfig, "speedtest")
-	return cfg
+	if cfg.MaxDistance < 0 {
+		cfg.MaxDistance = 0
+	}
+	var err error
+	cfg.Notifier, cfg.MongoClient, err = openServices(cfg.NotifyConfig, "SelectServer Process", false, cfg.MongoConfig)
+	return err
+}
+
+func ReadSsConfig() (*SsConfig, error) {
+	shared, err := SharedConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg := NewSsConfig(shared)
+	cfg.Flags(flag.CommandLine)
+	flag.Parse()
+	if err := cfg.Check(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Setup(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
 }
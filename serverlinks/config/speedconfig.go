@@ -0,0 +1,44 @@
+package config
+
+import (
+	"flag"
+	"path/filepath"
+	"spservers/notify"
+	"spservers/spdb"
+	"time"
+)
+
+type SpeedConfig struct {
+	ResultDir    string
+	MongoConfig  string
+	NotifyConfig string
+	VMWorker     int
+	Notifier     notify.Notifier
+	Deadline     time.Duration
+	MongoClient  spdb.Store
+}
+
+func ReadSpeedConfig() (*SpeedConfig, error) {
+	shared, err := SharedConfig()
+	if err != nil {
+		return nil, err
+	}
+	Param := &SpeedConfig{}
+	flag.StringVar(&Param.ResultDir, "r", filepath.Join(shared.ProjectDir, "result/speedtest"), "path to speed test results, as <vm>/<year>/<month>/")
+	flag.StringVar(&Param.MongoConfig, "db", shared.DB, "path to mongodb information, or memory[:snapshot.json]")
+	flag.StringVar(&Param.NotifyConfig, "notify", shared.Notify, NotifyUsage)
+	flag.IntVar(&Param.VMWorker, "vw", 5, "Number of VM workers")
+	flag.DurationVar(&Param.Deadline, "deadline", shared.Deadline, "Stop the run cleanly after this long, e.g. 90m, 0 for no deadline")
+	flag.Parse()
+	if err := checkExist("Result directory", Param.ResultDir); err != nil {
+		return nil, err
+	}
+	if Param.VMWorker <= 0 {
+		Param.VMWorker = 1
+	}
+	Param.Notifier, Param.MongoClient, err = openServices(Param.NotifyConfig, "Speedtest Updater", false, Param.MongoConfig)
+	if err != nil {
+		return nil, err
+	}
+	return Param, nil
+}
@@ -1,111 +1,156 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
-	"mmbot"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"serverlinks/fileutils"
 	"serverlinks/iputils"
+	"spservers/common"
+	"spservers/notify"
+	"spservers/platform"
 	"spservers/spdb"
 	"strings"
+	"time"
 )
 
 type TrConfig struct {
-	ScamperBin       string
-	ResultDir        string
-	Prefix2ASPathv4  string
-	MongoConfig      string
-	MattermostConfig string
-	VMWorker         int
-	TrWorker         int
-	Cleanup          bool
-	MMclient         *mmbot.MMBot
-	MongoClient      *spdb.SpeedtestMongo
+	ScamperBin      string
+	ResultDir       string
+	Prefix2ASPathv4 string
+	MongoConfig     string
+	NotifyConfig    string
+	VMWorker        int
+	TrWorker        int
+	Cleanup         bool
+	ServerRefresh   time.Duration
+	Notifier        notify.Notifier
+	Deadline        time.Duration
+	MongoClient     spdb.Store
 }
 
 type TrResult struct {
-	TraceTs          int64
-	MetaFile         string
-	NDTWartsFile     string
-	OoklaWartsFile   string
-	ComcastWartsFile string
-	Tmpdir           string
-	Prefix2As        iputils.IPHandler
+	TraceTs  int64
+	MetaFile string
+	//traceroutes towards the servers of each platform, by platform name
+	WartsFiles map[string]string
+	Tmpdir     string
+	Prefix2As  iputils.IPHandler
 }
 
-func ReadTrConfig() *TrConfig {
-	Param := &TrConfig{}
-	flag.StringVar(&Param.ScamperBin, "scamper", filepath.Join(PROJECTDIR, "bin/scamper/bin/"), "path to scamper util binaries")
-	flag.StringVar(&Param.ResultDir, "r", filepath.Join(PROJECTDIR, "result/trace"), "path to result file to be analyze (assume in tar.bz format)")
-	flag.StringVar(&Param.Prefix2ASPathv4, "pfxv4", "", "path prefix to IPv4 prefix2as file")
-	flag.StringVar(&Param.MongoConfig, "db", filepath.Join(PROJECTDIR, "bin/beamermongosp.json"), "path to mongodb information")
-	flag.StringVar(&Param.MattermostConfig, "mm", filepath.Join(PROJECTDIR, "bin/mattermostbot.json"), "path to mattermost bot config file")
-	flag.IntVar(&Param.VMWorker, "vw", 5, "Number of VM workers")
-	flag.IntVar(&Param.TrWorker, "tw", 100, "Number of traceroute workers")
-	flag.Parse()
-	if _, err := os.Stat(Param.ScamperBin); os.IsNotExist(err) {
-		log.Panic("scamper bin directory does not exist")
-	}
-	if _, err := os.Stat(Param.ResultDir); os.IsNotExist(err) {
-		log.Panic("Result directory does not exist")
+//NewTrConfig returns the traceroute update settings of the shared config
+func NewTrConfig(shared *common.ClaspConfig) *TrConfig {
+	return &TrConfig{
+		ScamperBin:      shared.Trace.ScamperBin,
+		ResultDir:       shared.Trace.ResultDir,
+		Prefix2ASPathv4: shared.Trace.Prefix2ASv4,
+		MongoConfig:     shared.DB,
+		NotifyConfig:    shared.Notify,
+		VMWorker:        shared.Trace.VMWorkers,
+		TrWorker:        shared.Trace.TraceWorkers,
+		ServerRefresh:   shared.Trace.ServerRefresh,
+		Deadline:        shared.Deadline,
 	}
+}
+
+//Flags adds the flags of the traceroute update to fs, the current settings are the defaults
+func (Param *TrConfig) Flags(fs *flag.FlagSet) {
+	fs.StringVar(&Param.ScamperBin, "scamper", Param.ScamperBin, "path to scamper util binaries")
+	fs.StringVar(&Param.ResultDir, "r", Param.ResultDir, "path to result file to be analyze (assume in tar.bz format)")
+	fs.StringVar(&Param.Prefix2ASPathv4, "pfxv4", Param.Prefix2ASPathv4, "path prefix to IPv4 prefix2as file")
+	fs.StringVar(&Param.MongoConfig, "db", Param.MongoConfig, "path to mongodb information, or memory[:snapshot.json]")
+	fs.StringVar(&Param.NotifyConfig, "notify", Param.NotifyConfig, NotifyUsage)
+	fs.IntVar(&Param.VMWorker, "vw", Param.VMWorker, "Number of VM workers")
+	fs.IntVar(&Param.TrWorker, "tw", Param.TrWorker, "Number of traceroute workers")
+	fs.DurationVar(&Param.ServerRefresh, "srvrefresh", Param.ServerRefresh, "Reload the speed test servers after this long, 0 to load them once per run")
+	fs.DurationVar(&Param.Deadline, "deadline", Param.Deadline, "Stop the run cleanly after this long, e.g. 90m, 0 for no deadline")
+}
+
+//Check reports the directories of the settings that do not exist
+func (Param *TrConfig) Check() error {
+	return errors.Join(checkExist("scamper bin directory", Param.ScamperBin), checkExist("Result directory", Param.ResultDir))
+}
+
+//Setup opens the notifier and the database
+func (Param *TrConfig) Setup() error {
 	if Param.VMWorker <= 0 {
 		Param.VMWorker = 1
 	}
 	if Param.TrWorker <= 0 {
 		Param.TrWorker = 1
 	}
-	Param.MMclient = mmbot.NewMMBot(Param.MattermostConfig)
-	Param.MongoClient = spdb.NewMongoDB(Param.MongoConfig, "speedtest")
-	return Param
+	if Param.ServerRefresh < 0 {
+		Param.ServerRefresh = 0
+	}
+	var err error
+	Param.Notifier, Param.MongoClient, err = openServices(Param.NotifyConfig, "Traceroute Updater", false, Param.MongoConfig)
+	return err
 }
 
-func (trconfig *TrConfig) PrepareTraceData(resultfile string) *TrResult {
-	tresult := &TrResult{}
+func ReadTrConfig() (*TrConfig, error) {
+	shared, err := SharedConfig()
+	if err != nil {
+		return nil, err
+	}
+	Param := NewTrConfig(shared)
+	Param.Flags(flag.CommandLine)
+	flag.Parse()
+	if err := Param.Check(); err != nil {
+		return nil, err
+	}
+	if err := Param.Setup(); err != nil {
+		return nil, err
+	}
+	return Param, nil
+}
+
+//PrepareTraceData extracts a traceroute result tarball into a temporary directory, errors
+//wrap fileutils.ErrIncompleteArchive when the tarball is broken or lacks a warts file
+func (trconfig *TrConfig) PrepareTraceData(resultfile string) (*TrResult, error) {
+	tresult := &TrResult{WartsFiles: make(map[string]string)}
 	tmpdir, err := ioutil.TempDir("./", "tr")
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 	tresult.Tmpdir, _ = filepath.Abs(tmpdir)
 	cmd := exec.Command("tar", "xjf", resultfile, "-C", tresult.Tmpdir)
 	err = cmd.Run()
 	if err != nil {
-		log.Println("Decompress result file failed", err)
-		return nil
+		tresult.CleanupTmp()
+		return nil, fmt.Errorf("%w: decompress %s: %v", fileutils.ErrIncompleteArchive, resultfile, err)
 	}
 
 	resultdir := filepath.Join(tresult.Tmpdir)
 	files, err := ioutil.ReadDir(resultdir)
 	if err != nil {
-		log.Panic("Read tmp dir failed")
+		tresult.CleanupTmp()
+		return nil, err
 	}
 	for _, f := range files {
 		if strings.Contains(f.Name(), "meta") {
 			tresult.MetaFile = filepath.Join(resultdir, f.Name())
 			continue
 		}
-		if strings.Contains(f.Name(), "ndt") {
-			tresult.NDTWartsFile = filepath.Join(resultdir, f.Name())
-			continue
-		}
-		if strings.Contains(f.Name(), "ookla") {
-			tresult.OoklaWartsFile = filepath.Join(resultdir, f.Name())
-			continue
-		}
-		if strings.Contains(f.Name(), "comcast") {
-			tresult.ComcastWartsFile = filepath.Join(resultdir, f.Name())
-			continue
+		if p, pexist := platform.ByFile(f.Name()); pexist {
+			tresult.WartsFiles[p.Name()] = filepath.Join(resultdir, f.Name())
 		}
 	}
-	if len(tresult.MetaFile) == 0 || len(tresult.NDTWartsFile) == 0 || len(tresult.OoklaWartsFile) == 0 || len(tresult.ComcastWartsFile) == 0 {
-		log.Println("Incomplete trace file", tresult)
+	if len(tresult.MetaFile) == 0 || len(tresult.WartsFiles) == 0 {
 		tresult.CleanupTmp()
-		return nil
+		return nil, fmt.Errorf("%w: %s lacks a meta or warts file", fileutils.ErrIncompleteArchive, resultfile)
+	}
+	//archives from before a platform was added lack its warts file
+	for _, p := range platform.All() {
+		if _, wexist := tresult.WartsFiles[p.Name()]; !wexist {
+			log.Println("No", p.Name(), "warts file in", filepath.Base(resultfile))
+		}
 	}
-	return tresult
+	return tresult, nil
 }
 func (b *TrResult) CleanupTmp() {
 	os.RemoveAll(b.Tmpdir)
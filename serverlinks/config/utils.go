@@ -1,14 +1,21 @@
 package config
 
 import (
+	"context"
 	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"spservers/common"
+	"spservers/notify"
+	"spservers/platform"
 	"spservers/spdb"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func VMNametoRegion(vmname string) string {
@@ -20,6 +27,11 @@ func VMNametoRegion(vmname string) string {
 	return ""
 }
 
+//links of a region are stored under its first vm, the one running bdrmap
+func RegionBdrmapVM(region string) string {
+	return region + "-1"
+}
+
 func VMNametoProvider(vmname string) string {
 	vmprovregx := regexp.MustCompile(`(\w+)-\w+-\w+`)
 	vmarr := vmprovregx.FindStringSubmatch(vmname)
@@ -42,63 +54,134 @@ func VMNumber(vmname string) int {
 	return -1
 }
 
-func OutputServerlist(mgoclient *spdb.SpeedtestMongo, outputdir string) (map[string]int, error) {
-	if mgoclient != nil {
-		if allmeasagg := mgoclient.QueryAllEnabledSpeedMeas(); allmeasagg != nil {
-			googlefout, err := os.Create(filepath.Join(outputdir, "google-serverlist.txt"))
-			if err != nil {
-				log.Fatal(err)
-			}
-			defer googlefout.Close()
-			azurefout, err := os.Create(filepath.Join(outputdir, "azure-serverlist.txt"))
-			if err != nil {
-				log.Fatal(err)
+//NotifyUsage describes the -notify flag of the commands
+const NotifyUsage = "where to send notifications: path to the mattermost bot config file, slack:<webhook url>, smtp:<smtp.json>, stdout or none"
+
+//OpenNotifier opens the notifier of spec, all messages are dropped if quiet
+func OpenNotifier(spec, username string, quiet bool) (notify.Notifier, error) {
+	if quiet {
+		spec = "none"
+	}
+	n, err := notify.Open(spec, username)
+	if err != nil {
+		return nil, fmt.Errorf("open notifier: %w", err)
+	}
+	return n, nil
+}
+
+//openServices opens the notifier and the database of a command, a database that
+//can not be opened is reported to the notifier
+func openServices(spec, username string, quiet bool, dbconfig string) (notify.Notifier, spdb.Store, error) {
+	n, err := OpenNotifier(spec, username, quiet)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := spdb.OpenStore(dbconfig, "speedtest")
+	if err != nil {
+		log.Println("Connect mongodb error", err)
+		notify.Error(n, "connect mongodb error", notify.F("config", dbconfig), notify.Err(err))
+		n.Close()
+		return nil, nil, fmt.Errorf("open database: %w", err)
+	}
+	return n, db, nil
+}
+
+//SharedConfig loads the shared config file of the commands, see common.LoadClaspConfig
+func SharedConfig() (*common.ClaspConfig, error) {
+	shared, err := common.LoadClaspConfig("")
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	return shared, nil
+}
+
+func checkExist(desc, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("%s does not exist: %s", desc, path)
+	}
+	return nil
+}
+
+//unixFlag is a time flag given as unix timestamp
+type unixFlag struct {
+	t *time.Time
+}
+
+func (u unixFlag) String() string {
+	if u.t == nil {
+		return ""
+	}
+	return strconv.FormatInt(u.t.Unix(), 10)
+}
+
+func (u unixFlag) Set(s string) error {
+	ts, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*u.t = time.Unix(ts, 0)
+	return nil
+}
+
+//UnixFlag is a flag.Value setting t from a unix timestamp
+func UnixFlag(t *time.Time) flag.Value {
+	return unixFlag{t}
+}
+
+func OutputServerlist(ctx context.Context, mgoclient spdb.Store, outputdir string) (map[string]int, error) {
+	if mgoclient == nil {
+		return nil, errors.New("Database is nil")
+	}
+	allmeasagg, err := mgoclient.QueryAllEnabledSpeedMeas(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query enabled speedmeas: %w", err)
+	}
+	googlefout, err := os.Create(filepath.Join(outputdir, "google-serverlist.txt"))
+	if err != nil {
+		return nil, fmt.Errorf("create server list: %w", err)
+	}
+	defer googlefout.Close()
+	azurefout, err := os.Create(filepath.Join(outputdir, "azure-serverlist.txt"))
+	if err != nil {
+		return nil, fmt.Errorf("create server list: %w", err)
+	}
+	defer azurefout.Close()
+	awsfout, err := os.Create(filepath.Join(outputdir, "amazon-serverlist.txt"))
+	if err != nil {
+		return nil, fmt.Errorf("create server list: %w", err)
+	}
+	defer awsfout.Close()
+	logmap := make(map[string]int)
+	for _, speedmeasagg := range allmeasagg {
+		if len(speedmeasagg.SpserverInfo) > 0 {
+			farip := speedmeasagg.SpserverInfo[0].IPv4
+			faras := speedmeasagg.SpserverInfo[0].Asnv4
+			meastype := platform.FileTag(speedmeasagg.SpserverInfo[0].Type)
+			if len(speedmeasagg.LinkInfo) > 0 {
+				if !speedmeasagg.LinkInfo[0].LinkId.IsZero() {
+					farip = speedmeasagg.LinkInfo[0].FarIP
+					faras = speedmeasagg.LinkInfo[0].FarAS
+				}
 			}
-			defer azurefout.Close()
-			awsfout, err := os.Create(filepath.Join(outputdir, "amazon-serverlist.txt"))
-			if err != nil {
-				log.Fatal(err)
+			if _, lexist := logmap[speedmeasagg.Mon]; !lexist {
+				logmap[speedmeasagg.Mon] = 1
+			} else {
+				logmap[speedmeasagg.Mon] = logmap[speedmeasagg.Mon] + 1
 			}
-			defer awsfout.Close()
-			logmap := make(map[string]int)
-			for _, speedmeasagg := range allmeasagg {
-				if len(speedmeasagg.SpserverInfo) > 0 {
-					farip := speedmeasagg.SpserverInfo[0].IPv4
-					faras := speedmeasagg.SpserverInfo[0].Asnv4
-					meastype := speedmeasagg.SpserverInfo[0].Type
-					if meastype == "mlab" {
-						meastype = "ndt"
-					}
-					if len(speedmeasagg.LinkInfo) > 0 {
-						if !speedmeasagg.LinkInfo[0].LinkId.IsZero() {
-							farip = speedmeasagg.LinkInfo[0].FarIP
-							faras = speedmeasagg.LinkInfo[0].FarAS
-						}
-					}
-					if _, lexist := logmap[speedmeasagg.Mon]; !lexist {
-						logmap[speedmeasagg.Mon] = 1
-					} else {
-						logmap[speedmeasagg.Mon] = logmap[speedmeasagg.Mon] + 1
-					}
-					resultstr := strings.Join([]string{speedmeasagg.Mon, farip, faras, meastype, speedmeasagg.SpserverInfo[0].Identifier}, "|")
-					log.Println(resultstr)
-					switch VMNametoProvider(speedmeasagg.Mon) {
-					case "gcp":
-						_, _ = googlefout.WriteString(resultstr + "\n")
-					case "ms":
-						_, _ = azurefout.WriteString(resultstr + "\n")
-					case "aws":
-						_, _ = awsfout.WriteString(resultstr + "\n")
-					}
-				}
+			resultstr := strings.Join([]string{speedmeasagg.Mon, farip, faras, meastype, speedmeasagg.SpserverInfo[0].Identifier}, "|")
+			log.Println(resultstr)
+			switch VMNametoProvider(speedmeasagg.Mon) {
+			case "gcp":
+				_, _ = googlefout.WriteString(resultstr + "\n")
+			case "ms":
+				_, _ = azurefout.WriteString(resultstr + "\n")
+			case "aws":
+				_, _ = awsfout.WriteString(resultstr + "\n")
 			}
-			googlefout.Sync()
-			azurefout.Sync()
-			awsfout.Sync()
-			return logmap, nil
 		}
-		return nil, errors.New("Failed to query enabled speedmes")
 	}
-	return nil, errors.New("Database is nil")
-
+	googlefout.Sync()
+	azurefout.Sync()
+	awsfout.Sync()
+	return logmap, nil
 }
@@ -0,0 +1,161 @@
+package congestion
+
+import (
+	"context"
+	"serverlinks/config"
+	"sort"
+	"spservers/spdb"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//links are stored under the vm that ran bdrmap, report them by region
+func regionOf(link *spdb.Link) string {
+	if region := config.VMNametoRegion(link.Region); len(region) > 0 {
+		return region
+	}
+	return link.Region
+}
+
+//load speed results and traceroutes of all links in region between start and end
+func LoadRegion(ctx context.Context, db spdb.Store, region string, start, end time.Time) (map[primitive.ObjectID]*LinkData, error) {
+	linkkeymap, _, err := db.CreateLinkmap(ctx, config.RegionBdrmapVM(region))
+	if err != nil {
+		return nil, err
+	}
+	lds := make(map[primitive.ObjectID]*LinkData)
+	linkids := make([]primitive.ObjectID, 0, len(linkkeymap))
+	for _, link := range linkkeymap {
+		lds[link.LinkId] = &LinkData{Link: link}
+		linkids = append(linkids, link.LinkId)
+	}
+	if len(linkids) == 0 {
+		return lds, nil
+	}
+	speeds, err := db.QuerySpeedResultsbyLink(ctx, linkids, start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range speeds {
+		lds[s.Link].Speeds = append(lds[s.Link].Speeds, s)
+	}
+	trs, err := db.QueryTraceroutesbyLink(ctx, linkids, start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	for _, tr := range trs {
+		lds[tr.LinkId].Traces = append(lds[tr.LinkId].Traces, tr)
+	}
+	return lds, nil
+}
+
+//score all links of region day by day and store the scores in linkcongestion
+func AnalyzeRegion(ctx context.Context, db spdb.Store, region string, start, end time.Time, p *Params) ([]*spdb.LinkCongestion, error) {
+	lds, err := LoadRegion(ctx, db, region, start, end)
+	if err != nil {
+		return nil, err
+	}
+	lcs := make([]*spdb.LinkCongestion, 0)
+	for _, ld := range lds {
+		if len(ld.Speeds) == 0 && len(ld.Traces) == 0 {
+			continue
+		}
+		lcs = append(lcs, ScoreDays(ld, p)...)
+	}
+	if len(lcs) > 0 {
+		if _, err := db.UpsertLinkCongestion(ctx, lcs); err != nil {
+			return nil, err
+		}
+	}
+	return lcs, nil
+}
+
+type FlaggedLink struct {
+	Link          primitive.ObjectID
+	Region        string
+	FarAS         string
+	Days          int
+	CongestedDays int
+	MaxScore      float64
+}
+
+//links congested on enough of their days to be a recurring pattern
+func Flag(lcs []*spdb.LinkCongestion, p *Params) []*FlaggedLink {
+	bylink := make(map[primitive.ObjectID]*FlaggedLink)
+	for _, lc := range lcs {
+		fl, fexist := bylink[lc.Link]
+		if !fexist {
+			fl = &FlaggedLink{Link: lc.Link, Region: lc.Region, FarAS: lc.FarAS}
+			bylink[lc.Link] = fl
+		}
+		fl.Days++
+		if lc.Congested {
+			fl.CongestedDays++
+		}
+		if lc.Score > fl.MaxScore {
+			fl.MaxScore = lc.Score
+		}
+	}
+	flagged := make([]*FlaggedLink, 0)
+	for _, fl := range bylink {
+		if fl.CongestedDays >= p.MinDays && float64(fl.CongestedDays) >= p.RecurringFraction*float64(fl.Days) {
+			flagged = append(flagged, fl)
+		}
+	}
+	sort.Slice(flagged, func(i, j int) bool {
+		if flagged[i].CongestedDays != flagged[j].CongestedDays {
+			return flagged[i].CongestedDays > flagged[j].CongestedDays
+		}
+		return flagged[i].Link.Hex() < flagged[j].Link.Hex()
+	})
+	return flagged
+}
+
+type ASSummary struct {
+	FarAS         string
+	Links         int
+	FlaggedLinks  int
+	Days          int
+	CongestedDays int
+}
+
+//per far AS totals, ASes with flagged links first
+func SummarizeAS(lcs []*spdb.LinkCongestion, flagged []*FlaggedLink) []*ASSummary {
+	byas := make(map[string]*ASSummary)
+	links := make(map[primitive.ObjectID]bool)
+	for _, lc := range lcs {
+		as, aexist := byas[lc.FarAS]
+		if !aexist {
+			as = &ASSummary{FarAS: lc.FarAS}
+			byas[lc.FarAS] = as
+		}
+		if !links[lc.Link] {
+			links[lc.Link] = true
+			as.Links++
+		}
+		as.Days++
+		if lc.Congested {
+			as.CongestedDays++
+		}
+	}
+	for _, fl := range flagged {
+		if as, aexist := byas[fl.FarAS]; aexist {
+			as.FlaggedLinks++
+		}
+	}
+	summary := make([]*ASSummary, 0, len(byas))
+	for _, as := range byas {
+		summary = append(summary, as)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].FlaggedLinks != summary[j].FlaggedLinks {
+			return summary[i].FlaggedLinks > summary[j].FlaggedLinks
+		}
+		if summary[i].CongestedDays != summary[j].CongestedDays {
+			return summary[i].CongestedDays > summary[j].CongestedDays
+		}
+		return summary[i].FarAS < summary[j].FarAS
+	})
+	return summary
+}
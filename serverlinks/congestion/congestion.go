@@ -0,0 +1,179 @@
+// Package congestion looks for recurring congestion on interdomain links. It
+// compares peak and off-peak hours of each day using two signals: the
+// throughput of speed tests crossing the link, and the rtt difference between
+// the far and the near side of the link in traceroutes (a TSLP-style signal).
+package congestion
+
+import (
+	"sort"
+	"spservers/spdb"
+	"time"
+)
+
+type Params struct {
+	Loc *time.Location
+	//peak hours in local time, [PeakStart, PeakEnd)
+	PeakStart int
+	PeakEnd   int
+	//minimum samples in both peak and off-peak hours of a day for a signal to count
+	MinSamples int
+	//relative drop of the peak download that counts as congestion
+	DropThreshold float64
+	//ms of peak rtt elevation across the link that counts as congestion
+	ElevThreshold float64
+	//a link is flagged if at least this fraction of its days, and at least
+	//MinDays days, are congested
+	RecurringFraction float64
+	MinDays           int
+}
+
+func DefaultParams() *Params {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		loc = time.UTC
+	}
+	return &Params{Loc: loc, PeakStart: 19, PeakEnd: 23, MinSamples: 2, DropThreshold: 0.2, ElevThreshold: 10, RecurringFraction: 0.3, MinDays: 3}
+}
+
+func (p *Params) IsPeak(t time.Time) bool {
+	h := t.In(p.Loc).Hour()
+	if p.PeakStart <= p.PeakEnd {
+		return h >= p.PeakStart && h < p.PeakEnd
+	}
+	//window wraps around midnight
+	return h >= p.PeakStart || h < p.PeakEnd
+}
+
+func (p *Params) Day(t time.Time) time.Time {
+	lt := t.In(p.Loc)
+	return time.Date(lt.Year(), lt.Month(), lt.Day(), 0, 0, 0, 0, p.Loc)
+}
+
+//speed results and traceroutes crossing one link
+type LinkData struct {
+	Link   *spdb.Link
+	Speeds []*spdb.SpeedResult
+	Traces []*spdb.Traceroute
+}
+
+//rtt of the far side of the link minus the near side. the near side is the
+//hop right before the far address, which is how traceroutes were matched to
+//the link in the first place
+func RttDelta(tr *spdb.Traceroute, link *spdb.Link) (float64, bool) {
+	for h := 1; h < len(tr.Hops); h++ {
+		if tr.Hops[h].Addr == link.FarIP && tr.Hops[h-1].ProbeTTL == tr.Hops[h].ProbeTTL-1 {
+			if tr.Hops[h].Rtt <= 0 || tr.Hops[h-1].Rtt <= 0 {
+				return 0, false
+			}
+			return tr.Hops[h].Rtt - tr.Hops[h-1].Rtt, true
+		}
+	}
+	return 0, false
+}
+
+//samples of one day, split by peak and off-peak hours
+type daySamples struct {
+	peakdown, offdown   []float64
+	peakdelta, offdelta []float64
+}
+
+//score every day with data for the link
+func ScoreDays(ld *LinkData, p *Params) []*spdb.LinkCongestion {
+	days := make(map[time.Time]*daySamples)
+	getday := func(t time.Time) *daySamples {
+		d := p.Day(t)
+		if _, dexist := days[d]; !dexist {
+			days[d] = &daySamples{}
+		}
+		return days[d]
+	}
+	for _, s := range ld.Speeds {
+		//failed tests say nothing about the link
+		if s.Download <= 0 {
+			continue
+		}
+		ds := getday(time.Unix(s.Ts, 0))
+		if p.IsPeak(time.Unix(s.Ts, 0)) {
+			ds.peakdown = append(ds.peakdown, s.Download)
+		} else {
+			ds.offdown = append(ds.offdown, s.Download)
+		}
+	}
+	for _, tr := range ld.Traces {
+		delta, ok := RttDelta(tr, ld.Link)
+		if !ok {
+			continue
+		}
+		ds := getday(time.Unix(tr.Ts, 0))
+		if p.IsPeak(time.Unix(tr.Ts, 0)) {
+			ds.peakdelta = append(ds.peakdelta, delta)
+		} else {
+			ds.offdelta = append(ds.offdelta, delta)
+		}
+	}
+	lcs := make([]*spdb.LinkCongestion, 0, len(days))
+	for day, ds := range days {
+		lc := &spdb.LinkCongestion{Link: ld.Link.LinkId, Region: regionOf(ld.Link), FarAS: ld.Link.FarAS, Day: day}
+		lc.SpeedSamples = len(ds.peakdown) + len(ds.offdown)
+		lc.TraceSamples = len(ds.peakdelta) + len(ds.offdelta)
+		if len(ds.peakdown) >= p.MinSamples && len(ds.offdown) >= p.MinSamples {
+			lc.PeakDownload, lc.OffpeakDownload = median(ds.peakdown), median(ds.offdown)
+			if lc.OffpeakDownload > 0 {
+				lc.ThroughputDrop = 1 - lc.PeakDownload/lc.OffpeakDownload
+				lc.Score = lc.ThroughputDrop / p.DropThreshold
+			}
+		}
+		if len(ds.peakdelta) >= p.MinSamples && len(ds.offdelta) >= p.MinSamples {
+			lc.PeakRttDelta, lc.OffpeakRttDelta = median(ds.peakdelta), median(ds.offdelta)
+			lc.RttElevation = lc.PeakRttDelta - lc.OffpeakRttDelta
+			if s := lc.RttElevation / p.ElevThreshold; s > lc.Score {
+				lc.Score = s
+			}
+		}
+		lc.Congested = lc.Score >= 1
+		lcs = append(lcs, lc)
+	}
+	sort.Slice(lcs, func(i, j int) bool { return lcs[i].Day.Before(lcs[j].Day) })
+	return lcs
+}
+
+//hourly medians in local time, 0 where there is no data
+type Profile struct {
+	Download [24]float64
+	RttDelta [24]float64
+}
+
+func DiurnalProfile(ld *LinkData, p *Params) *Profile {
+	var down, delta [24][]float64
+	for _, s := range ld.Speeds {
+		if s.Download > 0 {
+			h := time.Unix(s.Ts, 0).In(p.Loc).Hour()
+			down[h] = append(down[h], s.Download)
+		}
+	}
+	for _, tr := range ld.Traces {
+		if d, ok := RttDelta(tr, ld.Link); ok {
+			h := time.Unix(tr.Ts, 0).In(p.Loc).Hour()
+			delta[h] = append(delta[h], d)
+		}
+	}
+	prof := &Profile{}
+	for h := 0; h < 24; h++ {
+		prof.Download[h] = median(down[h])
+		prof.RttDelta[h] = median(delta[h])
+	}
+	return prof
+}
+
+func median(vals []float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), vals...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,10 @@
+package fileutils
+
+import "errors"
+
+var (
+	//ErrIncompleteArchive: a result tarball cannot be extracted or lacks a file the pipeline needs
+	ErrIncompleteArchive = errors.New("incomplete result archive")
+	//ErrMissingAuxFile: a prefix2as, as-rel, sibling, peering or delegation file is missing or unreadable
+	ErrMissingAuxFile = errors.New("missing auxiliary file")
+)
@@ -1,7 +1,9 @@
 package fileutils
 
 import (
+	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 )
 
@@ -13,8 +15,32 @@ func SortResultFiles(dir string, tsfunc ParseFileTsFunc, asc int) ([]string, err
 	if err != nil {
 		return nil, err
 	}
+	SortFilesbyTs(resultfiles, tsfunc, asc)
+	return resultfiles, nil
+}
+
+//find files under dir (recursively) whose base name matches namere, sorted by ts
+func FindResultFiles(dir string, namere *regexp.Regexp, tsfunc ParseFileTsFunc, asc int) ([]string, error) {
+	resultfiles := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && namere.MatchString(info.Name()) {
+			resultfiles = append(resultfiles, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	SortFilesbyTs(resultfiles, tsfunc, asc)
+	return resultfiles, nil
+}
+
+func SortFilesbyTs(resultfiles []string, tsfunc ParseFileTsFunc, asc int) {
 	if len(resultfiles) == 0 {
-		return resultfiles, nil
+		return
 	}
 	sort.Slice(resultfiles, func(i, j int) bool {
 		its := tsfunc(filepath.Base(resultfiles[i]))
@@ -25,5 +51,4 @@ func SortResultFiles(dir string, tsfunc ParseFileTsFunc, asc int) ([]string, err
 			return its > jts
 		}
 	})
-	return resultfiles, nil
 }
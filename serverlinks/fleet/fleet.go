@@ -0,0 +1,84 @@
+// Package fleet brings the vms of each region in line with the measurement
+// targets selectservers assigned to it. It compares the vms a region needs
+// with the live inventory of its cloud provider and with the vmInfo
+// collection, plans the create/start/stop/delete steps and applies them.
+package fleet
+
+import (
+	"cloudutils/cloud"
+	"context"
+	"math"
+	"serverlinks/config"
+	"spservers/spdb"
+	"strings"
+)
+
+//vms each region needs for its enabled targets, ceil(targets / targetpervm)
+//capped at maxvm as in selectservers. a region never needs fewer vms than the
+//highest one that still has targets
+func DesiredVMs(smeasmap map[string][]*spdb.SpeedMeas, targetpervm, maxvm int) map[string]int {
+	targets := make(map[string]int)
+	highest := make(map[string]int)
+	for _, smeas := range smeasmap {
+		for _, s := range smeas {
+			vmnum := config.VMNumber(s.Mon)
+			//targets on vm 0 were never allocated
+			if !s.Enabled || vmnum < 1 {
+				continue
+			}
+			region := config.VMNametoRegion(s.Mon)
+			targets[region]++
+			if vmnum > highest[region] {
+				highest[region] = vmnum
+			}
+		}
+	}
+	desired := make(map[string]int)
+	for region, cnt := range targets {
+		numvm := int(math.Ceil(float64(cnt) / float64(targetpervm)))
+		if numvm > maxvm {
+			numvm = maxvm
+		}
+		if highest[region] > numvm {
+			numvm = highest[region]
+		}
+		desired[region] = numvm
+	}
+	return desired
+}
+
+func NewProvider(cr *config.CloudRegion) (cloud.Provider, error) {
+	return cloud.NewProvider(cr.Provider, cloud.Options{Region: cr.Region, Project: cr.Project, VMConfig: cr.VMConfig, SSHKey: cr.SSHKey})
+}
+
+//list, plan and, unless dryrun, apply the steps for one region
+func Reconcile(ctx context.Context, db spdb.Store, provider cloud.Provider, region string, desired int, deletesurplus, dryrun bool) ([]*Step, []*spdb.FleetAction, error) {
+	live, err := provider.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	recorded, err := db.QueryVMs(ctx, spdb.VMCollection)
+	if err != nil {
+		return nil, nil, err
+	}
+	steps := Plan(region, desired, provider.Type(), live, recorded, deletesurplus)
+	if dryrun {
+		return steps, nil, nil
+	}
+	return steps, Apply(ctx, db, provider, steps), nil
+}
+
+//pending vms count as running, so they are not started twice
+func IsRunning(status string) bool {
+	switch strings.ToLower(status) {
+	case "running", "pending", "provisioning", "staging", "starting":
+		return true
+	}
+	return false
+}
+
+//terminated ec2 instances stay listed for a while, but are gone. a
+//terminated gcp instance is only stopped
+func isGone(vm spdb.VMInfo) bool {
+	return vm.Type == "aws" && (vm.Status == "terminated" || vm.Status == "shutting-down")
+}
@@ -0,0 +1,89 @@
+package fleet
+
+import (
+	"cloudutils/cloud"
+	"context"
+	"reflect"
+	"sort"
+	"spservers/spdb"
+	"testing"
+	"time"
+)
+
+func TestDesiredVMs(t *testing.T) {
+	smeas := func(mon string, enabled bool, n int) []*spdb.SpeedMeas {
+		sms := make([]*spdb.SpeedMeas, n)
+		for i := range sms {
+			sms[i] = &spdb.SpeedMeas{Mon: mon, Enabled: enabled}
+		}
+		return sms
+	}
+	smeasmap := map[string][]*spdb.SpeedMeas{
+		//5 targets on 2 vms need 3 vms of 2 targets
+		"gcp-uswest1:a": append(smeas("gcp-uswest1-1", true, 3), smeas("gcp-uswest1-2", true, 2)...),
+		//more targets than maxvm vms hold
+		"aws-oh:a": smeas("aws-oh-1", true, 9),
+		//vm 4 still has a target, vms are not renumbered
+		"ms-eastus:a": append(smeas("ms-eastus-1", true, 1), smeas("ms-eastus-4", true, 1)...),
+		//disabled and unallocated targets need no vm
+		"ms-westus:a": append(smeas("ms-westus-1", false, 5), smeas("ms-westus-0", true, 5)...),
+	}
+	got := DesiredVMs(smeasmap, 2, 4)
+	want := map[string]int{"gcp-uswest1": 3, "aws-oh": 4, "ms-eastus": 4}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DesiredVMs = %v, want %v", got, want)
+	}
+}
+
+//a reconciled region plans nothing on the next run
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	p := cloud.NewFake("gcp")
+	db := spdb.NewMemStore()
+	for _, vm := range []vmState{{"gcp-uswest1-1", cloud.StatusRunning}, {"gcp-uswest1-2", cloud.StatusStopped}, {"gcp-uswest1-3", cloud.StatusRunning}} {
+		info := p.Add(vm.name, "", vm.status)
+		if err := db.UpsertVMs(ctx, spdb.VMCollection, []spdb.VMInfo{*info}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Add("gcp-uswest1-4", "", cloud.StatusRunning)
+	if err := db.UpsertVMs(ctx, spdb.VMCollection, []spdb.VMInfo{{Type: "gcp", Name: "gcp-uswest1-5", ID: "99"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	steps, actions, err := Reconcile(ctx, db, p, "gcp-uswest1", 2, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != len(steps) {
+		t.Errorf("got %d actions for %d steps", len(actions), len(steps))
+	}
+	for _, a := range actions {
+		if a.Err != "" {
+			t.Errorf("step %s %s failed: %s", a.Op, a.VM, a.Err)
+		}
+	}
+	wantcalls := []string{"start gcp-uswest1-2", "stop gcp-uswest1-3", "stop gcp-uswest1-4"}
+	if !reflect.DeepEqual(p.Calls, wantcalls) {
+		t.Errorf("calls = %v, want %v", p.Calls, wantcalls)
+	}
+	stored, err := db.QueryFleetActions(ctx, "gcp-uswest1", time.Time{}, time.Now().Add(time.Minute))
+	if err != nil || len(stored) != len(actions) {
+		t.Errorf("stored %d fleet actions, want %d: %v", len(stored), len(actions), err)
+	}
+
+	//vmInfo now matches the inventory
+	live, _ := p.List(ctx)
+	recorded, err := db.QueryVMs(ctx, spdb.VMCollection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(recorded, func(i, j int) bool { return recorded[i].Name < recorded[j].Name })
+	if !reflect.DeepEqual(recorded, live) {
+		t.Errorf("vmInfo = %v, want %v", recorded, live)
+	}
+	steps, _, err = Reconcile(ctx, db, p, "gcp-uswest1", 2, false, true)
+	if err != nil || len(steps) != 0 {
+		t.Errorf("second run steps = %v, %v", steps, err)
+	}
+}
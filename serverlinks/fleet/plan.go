@@ -0,0 +1,172 @@
+package fleet
+
+import (
+	"cloudutils/cloud"
+	"context"
+	"fmt"
+	"log"
+	"serverlinks/config"
+	"sort"
+	"spservers/spdb"
+	"strconv"
+	"time"
+)
+
+const (
+	OpCreate = "create"
+	OpStart  = "start"
+	OpStop   = "stop"
+	OpDelete = "delete"
+	//vm is live but missing from vmInfo
+	OpRecord = "record"
+	//vmInfo has a vm the provider does not
+	OpForget = "forget"
+)
+
+//one planned change, Info is the vm as last seen, if it exists
+type Step struct {
+	spdb.FleetAction
+	Info spdb.VMInfo
+}
+
+func (s *Step) String() string {
+	return fmt.Sprintf("%s %s %s (%s)", s.Region, s.Op, s.VM, s.Reason)
+}
+
+//vms of vmtype in region, by vm number
+func regionVMs(vms []spdb.VMInfo, region, vmtype string) map[int]spdb.VMInfo {
+	byvmnum := make(map[int]spdb.VMInfo)
+	for _, vm := range vms {
+		if vm.Type != vmtype || config.VMNametoRegion(vm.Name) != region || isGone(vm) {
+			continue
+		}
+		vmnum := config.VMNumber(vm.Name)
+		if vmnum < 1 {
+			continue
+		}
+		if _, vexist := byvmnum[vmnum]; !vexist {
+			byvmnum[vmnum] = vm
+		}
+	}
+	return byvmnum
+}
+
+//steps that take region from the live vms to desired running vms, named
+//region-1 to region-desired. surplus vms are stopped, or deleted if
+//deletesurplus. vm 1 runs bdrmap, so every region keeps at least one vm
+func Plan(region string, desired int, vmtype string, live, recorded []spdb.VMInfo, deletesurplus bool) []*Step {
+	if desired < 1 {
+		desired = 1
+	}
+	livevms := regionVMs(live, region, vmtype)
+	recordedvms := regionVMs(recorded, region, vmtype)
+	newstep := func(op, name, reason string, vm spdb.VMInfo) *Step {
+		return &Step{FleetAction: spdb.FleetAction{Region: region, Provider: vmtype, VM: name, VMID: vm.ID, Op: op, Reason: reason}, Info: vm}
+	}
+	steps := make([]*Step, 0)
+	//first bring vmInfo in line with the inventory, the changes below then
+	//update the records as they are applied
+	recordnums := make([]int, 0)
+	for vmnum := range recordedvms {
+		recordnums = append(recordnums, vmnum)
+	}
+	for vmnum := range livevms {
+		if _, rexist := recordedvms[vmnum]; !rexist {
+			recordnums = append(recordnums, vmnum)
+		}
+	}
+	sort.Ints(recordnums)
+	for _, vmnum := range recordnums {
+		vm, lexist := livevms[vmnum]
+		rvm, rexist := recordedvms[vmnum]
+		if rexist && (!lexist || rvm.ID != vm.ID) {
+			steps = append(steps, newstep(OpForget, rvm.Name, "not in "+vmtype+" inventory", rvm))
+		}
+		if lexist && (!rexist || rvm.ID != vm.ID) && !(deletesurplus && vmnum > desired) {
+			steps = append(steps, newstep(OpRecord, vm.Name, "missing from vmInfo", vm))
+		}
+	}
+	for vmnum := 1; vmnum <= desired; vmnum++ {
+		name := region + "-" + strconv.Itoa(vmnum)
+		if vm, vexist := livevms[vmnum]; vexist {
+			if !IsRunning(vm.Status) {
+				steps = append(steps, newstep(OpStart, name, "needed, was "+vm.Status, vm))
+			}
+		} else {
+			steps = append(steps, newstep(OpCreate, name, "needed, does not exist", spdb.VMInfo{}))
+		}
+	}
+	surplus := make([]int, 0)
+	for vmnum := range livevms {
+		if vmnum > desired {
+			surplus = append(surplus, vmnum)
+		}
+	}
+	sort.Ints(surplus)
+	for _, vmnum := range surplus {
+		vm := livevms[vmnum]
+		if deletesurplus {
+			steps = append(steps, newstep(OpDelete, vm.Name, "surplus, region needs "+strconv.Itoa(desired), vm))
+		} else if IsRunning(vm.Status) {
+			steps = append(steps, newstep(OpStop, vm.Name, "surplus, region needs "+strconv.Itoa(desired), vm))
+		}
+	}
+	return steps
+}
+
+//run the steps in order, a failed step does not stop the others. vmInfo is
+//updated after each step, and all steps are stored in fleetaction. Once ctx is
+//done no further step starts, but the changes already made are still recorded
+func Apply(ctx context.Context, db spdb.Store, provider cloud.Provider, steps []*Step) []*spdb.FleetAction {
+	actions := make([]*spdb.FleetAction, 0, len(steps))
+	dbctx := context.WithoutCancel(ctx)
+	for _, step := range steps {
+		if ctx.Err() != nil {
+			log.Println("Fleet stopped before", step, ctx.Err())
+			break
+		}
+		var vm *spdb.VMInfo
+		var err error
+		switch step.Op {
+		case OpCreate:
+			if vm, err = provider.Create(ctx, step.VM, ""); err == nil {
+				err = db.UpsertVMs(dbctx, spdb.VMCollection, []spdb.VMInfo{*vm})
+			}
+		case OpStart:
+			if vm, err = provider.Start(ctx, step.VM); err == nil {
+				err = db.UpdateVMState(dbctx, spdb.VMCollection, vm.Status, vm.ID)
+			}
+		case OpStop:
+			if vm, err = provider.Stop(ctx, step.VM); err == nil {
+				err = db.UpdateVMState(dbctx, spdb.VMCollection, vm.Status, vm.ID)
+			}
+		case OpDelete:
+			if vm, err = provider.Delete(ctx, step.VM); err == nil {
+				err = db.DeleteVM(dbctx, spdb.VMCollection, vm.ID)
+			}
+		case OpRecord:
+			err = db.UpsertVMs(dbctx, spdb.VMCollection, []spdb.VMInfo{step.Info})
+		case OpForget:
+			err = db.DeleteVM(dbctx, spdb.VMCollection, step.Info.ID)
+		default:
+			err = fmt.Errorf("unknown fleet step %s", step.Op)
+		}
+		if vm != nil {
+			step.VMID = vm.ID
+		}
+		step.Ts = time.Now()
+		if err != nil {
+			step.Err = err.Error()
+			log.Println("Fleet step failed", step, err)
+		} else {
+			log.Println("Fleet step done", step)
+		}
+		actions = append(actions, &step.FleetAction)
+	}
+	if len(actions) > 0 {
+		if _, err := db.InsertFleetActions(dbctx, actions); err != nil {
+			log.Println("Insert fleet actions failed", err)
+		}
+	}
+	return actions
+}
@@ -0,0 +1,142 @@
+package fleet
+
+import (
+	"cloudutils/cloud"
+	"context"
+	"reflect"
+	"spservers/spdb"
+	"testing"
+)
+
+type vmState struct {
+	name   string
+	status string
+}
+
+func TestPlan(t *testing.T) {
+	gone := spdb.VMInfo{Type: "gcp", Name: "gcp-uswest1-2", ID: "99", Status: cloud.StatusRunning}
+	tests := []struct {
+		name          string
+		desired       int
+		deletesurplus bool
+		live          []vmState
+		//names of the live vms recorded in vmInfo
+		recorded []string
+		//recorded vms the provider does not have
+		gone []spdb.VMInfo
+		want []string
+	}{
+		{
+			name:     "in line",
+			desired:  2,
+			live:     []vmState{{"gcp-uswest1-1", cloud.StatusRunning}, {"gcp-uswest1-2", cloud.StatusRunning}},
+			recorded: []string{"gcp-uswest1-1", "gcp-uswest1-2"},
+			want:     []string{},
+		},
+		{
+			name:     "missing vm created",
+			desired:  2,
+			live:     []vmState{{"gcp-uswest1-1", cloud.StatusRunning}},
+			recorded: []string{"gcp-uswest1-1"},
+			want:     []string{"create gcp-uswest1-2"},
+		},
+		{
+			name:     "stopped vm started",
+			desired:  2,
+			live:     []vmState{{"gcp-uswest1-1", cloud.StatusRunning}, {"gcp-uswest1-2", cloud.StatusStopped}},
+			recorded: []string{"gcp-uswest1-1", "gcp-uswest1-2"},
+			want:     []string{"start gcp-uswest1-2"},
+		},
+		{
+			name:     "surplus stopped",
+			desired:  1,
+			live:     []vmState{{"gcp-uswest1-1", cloud.StatusRunning}, {"gcp-uswest1-2", cloud.StatusRunning}, {"gcp-uswest1-3", cloud.StatusStopped}},
+			recorded: []string{"gcp-uswest1-1", "gcp-uswest1-2", "gcp-uswest1-3"},
+			want:     []string{"stop gcp-uswest1-2"},
+		},
+		{
+			name:          "surplus deleted",
+			desired:       1,
+			deletesurplus: true,
+			live:          []vmState{{"gcp-uswest1-1", cloud.StatusRunning}, {"gcp-uswest1-2", cloud.StatusRunning}, {"gcp-uswest1-3", cloud.StatusStopped}},
+			//a surplus vm missing from vmInfo is deleted without recording it first
+			recorded: []string{"gcp-uswest1-1", "gcp-uswest1-2"},
+			want:     []string{"delete gcp-uswest1-2", "delete gcp-uswest1-3"},
+		},
+		{
+			name:     "vm 1 kept",
+			desired:  0,
+			live:     []vmState{{"gcp-uswest1-1", cloud.StatusStopped}, {"gcp-uswest1-2", cloud.StatusRunning}},
+			recorded: []string{"gcp-uswest1-1", "gcp-uswest1-2"},
+			want:     []string{"start gcp-uswest1-1", "stop gcp-uswest1-2"},
+		},
+		{
+			name:     "recorded but gone",
+			desired:  2,
+			live:     []vmState{{"gcp-uswest1-1", cloud.StatusRunning}},
+			recorded: []string{"gcp-uswest1-1"},
+			gone:     []spdb.VMInfo{gone},
+			want:     []string{"forget gcp-uswest1-2", "create gcp-uswest1-2"},
+		},
+		{
+			name:     "live but unrecorded",
+			desired:  2,
+			live:     []vmState{{"gcp-uswest1-1", cloud.StatusRunning}, {"gcp-uswest1-2", cloud.StatusRunning}},
+			recorded: []string{"gcp-uswest1-1"},
+			want:     []string{"record gcp-uswest1-2"},
+		},
+		{
+			name:     "recreated outside the fleet",
+			desired:  2,
+			live:     []vmState{{"gcp-uswest1-1", cloud.StatusRunning}, {"gcp-uswest1-2", cloud.StatusRunning}},
+			recorded: []string{"gcp-uswest1-1"},
+			gone:     []spdb.VMInfo{gone},
+			want:     []string{"forget gcp-uswest1-2", "record gcp-uswest1-2"},
+		},
+		{
+			name:     "other regions ignored",
+			desired:  1,
+			live:     []vmState{{"gcp-uswest1-1", cloud.StatusRunning}, {"gcp-useast1-2", cloud.StatusRunning}, {"gcp-uswest1", cloud.StatusRunning}},
+			recorded: []string{"gcp-uswest1-1"},
+			want:     []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			p := cloud.NewFake("gcp")
+			for _, vm := range tt.live {
+				p.Add(vm.name, "", vm.status)
+			}
+			live, err := p.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorded := append([]spdb.VMInfo{}, tt.gone...)
+			for _, vm := range live {
+				for _, name := range tt.recorded {
+					if vm.Name == name {
+						recorded = append(recorded, vm)
+					}
+				}
+			}
+			got := []string{}
+			for _, step := range Plan("gcp-uswest1", tt.desired, p.Type(), live, recorded, tt.deletesurplus) {
+				got = append(got, step.Op+" "+step.VM)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Plan = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+//terminated ec2 instances are still listed, but a new vm takes their name
+func TestPlanTerminated(t *testing.T) {
+	live := []spdb.VMInfo{{Type: "aws", Name: "aws-oh-1", ID: "i-1", Status: "terminated"}}
+	recorded := []spdb.VMInfo{{Type: "aws", Name: "aws-oh-1", ID: "i-1", Status: "running"}}
+	steps := Plan("aws-oh", 1, "aws", live, recorded, false)
+	if len(steps) != 2 || steps[0].Op != OpForget || steps[1].Op != OpCreate {
+		t.Errorf("steps = %v", steps)
+	}
+}
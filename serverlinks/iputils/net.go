@@ -2,13 +2,14 @@ package iputils
 
 import (
 	"bufio"
+	"compress/gzip"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"serverlinks/fileutils"
 	"strings"
 	"time"
 
@@ -20,11 +21,25 @@ const (
 	Prefix2ASv6Latest = "/data/routing/routeviews6-prefix2as/routeviews-rv6-latest.pfx2as.gz"
 	Routeviewv4dir    = "/data/routing/routeviews-prefix2as/%d/%0.2d/"
 	Routeviewv4name   = "routeviews-rv2-%d%0.2d%0.2d-*.pfx2as.gz"
+	Routeviewv6dir    = "/data/routing/routeviews6-prefix2as/%d/%0.2d/"
+	Routeviewv6name   = "routeviews-rv6-%d%0.2d%0.2d-*.pfx2as.gz"
 )
 
 type IPHandler interface {
 	ResolveV4(host string) (string, string)
+	ResolveV6(host string) (string, string)
+	ResolveAll(host string) *Resolved
 	IPv4toASN(ip net.IP) string
+	IPv6toASN(ip net.IP) string
+	IPtoASN(ip net.IP) string
+}
+
+//first v4 and v6 address of a host, with their asn. empty if not found
+type Resolved struct {
+	IPv4  string
+	Asnv4 string
+	IPv6  string
+	Asnv6 string
 }
 
 type ipHandler struct {
@@ -32,49 +47,43 @@ type ipHandler struct {
 	Treev6 *iptree.IPTree
 }
 
-func NewIPHandler(options ...string) IPHandler {
+//options: prefix2as file for v4, then optionally for v6. an empty name skips
+//that family. without options, the latest routeviews files are loaded.
+//a v4 file that cannot be read gives an error wrapping fileutils.ErrMissingAuxFile
+func NewIPHandler(options ...string) (IPHandler, error) {
 	h := new(ipHandler)
 	log.Println("New iphandler")
 	if len(options) == 0 {
-		tmpv4, err := ioutil.TempFile("", "pfx2as")
+		options = []string{Prefix2ASv4Latest, Prefix2ASv6Latest}
+	}
+	if len(options[0]) > 0 {
+		t, err := prepareIP2ASTrie(options[0])
 		if err != nil {
-			log.Fatal("Create tmp pfx2as error", err)
+			return nil, err
 		}
-		defer os.Remove(tmpv4.Name())
-		out, err := exec.Command("gzcat", Prefix2ASv4Latest).Output()
-		if err != nil {
-			if _, err = tmpv4.Write(out); err != nil {
-				log.Fatal("Write to tmpfile error", err)
-			}
-			if err = tmpv4.Close(); err != nil {
-				log.Fatal(err)
-			}
-			h.Treev4 = prepareIP2ASTrie(tmpv4.Name())
-			log.Println("Built Trie", tmpv4.Name())
+		h.Treev4 = t
+		log.Println("Built v4 Trie", options[0])
+	}
+	if len(options) > 1 && len(options[1]) > 0 {
+		//v6 is optional, fall back to cymru if the file is missing
+		if t, err := prepareIP2ASTrie(options[1]); err == nil {
+			h.Treev6 = t
+			log.Println("Built v6 Trie", options[1])
 		} else {
-			log.Fatal("Obtain latest pfx2as error", err)
-		}
-	} else {
-		if len(options) == 1 {
-			h.Treev4 = prepareIP2ASTrie(options[0])
+			log.Println("Skip v6 prefix2as", err)
 		}
 	}
-	return h
+	return h, nil
 }
 
 //new iphandler of thte first day of the month of ts
 //if data cannot be found, simple return latest
-func NewIPHandlerbyMonth(ts time.Time) IPHandler {
+func NewIPHandlerbyMonth(ts time.Time) (IPHandler, error) {
 	if !ts.IsZero() {
-		monthdir := fmt.Sprintf(Routeviewv4dir, ts.Year(), int(ts.Month()))
-		filenamewild := fmt.Sprintf(Routeviewv4name, ts.Year(), int(ts.Month()), 1)
-		matchfile, err := filepath.Glob(filepath.Join(monthdir, filenamewild))
-		if err != nil {
-			log.Panic(err, filepath.Join(monthdir, filenamewild))
-		}
+		v4file := monthlyPrefix2AS(Routeviewv4dir, Routeviewv4name, ts)
 		//assume there is only one file match
-		if len(matchfile) > 0 {
-			return NewIPHandler(matchfile[0])
+		if len(v4file) > 0 {
+			return NewIPHandler(v4file, monthlyPrefix2AS(Routeviewv6dir, Routeviewv6name, ts))
 		} else {
 			return NewIPHandler()
 		}
@@ -82,58 +91,139 @@ func NewIPHandlerbyMonth(ts time.Time) IPHandler {
 	return NewIPHandler()
 }
 
+//first prefix2as file of the month of ts, empty if none
+func monthlyPrefix2AS(dirfmt, namefmt string, ts time.Time) string {
+	monthdir := fmt.Sprintf(dirfmt, ts.Year(), int(ts.Month()))
+	filenamewild := fmt.Sprintf(namefmt, ts.Year(), int(ts.Month()), 1)
+	matchfile, err := filepath.Glob(filepath.Join(monthdir, filenamewild))
+	if err != nil {
+		log.Println("Bad prefix2as pattern", err, filepath.Join(monthdir, filenamewild))
+		return ""
+	}
+	if len(matchfile) > 0 {
+		return matchfile[0]
+	}
+	return ""
+}
+
 func (i *ipHandler) IPv4toASN(ip net.IP) string {
-	if i.Treev4 != nil && ip != nil {
+	if ip == nil || ip.To4() == nil {
+		return ""
+	}
+	if i.Treev4 != nil {
 		//search prefix2as trie
 		if asnval, foundasn, err := i.Treev4.GetByString(ip.String()); err == nil && foundasn {
 			return strings.TrimSpace(asnval.(string))
-		} else {
-			//cannot find a record in prefix2as, try cymru
-			tmpip := ip.To4()
-			asnq := net.IPv4(tmpip[3], tmpip[2], tmpip[1], tmpip[0]).String() + ".origin.asn.cymru.com"
-			outtxt, err := net.LookupTXT(asnq)
-			if err != nil {
-				return ""
-			}
-			asnstr := strings.Split(outtxt[0], "|")
-			return strings.TrimSpace(asnstr[0])
 		}
-	} else {
-		log.Fatal("IPv4 Tree is nil")
 	}
-	return ""
+	//cannot find a record in prefix2as, try cymru
+	tmpip := ip.To4()
+	asnq := net.IPv4(tmpip[3], tmpip[2], tmpip[1], tmpip[0]).String() + ".origin.asn.cymru.com"
+	outtxt, err := net.LookupTXT(asnq)
+	if err != nil || len(outtxt) == 0 {
+		return ""
+	}
+	asnstr := strings.Split(outtxt[0], "|")
+	return strings.TrimSpace(asnstr[0])
+
+}
+
+func (i *ipHandler) IPv6toASN(ip net.IP) string {
+	if ip == nil || ip.To4() != nil || ip.To16() == nil {
+		return ""
+	}
+	//search prefix2as trie
+	if i.Treev6 != nil {
+		if asnval, foundasn, err := i.Treev6.GetByString(ip.String()); err == nil && foundasn {
+			return strings.TrimSpace(asnval.(string))
+		}
+	}
+	//cannot find a record in prefix2as, try cymru
+	tmpip := ip.To16()
+	nibbles := make([]string, 0, 32)
+	for b := len(tmpip) - 1; b >= 0; b-- {
+		nibbles = append(nibbles, fmt.Sprintf("%x", tmpip[b]&0x0f), fmt.Sprintf("%x", tmpip[b]>>4))
+	}
+	outtxt, err := net.LookupTXT(strings.Join(nibbles, ".") + ".origin6.asn.cymru.com")
+	if err != nil || len(outtxt) == 0 {
+		return ""
+	}
+	asnstr := strings.Split(outtxt[0], "|")
+	return strings.TrimSpace(asnstr[0])
+}
 
+//asn of either a v4 or v6 address
+func (i *ipHandler) IPtoASN(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if ip.To4() != nil {
+		return i.IPv4toASN(ip)
+	}
+	return i.IPv6toASN(ip)
 }
 
 //input: hostname
 //output: ipv4, asnv4
 func (i *ipHandler) ResolveV4(host string) (string, string) {
-	addrs := strings.Split(host, ":")
-	if len(host) > 0 {
-		ips, err := net.LookupIP(addrs[0])
-		if err != nil {
-			log.Println(err)
-			return "", ""
-		}
-		if len(ips) > 0 {
-			//log.Println(addrs[0], ips)
-			j := 0
-			foundv4 := -1
-			for j = 0; j < len(ips); j++ {
-				if ips[j].To4() != nil {
-					foundv4 = j
-					break
-				}
-			}
-			if foundv4 < 0 {
-				log.Println("No IPv4 found")
-				return "", ""
+	r := i.resolve(host, true, false)
+	if len(r.IPv4) == 0 {
+		log.Println("No IPv4 found", host)
+	}
+	return r.IPv4, r.Asnv4
+}
+
+//input: hostname
+//output: ipv6, asnv6
+func (i *ipHandler) ResolveV6(host string) (string, string) {
+	r := i.resolve(host, false, true)
+	if len(r.IPv6) == 0 {
+		log.Println("No IPv6 found", host)
+	}
+	return r.IPv6, r.Asnv6
+}
+
+//resolve both families with a single lookup
+func (i *ipHandler) ResolveAll(host string) *Resolved {
+	return i.resolve(host, true, true)
+}
+
+func (i *ipHandler) resolve(host string, v4, v6 bool) *Resolved {
+	r := &Resolved{}
+	ips := lookupHost(host)
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			if v4 && len(r.IPv4) == 0 {
+				r.IPv4, r.Asnv4 = ip.String(), i.IPv4toASN(ip)
 			}
-			return ips[foundv4].String(), i.IPv4toASN(ips[foundv4])
+		} else if v6 && len(r.IPv6) == 0 {
+			r.IPv6, r.Asnv6 = ip.String(), i.IPv6toASN(ip)
 		}
 	}
-	return "", ""
+	return r
+}
 
+//host may carry a port, as host:port or [v6]:port
+func lookupHost(host string) []net.IP {
+	if len(host) == 0 {
+		return nil
+	}
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	} else if strings.Count(host, ":") == 1 {
+		name = strings.Split(host, ":")[0]
+	}
+	name = strings.Trim(name, "[]")
+	if ip := net.ParseIP(name); ip != nil {
+		return []net.IP{ip}
+	}
+	ips, err := net.LookupIP(name)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	return ips
 }
 
 //given a hostname:port, return the first ipv4 address, and the asn
@@ -173,13 +263,23 @@ func (i *ipHandler) ResolveV4(host string) (string, string) {
 }
 */
 //struct for parsing scamper traceroute. do not parse all the fields here. only extract those required ones.
-func prepareIP2ASTrie(prefix2asfile string) *iptree.IPTree {
+func prepareIP2ASTrie(prefix2asfile string) (*iptree.IPTree, error) {
 	t := iptree.New()
 	ipasnfile, err := os.Open(prefix2asfile)
 	if err != nil {
-		log.Panic(err)
+		return nil, fmt.Errorf("%w: %v", fileutils.ErrMissingAuxFile, err)
 	}
-	scanner := bufio.NewScanner(ipasnfile)
+	defer ipasnfile.Close()
+	var rd io.Reader = ipasnfile
+	if strings.HasSuffix(prefix2asfile, ".gz") {
+		gz, err := gzip.NewReader(ipasnfile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", fileutils.ErrMissingAuxFile, prefix2asfile, err)
+		}
+		defer gz.Close()
+		rd = gz
+	}
+	scanner := bufio.NewScanner(rd)
 	for scanner.Scan() {
 		line := strings.Trim(scanner.Text(), "\n")
 		if len(line) > 1 {
@@ -187,12 +287,16 @@ func prepareIP2ASTrie(prefix2asfile string) *iptree.IPTree {
 			if line[0] != '#' {
 				data := strings.Fields(line)
 				//expect: 115.146.123.131 32      45903
+				//or:     2001:db8::      32      64496
 				if len(data) == 3 {
 					t.AddByString(data[0]+"/"+data[1], data[2])
 				}
 			}
 		}
 	}
-	return t
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", fileutils.ErrMissingAuxFile, prefix2asfile, err)
+	}
+	return t, nil
 
 }
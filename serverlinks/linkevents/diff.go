@@ -0,0 +1,89 @@
+package linkevents
+
+import (
+	"sort"
+	"spservers/spdb"
+)
+
+const (
+	EventAppeared    = "appeared"
+	EventDisappeared = "disappeared"
+	EventReappeared  = "reappeared"
+)
+
+//Diff compares the links of region stored before the bdrmap run at ts, oldlinks as
+//returned by CreateLinkmap, with the links the run found. Links current before and
+//missing now disappeared, links missing before appeared, or reappeared if an earlier
+//run had seen them. The first run of a region has nothing to compare with and gives no events.
+func Diff(region string, ts int64, oldlinks map[string]*spdb.Link, newlinks map[string]*spdb.Link) []*spdb.LinkEvent {
+	events := []*spdb.LinkEvent{}
+	if len(oldlinks) == 0 {
+		return events
+	}
+	var prevts int64
+	oldas := make(map[string]bool)
+	for _, link := range oldlinks {
+		if _, last := seenRange(link); last > prevts && last < ts {
+			prevts = last
+		}
+		if link.Current {
+			oldas[link.FarAS] = true
+		}
+	}
+	newas := make(map[string]bool)
+	for _, link := range newlinks {
+		newas[link.FarAS] = true
+	}
+
+	for lkey, link := range newlinks {
+		old, exist := oldlinks[lkey]
+		if exist && old.Current {
+			continue
+		}
+		ev := newEvent(region, lkey, link, ts, prevts)
+		ev.FirstSeen, ev.LastSeen = ts, ts
+		ev.Event = EventAppeared
+		if exist {
+			ev.Event = EventReappeared
+			ev.FirstSeen, _ = seenRange(old)
+		}
+		ev.PeerChange = !oldas[link.FarAS]
+		events = append(events, ev)
+	}
+	for lkey, old := range oldlinks {
+		if _, exist := newlinks[lkey]; exist || !old.Current {
+			continue
+		}
+		ev := newEvent(region, lkey, old, ts, prevts)
+		ev.Event = EventDisappeared
+		ev.FirstSeen, ev.LastSeen = seenRange(old)
+		ev.PeerChange = !newas[old.FarAS]
+		events = append(events, ev)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Event != events[j].Event {
+			return events[i].Event < events[j].Event
+		}
+		return events[i].Linkkey < events[j].Linkkey
+	})
+	return events
+}
+
+func newEvent(region string, lkey string, link *spdb.Link, ts, prevts int64) *spdb.LinkEvent {
+	return &spdb.LinkEvent{Region: region, Linkkey: lkey, NearIP: link.NearIP, FarIP: link.FarIP, FarAS: link.FarAS, Ts: ts, PrevTs: prevts}
+}
+
+//first and last run the link was seen in
+func seenRange(link *spdb.Link) (int64, int64) {
+	var first, last int64
+	for _, seen := range link.LastSeen {
+		ts := seen.Unix()
+		if first == 0 || ts < first {
+			first = ts
+		}
+		if ts > last {
+			last = ts
+		}
+	}
+	return first, last
+}
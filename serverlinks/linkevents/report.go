@@ -0,0 +1,115 @@
+package linkevents
+
+import (
+	"fmt"
+	"serverlinks/config"
+	"sort"
+	"spservers/spdb"
+	"strings"
+)
+
+//Report summarizes the link events of one region of a cloud
+type Report struct {
+	Cloud       string
+	Region      string
+	Appeared    int
+	Disappeared int
+	Reappeared  int
+	//far ASes that got their first link, or lost their last one
+	NewPeers  []string
+	LostPeers []string
+	//links that disappeared and came back, or the other way round, within the period
+	Flapping []string
+}
+
+//Summarize groups events by cloud and region, reports are sorted by cloud then region
+func Summarize(events []*spdb.LinkEvent) []*Report {
+	reports := make(map[string]*Report)
+	newpeers := make(map[string]map[string]bool)
+	lostpeers := make(map[string]map[string]bool)
+	linkevents := make(map[string]map[string]map[string]bool)
+	//peer changes are replayed in run order
+	events = append([]*spdb.LinkEvent(nil), events...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Ts < events[j].Ts })
+	for _, ev := range events {
+		region := config.VMNametoRegion(ev.Region)
+		if len(region) == 0 {
+			region = ev.Region
+		}
+		r, rexist := reports[region]
+		if !rexist {
+			r = &Report{Cloud: config.VMNametoProvider(ev.Region), Region: region}
+			reports[region] = r
+			newpeers[region] = make(map[string]bool)
+			lostpeers[region] = make(map[string]bool)
+			linkevents[region] = make(map[string]map[string]bool)
+		}
+		switch ev.Event {
+		case EventAppeared:
+			r.Appeared++
+		case EventDisappeared:
+			r.Disappeared++
+		case EventReappeared:
+			r.Reappeared++
+		}
+		if ev.PeerChange {
+			if ev.Event == EventDisappeared {
+				lostpeers[region][ev.FarAS] = true
+				delete(newpeers[region], ev.FarAS)
+			} else {
+				newpeers[region][ev.FarAS] = true
+				delete(lostpeers[region], ev.FarAS)
+			}
+		}
+		if _, lexist := linkevents[region][ev.Linkkey]; !lexist {
+			linkevents[region][ev.Linkkey] = make(map[string]bool)
+		}
+		linkevents[region][ev.Linkkey][ev.Event] = true
+	}
+
+	res := make([]*Report, 0, len(reports))
+	for region, r := range reports {
+		r.NewPeers = sortedKeys(newpeers[region])
+		r.LostPeers = sortedKeys(lostpeers[region])
+		for lkey, evs := range linkevents[region] {
+			if evs[EventDisappeared] && (evs[EventReappeared] || evs[EventAppeared]) {
+				r.Flapping = append(r.Flapping, lkey)
+			}
+		}
+		sort.Strings(r.Flapping)
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Cloud != res[j].Cloud {
+			return res[i].Cloud < res[j].Cloud
+		}
+		return res[i].Region < res[j].Region
+	})
+	return res
+}
+
+//String formats the report as a few lines of text
+func (r *Report) String() string {
+	lines := []string{
+		fmt.Sprintf("%s %s: %d appeared, %d disappeared, %d reappeared", r.Cloud, r.Region, r.Appeared, r.Disappeared, r.Reappeared),
+	}
+	if len(r.NewPeers) > 0 {
+		lines = append(lines, "  new peers: AS"+strings.Join(r.NewPeers, " AS"))
+	}
+	if len(r.LostPeers) > 0 {
+		lines = append(lines, "  lost peers: AS"+strings.Join(r.LostPeers, " AS"))
+	}
+	if len(r.Flapping) > 0 {
+		lines = append(lines, "  flapping: "+strings.Join(r.Flapping, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,189 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"serverlinks/bdrmaplink"
+	"serverlinks/config"
+	"serverlinks/fileutils"
+	"serverlinks/linkevents"
+	"spservers/common"
+	"spservers/notify"
+	"spservers/runlog"
+	"spservers/spdb"
+	"sync"
+)
+
+//bdrmap runs one sc_bdrmap per vm, a few vms at a time keep the machine busy
+const bdrmapWorkers = 5
+
+//UpdateBdrmap loads the bdrmap results of every vm under the result directory
+//newer than the last loaded one, a bad vm does not stop the others. The
+//database and the notifier of bdrlnkconfig are closed when it returns.
+func UpdateBdrmap(bdrlnkconfig *config.BdrConfig) error {
+	defer bdrlnkconfig.MongoClient.Close()
+	defer bdrlnkconfig.Notifier.Close()
+	ctx, cancel := common.Context(bdrlnkconfig.Deadline)
+	defer cancel()
+	run := runlog.Start(ctx, bdrlnkconfig.MongoClient, "updatebdrmap")
+	var wg sync.WaitGroup
+	workerch := make(chan int, bdrmapWorkers)
+	vmnamere := regexp.MustCompile(`(\w+-\w+-\w+)`)
+	resultfolder, err := ioutil.ReadDir(bdrlnkconfig.ResultDir)
+	if err != nil {
+		run.Finish(err)
+		log.Println(err)
+		return err
+	}
+	for _, f := range resultfolder {
+		if f.IsDir() {
+			log.Println("fname", f.Name())
+			nameslice := vmnamere.FindStringSubmatch(f.Name())
+			if len(nameslice) > 0 {
+				wg.Add(1)
+				workerch <- 1
+				go func(vmname string) {
+					defer wg.Done()
+					if err := processVMbdrmap(ctx, vmname, bdrlnkconfig, run); err != nil {
+						log.Println("Update bdrmap failed", vmname, err)
+					}
+					<-workerch
+				}(nameslice[1])
+			}
+		}
+	}
+	wg.Wait()
+	run.Finish(ctx.Err())
+	if err := ctx.Err(); err != nil {
+		log.Println("Update bdrmap stopped, the remaining files are loaded by the next run", err)
+	}
+	//a bad vm does not stop the others, report all of them at the end
+	if err := run.Err(); err != nil {
+		log.Println("Update bdrmap failed on some vms:\n" + err.Error())
+		notify.Error(bdrlnkconfig.Notifier, "updatebdrmap failed on some vms", notify.Err(err))
+		return err
+	}
+	return nil
+}
+
+//processVMbdrmap loads the bdrmap results of vmname newer than the last loaded one. A broken
+//tarball or a missing auxiliary file only skips that file, a database error stops the vm.
+//Once ctx is done no further file is started
+func processVMbdrmap(ctx context.Context, vmname string, config *config.BdrConfig, run *runlog.Recorder) error {
+	if err := ctx.Err(); err != nil {
+		run.VMStatus(vmname, runlog.StatusCanceled, err.Error())
+		return err
+	}
+	vmpath := filepath.Join(config.ResultDir, vmname)
+	log.Println("working on", vmpath)
+	monbdrstatus, err := config.MongoClient.QueryDataStatus(ctx, vmname)
+	if err != nil {
+		run.VMDone(vmname, err)
+		return err
+	}
+	log.Println(monbdrstatus)
+	//sort file names by desc order of timestamp in the filename
+	//only consider tar.bz2 file here. other files will set ts as 0
+	vmbdrfiles, err := fileutils.SortResultFiles(vmpath, bdrmaplink.ParseBdrmapFileTs, 0)
+	if err != nil {
+		run.VMDone(vmname, err)
+		return err
+	}
+	var lastts int64 = 0
+	if len(monbdrstatus.BdrmapFile) > 0 {
+		//exist record in mongodb
+		lastts = bdrmaplink.ParseBdrmapFileTs(monbdrstatus.BdrmapFile)
+	}
+	processed := 0
+	errs := []error{}
+	for _, bdrfile := range vmbdrfiles {
+		if ctx.Err() != nil {
+			break
+		}
+		newbdrts := bdrmaplink.ParseBdrmapFileTs(bdrfile)
+		log.Println("new:", newbdrts, lastts)
+		if newbdrts <= 0 || newbdrts <= lastts {
+			continue
+		}
+		if err := processBdrmapFile(ctx, vmname, bdrfile, newbdrts, config, run); err != nil {
+			if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+				break
+			}
+			log.Println("bdrmapfile invalid", vmname, bdrfile, err)
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(bdrfile), err))
+			if errors.Is(err, fileutils.ErrIncompleteArchive) || errors.Is(err, fileutils.ErrMissingAuxFile) {
+				continue
+			}
+			break
+		}
+		//the links of the file are stored, record it even if ctx is done by now
+		if err := config.MongoClient.UpdateDataStatus(context.WithoutCancel(ctx), &spdb.VMDataStatus{Mon: vmname, BdrmapFile: filepath.Base(bdrfile)}); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		lastts = newbdrts
+		processed++
+	}
+	if len(errs) == 0 && ctx.Err() != nil {
+		run.VMStatus(vmname, runlog.StatusCanceled, ctx.Err().Error())
+		return ctx.Err()
+	}
+	if processed == 0 && len(errs) == 0 {
+		run.VMStatus(vmname, runlog.StatusSkipped, "no new bdrmap file")
+		return nil
+	}
+	err = errors.Join(errs...)
+	run.VMDone(vmname, err)
+	return err
+}
+
+//processBdrmapFile computes the links and routers of one bdrmap result and loads them into the database.
+//ctx is checked before the first write, the writes of a file are not canceled
+func processBdrmapFile(ctx context.Context, vmname, bdrfile string, newbdrts int64, config *config.BdrConfig, run *runlog.Recorder) error {
+	bresult, err := config.PrepareData(bdrfile)
+	if err != nil {
+		return err
+	}
+	defer bresult.CleanupTmp()
+	run.Input("bdrmap", vmname, bdrfile)
+	run.Input("prefix2as", vmname, bresult.Prefix2ASFile)
+	run.Input("as-rel", vmname, bresult.ASRelFile)
+	run.Input("sibling", vmname, bresult.SiblingFile)
+	run.Input("delegation", vmname, bresult.DelegationFile)
+	run.Input("peering", vmname, bresult.PeeringFile)
+	linkmap := make(map[string]*spdb.Link)
+	faripmap := make(map[string][]*spdb.Link)
+	routers, err := bdrmaplink.GenerateLinks(config, bresult, linkmap, faripmap)
+	if err != nil {
+		return err
+	}
+	oldlinks, _, err := config.MongoClient.CreateLinkmap(ctx, vmname)
+	if err != nil {
+		return fmt.Errorf("query previous links: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	dbctx := context.WithoutCancel(ctx)
+	if err := config.MongoClient.UpdateLinkstoMongo(dbctx, vmname, newbdrts, linkmap); err != nil {
+		return err
+	}
+	events := linkevents.Diff(vmname, newbdrts, oldlinks, linkmap)
+	if _, err := config.MongoClient.InsertLinkEvents(dbctx, events); err != nil {
+		return fmt.Errorf("insert link events: %w", err)
+	}
+	log.Println(vmname, len(events), "link events")
+	if _, err := config.MongoClient.InsertRouters(dbctx, vmname, newbdrts, bdrmaplink.DBRouters(routers)); err != nil {
+		return fmt.Errorf("insert routers: %w", err)
+	}
+	run.VMCount(vmname, "linkevents", len(events))
+	run.VMCount(vmname, "bdrmapfiles", 1)
+	run.VMCount(vmname, "links", len(linkmap))
+	run.VMCount(vmname, "routers", len(routers))
+	return nil
+}
@@ -0,0 +1,473 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"serverlinks/config"
+	"serverlinks/sptraceroute"
+	"sort"
+	"spservers/common"
+	"spservers/geo"
+	"spservers/notify"
+	"spservers/runlog"
+	"spservers/serverhistory"
+	"spservers/spdb"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//SelectServers selects the speed test servers of every region and commits them
+//as the measurement targets, then writes the server lists of the vms. The
+//database and the notifier of SsParam are closed when it returns.
+func SelectServers(SsParam *config.SsConfig) error {
+	var wg, wgres sync.WaitGroup
+	defer SsParam.MongoClient.Close()
+	ctx, cancel := common.Context(SsParam.Deadline)
+	defer cancel()
+	defer SsParam.Notifier.Close()
+	run := runlog.Start(ctx, SsParam.MongoClient, "selectservers")
+	allregions, err := SsParam.MongoClient.ListRegions(ctx)
+	if err != nil {
+		run.Finish(err)
+		log.Println("List regions failed", err)
+		return err
+	}
+	allresults := make([]*config.SsResult, 0)
+	reschan := make(chan *config.SsResult)
+	workchan := make(chan int, SsParam.Worker)
+	wgres.Add(1)
+	go func() {
+		allresults = SelectSpserverCollector(allresults, reschan)
+		wgres.Done()
+	}()
+	for _, region := range allregions {
+		log.Println(region)
+		if config.VMNumber(region) == 1 {
+			wg.Add(1)
+			go func(rg string) {
+				workchan <- 1
+				sptraceroute.MergeLinkSpservers(ctx, SsParam, rg, reschan)
+				<-workchan
+				wg.Done()
+			}(region)
+		}
+	}
+	wg.Wait()
+	close(reschan)
+	wgres.Wait()
+	if err := ctx.Err(); err != nil {
+		//the selection of some regions is incomplete, updating the targets
+		//would disable the servers they are still measuring
+		run.Finish(err)
+		log.Println("Stopped before updating targets", err)
+		return err
+	}
+	for _, servers := range allresults {
+		log.Println("Region", servers.Region, servers.SpServerId)
+	}
+	log.Println("Allresult length", len(allresults))
+	ended, err := ReviewServerChanges(ctx, SsParam)
+	if err != nil {
+		log.Println("Review server changes failed", err)
+		notify.Warn(SsParam.Notifier, "review server changes failed", notify.Err(err))
+	}
+	run.Count("changedservers", ended)
+	mmreportdata, err := UpdateTargets(ctx, SsParam, allresults)
+	if err != nil {
+		run.Finish(err)
+		log.Println("Update targets failed", err)
+		notify.Error(SsParam.Notifier, "update targets failed", notify.Err(err))
+		return err
+	}
+	crossed := CrossContinentTargets(ctx, SsParam)
+	run.Count("crosscontinent", crossed)
+	MMRunReport(SsParam, mmreportdata)
+	RecordRun(run, mmreportdata)
+	logmap, err := config.OutputServerlist(ctx, SsParam.MongoClient, "./")
+	if err == nil {
+		logstr := []string{}
+		for name, cnt := range logmap {
+			logstr = append(logstr, name+":"+strconv.Itoa(cnt))
+		}
+		notify.Report(SsParam.Notifier, notify.SeverityInfo, "Speedserver assignment updated", logstr...)
+	} else {
+		notify.Error(SsParam.Notifier, "output serverlist failed", notify.Err(err))
+		return err
+	}
+	return nil
+}
+
+func SelectSpserverCollector(allresults []*config.SsResult, resultch chan *config.SsResult) []*config.SsResult {
+	for res := range resultch {
+		allresults = append(allresults, res)
+	}
+	log.Println("Exit result collector", len(allresults))
+	return allresults
+}
+
+//ReasonServerChanged ends the assignment of a server whose address or network
+//changed since the previous selection
+const ReasonServerChanged = -3
+
+//ReviewServerChanges ends the auto assignments of servers the crawls found
+//readdressed or rehomed since the previous selection. The link of such an
+//assignment was chosen through the old address, UpdateTargets assigns the
+//server again if the selection still picks it. It returns the number of
+//assignments it ended.
+func ReviewServerChanges(ctx context.Context, ssparam *config.SsConfig) (int, error) {
+	since := lastSelection(ctx, ssparam)
+	changes, err := ssparam.MongoClient.QueryServerChanges(ctx, "", since.Unix(), time.Now().Unix()+1)
+	if err != nil {
+		return 0, err
+	}
+	changed := make(map[primitive.ObjectID]*spdb.ServerChange)
+	for _, sc := range changes {
+		if serverhistory.Affects(sc) && !sc.SpId.IsZero() {
+			changed[sc.SpId] = sc
+		}
+	}
+	if len(changed) == 0 {
+		return 0, nil
+	}
+	smeasmap, err := ssparam.MongoClient.QueryMapSpeedMeas(ctx)
+	if err != nil {
+		return 0, err
+	}
+	dbctx := context.WithoutCancel(ctx)
+	ended := 0
+	report := []string{}
+	for _, smeas := range smeasmap {
+		for _, sm := range smeas {
+			sc, cexist := changed[sm.SpeedServer]
+			if !cexist || !sm.Enabled || sm.Assigntype != "auto" {
+				continue
+			}
+			log.Println("Server changed, ending target", sm.Mon, sm.SpeedServer.Hex(), sc.Change)
+			sm.Enabled = false
+			sm.Reason = ReasonServerChanged
+			if len(sm.Activeperiod) > 0 {
+				sm.Activeperiod[len(sm.Activeperiod)-1].End = ssparam.EnableDate
+			}
+			if err := ssparam.MongoClient.UpdateSpeedserver(dbctx, sm); err != nil {
+				log.Println("Update error", err)
+				notify.Error(ssparam.Notifier, "update target error", notify.VM(sm.Mon), notify.Err(err))
+				continue
+			}
+			ended++
+			report = append(report, sm.Mon+" "+serverhistory.Line(sc))
+		}
+	}
+	if len(report) > 0 {
+		notify.Report(ssparam.Notifier, notify.SeverityWarning, "Ended the targets of changed servers:", report...)
+	}
+	return ended, nil
+}
+
+//start of the last successful selection, the start of the selection window if
+//there was none within it
+func lastSelection(ctx context.Context, ssparam *config.SsConfig) time.Time {
+	runs, err := ssparam.MongoClient.QueryRuns(ctx, "selectservers", ssparam.StartDate, time.Now())
+	if err != nil {
+		log.Println("Query runs failed", err)
+		return ssparam.StartDate
+	}
+	//newest first
+	for _, run := range runs {
+		if run.Status == runlog.StatusOK {
+			return run.Start
+		}
+	}
+	return ssparam.StartDate
+}
+
+//CrossContinentTargets warns about the enabled targets measured from a
+//region on another continent than the server, their rtt is dominated by the
+//distance rather than the interconnect. It returns the number of such targets.
+func CrossContinentTargets(ctx context.Context, ssparam *config.SsConfig) int {
+	allmeasagg, err := ssparam.MongoClient.QueryAllEnabledSpeedMeas(ctx)
+	if err != nil {
+		notify.Error(ssparam.Notifier, "failed to query enabled speedmeas", notify.Err(err))
+		log.Println("query enabled speedmeas failed", err)
+		return 0
+	}
+	report := []string{}
+	unknown := make(map[string]int)
+	for _, smeasagg := range allmeasagg {
+		if len(smeasagg.SpserverInfo) == 0 {
+			continue
+		}
+		sp := smeasagg.SpserverInfo[0]
+		regioncont, servercont := config.RegionContinent(smeasagg.Mon), geo.Continent(sp.Country)
+		if len(regioncont) == 0 {
+			unknown[config.VMNametoRegion(smeasagg.Mon)]++
+			continue
+		}
+		if len(servercont) == 0 || regioncont == servercont {
+			continue
+		}
+		line := fmt.Sprintf("%s %s %s (%s, %s) %s -> %s", smeasagg.Mon, sp.Type, sp.Identifier, sp.City, sp.Country, regioncont, servercont)
+		if regionloc, rexist := config.RegionLocation(smeasagg.Mon); rexist && sp.Location.Valid() {
+			line += fmt.Sprintf(" %d km", int(regionloc.DistanceKm(sp.Location)))
+		}
+		report = append(report, line)
+	}
+	if len(report) > 0 {
+		sort.Strings(report)
+		notify.Report(ssparam.Notifier, notify.SeverityWarning, "Targets on another continent than their region:", report...)
+	}
+	if len(unknown) > 0 {
+		lines := []string{}
+		for region, cnt := range unknown {
+			lines = append(lines, fmt.Sprintf("%s: %d targets", region, cnt))
+		}
+		sort.Strings(lines)
+		log.Println("Regions without coordinates", lines)
+		notify.Report(ssparam.Notifier, notify.SeverityWarning, "Regions missing from the region table, their targets are not checked:", lines...)
+	}
+	return len(report)
+}
+
+type RunRecord struct {
+	SelectedTotal      int
+	UnallocatedTargets int
+	InsertedTargets    int
+	UpdatedTargets     int
+}
+
+//UpdateTargets commits the selection, the updates are not canceled with ctx once
+//they started so the targets of a region are never left half assigned
+func UpdateTargets(ctx context.Context, ssparam *config.SsConfig, allresult []*config.SsResult) (map[string]*RunRecord, error) {
+	runrec := make(map[string]*RunRecord)
+	smeasmap, err := ssparam.MongoClient.QueryMapSpeedMeas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	servertoupdate := make([]*spdb.SpeedMeas, 0)
+	servertoinsert := make([]*spdb.SpeedMeas, 0)
+	updatedsmeas := make(map[string]int)
+	updatedregions := make(map[string]int)
+	//sort targets according to reasons
+	sort.Slice(allresult, func(i, j int) bool {
+		return allresult[i].Reason < allresult[j].Reason
+	})
+	for _, result := range allresult {
+		//		spserver, errs := ssparam.MongoClient.QueryServerbyId(result.SpServerId)
+		//		link,errl := ssparam.MongoClient.QueryLinkbyId(result.LinkId)
+		//		if errs == nil && errl == nil {
+		skey := config.VMNametoRegion(result.Region) + ":" + result.SpServerId.Hex()
+		updatedregions[config.VMNametoRegion(result.Region)] = 1
+		updatedsmeas[skey] = 1
+		log.Println("skey", skey)
+		if smeas, sexist := smeasmap[skey]; sexist {
+			if !smeas[0].Enabled {
+				//this server is currently disabled
+				smeasmap[skey][0].Enabled = true
+				smeasmap[skey][0].Mon = config.VMNametoRegion(result.Region) + "-0"
+				smeasmap[skey][0].Reason = result.Reason
+				smeasmap[skey][0].Activeperiod = append(smeasmap[skey][0].Activeperiod, spdb.TimePeriod{Start: ssparam.EnableDate})
+				servertoupdate = append(servertoupdate, smeasmap[skey][0])
+			} else {
+				log.Println("Existing server", skey, smeasmap[skey][0].Mon)
+			}
+			//existing duplicate target
+			if len(smeasmap[skey]) > 1 {
+				log.Println("Duplicate target", skey)
+				for s := 1; s < len(smeasmap[skey]); s++ {
+					log.Println("  ", smeasmap[skey][s].Mon, smeasmap[skey][s].Link)
+					smeasmap[skey][s].Enabled = false
+					smeasmap[skey][s].Reason = -99
+					smeasmap[skey][s].Activeperiod[len(smeasmap[skey][s].Activeperiod)-1].End = ssparam.EnableDate
+					servertoupdate = append(servertoupdate, smeasmap[skey][s])
+				}
+			}
+		} else {
+			//add new server, create a record
+			actper := []spdb.TimePeriod{spdb.TimePeriod{Start: ssparam.EnableDate}}
+			//temporarily add to VM "0"
+			newserver := &spdb.SpeedMeas{Mon: config.VMNametoRegion(result.Region) + "-0", SpeedServer: result.SpServerId, Enabled: true, Link: result.LinkId, Activeperiod: actper, Assigntype: "auto", Reason: result.Reason}
+			servertoinsert = append(servertoinsert, newserver)
+			smeasmap[skey] = []*spdb.SpeedMeas{newserver}
+			log.Println("Add new server", newserver)
+		}
+	}
+	//Disable all other "auto" (this will keep other types)
+	for smeaskey, smeas := range smeasmap {
+		if _, rexist := updatedregions[config.VMNametoRegion(smeas[0].Mon)]; rexist {
+			if _, uexist := updatedsmeas[smeaskey]; !uexist {
+				for s := 0; s < len(smeas); s++ {
+					if smeas[s].Assigntype == "auto" && smeas[s].Enabled {
+						log.Println("Disabling target", smeasmap[smeaskey][s], smeasmap[smeaskey][s].Mon)
+						smeasmap[smeaskey][s].Enabled = false
+						smeasmap[smeaskey][s].Reason = -99
+						smeasmap[smeaskey][s].Activeperiod[len(smeasmap[smeaskey][s].Activeperiod)-1].End = ssparam.EnableDate
+						servertoupdate = append(servertoupdate, smeasmap[smeaskey][s])
+					}
+				}
+			}
+		}
+	}
+	//count targets in each region.
+	regioncnt := make(map[string]int)
+	regionkeys := make(map[string][]string)
+	for smeaskey, smeas := range smeasmap {
+		//we still iterate over the slice, but expected that index >0 has been disabled
+		for s := 0; s < len(smeas); s++ {
+			if _, uexist := updatedregions[config.VMNametoRegion(smeas[s].Mon)]; uexist {
+				runrec[config.VMNametoRegion(smeas[s].Mon)] = &RunRecord{}
+				if smeas[s].Enabled {
+					if _, rexist := regioncnt[config.VMNametoRegion(smeas[s].Mon)]; !rexist {
+						regioncnt[config.VMNametoRegion(smeas[s].Mon)] = 1
+						regionkeys[config.VMNametoRegion(smeas[s].Mon)] = []string{smeaskey}
+					} else {
+						regioncnt[config.VMNametoRegion(smeas[s].Mon)] = regioncnt[config.VMNametoRegion(smeas[s].Mon)] + 1
+						regionkeys[config.VMNametoRegion(smeas[s].Mon)] = append(regionkeys[config.VMNametoRegion(smeas[s].Mon)], smeaskey)
+					}
+				}
+			}
+		}
+	}
+	vmtoset := make([]string, 0)
+	//iterate over all regions, new targets are put into 0 by default
+	for regionkey, regioncount := range regioncnt {
+		//compute the VMs needed in each region
+		numvm := int(math.Ceil(float64(regioncount) / float64(ssparam.TargetperVM)))
+		log.Println("Region", regionkey, "needs", numvm, "VMs")
+		runrec[regionkey].SelectedTotal = regioncount
+		if numvm > ssparam.MaxVMperRegion {
+			numvm = ssparam.MaxVMperRegion
+			log.Println("Region", regionkey, "will construct", numvm, "VMs")
+		}
+		//loop over the VMs and move extra VMs to 0
+		vmmap := make(map[string][]string)
+		for _, vmkeys := range regionkeys[regionkey] {
+			vmnum := config.VMNumber(smeasmap[vmkeys][0].Mon)
+			if vmnum == 0 {
+				vmtoset = append(vmtoset, vmkeys)
+			} else {
+				if vmnum > numvm {
+					log.Println("Target in closing VM", vmkeys)
+					//current vm number is larger than the vm to be used, set it to 0, and redistribute it later
+					smeasmap[vmkeys][0].Mon = config.VMNametoRegion(smeasmap[vmkeys][0].Mon) + "-0"
+					servertoupdate = append(servertoupdate, smeasmap[vmkeys][0])
+					vmtoset = append(vmtoset, vmkeys)
+				} else {
+					log.Println("Check Target", vmkeys, smeasmap[vmkeys][0].Mon, smeasmap[vmkeys][0].Enabled)
+					if smeasmap[vmkeys][0].Enabled {
+						if _, vexist := vmmap[smeasmap[vmkeys][0].Mon]; !vexist {
+							vmmap[smeasmap[vmkeys][0].Mon] = []string{vmkeys}
+						} else {
+							vmmap[smeasmap[vmkeys][0].Mon] = append(vmmap[smeasmap[vmkeys][0].Mon], vmkeys)
+						}
+					}
+				}
+			}
+		}
+		//redistribute VMs
+		for v := 1; v <= numvm; v++ {
+			vmnames := regionkey + "-" + strconv.Itoa(v)
+			vmroom := 0
+			if vms, vexist := vmmap[vmnames]; vexist {
+				vmroom = ssparam.TargetperVM - len(vms)
+			} else {
+				vmroom = ssparam.TargetperVM
+			}
+			if vmroom > 0 {
+				log.Println("VM", vmnames, "has", len(vmmap[vmnames]), "and has room", vmroom, "and has remaining", len(vmtoset))
+				log.Println("  ", vmmap[vmnames])
+				setvmidx := 0
+				if len(vmtoset) > vmroom {
+					//this vm is not going to fit all new ones
+					setvmidx = vmroom
+				} else {
+					//this vm can fit all targets
+					setvmidx = len(vmtoset)
+				}
+				//set the first setvmidx targets as this monitor
+				for _, target := range vmtoset[:setvmidx] {
+					smeasmap[target][0].Mon = vmnames
+					log.Println("Target", target, "assigned to", vmnames)
+				}
+				//cut them out from vmtoset, this slice will be empty if all targets are allocated
+				vmtoset = vmtoset[setvmidx:]
+			} else if vmroom == 0 {
+				log.Println("VM", vmnames, "is full")
+			} else {
+				//this vm is overflow
+				log.Println("VM", vmnames, "currently overflow")
+				overflown := len(vmmap[vmnames]) - ssparam.TargetperVM
+				extravms := vmmap[vmnames][overflown:]
+				vmmap[vmnames] = vmmap[vmnames][:overflown]
+				for _, vmkeys := range extravms {
+					smeasmap[vmkeys][0].Mon = config.VMNametoRegion(smeasmap[vmkeys][0].Mon) + "-0"
+					vmtoset = append(vmtoset, vmkeys)
+					log.Println("Overflown Target", vmkeys, "removed from ", vmnames)
+				}
+			}
+		}
+		if len(vmtoset) > 0 {
+			//we need more VMs than existing ones
+			runrec[regionkey].UnallocatedTargets = len(vmtoset)
+			log.Println("Still have", len(vmtoset), "targets cannot be allocated", vmtoset)
+			vmtoset = []string{}
+		}
+	}
+	//	finalupdate := make([]*spdb.SpeedMeas, 0)
+	finalinsert := make([]*spdb.SpeedMeas, 0)
+	for _, server := range servertoinsert {
+		if config.VMNumber(server.Mon) > 0 {
+			log.Println(server)
+			runrec[config.VMNametoRegion(server.Mon)].InsertedTargets += 1
+			finalinsert = append(finalinsert, server)
+		} else {
+			log.Println("Not adding", server)
+		}
+	}
+	//commit to db
+	dbctx := context.WithoutCancel(ctx)
+	_, err = ssparam.MongoClient.InsertManySpeedMeas(dbctx, finalinsert)
+	if err != nil {
+		log.Println("Insert error", err)
+		notify.Error(ssparam.Notifier, "insert target error", notify.Count("targets", len(finalinsert)), notify.Err(err))
+	}
+	log.Println("Final to update")
+	for _, server := range servertoupdate {
+		if (config.VMNumber(server.Mon) > 0 && server.Enabled) || server.Enabled == false {
+			log.Println(server)
+			err := ssparam.MongoClient.UpdateSpeedserver(dbctx, server)
+			if err != nil {
+				log.Println("Update error", err)
+				notify.Error(ssparam.Notifier, "update target error", notify.VM(server.Mon), notify.Err(err))
+			}
+			runrec[config.VMNametoRegion(server.Mon)].UpdatedTargets += 1
+			//finalupdate = append(finalupdate, server)
+		} else {
+			log.Println("Dropped update plan", server)
+		}
+	}
+	return runrec, nil
+}
+
+func MMRunReport(ssparam *config.SsConfig, runreportdata map[string]*RunRecord) {
+	runreport := []string{}
+	for region, stat := range runreportdata {
+		runreport = append(runreport, fmt.Sprintf(" %s: ,Total: %d, Discarded: %d, Updated: %d, Inserted: %d", region, stat.SelectedTotal, stat.UnallocatedTargets, stat.UpdatedTargets, stat.InsertedTargets))
+	}
+	notify.Report(ssparam.Notifier, notify.SeverityInfo, "Select Target report:", runreport...)
+}
+
+//RecordRun stores the per region counts in the run record and finishes it
+func RecordRun(run *runlog.Recorder, runreportdata map[string]*RunRecord) {
+	for region, stat := range runreportdata {
+		run.VMCount(region, "selected", stat.SelectedTotal)
+		run.VMCount(region, "discarded", stat.UnallocatedTargets)
+		run.VMCount(region, "updated", stat.UpdatedTargets)
+		run.VMCount(region, "inserted", stat.InsertedTargets)
+		run.VMStatus(region, runlog.StatusOK, "")
+	}
+	run.Finish(nil)
+}
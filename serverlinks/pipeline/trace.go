@@ -0,0 +1,215 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"serverlinks/config"
+	"serverlinks/fileutils"
+	"serverlinks/iputils"
+	"serverlinks/sptraceroute"
+	"spservers/common"
+	"spservers/notify"
+	"spservers/runlog"
+	"spservers/spdb"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	GlobalStart = 1588291200
+)
+
+//UpdateTraceroute loads the traceroute results of every vm under the result
+//directory newer than the last loaded one and reports the last file of each vm.
+//The database and the notifier of trconfig are closed when it returns.
+func UpdateTraceroute(trconfig *config.TrConfig) error {
+	defer trconfig.MongoClient.Close()
+	defer trconfig.Notifier.Close()
+	ctx, cancel := common.Context(trconfig.Deadline)
+	defer cancel()
+	run := runlog.Start(ctx, trconfig.MongoClient, "updatetr")
+	var wg sync.WaitGroup
+	workerchan := make(chan int, trconfig.VMWorker)
+	vmnamere := regexp.MustCompile(`(\w+-\w+-\w+)`)
+	resultfolder, err := ioutil.ReadDir(trconfig.ResultDir)
+	if err != nil {
+		run.Finish(err)
+		log.Println(err)
+		return err
+	}
+	//one index for the vms of the run, they trace the same servers
+	servers := sptraceroute.NewServerIndex(trconfig.MongoClient, trconfig.ServerRefresh)
+	if err := servers.Load(ctx); err != nil {
+		run.Finish(err)
+		log.Println("Load servers failed", err)
+		return err
+	}
+	vmlist := []string{}
+	for _, f := range resultfolder {
+		if f.IsDir() {
+			nameslice := vmnamere.FindStringSubmatch(f.Name())
+			if len(nameslice) > 0 {
+				vmlist = append(vmlist, nameslice[1])
+				wg.Add(1)
+				go func() {
+					workerchan <- 1
+					if err := processVMTr(ctx, nameslice[1], trconfig, servers, run); err != nil {
+						log.Println("Update traceroute failed", nameslice[1], err)
+					}
+					<-workerchan
+					wg.Done()
+				}()
+
+			}
+		}
+	}
+	wg.Wait()
+	hits, misses, loads := servers.Stats()
+	log.Println("Server index hits", hits, "misses", misses, "loads", loads)
+	run.Count("serverhits", int(hits))
+	run.Count("servermisses", int(misses))
+	run.Count("serverloads", int(loads))
+	run.Finish(ctx.Err())
+	if err := ctx.Err(); err != nil {
+		log.Println("Update traceroute stopped, the remaining files are loaded by the next run", err)
+	}
+	ReportTracerouteStatus(context.WithoutCancel(ctx), trconfig, vmlist)
+	//a bad vm does not stop the others, report all of them at the end
+	if err := run.Err(); err != nil {
+		log.Println("Update traceroute failed on some vms:\n" + err.Error())
+		notify.Error(trconfig.Notifier, "updatetr failed on some vms", notify.Err(err))
+		return err
+	}
+	return nil
+}
+
+//processVMTr loads the traceroute results of vmname newer than the last loaded one. A broken
+//tarball only skips that file, a database error stops the vm. Once ctx is done no further
+//file is started
+func processVMTr(ctx context.Context, vmname string, config *config.TrConfig, servers *sptraceroute.ServerIndex, run *runlog.Recorder) error {
+	if err := ctx.Err(); err != nil {
+		run.VMStatus(vmname, runlog.StatusCanceled, err.Error())
+		return err
+	}
+	vmpath := filepath.Join(config.ResultDir, vmname)
+	log.Println("working on", vmpath)
+	monvmstatus, err := config.MongoClient.QueryDataStatus(ctx, vmname)
+	if err != nil {
+		run.VMDone(vmname, err)
+		return err
+	}
+	var lastts time.Time
+
+	if len(monvmstatus.TraceFile) > 0 {
+		lastts = time.Unix(sptraceroute.ParseTraceFileTs(monvmstatus.TraceFile), 0)
+	} else {
+		lastts = time.Unix(int64(GlobalStart), 0)
+	}
+	today := time.Now()
+	linkkeymap, faripmap, err := config.MongoClient.CreateLinkmap(ctx, convregion(vmname))
+	if err != nil {
+		notify.Error(config.Notifier, "failed to create link map", notify.VM(vmname), notify.Err(err))
+		log.Println("create link map failed", vmname, err)
+		err = fmt.Errorf("create link map: %w", err)
+		run.VMDone(vmname, err)
+		return err
+	}
+	processed := 0
+	errs := []error{}
+	for curtime := lastts; curtime.Before(today) && !stopVM(errs) && ctx.Err() == nil; curtime = curtime.AddDate(0, 1, 0) {
+		monthdir := filepath.Join(vmpath, strconv.Itoa(curtime.Year()), strconv.Itoa(int(curtime.Month())))
+		if _, err := os.Stat(monthdir); os.IsNotExist(err) {
+			continue
+		}
+		//month directory exists
+		trfiles, err := fileutils.SortResultFiles(monthdir, sptraceroute.ParseTraceFileTs, 0)
+		if err != nil {
+			log.Println("List result files error", err, monthdir)
+			errs = append(errs, err)
+			continue
+		}
+		monthprefix2as, err := iputils.NewIPHandlerbyMonth(curtime)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("prefix2as of %s: %w", curtime.Format("2006-01"), err))
+			continue
+		}
+		for _, trfile := range trfiles {
+			if ctx.Err() != nil {
+				break
+			}
+			filets := sptraceroute.ParseTraceFileTs(filepath.Base(trfile))
+			if filets <= 0 || filets <= lastts.Unix() {
+				continue
+			}
+			trresult, err := config.PrepareTraceData(trfile)
+			if err != nil {
+				log.Println("Trace file invalid", vmname, err)
+				errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(trfile), err))
+				continue
+			}
+			run.Input("trace", vmname, trfile)
+			trresult.Prefix2As = monthprefix2as
+			trresult.TraceTs = filets
+			inserted, err := sptraceroute.ParseServerTrace(ctx, config, trresult, vmname, servers, linkkeymap, faripmap)
+			run.VMCount(vmname, "traceroutes", inserted)
+			if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+				//nothing of the file was inserted
+				break
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(trfile), err))
+				if stopVM(errs) {
+					break
+				}
+			}
+			if err := config.MongoClient.UpdateDataStatus(context.WithoutCancel(ctx), &spdb.VMDataStatus{Mon: vmname, TraceFile: filepath.Base(trfile)}); err != nil {
+				errs = append(errs, err)
+				break
+			}
+			log.Println(vmname, "updated to", filepath.Base(trfile))
+			run.VMCount(vmname, "tracefiles", 1)
+			processed++
+		}
+	}
+	if len(errs) == 0 && ctx.Err() != nil {
+		run.VMStatus(vmname, runlog.StatusCanceled, ctx.Err().Error())
+		return ctx.Err()
+	}
+	if processed == 0 && len(errs) == 0 {
+		run.VMStatus(vmname, runlog.StatusSkipped, "no new trace file")
+		return nil
+	}
+	err = errors.Join(errs...)
+	run.VMDone(vmname, err)
+	return err
+}
+
+//stopVM tells if the last error is a database error, the following files would fail the same way
+func stopVM(errs []error) bool {
+	return len(errs) > 0 && errors.Is(errs[len(errs)-1], spdb.ErrDBUnavailable)
+}
+
+func ReportTracerouteStatus(ctx context.Context, config *config.TrConfig, vmlist []string) {
+	outstr := []string{}
+	for _, vm := range vmlist {
+		monstatus, _ := config.MongoClient.QueryDataStatus(ctx, vm)
+		outstr = append(outstr, monstatus.TraceFile)
+	}
+	notify.Report(config.Notifier, notify.SeverityInfo, "I updated traceroute from these VMs:", outstr...)
+}
+
+func convregion(vmname string) string {
+	vmnamere := regexp.MustCompile(`(\w+-\w+-)\w+`)
+	namearr := vmnamere.FindStringSubmatch(vmname)
+	if len(namearr) > 0 {
+		return namearr[1] + "1"
+	}
+	return vmname
+}
@@ -0,0 +1,291 @@
+// Package speedresult reads the metadata files written by metameasurement.py
+// around each speed test, and turns them into spdb.SpeedResult records.
+package speedresult
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"sort"
+	"spservers/spdb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//time format of start/end in the metadata file, e.g. 20200501_123456.123456
+const metaTimeLayout = "20060102_150405.000000"
+
+//metadata file written by metameasurement.py
+type SoMeta struct {
+	Start         string                     `json:"start"`
+	End           string                     `json:"end"`
+	Version       string                     `json:"version"`
+	Command       string                     `json:"command"`
+	CommandOutput json.RawMessage            `json:"commandoutput"`
+	Monitors      map[string]json.RawMessage `json:"monitors"`
+}
+
+type ToolOutput struct {
+	ReturnCode int    `json:"returncode"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+}
+
+//one monitor sample, [ts, {key: value}]. values are nil when python wrote inf/nan
+type monSample struct {
+	Ts     float64
+	Values map[string]*float64
+}
+
+func (s *monSample) UnmarshalJSON(data []byte) error {
+	var pair []json.RawMessage
+	if err := json.Unmarshal(data, &pair); err != nil {
+		return err
+	}
+	if len(pair) != 2 {
+		return nil
+	}
+	if err := json.Unmarshal(pair[0], &s.Ts); err != nil {
+		return err
+	}
+	//drop non numeric values, like ipsrc of rtt samples
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(pair[1], &raw); err != nil {
+		return err
+	}
+	s.Values = make(map[string]*float64)
+	for k, v := range raw {
+		var f *float64
+		if json.Unmarshal(v, &f) == nil {
+			s.Values[k] = f
+		}
+	}
+	return nil
+}
+
+//ss samples keep the raw command output
+type ssSample struct {
+	Ts   float64
+	Info string
+}
+
+func (s *ssSample) UnmarshalJSON(data []byte) error {
+	var pair []json.RawMessage
+	if err := json.Unmarshal(data, &pair); err != nil {
+		return err
+	}
+	if len(pair) != 2 {
+		return nil
+	}
+	var info struct {
+		Info string `json:"info"`
+	}
+	if err := json.Unmarshal(pair[0], &s.Ts); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(pair[1], &info); err != nil {
+		return err
+	}
+	s.Info = info.Info
+	return nil
+}
+
+type rttMeta struct {
+	ProbeConfig struct {
+		Dest      string `json:"dest"`
+		ProbeType string `json:"probetype"`
+	} `json:"probe_config"`
+	Ping []monSample `json:"ping"`
+}
+
+func ReadMeta(filename string) (*SoMeta, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	meta := &SoMeta{}
+	if err := json.Unmarshal(sanitizeJSON(data), meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (m *SoMeta) StartTime() time.Time {
+	return parseMetaTime(m.Start)
+}
+
+func (m *SoMeta) EndTime() time.Time {
+	return parseMetaTime(m.End)
+}
+
+func parseMetaTime(ts string) time.Time {
+	t, err := time.Parse(metaTimeLayout, ts)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+//output of the speed test tool. nil if the tool did not start or complete,
+//in that case metameasurement stores an error string instead
+func (m *SoMeta) Output() *ToolOutput {
+	out := &ToolOutput{}
+	if len(m.CommandOutput) == 0 || json.Unmarshal(m.CommandOutput, out) != nil {
+		return nil
+	}
+	return out
+}
+
+//summarize all monitors found in the metadata
+func (m *SoMeta) MonitorMeta() spdb.MonitorMeta {
+	mm := spdb.MonitorMeta{}
+	for name, raw := range m.Monitors {
+		switch {
+		case name == "cpu":
+			var samples []monSample
+			if json.Unmarshal(raw, &samples) == nil {
+				mm.CpuIdleMean, mm.CpuIdleMin = meanmin(sampleValues(samples, "idle"))
+			}
+		case name == "mem":
+			var samples []monSample
+			if json.Unmarshal(raw, &samples) == nil {
+				mm.MemAvailMean, mm.MemAvailMin = meanmin(sampleValues(samples, "available"))
+			}
+		case name == "netstat":
+			var samples []monSample
+			if json.Unmarshal(raw, &samples) == nil {
+				for _, s := range samples {
+					for k, v := range s.Values {
+						if v == nil {
+							continue
+						}
+						if strings.Contains(k, "drop") {
+							mm.NetstatDrops += int64(*v)
+						} else if strings.Contains(k, "err") {
+							mm.NetstatErrors += int64(*v)
+						}
+					}
+				}
+			}
+		case name == "ss":
+			var samples []ssSample
+			if json.Unmarshal(raw, &samples) == nil {
+				mm.SsSamples = len(samples)
+				rtts := []float64{}
+				for _, s := range samples {
+					rtts = append(rtts, ssRtts(s.Info)...)
+				}
+				mm.SsRttMedian = median(rtts)
+			}
+		case strings.HasPrefix(name, "rtt"):
+			rmeta := &rttMeta{}
+			if json.Unmarshal(raw, rmeta) == nil && len(rmeta.Ping) > 0 {
+				mm.RttDest = rmeta.ProbeConfig.Dest
+				mm.RttProbes = len(rmeta.Ping)
+				rtts := []float64{}
+				for _, s := range rmeta.Ping {
+					if v := s.Values["rtt"]; v != nil {
+						rtts = append(rtts, *v*1000)
+					} else {
+						mm.RttLost++
+					}
+				}
+				mm.RttMean, mm.RttMin = meanmin(rtts)
+				mm.RttMedian = median(rtts)
+			}
+		}
+	}
+	return mm
+}
+
+func sampleValues(samples []monSample, key string) []float64 {
+	vals := []float64{}
+	for _, s := range samples {
+		if v := s.Values[key]; v != nil {
+			vals = append(vals, *v)
+		}
+	}
+	return vals
+}
+
+var ssrttre = regexp.MustCompile(`\brtt:([\d.]+)/`)
+
+//tcp rtt (ms) of every connection listed by ss -i
+func ssRtts(info string) []float64 {
+	rtts := []float64{}
+	for _, m := range ssrttre.FindAllStringSubmatch(info, -1) {
+		if r, err := strconv.ParseFloat(m[1], 64); err == nil {
+			rtts = append(rtts, r)
+		}
+	}
+	return rtts
+}
+
+func meanmin(vals []float64) (float64, float64) {
+	if len(vals) == 0 {
+		return 0, 0
+	}
+	sum, min := 0.0, math.Inf(1)
+	for _, v := range vals {
+		sum += v
+		if v < min {
+			min = v
+		}
+	}
+	return sum / float64(len(vals)), min
+}
+
+func median(vals []float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), vals...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+//python's json module writes inf and nan as bare Infinity/NaN, which is not
+//valid json. replace them with null outside of strings
+func sanitizeJSON(data []byte) []byte {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	instr, escaped := false, false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if instr {
+			out.WriteByte(c)
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				instr = false
+			}
+			continue
+		}
+		switch {
+		case c == '"':
+			instr = true
+		case bytes.HasPrefix(data[i:], []byte("-Infinity")):
+			out.WriteString("null")
+			i += len("-Infinity") - 1
+			continue
+		case bytes.HasPrefix(data[i:], []byte("Infinity")):
+			out.WriteString("null")
+			i += len("Infinity") - 1
+			continue
+		case bytes.HasPrefix(data[i:], []byte("NaN")):
+			out.WriteString("null")
+			i += len("NaN") - 1
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,154 @@
+package speedresult
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"regexp"
+	"serverlinks/config"
+	"spservers/platform"
+	_ "spservers/platform/all"
+	"spservers/spdb"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//metadata files are named <platform file tag>_<unix ts>.json, e.g. comcast_1588360880.6140668.json
+var SpeedFileRe = regexp.MustCompile(`^(` + strings.Join(platform.Tags(), "|") + `)_(\d+)(\.\d+)?\.json$`)
+
+func ParseSpeedFileTs(filename string) int64 {
+	namearr := SpeedFileRe.FindStringSubmatch(filepath.Base(filename))
+	if len(namearr) > 2 {
+		ts, err := strconv.ParseInt(namearr[2], 10, 64)
+		if err == nil {
+			return ts
+		}
+	}
+	return 0
+}
+
+func ParseSpeedFilePlatform(filename string) string {
+	namearr := SpeedFileRe.FindStringSubmatch(filepath.Base(filename))
+	if len(namearr) > 1 {
+		return namearr[1]
+	}
+	return ""
+}
+
+//Linker finds the speed server and the SpeedMeas assignment behind a test.
+//servers are cached, so use one Linker per VM
+type Linker struct {
+	db       spdb.Store
+	smeasmap map[string][]*spdb.SpeedMeas
+	servers  map[string]*spdb.SpeedServer
+}
+
+func NewLinker(ctx context.Context, db spdb.Store) (*Linker, error) {
+	smeasmap, err := db.QueryMapSpeedMeas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Linker{db: db, smeasmap: smeasmap, servers: make(map[string]*spdb.SpeedServer)}, nil
+}
+
+//find the server by the ip the client reported, or by resolving its host name
+func (l *Linker) Server(ctx context.Context, tag string, t *Throughput) *spdb.SpeedServer {
+	ip := net.ParseIP(t.ServerIP)
+	if ip == nil && len(t.ServerHost) > 0 {
+		if ips, err := net.DefaultResolver.LookupIP(ctx, "ip", t.ServerHost); err == nil {
+			for _, hip := range ips {
+				if hip.To4() != nil {
+					ip = hip
+					break
+				}
+			}
+		}
+	}
+	if ip == nil || ip.To4() == nil {
+		return nil
+	}
+	stype := platform.ServerType(tag)
+	key := stype + "|" + ip.String()
+	if s, sexist := l.servers[key]; sexist {
+		return s
+	}
+	var found *spdb.SpeedServer
+	servers, err := l.db.QueryServersbyIPv4(ctx, ip)
+	if err != nil {
+		log.Println("Query server error", ip, err)
+		return nil
+	}
+	for sidx, s := range servers {
+		if s.Type == stype {
+			found = &servers[sidx]
+			break
+		}
+	}
+	l.servers[key] = found
+	return found
+}
+
+//the assignment of the server to the region of vmname that was active at ts
+func (l *Linker) Assignment(vmname string, spid primitive.ObjectID, ts time.Time) *spdb.SpeedMeas {
+	for _, smeas := range l.smeasmap[config.VMNametoRegion(vmname)+":"+spid.Hex()] {
+		for _, period := range smeas.Activeperiod {
+			if !ts.Before(period.Start) && (period.End.IsZero() || ts.Before(period.End)) {
+				return smeas
+			}
+		}
+	}
+	return nil
+}
+
+//read one metadata file and build the result record
+func ProcessSpeedFile(ctx context.Context, vmname string, filename string, linker *Linker) (*spdb.SpeedResult, error) {
+	tag := ParseSpeedFilePlatform(filename)
+	if len(tag) == 0 {
+		return nil, errors.New("not a speed test metadata file " + filename)
+	}
+	meta, err := ReadMeta(filename)
+	if err != nil {
+		return nil, err
+	}
+	result := &spdb.SpeedResult{Mon: vmname, Type: tag, File: filepath.Base(filename), Ts: ParseSpeedFileTs(filename), Start: meta.StartTime(), End: meta.EndTime(), ReturnCode: -1}
+	result.Monitor = meta.MonitorMeta()
+	out := meta.Output()
+	if out == nil {
+		//tool never completed, keep the monitor data anyway
+		return result, nil
+	}
+	result.ReturnCode = out.ReturnCode
+	tput, err := ParseToolOutput(tag, out.Stdout)
+	if err != nil {
+		//keep the monitor data, the caller counts the tests without throughput
+		result.ParseError = fmt.Sprintf("parse %s output: %v", tag, err)
+		return result, nil
+	}
+	result.ServerIP, result.ServerHost = tput.ServerIP, tput.ServerHost
+	result.Download, result.Upload, result.Latency = tput.Download, tput.Upload, tput.Latency
+	if linker != nil {
+		if server := linker.Server(ctx, tag, tput); server != nil {
+			result.SpeedServer = server.SpId
+			if len(result.ServerIP) == 0 {
+				result.ServerIP = server.IPv4
+			}
+			ts := result.Start
+			if ts.IsZero() {
+				ts = time.Unix(result.Ts, 0)
+			}
+			if smeas := linker.Assignment(vmname, server.SpId, ts); smeas != nil {
+				result.SpeedMeas = smeas.SpMeasId
+				result.Link = smeas.Link
+			}
+		} else {
+			log.Println("Speed server not found", vmname, tag, tput.ServerIP, tput.ServerHost)
+		}
+	}
+	return result, nil
+}
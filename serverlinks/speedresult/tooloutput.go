@@ -0,0 +1,144 @@
+package speedresult
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+//what the speed test clients report, regardless of platform
+type Throughput struct {
+	ServerIP   string
+	ServerHost string
+	//Mbps
+	Download float64
+	Upload   float64
+	//ms
+	Latency float64
+}
+
+var ErrNoResult = errors.New("no speed test result in tool output")
+
+//parse stdout of the speed test client of the given platform
+func ParseToolOutput(platform string, stdout string) (*Throughput, error) {
+	switch platform {
+	case "ookla":
+		return parseOokla(stdout)
+	case "ndt", "mlab":
+		return parseNDT(stdout)
+	case "comcast":
+		return parseComcast(stdout)
+	}
+	return nil, errors.New("unknown platform " + platform)
+}
+
+//ookla cli, speedtest -f json. bandwidth is in bytes per second
+type ooklaResult struct {
+	Type string `json:"type"`
+	Ping struct {
+		Latency float64 `json:"latency"`
+	} `json:"ping"`
+	Download struct {
+		Bandwidth float64 `json:"bandwidth"`
+	} `json:"download"`
+	Upload struct {
+		Bandwidth float64 `json:"bandwidth"`
+	} `json:"upload"`
+	Server struct {
+		Host string `json:"host"`
+		IP   string `json:"ip"`
+	} `json:"server"`
+}
+
+func parseOokla(stdout string) (*Throughput, error) {
+	for _, line := range reverseLines(stdout) {
+		res := &ooklaResult{}
+		if json.Unmarshal([]byte(line), res) == nil && res.Type == "result" {
+			return &Throughput{ServerIP: res.Server.IP, ServerHost: res.Server.Host, Download: res.Download.Bandwidth * 8 / 1e6, Upload: res.Upload.Bandwidth * 8 / 1e6, Latency: res.Ping.Latency}, nil
+		}
+	}
+	return nil, ErrNoResult
+}
+
+type ndtValue struct {
+	Value float64 `json:"Value"`
+	Unit  string  `json:"Unit"`
+}
+
+//summary printed last by ndt7-client -format json
+type ndtSummary struct {
+	ServerFQDN string    `json:"ServerFQDN"`
+	ServerIP   string    `json:"ServerIP"`
+	Download   *ndtValue `json:"Download"`
+	Upload     *ndtValue `json:"Upload"`
+	MinRTT     *ndtValue `json:"MinRTT"`
+}
+
+func parseNDT(stdout string) (*Throughput, error) {
+	for _, line := range reverseLines(stdout) {
+		res := &ndtSummary{}
+		if json.Unmarshal([]byte(line), res) == nil && (res.Download != nil || res.Upload != nil) {
+			t := &Throughput{ServerIP: res.ServerIP, ServerHost: res.ServerFQDN}
+			if res.Download != nil {
+				t.Download = toMbps(res.Download)
+			}
+			if res.Upload != nil {
+				t.Upload = toMbps(res.Upload)
+			}
+			if res.MinRTT != nil {
+				t.Latency = res.MinRTT.Value
+			}
+			return t, nil
+		}
+	}
+	return nil, ErrNoResult
+}
+
+func toMbps(v *ndtValue) float64 {
+	switch strings.ToLower(v.Unit) {
+	case "kbit/s":
+		return v.Value / 1000
+	case "gbit/s":
+		return v.Value * 1000
+	}
+	return v.Value
+}
+
+//comcast.js prints download;upload;latency;protocol;host as the last line
+func parseComcast(stdout string) (*Throughput, error) {
+	for _, line := range reverseLines(stdout) {
+		fields := strings.Split(line, ";")
+		if len(fields) != 5 {
+			continue
+		}
+		down, derr := leadingFloat(fields[0])
+		up, uerr := leadingFloat(fields[1])
+		if derr != nil || uerr != nil {
+			continue
+		}
+		latency, _ := leadingFloat(fields[2])
+		return &Throughput{ServerHost: strings.TrimSpace(fields[4]), Download: down, Upload: up, Latency: latency}, nil
+	}
+	return nil, ErrNoResult
+}
+
+//number at the start of strings like "12 ms"
+func leadingFloat(s string) (float64, error) {
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return 0, ErrNoResult
+	}
+	return strconv.ParseFloat(f[0], 64)
+}
+
+func reverseLines(s string) []string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return lines
+}
@@ -1,6 +1,7 @@
 package sptraceroute
 
 import (
+	"context"
 	"log"
 	"math"
 	"serverlinks/config"
@@ -12,29 +13,41 @@ import (
 )
 
 //implements the logic for selection speedtest servers
-func MergeLinkSpservers(ssparam *config.SsConfig, region string, resultch chan *config.SsResult) {
+func MergeLinkSpservers(ctx context.Context, ssparam *config.SsConfig, region string, resultch chan *config.SsResult) {
 	log.Println("working on", region)
+	if _, rexist := config.RegionLocation(region); ssparam.MaxDistance > 0 && !rexist {
+		log.Println("No coordinates for region", region, "candidates are not filtered by distance")
+	}
 	reschan := make(chan *spdb.LinkSpAgg)
 	go func() {
-		err := ssparam.MongoClient.QueryLinksSpServerMatch(region, ssparam.StartDate.Unix(), reschan)
+		err := ssparam.MongoClient.QueryLinksSpServerMatch(ctx, region, ssparam.StartDate.Unix(), reschan)
 		if err != nil {
 			log.Println(err)
 		}
 		close(reschan)
 	}()
 	serverrec := make(map[string]int)
+	//link groups that already have a server
+	grouprec := make(map[string]string)
 	for res := range reschan {
 		selectedspidx := ""
 		done := false
 		reason := -99
 		rtt := 0.0
+		//candidates by id, read once
+		spinfos := make(map[string]*spdb.SpeedServer)
 		if len(res.LinkObj) == 0 {
 			log.Println("No link", res)
 			continue
 		}
+		gkey := spdb.LinkGroupKey(&res.LinkObj[0], ssparam.LinkGroup)
+		if selectedlink, gexist := grouprec[gkey]; gexist {
+			log.Println("Link", res.LinkObj[0].Linkkey, "shares", ssparam.LinkGroup, gkey, "with", selectedlink)
+			continue
+		}
 		if len(res.SpServerIds) > 0 {
 			/*for _, spid := range res.SpServerIds {
-				curserver, err := ssparam.MongoClient.QuerySpeedserverExist(region, spid)
+				curserver, err := ssparam.MongoClient.QuerySpeedserverExist(ctx, region, spid)
 				if err == nil {
 					if curserver == 1 {
 						//this server is current performing measurement, just keep it
@@ -45,21 +58,19 @@ func MergeLinkSpservers(ssparam *config.SsConfig, region string, resultch chan *
 					}
 				}
 			}*/
-			rttmap, tridmap, err := ssparam.MongoClient.TracerouteDestRtt(res.TrIds)
+			stats, err := ssparam.MongoClient.LinkServerStats(ctx, region, res.LinkObj[0].LinkId, ssparam.StartDate.Unix())
 			if err != nil {
 				log.Println("compute rtt error", err)
 			}
 			log.Println("Link:", res.LinkObj[0].Linkkey, res.LinkObj[0].LinkId)
+			statmap := make(map[string]*spdb.ServerTraceStats)
 			minrttset := make([]float64, 0)
-
-			for spid, _ := range rttmap {
-				sort.Float64s(rttmap[spid])
-				if len(rttmap[spid]) > 0 {
-					log.Println(" server", spid, "min rtt", rttmap[spid][0])
-					minrttset = append(minrttset, rttmap[spid][0])
+			for _, st := range stats {
+				statmap[st.SpServerId.Hex()] = st
+				if st.RttCount > 0 {
+					log.Println(" server", st.SpServerId.Hex(), "min rtt", st.MinRtt, "median", st.MedianRtt, "p90", st.P90Rtt)
+					minrttset = append(minrttset, st.MinRtt)
 				}
-				//linkopt, _ := ssparam.MongoClient.SpServersLinkChoice(region, spidhex)
-				//log.Println(" mean rtt:", spidhex, stat.Mean(rtts, nil), linkopt)
 			}
 			if len(minrttset) == 0 {
 				log.Println("No rtt found")
@@ -70,27 +81,30 @@ func MergeLinkSpservers(ssparam *config.SsConfig, region string, resultch chan *
 			low10qrtt := stat.Quantile(0.25, 1, minrttset, nil)
 			log.Println(" lowq rtt", low10qrtt)
 			candspservers := make([]string, 0)
-			for spidhex, _ := range rttmap {
-				if len(rttmap[spidhex]) > 0 {
-					if rttmap[spidhex][0] <= low10qrtt && rttmap[spidhex][0] < ssparam.RttThreshold {
-						candspservers = append(candspservers, spidhex)
-					}
+			for _, st := range stats {
+				if st.RttCount > 0 && st.MinRtt <= low10qrtt && st.MinRtt < ssparam.RttThreshold {
+					candspservers = append(candspservers, st.SpServerId.Hex())
 				}
 			}
-			//check if any server is in Far AS
+			if ssparam.MaxDistance > 0 {
+				candspservers = nearServers(ctx, ssparam, region, candspservers)
+			}
+			//check if any server is in Far AS. a candidate that can not be read is
+			//not selected
 			for _, spidhex := range candspservers {
 				spid, _ := primitive.ObjectIDFromHex(spidhex)
-				spinfo, err := ssparam.MongoClient.QueryServerbyId(spid)
+				spinfo, err := ssparam.MongoClient.QueryServerbyId(ctx, spid)
 				if err != nil {
-					log.Println("query server error", spidhex)
+					log.Println("query server error", spidhex, err)
 					continue
 				}
+				spinfos[spidhex] = spinfo
 				if _, srexist := serverrec[spidhex]; !srexist && spinfo.Asnv4 == res.LinkObj[0].FarAS {
 					done = true
 					selectedspidx = spidhex
 					log.Println(" sp is direct peer", spidhex)
 					reason = 1
-					rtt = rttmap[spidhex][0]
+					rtt = statmap[spidhex].MinRtt
 					serverrec[spidhex] = 1
 					break
 				}
@@ -102,25 +116,19 @@ func MergeLinkSpservers(ssparam *config.SsConfig, region string, resultch chan *
 				minlen := 99
 				maxfreq := 0
 				for _, spidhex := range candspservers {
-					aslen, err := ssparam.MongoClient.TracerouteASPathLen(tridmap[spidhex])
-					if err != nil {
-						log.Println("as path len error")
+					if _, sexist := spinfos[spidhex]; !sexist {
 						continue
 					}
-					sort.Ints(aslen)
-					log.Println("  sp", spidhex, "aslen", aslen)
-					trfreqmap[spidhex] = len(aslen)
-					if len(aslen) > maxfreq {
-						maxfreq = len(aslen)
+					st := statmap[spidhex]
+					log.Println("  sp", spidhex, "traces", st.Traces, "aslen", st.MinASPathLen, "-", st.MaxASPathLen)
+					trfreqmap[spidhex] = st.Traces
+					if st.Traces > maxfreq {
+						maxfreq = st.Traces
 					}
-					for _, asl := range aslen {
-						if asl > 0 {
-							if _, asexist := aspathmap[spidhex]; !asexist {
-								aspathmap[spidhex] = asl
-							}
-							if asl < minlen {
-								minlen = asl
-							}
+					if st.MinASPathLen > 0 {
+						aspathmap[spidhex] = st.MinASPathLen
+						if st.MinASPathLen < minlen {
+							minlen = st.MinASPathLen
 						}
 					}
 				}
@@ -131,9 +139,10 @@ func MergeLinkSpservers(ssparam *config.SsConfig, region string, resultch chan *
 					reason = -1
 					log.Println("  all servers seldom used this interconnects")
 				} else {
+					//in candidate order, ties go to the lower server id
 					minaspathserver := []string{}
-					for spidx, aspath := range aspathmap {
-						if aspath == minlen && trfreqmap[spidx] > 10 {
+					for _, spidx := range candspservers {
+						if aspath, aexist := aspathmap[spidx]; aexist && aspath == minlen && trfreqmap[spidx] >= ssparam.MinTrThreshold {
 							minaspathserver = append(minaspathserver, spidx)
 						}
 					}
@@ -149,7 +158,7 @@ func MergeLinkSpservers(ssparam *config.SsConfig, region string, resultch chan *
 						//pick the one with min rtt or existing
 						for _, mser := range minaspathserver {
 							tmpspid, _ := primitive.ObjectIDFromHex(mser)
-							curserver, err := ssparam.MongoClient.QuerySpeedserverExist(region, tmpspid)
+							curserver, err := ssparam.MongoClient.QuerySpeedserverExist(ctx, region, tmpspid)
 							if err == nil {
 								//not a server that we selected before
 								if _, srexist := serverrec[mser]; !srexist {
@@ -159,10 +168,10 @@ func MergeLinkSpservers(ssparam *config.SsConfig, region string, resultch chan *
 										reason = 3
 										break
 									} else {
-										if len(rttmap[mser]) >= 1 {
-											if rttmap[mser][0] < mrtt {
+										if statmap[mser].RttCount >= 1 {
+											if statmap[mser].MinRtt < mrtt {
 												mserver = mser
-												mrtt = rttmap[mser][0]
+												mrtt = statmap[mser].MinRtt
 											}
 										}
 
@@ -182,8 +191,8 @@ func MergeLinkSpservers(ssparam *config.SsConfig, region string, resultch chan *
 		}
 		if done && selectedspidx != "" {
 			sspidx, _ := primitive.ObjectIDFromHex(selectedspidx)
-			spserver, _ := ssparam.MongoClient.QueryServerbyId(sspidx)
-			log.Println("Selected ", spserver.Host, selectedspidx, "for link", res.LinkObj[0].Linkkey, reason)
+			log.Println("Selected ", spinfos[selectedspidx].Host, selectedspidx, "for link", res.LinkObj[0].Linkkey, reason)
+			grouprec[gkey] = res.LinkObj[0].Linkkey
 			lnk := &config.SsResult{Region: region, LinkId: res.LinkObj[0].LinkId, SpServerId: sspidx, Reason: reason, AvgRtt: rtt}
 			resultch <- lnk
 		} else {
@@ -191,3 +200,26 @@ func MergeLinkSpservers(ssparam *config.SsConfig, region string, resultch chan *
 		}
 	}
 }
+
+//nearServers drops the candidates farther than MaxDistance from the region, a
+//candidate without a known location is kept, and all of them for a region
+//missing from the region table, which MergeLinkSpservers logs
+func nearServers(ctx context.Context, ssparam *config.SsConfig, region string, candspservers []string) []string {
+	regionloc, rexist := config.RegionLocation(region)
+	if !rexist {
+		return candspservers
+	}
+	near := make([]string, 0, len(candspservers))
+	for _, spidhex := range candspservers {
+		spid, _ := primitive.ObjectIDFromHex(spidhex)
+		spinfo, err := ssparam.MongoClient.QueryServerbyId(ctx, spid)
+		if err == nil && spinfo.Location.Valid() {
+			if dist := regionloc.DistanceKm(spinfo.Location); dist > ssparam.MaxDistance {
+				log.Println("  sp", spidhex, "too far", int(dist), "km")
+				continue
+			}
+		}
+		near = append(near, spidhex)
+	}
+	return near
+}
@@ -0,0 +1,150 @@
+package sptraceroute
+
+import (
+	"context"
+	"serverlinks/config"
+	"spservers/spdb"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const mergeRegion = "aws-useast-1"
+
+func spId(t *testing.T, hex string) primitive.ObjectID {
+	t.Helper()
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+//mergeStore has one link of mergeRegion with ntraces traceroutes towards each
+//server, the traceroutes cross AS 64500 and end in the AS of the server
+func mergeStore(t *testing.T, faras string, servers []spdb.SpeedServer, ntraces int, rtt float64) (*spdb.MemStore, primitive.ObjectID) {
+	t.Helper()
+	ctx := context.Background()
+	store := spdb.NewMemStore()
+	if _, err := store.InsertServers(ctx, servers); err != nil {
+		t.Fatal(err)
+	}
+	linkkey := "10.0.0.1-198.51.100.1"
+	if err := store.UpdateLinkstoMongo(ctx, mergeRegion, 1700000000, map[string]*spdb.Link{linkkey: {NearIP: "10.0.0.1", FarIP: "198.51.100.1", FarAS: faras}}); err != nil {
+		t.Fatal(err)
+	}
+	link, err := store.QueryLinkbyKey(ctx, mergeRegion, linkkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trs := []*spdb.Traceroute{}
+	for _, s := range servers {
+		for i := 0; i < ntraces; i++ {
+			trs = append(trs, &spdb.Traceroute{Region: mergeRegion, DstIP: s.IPv4, SpServerId: s.SpId, LinkId: link.LinkId, Ts: 1700000000, Hops: []spdb.TrHop{
+				{Addr: "10.0.0.1", ProbeTTL: 1, Rtt: 1, Asn: "64500"},
+				{Addr: "198.51.100.1", ProbeTTL: 2, Rtt: rtt / 2, Asn: faras},
+				{Addr: s.IPv4, ProbeTTL: 3, Rtt: rtt, Asn: s.Asnv4},
+			}})
+		}
+	}
+	if _, err := store.InsertManyTraceroutes(ctx, trs); err != nil {
+		t.Fatal(err)
+	}
+	return store, link.LinkId
+}
+
+func runMerge(t *testing.T, store spdb.Store) []*config.SsResult {
+	t.Helper()
+	ssparam := &config.SsConfig{MongoClient: store, StartDate: time.Unix(1600000000, 0), RttThreshold: 100, MinTrThreshold: 5}
+	resultch := make(chan *config.SsResult)
+	go func() {
+		MergeLinkSpservers(context.Background(), ssparam, mergeRegion, resultch)
+		close(resultch)
+	}()
+	results := []*config.SsResult{}
+	for res := range resultch {
+		results = append(results, res)
+	}
+	return results
+}
+
+func TestMergeLinkSpserversDirectPeer(t *testing.T) {
+	servers := []spdb.SpeedServer{
+		{SpId: spId(t, "000000000000000000000001"), Type: "ookla", Id: "1", IPv4: "192.0.2.1", Asnv4: "64501", Enabled: true},
+		{SpId: spId(t, "000000000000000000000002"), Type: "ookla", Id: "2", IPv4: "192.0.2.2", Asnv4: "64502", Enabled: true},
+	}
+	store, linkid := mergeStore(t, "64502", servers, 12, 8)
+	results := runMerge(t, store)
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	if r := results[0]; r.LinkId != linkid || r.SpServerId != servers[1].SpId || r.Reason != 1 || r.AvgRtt != 8 {
+		t.Errorf("result = %+v, want server 2 as direct peer", *r)
+	}
+}
+
+//servers with the same AS path length and rtt go to the lowest server id, on
+//every run
+func TestMergeLinkSpserversTieBreak(t *testing.T) {
+	servers := []spdb.SpeedServer{}
+	for _, hex := range []string{"000000000000000000000003", "000000000000000000000001", "000000000000000000000004", "000000000000000000000002"} {
+		servers = append(servers, spdb.SpeedServer{SpId: spId(t, hex), Type: "ookla", Id: hex, IPv4: "192.0.2." + hex[23:], Asnv4: "64510", Enabled: true})
+	}
+	store, _ := mergeStore(t, "64502", servers, 12, 8)
+	for run := 0; run < 20; run++ {
+		results := runMerge(t, store)
+		if len(results) != 1 {
+			t.Fatalf("got %d results, want 1", len(results))
+		}
+		if r := results[0]; r.SpServerId.Hex() != "000000000000000000000001" || r.Reason != 4 {
+			t.Fatalf("run %d selected %s reason %d, want the lowest server id", run, r.SpServerId.Hex(), r.Reason)
+		}
+	}
+}
+
+//deletedStore loses a server between the traceroutes and the selection
+type deletedStore struct {
+	*spdb.MemStore
+	deleted primitive.ObjectID
+}
+
+func (d *deletedStore) QueryServerbyId(ctx context.Context, sid primitive.ObjectID) (*spdb.SpeedServer, error) {
+	if sid == d.deleted {
+		return nil, context.Canceled
+	}
+	return d.MemStore.QueryServerbyId(ctx, sid)
+}
+
+func TestMergeLinkSpserversDeletedServer(t *testing.T) {
+	servers := []spdb.SpeedServer{
+		{SpId: spId(t, "000000000000000000000001"), Type: "ookla", Id: "1", IPv4: "192.0.2.1", Asnv4: "64510", Enabled: true},
+		{SpId: spId(t, "000000000000000000000002"), Type: "ookla", Id: "2", IPv4: "192.0.2.2", Asnv4: "64510", Enabled: true},
+	}
+	mem, _ := mergeStore(t, "64502", servers, 12, 8)
+	results := runMerge(t, &deletedStore{MemStore: mem, deleted: servers[0].SpId})
+	if len(results) != 1 || results[0].SpServerId != servers[1].SpId {
+		t.Fatalf("results = %+v, want server 2", results)
+	}
+	//and the other way round
+	results = runMerge(t, &deletedStore{MemStore: mem, deleted: servers[1].SpId})
+	if len(results) != 1 || results[0].SpServerId != servers[0].SpId {
+		t.Fatalf("results = %+v, want server 1", results)
+	}
+}
+
+//servers seen by fewer than MinTrThreshold traceroutes are not selected
+func TestMergeLinkSpserversMinTraceroutes(t *testing.T) {
+	servers := []spdb.SpeedServer{
+		{SpId: spId(t, "000000000000000000000001"), Type: "ookla", Id: "1", IPv4: "192.0.2.1", Asnv4: "64510", Enabled: true},
+	}
+	for _, tt := range []struct {
+		ntraces int
+		want    int
+	}{{4, 0}, {5, 1}, {6, 1}} {
+		store, _ := mergeStore(t, "64502", servers, tt.ntraces, 8)
+		if results := runMerge(t, store); len(results) != tt.want {
+			t.Errorf("%d traceroutes gave %d results, want %d", tt.ntraces, len(results), tt.want)
+		}
+	}
+}
@@ -1,31 +1,28 @@
 package sptraceroute
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"serverlinks/config"
+	"serverlinks/fileutils"
+	"serverlinks/warts"
 	"sort"
+	"spservers/notify"
 	"spservers/spdb"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 )
 
-const (
-	Comcast = 1
-	Ookla   = 2
-	Ndt     = 3
-)
-
-type Testplatform int
-
 type ServerLink struct {
-	Type       Testplatform
+	//name of the platform of the server, see spservers/platform
+	Type       string
 	ServerIP   string
 	Lnk        *spdb.Link
 	Traceroute *SCTraceroute
@@ -50,47 +47,72 @@ type SCHop struct {
 }
 
 //func ParseServerTrace(Param *config.TrConfig, idlink map[string]*bdrmaplink.Link, farlink map[string][]*bdrmaplink.Link, servermap map[string]*ServerLink, prefixip *iputils.IPHandler, platform Testplatform) {
-func ParseServerTrace(Param *config.TrConfig, TrResult *config.TrResult, vmname string, linkkeymap map[string]*spdb.Link, faripmap map[string][]*spdb.Link) {
-	var out bytes.Buffer
-	allwarts := []string{TrResult.ComcastWartsFile, TrResult.NDTWartsFile, TrResult.OoklaWartsFile}
+//ParseServerTrace matches the traceroutes of every platform to the links of the vm and
+//inserts them, servers gives the server of each destination. A broken warts file does not
+//stop the others, it returns the number of inserted traceroutes and the errors of all warts
+//files joined. Nothing is inserted if ctx is done before all warts files are read, the next
+//run parses the trace file again
+func ParseServerTrace(ctx context.Context, Param *config.TrConfig, TrResult *config.TrResult, vmname string, servers *ServerIndex, linkkeymap map[string]*spdb.Link, faripmap map[string][]*spdb.Link) (int, error) {
+	allwarts := make([]string, 0, len(TrResult.WartsFiles))
+	for _, tracewarts := range TrResult.WartsFiles {
+		allwarts = append(allwarts, tracewarts)
+	}
+	sort.Strings(allwarts)
 	defer TrResult.CleanupTmp()
 	//	alltrs := make([]*spdb.Traceroute, 0)
 	log.Printf("Processing traceroute %s %d\n", vmname, TrResult.TraceTs)
+	inserted := 0
+	errs := []error{}
+	var trwg sync.WaitGroup
+	trresultchan := make(chan *spdb.Traceroute)
+	trworkers := make(chan int, Param.TrWorker)
+	collected := make(chan error)
+	go func() {
+		n, err := TracerouteCollector(ctx, Param, trresultchan)
+		inserted = n
+		collected <- err
+	}()
 	for _, tracewarts := range allwarts {
-		tracejsoncmd := exec.Command(filepath.Join(Param.ScamperBin, "sc_warts2json"), tracewarts)
-		tracejsoncmd.Stdout = &out
-		err := tracejsoncmd.Run()
+		if ctx.Err() != nil {
+			break
+		}
+		wartsreader, err := warts.Open(tracewarts)
 		if err != nil {
 			log.Println(err) //o. he is so scary, fear, and need a panic button  >.<
-			Param.MMclient.SendPanic("warts2json error:", err.Error(), tracewarts)
+			notify.Error(Param.Notifier, "warts open error", notify.VM(vmname), notify.File(tracewarts), notify.Err(err))
+			errs = append(errs, fmt.Errorf("%w: %s: %v", fileutils.ErrIncompleteArchive, filepath.Base(tracewarts), err))
+			continue
 		}
-		var trwg sync.WaitGroup
-		trresultchan := make(chan *spdb.Traceroute)
-		trworkers := make(chan int, Param.TrWorker)
-		lines := strings.Split(out.String(), "\n")
-		go TracerouteCollector(Param, trresultchan)
-		for _, line := range lines {
-			var tr SCTraceroute
-			if len(line) < 2 {
-				continue
+		for ctx.Err() == nil {
+			rec, err := wartsreader.Next()
+			if err == io.EOF {
+				break
 			}
-			err := json.Unmarshal([]byte(line), &tr)
 			if err != nil {
-				log.Panic(err)
+				log.Println("warts read error", err, tracewarts)
+				notify.Error(Param.Notifier, "warts read error", notify.VM(vmname), notify.File(tracewarts), notify.Err(err))
+				errs = append(errs, fmt.Errorf("%w: %s: %v", fileutils.ErrIncompleteArchive, filepath.Base(tracewarts), err))
+				break
 			}
-			if tr.Type == "trace" {
+			if wtrace, istrace := rec.(*warts.Trace); istrace {
+				tr := ConvWartsTrace(wtrace)
 				trwg.Add(1)
 				go func() {
 					trworkers <- 1
 					//traceroute with less than 2 hops, or it is a duplicate server, simply skip ^.^
-					if spservers, err := Param.MongoClient.QueryServersbyIPv4(net.ParseIP(tr.DstIP)); len(tr.Hops) >= 2 && len(spservers) > 0 && err == nil {
-						dbtrace := spdb.Traceroute{Region: vmname, DstIP: tr.DstIP, DstAS: spservers[0].Asnv4, SpServerId: spservers[0].SpId, Ts: TrResult.TraceTs}
+					dstip := net.ParseIP(tr.DstIP)
+					if spserver, err := servers.Lookup(ctx, dstip); len(tr.Hops) >= 2 && spserver != nil && err == nil {
+						dstas := spserver.Asnv4
+						if dstip.To4() == nil {
+							dstas = spserver.Asnv6
+						}
+						dbtrace := spdb.Traceroute{Region: vmname, DstIP: tr.DstIP, DstAS: dstas, SpServerId: spserver.SpId, Ts: TrResult.TraceTs}
 						//sort by hop ttl
 						sort.Slice(tr.Hops, func(i, j int) bool { return tr.Hops[i].ProbeTTL < tr.Hops[j].ProbeTTL })
 						prevIdx := 0
 						dbtrace.Hops = make([]spdb.TrHop, 0)
 						for h := 1; h < len(tr.Hops); h++ {
-							dbtrace.Hops = append(dbtrace.Hops, spdb.TrHop{Addr: tr.Hops[h].Addr, ProbeTTL: tr.Hops[h].ProbeTTL, Rtt: tr.Hops[h].RTT, Asn: TrResult.Prefix2As.IPv4toASN(net.ParseIP(tr.Hops[h].Addr))})
+							dbtrace.Hops = append(dbtrace.Hops, spdb.TrHop{Addr: tr.Hops[h].Addr, ProbeTTL: tr.Hops[h].ProbeTTL, Rtt: tr.Hops[h].RTT, Asn: TrResult.Prefix2As.IPtoASN(net.ParseIP(tr.Hops[h].Addr))})
 							//consecutive hops. hops. and bunnies hops again.
 							if dbtrace.LinkId.IsZero() {
 								if tr.Hops[h].ProbeTTL == (tr.Hops[prevIdx].ProbeTTL + 1) {
@@ -140,8 +162,12 @@ func ParseServerTrace(Param *config.TrConfig, TrResult *config.TrResult, vmname
 				}()
 			}
 		}
-		trwg.Wait()
-		close(trresultchan)
+		wartsreader.Close()
+	}
+	trwg.Wait()
+	close(trresultchan)
+	if err := <-collected; err != nil {
+		errs = append(errs, err)
 	}
 	/*
 		lentr, err := Param.MongoClient.InsertManyTraceroutes(alltrs)
@@ -149,20 +175,46 @@ func ParseServerTrace(Param *config.TrConfig, TrResult *config.TrResult, vmname
 			log.Panic("Insertion error", err)
 		}
 		log.Printf("%s %d Inserted %d traceroutes\n", vmname, TrResult.TraceTs, lentr)*/
+	return inserted, errors.Join(errs...)
 }
 
-func TracerouteCollector(Param *config.TrConfig, trchan chan *spdb.Traceroute) {
+//convert a decoded warts trace into the same form sc_warts2json used to give us.
+//rtt is in milliseconds and hops without an address are dropped.
+func ConvWartsTrace(wtrace *warts.Trace) *SCTraceroute {
+	tr := &SCTraceroute{Type: "trace", Hops: make([]SCHop, 0, len(wtrace.Hops))}
+	if wtrace.Dst != nil {
+		tr.DstIP = wtrace.Dst.String()
+	}
+	for _, hop := range wtrace.Hops {
+		if hop.Addr == nil {
+			continue
+		}
+		tr.Hops = append(tr.Hops, SCHop{Addr: hop.Addr.String(), ProbeTTL: int(hop.ProbeTTL), RTT: float64(hop.RTT) / float64(time.Millisecond)})
+	}
+	return tr
+}
+
+//TracerouteCollector inserts the traceroutes of trchan once it is closed, or none if
+//ctx is done by then. A started insert is not canceled
+func TracerouteCollector(ctx context.Context, Param *config.TrConfig, trchan chan *spdb.Traceroute) (int, error) {
 	alltrs := make([]*spdb.Traceroute, 0)
 	for trelem := range trchan {
 		alltrs = append(alltrs, trelem)
 	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 	if len(alltrs) > 0 {
-		lentr, err := Param.MongoClient.InsertManyTraceroutes(alltrs)
+		lentr, err := Param.MongoClient.InsertManyTraceroutes(context.WithoutCancel(ctx), alltrs)
 		if err != nil {
-			log.Panic("Insertion error", err)
+			log.Println("Insertion error", err)
+			notify.Error(Param.Notifier, "traceroute insertion error", notify.Count("traceroutes", len(alltrs)), notify.Err(err))
+			return lentr, fmt.Errorf("insert traceroutes: %w", err)
 		}
 		log.Printf("Inserted %d traceroutes\n", lentr)
+		return lentr, nil
 	}
+	return 0, nil
 }
 
 func Convertfirstvm(vmname string) string {
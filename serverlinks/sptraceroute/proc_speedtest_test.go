@@ -0,0 +1,97 @@
+package sptraceroute
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"serverlinks/warts"
+	"testing"
+	"time"
+)
+
+func TestConvWartsTrace(t *testing.T) {
+	wtrace := &warts.Trace{
+		Dst: net.ParseIP("192.0.2.10"),
+		Hops: []warts.TraceHop{
+			{Addr: net.ParseIP("10.0.0.254"), ProbeTTL: 1, RTT: 1250 * time.Microsecond},
+			//no reply
+			{ProbeTTL: 2},
+			{Addr: net.ParseIP("192.0.2.10"), ProbeTTL: 3, RTT: 12345 * time.Microsecond},
+		},
+	}
+	tr := ConvWartsTrace(wtrace)
+	if tr.Type != "trace" || tr.DstIP != "192.0.2.10" {
+		t.Errorf("trace type %s dst %s", tr.Type, tr.DstIP)
+	}
+	want := []SCHop{{Addr: "10.0.0.254", ProbeTTL: 1, RTT: 1.25}, {Addr: "192.0.2.10", ProbeTTL: 3, RTT: 12.345}}
+	if len(tr.Hops) != len(want) {
+		t.Fatalf("got %d hops, want %d", len(tr.Hops), len(want))
+	}
+	for i, w := range want {
+		if tr.Hops[i] != w {
+			t.Errorf("hop %d = %+v, want %+v", i, tr.Hops[i], w)
+		}
+	}
+	if tr := ConvWartsTrace(&warts.Trace{}); tr.DstIP != "" || len(tr.Hops) != 0 {
+		t.Errorf("empty trace = %+v", *tr)
+	}
+}
+
+//the traceroutes of the warts fixtures convert as sc_warts2json gave them
+func TestConvWartsTraceFile(t *testing.T) {
+	r, err := warts.Open(filepath.Join("..", "warts", "testdata", "trace.warts.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	rec, err := r.Next()
+	for err == nil {
+		if _, istrace := rec.(*warts.Trace); istrace {
+			break
+		}
+		rec, err = r.Next()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := ConvWartsTrace(rec.(*warts.Trace))
+	if tr.DstIP != "192.0.2.10" || len(tr.Hops) != 4 {
+		t.Fatalf("trace = %+v", *tr)
+	}
+	last := tr.Hops[3]
+	if last.Addr != "192.0.2.10" || last.ProbeTTL != 3 || last.RTT != 12.345 {
+		t.Errorf("last hop = %+v", last)
+	}
+}
+
+//scamper-trace.json is the sc_warts2json output of scamper-trace.warts that
+//the old code read, the native reader gives the same traceroute
+func TestConvWartsTraceJSON(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "warts", "testdata", "scamper-trace.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want SCTraceroute
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatal(err)
+	}
+	r, err := warts.Open(filepath.Join("..", "warts", "testdata", "scamper-trace.warts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for {
+		rec, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wtrace, istrace := rec.(*warts.Trace); istrace {
+			if got := ConvWartsTrace(wtrace); !reflect.DeepEqual(*got, want) {
+				t.Errorf("trace = %+v, want %+v", *got, want)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package sptraceroute
+
+import (
+	"context"
+	"log"
+	"net"
+	"spservers/spdb"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//ServerIndex maps the IPv4 and IPv6 addresses of the speed test servers to the
+//servers, so the traceroutes of a run are matched to their server without a
+//database query each.
+//
+//Refresh policy: Load reads the enabled servers at the start of the run. Lookup
+//reads them again once they are older than maxage, a crawl running at the same
+//time may have replaced them, 0 keeps them for the whole run. An address that
+//is not indexed is looked up in the database once, traces older than the last
+//crawl go to servers disabled since. The answer is kept until the next load,
+//an address that is no server too.
+type ServerIndex struct {
+	store  spdb.Store
+	maxage time.Duration
+	mu     sync.RWMutex
+	//nil for an address that is no server
+	byip   map[string]*spdb.SpeedServer
+	loaded time.Time
+	//1 while a lookup reloads the index, the others keep the old one meanwhile
+	reloading int32
+	hits      int64
+	misses    int64
+	loads     int64
+}
+
+func NewServerIndex(store spdb.Store, maxage time.Duration) *ServerIndex {
+	return &ServerIndex{store: store, maxage: maxage, byip: make(map[string]*spdb.SpeedServer)}
+}
+
+//Load replaces the index with the enabled servers. The first server of an
+//address wins, as the first one of QueryServersbyIPv4 did
+func (si *ServerIndex) Load(ctx context.Context) error {
+	servers, err := si.store.QueryEnabledServers(ctx)
+	if err != nil {
+		return err
+	}
+	byip := make(map[string]*spdb.SpeedServer, 2*len(servers))
+	for sidx := range servers {
+		for _, addr := range []string{servers[sidx].IPv4, servers[sidx].IPv6} {
+			if ip := net.ParseIP(addr); ip != nil {
+				if _, iexist := byip[ip.String()]; !iexist {
+					byip[ip.String()] = &servers[sidx]
+				}
+			}
+		}
+	}
+	si.mu.Lock()
+	si.byip, si.loaded = byip, time.Now()
+	si.mu.Unlock()
+	atomic.AddInt64(&si.loads, 1)
+	log.Println("Indexed", len(byip), "server addresses of", len(servers), "servers")
+	return nil
+}
+
+func (si *ServerIndex) stale() bool {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	return si.maxage > 0 && time.Since(si.loaded) > si.maxage
+}
+
+//Lookup gives the server of ip, nil if ip is no server. The server is shared by
+//every caller and must not be changed
+func (si *ServerIndex) Lookup(ctx context.Context, ip net.IP) (*spdb.SpeedServer, error) {
+	if ip == nil {
+		return nil, nil
+	}
+	if si.stale() && atomic.CompareAndSwapInt32(&si.reloading, 0, 1) {
+		//a failed reload keeps the old index, the next lookup tries again
+		if err := si.Load(ctx); err != nil {
+			log.Println("Reload server index failed", err)
+		}
+		atomic.StoreInt32(&si.reloading, 0)
+	}
+	key := ip.String()
+	si.mu.RLock()
+	server, sexist := si.byip[key]
+	si.mu.RUnlock()
+	if sexist {
+		atomic.AddInt64(&si.hits, 1)
+		return server, nil
+	}
+	atomic.AddInt64(&si.misses, 1)
+	//the servers have no query by IPv6, an IPv6 address that is not indexed is no server
+	if ip.To4() != nil {
+		servers, err := si.store.QueryServersbyIPv4(ctx, ip)
+		if err != nil {
+			return nil, err
+		}
+		if len(servers) > 0 {
+			server = &servers[0]
+		}
+	}
+	si.mu.Lock()
+	si.byip[key] = server
+	si.mu.Unlock()
+	return server, nil
+}
+
+//Stats gives the lookups answered by the index, the lookups that went to the
+//database and the number of loads
+func (si *ServerIndex) Stats() (int64, int64, int64) {
+	return atomic.LoadInt64(&si.hits), atomic.LoadInt64(&si.misses), atomic.LoadInt64(&si.loads)
+}
@@ -0,0 +1,231 @@
+package sptraceroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"spservers/spdb"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//slowStore adds the round trip of a remote database to the server queries of a
+//MemStore and counts them. With fail set the enabled servers can not be read
+type slowStore struct {
+	spdb.Store
+	rtt     time.Duration
+	queries int64
+	fail    int32
+}
+
+func (s *slowStore) QueryServersbyIPv4(ctx context.Context, serverip net.IP) ([]spdb.SpeedServer, error) {
+	atomic.AddInt64(&s.queries, 1)
+	time.Sleep(s.rtt)
+	return s.Store.QueryServersbyIPv4(ctx, serverip)
+}
+
+func (s *slowStore) QueryEnabledServers(ctx context.Context) ([]spdb.SpeedServer, error) {
+	atomic.AddInt64(&s.queries, 1)
+	time.Sleep(s.rtt)
+	if atomic.LoadInt32(&s.fail) == 1 {
+		return nil, errors.New("database unavailable")
+	}
+	return s.Store.QueryEnabledServers(ctx)
+}
+
+func newSlowStore(t testing.TB, rtt time.Duration, servers []spdb.SpeedServer) *slowStore {
+	mem := spdb.NewMemStore()
+	if _, err := mem.InsertServers(context.Background(), servers); err != nil {
+		t.Fatal(err)
+	}
+	return &slowStore{Store: mem, rtt: rtt}
+}
+
+//synthetic servers, one of every ten with an IPv6 address, and the destinations
+//of ntraces traceroutes towards them. A tenth of the destinations is no server
+func synthetic(nservers, ntraces int) ([]spdb.SpeedServer, []net.IP) {
+	servers := make([]spdb.SpeedServer, nservers)
+	for sidx := range servers {
+		ipv4 := net.IPv4(10, byte(sidx>>16), byte(sidx>>8), byte(sidx)).String()
+		servers[sidx] = spdb.SpeedServer{Type: "ookla", Id: strconv.Itoa(sidx), IPv4: ipv4, Asnv4: "64512", Enabled: true}
+		if sidx%10 == 0 {
+			servers[sidx].IPv6 = fmt.Sprintf("2001:db8::%x", sidx)
+		}
+	}
+	rnd := rand.New(rand.NewSource(1))
+	dsts := make([]net.IP, ntraces)
+	for tidx := range dsts {
+		s := servers[rnd.Intn(nservers)]
+		switch {
+		case tidx%10 == 0:
+			dsts[tidx] = net.IPv4(192, 0, 2, byte(rnd.Intn(256)))
+		case len(s.IPv6) > 0 && tidx%2 == 0:
+			dsts[tidx] = net.ParseIP(s.IPv6)
+		default:
+			dsts[tidx] = net.ParseIP(s.IPv4)
+		}
+	}
+	return servers, dsts
+}
+
+//resolve looks up every destination with workers goroutines, as ParseServerTrace does
+func resolve(dsts []net.IP, workers int, lookup func(net.IP)) {
+	var wg sync.WaitGroup
+	workerchan := make(chan int, workers)
+	for _, dst := range dsts {
+		wg.Add(1)
+		workerchan <- 1
+		go func(ip net.IP) {
+			lookup(ip)
+			<-workerchan
+			wg.Done()
+		}(dst)
+	}
+	wg.Wait()
+}
+
+func lookupId(t *testing.T, si *ServerIndex, ip string) string {
+	t.Helper()
+	server, err := si.Lookup(context.Background(), net.ParseIP(ip))
+	if err != nil {
+		t.Fatalf("lookup %s: %v", ip, err)
+	}
+	if server == nil {
+		return ""
+	}
+	return server.Id
+}
+
+func checkStats(t *testing.T, si *ServerIndex, hits, misses, loads int64) {
+	t.Helper()
+	if h, m, l := si.Stats(); h != hits || m != misses || l != loads {
+		t.Errorf("stats hits %d misses %d loads %d, want %d %d %d", h, m, l, hits, misses, loads)
+	}
+}
+
+func TestServerIndexLookup(t *testing.T) {
+	store := newSlowStore(t, 0, []spdb.SpeedServer{
+		{Type: "ookla", Id: "1", IPv4: "192.0.2.1", IPv6: "2001:db8::1", Asnv4: "64512", Asnv6: "64513", Enabled: true},
+		//same address, the first server wins
+		{Type: "mlab", Id: "2", IPv4: "192.0.2.1", Enabled: true},
+		//disabled since the last crawl, older traces still go to it
+		{Type: "ookla", Id: "3", IPv4: "192.0.2.3", Enabled: false},
+	})
+	si := NewServerIndex(store, 0)
+	if err := si.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, si, 0, 0, 1)
+	for _, c := range []struct {
+		ip, id  string
+		queries int64
+	}{
+		{"192.0.2.1", "1", 1},
+		{"2001:db8::1", "1", 1},
+		//not indexed, asks the database once
+		{"192.0.2.3", "3", 2},
+		{"192.0.2.3", "3", 2},
+		//no server, the answer is kept too
+		{"198.51.100.1", "", 3},
+		{"198.51.100.1", "", 3},
+		//IPv6 is never asked
+		{"2001:db8::99", "", 3},
+	} {
+		if id := lookupId(t, si, c.ip); id != c.id {
+			t.Errorf("lookup %s = %q, want %q", c.ip, id, c.id)
+		}
+		if q := atomic.LoadInt64(&store.queries); q != c.queries {
+			t.Errorf("after lookup %s %d queries, want %d", c.ip, q, c.queries)
+		}
+	}
+	checkStats(t, si, 4, 3, 1)
+	if server, err := si.Lookup(context.Background(), nil); server != nil || err != nil {
+		t.Errorf("lookup nil = %v, %v", server, err)
+	}
+	checkStats(t, si, 4, 3, 1)
+}
+
+func TestServerIndexRefresh(t *testing.T) {
+	ctx := context.Background()
+	store := newSlowStore(t, 0, []spdb.SpeedServer{{Type: "ookla", Id: "1", IPv4: "192.0.2.1", IPv6: "2001:db8::1", Enabled: true}})
+	keep := NewServerIndex(store, 0)
+	refresh := NewServerIndex(store, time.Hour)
+	for _, si := range []*ServerIndex{keep, refresh} {
+		if err := si.Load(ctx); err != nil {
+			t.Fatal(err)
+		}
+		si.loaded = si.loaded.Add(-2 * time.Hour)
+	}
+	//a crawl adds a server during the run
+	if _, err := store.InsertServers(ctx, []spdb.SpeedServer{{Type: "ookla", Id: "2", IPv4: "192.0.2.2", IPv6: "2001:db8::2", Enabled: true}}); err != nil {
+		t.Fatal(err)
+	}
+	//maxage 0 keeps the servers of the load for the whole run
+	if id := lookupId(t, keep, "2001:db8::2"); id != "" {
+		t.Errorf("index without refresh found %q", id)
+	}
+	checkStats(t, keep, 0, 1, 1)
+	if id := lookupId(t, refresh, "2001:db8::2"); id != "2" {
+		t.Errorf("refreshed index found %q, want 2", id)
+	}
+	checkStats(t, refresh, 1, 0, 2)
+	//a failed reload keeps the old index and is tried again by the next lookup
+	refresh.loaded = refresh.loaded.Add(-2 * time.Hour)
+	atomic.StoreInt32(&store.fail, 1)
+	if id := lookupId(t, refresh, "2001:db8::1"); id != "1" {
+		t.Errorf("index after failed reload found %q, want 1", id)
+	}
+	checkStats(t, refresh, 2, 0, 2)
+	atomic.StoreInt32(&store.fail, 0)
+	if id := lookupId(t, refresh, "2001:db8::2"); id != "2" {
+		t.Errorf("index after reload found %q, want 2", id)
+	}
+	checkStats(t, refresh, 3, 0, 3)
+}
+
+//the synthetic trace set of the benchmarks, with the round trip of a database
+//query of a nearby replica set
+const (
+	benchServers = 5000
+	benchTraces  = 20000
+	benchWorkers = 100
+	benchRtt     = 500 * time.Microsecond
+)
+
+//BenchmarkQueryPerTrace resolves every traceroute with a database query, as
+//ParseServerTrace did before the ServerIndex
+func BenchmarkQueryPerTrace(b *testing.B) {
+	servers, dsts := synthetic(benchServers, benchTraces)
+	store := newSlowStore(b, benchRtt, servers)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resolve(dsts, benchWorkers, func(ip net.IP) { _, _ = store.QueryServersbyIPv4(ctx, ip) })
+	}
+	b.ReportMetric(float64(len(dsts)*b.N)/b.Elapsed().Seconds(), "traces/s")
+}
+
+//BenchmarkServerIndex resolves every traceroute with a new ServerIndex each
+//round, the load is part of the cost of a run
+func BenchmarkServerIndex(b *testing.B) {
+	servers, dsts := synthetic(benchServers, benchTraces)
+	store := newSlowStore(b, benchRtt, servers)
+	ctx := context.Background()
+	var index *ServerIndex
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index = NewServerIndex(store, 0)
+		if err := index.Load(ctx); err != nil {
+			b.Fatal(err)
+		}
+		resolve(dsts, benchWorkers, func(ip net.IP) { _, _ = index.Lookup(ctx, ip) })
+	}
+	b.ReportMetric(float64(len(dsts)*b.N)/b.Elapsed().Seconds(), "traces/s")
+	hits, misses, _ := index.Stats()
+	b.ReportMetric(float64(misses)/float64(hits+misses), "missrate")
+}
@@ -0,0 +1,186 @@
+package warts
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// buffer decodes the fields of a single warts object. the first error is
+// sticky, so callers can read a whole block and check err once.
+type buffer struct {
+	data []byte
+	off  int
+	err  error
+	//per-object address table
+	addrs  []net.IP
+	global []net.IP
+}
+
+func (b *buffer) need(n int) bool {
+	if b.err != nil {
+		return false
+	}
+	if n < 0 || b.off+n > len(b.data) {
+		b.err = ErrTruncated
+		return false
+	}
+	return true
+}
+
+func (b *buffer) u8() uint8 {
+	if !b.need(1) {
+		return 0
+	}
+	v := b.data[b.off]
+	b.off++
+	return v
+}
+
+func (b *buffer) u16() uint16 {
+	if !b.need(2) {
+		return 0
+	}
+	v := binary.BigEndian.Uint16(b.data[b.off:])
+	b.off += 2
+	return v
+}
+
+func (b *buffer) u32() uint32 {
+	if !b.need(4) {
+		return 0
+	}
+	v := binary.BigEndian.Uint32(b.data[b.off:])
+	b.off += 4
+	return v
+}
+
+func (b *buffer) bytes(n int) []byte {
+	if !b.need(n) {
+		return nil
+	}
+	v := b.data[b.off : b.off+n]
+	b.off += n
+	return v
+}
+
+// null terminated string
+func (b *buffer) str() string {
+	if b.err != nil {
+		return ""
+	}
+	for i := b.off; i < len(b.data); i++ {
+		if b.data[i] == 0 {
+			s := string(b.data[b.off:i])
+			b.off = i + 1
+			return s
+		}
+	}
+	b.err = ErrTruncated
+	return ""
+}
+
+func (b *buffer) timeval() time.Time {
+	sec := b.u32()
+	usec := b.u32()
+	return time.Unix(int64(sec), int64(usec)*1000)
+}
+
+// rtt is stored in microseconds
+func (b *buffer) rtt() time.Duration {
+	return time.Duration(b.u32()) * time.Microsecond
+}
+
+// address embedded in an object. a zero length means a reference to an
+// address previously seen in the same object.
+func (b *buffer) addr() net.IP {
+	alen := b.u8()
+	if b.err != nil {
+		return nil
+	}
+	if alen == 0 {
+		id := b.u32()
+		if b.err != nil {
+			return nil
+		}
+		if int(id) >= len(b.addrs) {
+			b.err = ErrBadAddr
+			return nil
+		}
+		return b.addrs[id]
+	}
+	atype := b.u8()
+	raw := b.bytes(int(alen))
+	if b.err != nil {
+		return nil
+	}
+	ip := toIP(atype, raw)
+	b.addrs = append(b.addrs, ip)
+	return ip
+}
+
+// reference into the deprecated global address table
+func (b *buffer) gid() net.IP {
+	id := b.u32()
+	if b.err != nil {
+		return nil
+	}
+	if int(id) >= len(b.global) {
+		b.err = ErrBadAddr
+		return nil
+	}
+	return b.global[id]
+}
+
+// params holds the flag bits of a parameter block and the offset where the
+// block ends
+type params struct {
+	flags []byte
+	end   int
+}
+
+func (b *buffer) params() *params {
+	p := &params{}
+	for {
+		f := b.u8()
+		if b.err != nil {
+			return p
+		}
+		p.flags = append(p.flags, f)
+		if f&0x80 == 0 {
+			break
+		}
+	}
+	//no flags set, so no parameter length follows
+	if p.flags[0] == 0 {
+		p.end = b.off
+		return p
+	}
+	plen := b.u16()
+	p.end = b.off + int(plen)
+	if b.err == nil && p.end > len(b.data) {
+		b.err = ErrTruncated
+	}
+	return p
+}
+
+// flag ids start at 1, seven flags per byte
+func (p *params) isset(id int) bool {
+	idx := (id - 1) / 7
+	if idx >= len(p.flags) {
+		return false
+	}
+	return p.flags[idx]&(1<<uint((id-1)%7)) != 0
+}
+
+// highest flag id that can be present in this block
+func (p *params) max() int {
+	return len(p.flags) * 7
+}
+
+// skip whatever parameters were not decoded
+func (b *buffer) finish(p *params) {
+	if b.err == nil {
+		b.off = p.end
+	}
+}
@@ -0,0 +1,110 @@
+package warts
+
+import "time"
+
+// List is the list a measurement belongs to
+type List struct {
+	//id used inside the file
+	Id      uint32
+	ListId  uint32
+	Name    string
+	Descr   string
+	Monitor string
+}
+
+func (l *List) Type() uint16 { return TypeList }
+
+// Cycle is a measurement cycle of a list
+type Cycle struct {
+	//id used inside the file
+	Id       uint32
+	ListId   uint32
+	CycleId  uint32
+	Start    time.Time
+	Stop     time.Time
+	Hostname string
+	otype    uint16
+}
+
+func (c *Cycle) Type() uint16 { return c.otype }
+
+// CycleStop marks the end of a cycle
+type CycleStop struct {
+	Cycle *Cycle
+	Stop  time.Time
+}
+
+func (c *CycleStop) Type() uint16 { return TypeCycleStop }
+
+func (r *Reader) readList(b *buffer) Record {
+	l := &List{}
+	l.Id = b.u32()
+	l.ListId = b.u32()
+	l.Name = b.str()
+	p := b.params()
+	for id := 1; id <= p.max() && b.err == nil; id++ {
+		if !p.isset(id) {
+			continue
+		}
+		switch id {
+		case 1:
+			l.Descr = b.str()
+		case 2:
+			l.Monitor = b.str()
+		default:
+			id = p.max()
+		}
+	}
+	b.finish(p)
+	if b.err != nil {
+		return nil
+	}
+	r.lists[l.Id] = l
+	return l
+}
+
+func (r *Reader) readCycleStart(b *buffer, otype uint16) Record {
+	c := &Cycle{otype: otype}
+	c.Id = b.u32()
+	c.ListId = b.u32()
+	c.CycleId = b.u32()
+	c.Start = time.Unix(int64(b.u32()), 0)
+	p := b.params()
+	for id := 1; id <= p.max() && b.err == nil; id++ {
+		if !p.isset(id) {
+			continue
+		}
+		switch id {
+		case 1:
+			c.Stop = time.Unix(int64(b.u32()), 0)
+		case 2:
+			c.Hostname = b.str()
+		default:
+			id = p.max()
+		}
+	}
+	b.finish(p)
+	if b.err != nil {
+		return nil
+	}
+	r.cycles[c.Id] = c
+	return c
+}
+
+func (r *Reader) readCycleStop(b *buffer) Record {
+	cs := &CycleStop{}
+	cid := b.u32()
+	cs.Stop = time.Unix(int64(b.u32()), 0)
+	p := b.params()
+	b.finish(p)
+	if b.err != nil {
+		return nil
+	}
+	if c, cexist := r.cycles[cid]; cexist {
+		c.Stop = cs.Stop
+		cs.Cycle = c
+	} else {
+		cs.Cycle = &Cycle{Id: cid, Stop: cs.Stop, otype: TypeCycleStart}
+	}
+	return cs
+}
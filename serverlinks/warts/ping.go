@@ -0,0 +1,165 @@
+package warts
+
+import (
+	"net"
+	"time"
+)
+
+// Ping is a scamper ping measurement
+type Ping struct {
+	List        *List
+	Cycle       *Cycle
+	Src         net.IP
+	Dst         net.IP
+	Start       time.Time
+	StopReason  uint8
+	StopData    uint8
+	ProbeCount  uint16
+	ProbeSize   uint16
+	ProbeWait   uint8
+	ProbeTTL    uint8
+	ReplyCount  uint16
+	PingSent    uint16
+	ProbeMethod uint8
+	SPort       uint16
+	DPort       uint16
+	UserId      uint32
+	Flags       uint8
+	ProbeTos    uint8
+	Replies     []PingReply
+}
+
+func (p *Ping) Type() uint16 { return TypePing }
+
+type PingReply struct {
+	Addr       net.IP
+	Flags      uint8
+	ReplyTTL   uint8
+	ReplySize  uint16
+	ICMPType   uint8
+	ICMPCode   uint8
+	RTT        time.Duration
+	ProbeId    uint16
+	ReplyIPID  uint16
+	ProbeIPID  uint16
+	ReplyProto uint8
+	TCPFlags   uint8
+}
+
+func (r *Reader) readPing(b *buffer) Record {
+	ping := &Ping{}
+	var datalen uint16
+	p := b.params()
+	for id := 1; id <= p.max() && b.err == nil; id++ {
+		if !p.isset(id) {
+			continue
+		}
+		switch id {
+		case 1:
+			ping.List = r.lists[b.u32()]
+		case 2:
+			ping.Cycle = r.cycles[b.u32()]
+		case 3:
+			ping.Src = b.gid()
+		case 4:
+			ping.Dst = b.gid()
+		case 5:
+			ping.Start = b.timeval()
+		case 6:
+			ping.StopReason = b.u8()
+		case 7:
+			ping.StopData = b.u8()
+		case 8:
+			datalen = b.u16()
+		case 9:
+			//probe payload, size given by the previous parameter
+			b.bytes(int(datalen))
+		case 10:
+			ping.ProbeCount = b.u16()
+		case 11:
+			ping.ProbeSize = b.u16()
+		case 12:
+			ping.ProbeWait = b.u8()
+		case 13:
+			ping.ProbeTTL = b.u8()
+		case 14:
+			ping.ReplyCount = b.u16()
+		case 15:
+			ping.PingSent = b.u16()
+		case 16:
+			ping.ProbeMethod = b.u8()
+		case 17:
+			ping.SPort = b.u16()
+		case 18:
+			ping.DPort = b.u16()
+		case 19:
+			ping.UserId = b.u32()
+		case 20:
+			ping.Src = b.addr()
+		case 21:
+			ping.Dst = b.addr()
+		case 22:
+			ping.Flags = b.u8()
+		case 23:
+			ping.ProbeTos = b.u8()
+		default:
+			id = p.max()
+		}
+	}
+	b.finish(p)
+	replycnt := int(b.u16())
+	if b.err != nil {
+		return nil
+	}
+	ping.Replies = make([]PingReply, 0, replycnt)
+	for i := 0; i < replycnt && b.err == nil; i++ {
+		ping.Replies = append(ping.Replies, b.pingReply())
+	}
+	if b.err != nil {
+		return nil
+	}
+	return ping
+}
+
+func (b *buffer) pingReply() PingReply {
+	reply := PingReply{}
+	p := b.params()
+	for id := 1; id <= p.max() && b.err == nil; id++ {
+		if !p.isset(id) {
+			continue
+		}
+		switch id {
+		case 1:
+			reply.Addr = b.gid()
+		case 2:
+			reply.Flags = b.u8()
+		case 3:
+			reply.ReplyTTL = b.u8()
+		case 4:
+			reply.ReplySize = b.u16()
+		case 5:
+			tc := b.u16()
+			reply.ICMPType = uint8(tc >> 8)
+			reply.ICMPCode = uint8(tc & 0xff)
+		case 6:
+			reply.RTT = b.rtt()
+		case 7:
+			reply.ProbeId = b.u16()
+		case 8:
+			reply.ReplyIPID = b.u16()
+		case 9:
+			reply.ProbeIPID = b.u16()
+		case 10:
+			reply.ReplyProto = b.u8()
+		case 11:
+			reply.TCPFlags = b.u8()
+		case 12:
+			reply.Addr = b.addr()
+		default:
+			//record route and timestamp options, skip the rest
+			id = p.max()
+		}
+	}
+	b.finish(p)
+	return reply
+}
@@ -0,0 +1,208 @@
+package warts
+
+import (
+	"net"
+	"time"
+)
+
+// Trace is a scamper traceroute. Only the fields needed by the analysis are
+// decoded; pmtud and lastditch blocks that follow the hops are ignored.
+type Trace struct {
+	List       *List
+	Cycle      *Cycle
+	Src        net.IP
+	Dst        net.IP
+	Rtr        net.IP
+	Start      time.Time
+	StopReason uint8
+	StopData   uint8
+	Flags      uint8
+	Attempts   uint8
+	HopLimit   uint8
+	TraceType  uint8
+	ProbeSize  uint16
+	SPort      uint16
+	DPort      uint16
+	FirstHop   uint8
+	Tos        uint8
+	Wait       uint8
+	Loops      uint8
+	HopCount   uint16
+	GapLimit   uint8
+	GapAction  uint8
+	LoopAction uint8
+	ProbeCount uint16
+	WaitProbe  uint8
+	Confidence uint8
+	UserId     uint32
+	Offset     uint16
+	Hops       []TraceHop
+}
+
+func (t *Trace) Type() uint16 { return TypeTrace }
+
+type TraceHop struct {
+	Addr        net.IP
+	ProbeTTL    uint8
+	ReplyTTL    uint8
+	Flags       uint8
+	ProbeId     uint8
+	RTT         time.Duration
+	ICMPType    uint8
+	ICMPCode    uint8
+	ProbeSize   uint16
+	ReplySize   uint16
+	ReplyIPID   uint16
+	ReplyTos    uint8
+	NHMtu       uint16
+	QuotedIPLen uint16
+	QuotedTTL   uint8
+	TCPFlags    uint8
+	QuotedTos   uint8
+	Tx          time.Time
+}
+
+func (r *Reader) readTrace(b *buffer) Record {
+	t := &Trace{}
+	p := b.params()
+	for id := 1; id <= p.max() && b.err == nil; id++ {
+		if !p.isset(id) {
+			continue
+		}
+		switch id {
+		case 1:
+			t.List = r.lists[b.u32()]
+		case 2:
+			t.Cycle = r.cycles[b.u32()]
+		case 3:
+			t.Src = b.gid()
+		case 4:
+			t.Dst = b.gid()
+		case 5:
+			t.Start = b.timeval()
+		case 6:
+			t.StopReason = b.u8()
+		case 7:
+			t.StopData = b.u8()
+		case 8:
+			t.Flags = b.u8()
+		case 9:
+			t.Attempts = b.u8()
+		case 10:
+			t.HopLimit = b.u8()
+		case 11:
+			t.TraceType = b.u8()
+		case 12:
+			t.ProbeSize = b.u16()
+		case 13:
+			t.SPort = b.u16()
+		case 14:
+			t.DPort = b.u16()
+		case 15:
+			t.FirstHop = b.u8()
+		case 16:
+			t.Tos = b.u8()
+		case 17:
+			t.Wait = b.u8()
+		case 18:
+			t.Loops = b.u8()
+		case 19:
+			t.HopCount = b.u16()
+		case 20:
+			t.GapLimit = b.u8()
+		case 21:
+			t.GapAction = b.u8()
+		case 22:
+			t.LoopAction = b.u8()
+		case 23:
+			t.ProbeCount = b.u16()
+		case 24:
+			t.WaitProbe = b.u8()
+		case 25:
+			t.Confidence = b.u8()
+		case 26:
+			t.Src = b.addr()
+		case 27:
+			t.Dst = b.addr()
+		case 28:
+			t.UserId = b.u32()
+		case 29:
+			t.Offset = b.u16()
+		case 30:
+			t.Rtr = b.addr()
+		default:
+			//newer parameter we do not know the size of
+			id = p.max()
+		}
+	}
+	b.finish(p)
+	hopcnt := int(b.u16())
+	if b.err != nil {
+		return nil
+	}
+	t.Hops = make([]TraceHop, 0, hopcnt)
+	for h := 0; h < hopcnt && b.err == nil; h++ {
+		t.Hops = append(t.Hops, b.traceHop())
+	}
+	if b.err != nil {
+		return nil
+	}
+	return t
+}
+
+func (b *buffer) traceHop() TraceHop {
+	hop := TraceHop{}
+	p := b.params()
+	for id := 1; id <= p.max() && b.err == nil; id++ {
+		if !p.isset(id) {
+			continue
+		}
+		switch id {
+		case 1:
+			hop.Addr = b.gid()
+		case 2:
+			hop.ProbeTTL = b.u8()
+		case 3:
+			hop.ReplyTTL = b.u8()
+		case 4:
+			hop.Flags = b.u8()
+		case 5:
+			hop.ProbeId = b.u8()
+		case 6:
+			hop.RTT = b.rtt()
+		case 7:
+			tc := b.u16()
+			hop.ICMPType = uint8(tc >> 8)
+			hop.ICMPCode = uint8(tc & 0xff)
+		case 8:
+			hop.ProbeSize = b.u16()
+		case 9:
+			hop.ReplySize = b.u16()
+		case 10:
+			hop.ReplyIPID = b.u16()
+		case 11:
+			hop.ReplyTos = b.u8()
+		case 12:
+			hop.NHMtu = b.u16()
+		case 13:
+			hop.QuotedIPLen = b.u16()
+		case 14:
+			hop.QuotedTTL = b.u8()
+		case 15:
+			hop.TCPFlags = b.u8()
+		case 16:
+			hop.QuotedTos = b.u8()
+		case 17:
+			//icmp extensions, skip the whole block
+			b.bytes(int(b.u16()))
+		case 18:
+			hop.Addr = b.addr()
+		case 19:
+			hop.Tx = b.timeval()
+		default:
+			id = p.max()
+		}
+	}
+	b.finish(p)
+	return hop
+}
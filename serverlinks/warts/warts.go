@@ -0,0 +1,180 @@
+// Package warts reads scamper warts files without shelling out to
+// sc_warts2json. Only the record types used by the analysis pipeline are
+// decoded (list, cycle, trace and ping); everything else is skipped.
+package warts
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+const (
+	wartsMagic = 0x1205
+	hdrLen     = 8
+)
+
+// warts object types
+const (
+	TypeList       uint16 = 0x0001
+	TypeCycleStart uint16 = 0x0002
+	TypeCycleDef   uint16 = 0x0003
+	TypeCycleStop  uint16 = 0x0004
+	TypeAddress    uint16 = 0x0005
+	TypeTrace      uint16 = 0x0006
+	TypePing       uint16 = 0x0007
+)
+
+// address types
+const (
+	addrIPv4 = 0x01
+	addrIPv6 = 0x02
+)
+
+var (
+	ErrBadMagic  = errors.New("warts: bad magic number")
+	ErrTruncated = errors.New("warts: truncated record")
+	ErrBadAddr   = errors.New("warts: invalid address reference")
+)
+
+// Record is implemented by every decoded warts object
+type Record interface {
+	Type() uint16
+}
+
+// Reader streams records from a warts file, one object at a time
+type Reader struct {
+	rd     *bufio.Reader
+	closer []io.Closer
+	lists  map[uint32]*List
+	cycles map[uint32]*Cycle
+	//deprecated global address table, ids start at 1
+	addrs []net.IP
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		rd:     bufio.NewReader(r),
+		lists:  make(map[uint32]*List),
+		cycles: make(map[uint32]*Cycle),
+		addrs:  []net.IP{nil},
+	}
+}
+
+// Open opens a warts file. bzip2 and gzip compressed files are detected by
+// their magic bytes and decompressed on the fly.
+func Open(filename string) (*Reader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	head, err := br.Peek(3)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, err
+	}
+	var r *Reader
+	switch {
+	case len(head) >= 3 && head[0] == 'B' && head[1] == 'Z' && head[2] == 'h':
+		r = NewReader(bzip2.NewReader(br))
+	case len(head) >= 2 && head[0] == 0x1f && head[1] == 0x8b:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		r = NewReader(gz)
+		r.closer = append(r.closer, gz)
+	default:
+		r = NewReader(br)
+	}
+	r.closer = append(r.closer, f)
+	return r, nil
+}
+
+func (r *Reader) Close() error {
+	var err error
+	for _, c := range r.closer {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Next returns the next decoded record. It returns io.EOF when the file ends
+// cleanly. Unsupported object types are skipped.
+func (r *Reader) Next() (Record, error) {
+	hdr := make([]byte, hdrLen)
+	for {
+		if _, err := io.ReadFull(r.rd, hdr); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, ErrTruncated
+			}
+			return nil, err
+		}
+		if binary.BigEndian.Uint16(hdr[0:2]) != wartsMagic {
+			return nil, ErrBadMagic
+		}
+		otype := binary.BigEndian.Uint16(hdr[2:4])
+		olen := binary.BigEndian.Uint32(hdr[4:8])
+		data := make([]byte, olen)
+		if _, err := io.ReadFull(r.rd, data); err != nil {
+			return nil, ErrTruncated
+		}
+		b := &buffer{data: data, global: r.addrs}
+		var rec Record
+		switch otype {
+		case TypeList:
+			rec = r.readList(b)
+		case TypeCycleStart, TypeCycleDef:
+			rec = r.readCycleStart(b, otype)
+		case TypeCycleStop:
+			rec = r.readCycleStop(b)
+		case TypeAddress:
+			r.readAddress(b)
+		case TypeTrace:
+			rec = r.readTrace(b)
+		case TypePing:
+			rec = r.readPing(b)
+		}
+		if b.err != nil {
+			return nil, fmt.Errorf("warts: object type %d: %w", otype, b.err)
+		}
+		if rec != nil {
+			return rec, nil
+		}
+	}
+}
+
+func (r *Reader) readAddress(b *buffer) {
+	alen := b.u8()
+	atype := b.u8()
+	raw := b.bytes(int(alen))
+	if b.err == nil {
+		r.addrs = append(r.addrs, toIP(atype, raw))
+	}
+}
+
+func toIP(atype uint8, raw []byte) net.IP {
+	switch atype {
+	case addrIPv4:
+		if len(raw) == net.IPv4len {
+			return net.IPv4(raw[0], raw[1], raw[2], raw[3])
+		}
+	case addrIPv6:
+		if len(raw) == net.IPv6len {
+			ip := make(net.IP, net.IPv6len)
+			copy(ip, raw)
+			return ip
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package warts
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// every fixture is checked in plain, gzip and bzip2 compressed
+var compressions = []string{"", ".gz", ".bz2"}
+
+// readAll decodes every record of a fixture
+func readAll(t *testing.T, name string) []Record {
+	t.Helper()
+	r, err := Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer r.Close()
+	recs := []Record{}
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func checkList(t *testing.T, l *List) {
+	t.Helper()
+	if l == nil {
+		t.Fatal("no list")
+	}
+	if l.Id != 1 || l.ListId != 7 || l.Name != "default" || l.Descr != "speedtest servers" || l.Monitor != "aws-us-east-1" {
+		t.Errorf("list = %+v", *l)
+	}
+}
+
+func checkCycle(t *testing.T, c *Cycle) {
+	t.Helper()
+	if c == nil {
+		t.Fatal("no cycle")
+	}
+	if c.Id != 1 || c.ListId != 1 || c.CycleId != 42 || c.Hostname != "vm1.example.net" || c.Start.Unix() != 1600000000 {
+		t.Errorf("cycle = %+v", *c)
+	}
+}
+
+type wantHop struct {
+	addr string
+	ttl  uint8
+	rtt  time.Duration
+}
+
+func checkHops(t *testing.T, hops []TraceHop, want []wantHop) {
+	t.Helper()
+	if len(hops) != len(want) {
+		t.Fatalf("got %d hops, want %d", len(hops), len(want))
+	}
+	for i, w := range want {
+		h := hops[i]
+		if !h.Addr.Equal(net.ParseIP(w.addr)) || h.ProbeTTL != w.ttl || h.RTT != w.rtt {
+			t.Errorf("hop %d = %s ttl %d rtt %s, want %s ttl %d rtt %s", i, h.Addr, h.ProbeTTL, h.RTT, w.addr, w.ttl, w.rtt)
+		}
+	}
+}
+
+func TestReadList(t *testing.T) {
+	for _, comp := range compressions {
+		t.Run("list.warts"+comp, func(t *testing.T) {
+			recs := readAll(t, "list.warts"+comp)
+			if len(recs) != 3 {
+				t.Fatalf("got %d records, want 3", len(recs))
+			}
+			l, _ := recs[0].(*List)
+			checkList(t, l)
+			c, _ := recs[1].(*Cycle)
+			checkCycle(t, c)
+			if c.Type() != TypeCycleStart {
+				t.Errorf("cycle type = %d", c.Type())
+			}
+			stop, _ := recs[2].(*CycleStop)
+			if stop == nil || stop.Cycle != c || stop.Stop.Unix() != 1600000600 || c.Stop.Unix() != 1600000600 {
+				t.Errorf("cycle stop = %+v", stop)
+			}
+		})
+	}
+}
+
+func TestReadTrace(t *testing.T) {
+	for _, comp := range compressions {
+		t.Run("trace.warts"+comp, func(t *testing.T) {
+			recs := readAll(t, "trace.warts"+comp)
+			traces := []*Trace{}
+			for _, rec := range recs {
+				if tr, istrace := rec.(*Trace); istrace {
+					traces = append(traces, tr)
+				}
+			}
+			if len(traces) != 2 {
+				t.Fatalf("got %d traces, want 2", len(traces))
+			}
+			tr := traces[0]
+			checkList(t, tr.List)
+			checkCycle(t, tr.Cycle)
+			if !tr.Src.Equal(net.ParseIP("10.0.0.1")) || !tr.Dst.Equal(net.ParseIP("192.0.2.10")) {
+				t.Errorf("trace src %s dst %s", tr.Src, tr.Dst)
+			}
+			if !tr.Start.Equal(time.Unix(1600000100, 500000000)) || tr.StopReason != 1 || tr.Attempts != 2 || tr.HopLimit != 30 {
+				t.Errorf("trace = %+v", *tr)
+			}
+			// the last hop refers to the destination address of the trace
+			checkHops(t, tr.Hops, []wantHop{
+				{"10.0.0.254", 1, 1250 * time.Microsecond},
+				{"198.51.100.1", 2, 5500 * time.Microsecond},
+				{"198.51.100.1", 2, 5700 * time.Microsecond},
+				{"192.0.2.10", 3, 12345 * time.Microsecond},
+			})
+			tr6 := traces[1]
+			if !tr6.Src.Equal(net.ParseIP("2001:db8::1")) || !tr6.Dst.Equal(net.ParseIP("2001:db8:ffff::10")) {
+				t.Errorf("trace src %s dst %s", tr6.Src, tr6.Dst)
+			}
+			checkHops(t, tr6.Hops, []wantHop{
+				{"2001:db8:1::1", 1, 800 * time.Microsecond},
+				{"2001:db8:ffff::10", 2, 20 * time.Millisecond},
+			})
+		})
+	}
+}
+
+func TestReadPing(t *testing.T) {
+	for _, comp := range compressions {
+		t.Run("ping.warts"+comp, func(t *testing.T) {
+			recs := readAll(t, "ping.warts"+comp)
+			var ping *Ping
+			for _, rec := range recs {
+				if p, isping := rec.(*Ping); isping {
+					ping = p
+				}
+			}
+			if ping == nil {
+				t.Fatal("no ping")
+			}
+			checkList(t, ping.List)
+			if !ping.Src.Equal(net.ParseIP("10.0.0.1")) || !ping.Dst.Equal(net.ParseIP("203.0.113.5")) {
+				t.Errorf("ping src %s dst %s", ping.Src, ping.Dst)
+			}
+			if ping.ProbeCount != 3 || ping.ProbeSize != 84 || ping.ProbeTTL != 64 || ping.PingSent != 3 {
+				t.Errorf("ping = %+v", *ping)
+			}
+			want := []struct {
+				ttl     uint8
+				rtt     time.Duration
+				probeid uint16
+			}{{57, 10500 * time.Microsecond, 0}, {57, 11 * time.Millisecond, 2}}
+			if len(ping.Replies) != len(want) {
+				t.Fatalf("got %d replies, want %d", len(ping.Replies), len(want))
+			}
+			for i, w := range want {
+				r := ping.Replies[i]
+				if !r.Addr.Equal(ping.Dst) || r.ReplyTTL != w.ttl || r.RTT != w.rtt || r.ProbeId != w.probeid {
+					t.Errorf("reply %d = %+v", i, r)
+				}
+			}
+		})
+	}
+}
+
+// scamper-trace.warts lays out a trace like scamper's warts writer: every
+// trace and hop parameter of an icmp-paris trace, icmp extensions and the
+// end of trace marker after the hops
+func TestReadScamperTrace(t *testing.T) {
+	recs := readAll(t, "scamper-trace.warts")
+	if len(recs) != 4 {
+		t.Fatalf("got %d records, want list, cycle, trace and cycle stop", len(recs))
+	}
+	tr, istrace := recs[2].(*Trace)
+	if !istrace {
+		t.Fatalf("record 2 = %T, want a trace", recs[2])
+	}
+	if tr.List == nil || tr.List.Monitor != "aws-oh-1" || tr.Cycle == nil || tr.Cycle.CycleId != 1 {
+		t.Errorf("trace list %+v cycle %+v", tr.List, tr.Cycle)
+	}
+	if !tr.Src.Equal(net.ParseIP("172.31.0.10")) || !tr.Dst.Equal(net.ParseIP("52.93.239.98")) {
+		t.Errorf("trace src %s dst %s", tr.Src, tr.Dst)
+	}
+	if tr.TraceType != 4 || tr.StopReason != 1 || tr.Attempts != 2 || tr.SPort != 31337 || tr.DPort != 33435 ||
+		tr.HopCount != 4 || tr.ProbeCount != 4 || tr.GapLimit != 5 || !tr.Start.Equal(time.Unix(1600000010, 250000000)) {
+		t.Errorf("trace = %+v", *tr)
+	}
+	want := []TraceHop{
+		{ProbeTTL: 1, ReplyTTL: 255, ProbeId: 1, ICMPType: 11, ReplySize: 56, QuotedTTL: 1, RTT: 512 * time.Microsecond},
+		{ProbeTTL: 2, ReplyTTL: 253, ProbeId: 1, ICMPType: 11, ReplySize: 72, QuotedTTL: 1, RTT: 1203 * time.Microsecond},
+		{ProbeTTL: 2, ReplyTTL: 253, ProbeId: 2, ICMPType: 11, ReplySize: 72, QuotedTTL: 1, RTT: 1187 * time.Microsecond},
+		{ProbeTTL: 4, ReplyTTL: 60, ProbeId: 1, ICMPType: 0, ReplySize: 44, RTT: 11734 * time.Microsecond},
+	}
+	addrs := []string{"100.65.10.1", "52.95.2.97", "52.95.2.97", "52.93.239.98"}
+	if len(tr.Hops) != len(want) {
+		t.Fatalf("got %d hops, want %d", len(tr.Hops), len(want))
+	}
+	for i, w := range want {
+		h := tr.Hops[i]
+		if !h.Addr.Equal(net.ParseIP(addrs[i])) || h.ProbeTTL != w.ProbeTTL || h.ReplyTTL != w.ReplyTTL || h.ProbeId != w.ProbeId ||
+			h.ICMPType != w.ICMPType || h.ReplySize != w.ReplySize || h.QuotedTTL != w.QuotedTTL || h.RTT != w.RTT || h.ProbeSize != 44 || h.Tx.IsZero() {
+			t.Errorf("hop %d = %+v", i, h)
+		}
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "trace.warts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(bytes.NewReader(data[:len(data)-10]))
+	for {
+		_, err = r.Next()
+		if err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("err = %v, want %v", err, ErrTruncated)
+	}
+}
+
+func TestReadBadMagic(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{0x12, 0x06, 0, 1, 0, 0, 0, 0}))
+	if _, err := r.Next(); err != ErrBadMagic {
+		t.Errorf("err = %v, want %v", err, ErrBadMagic)
+	}
+}
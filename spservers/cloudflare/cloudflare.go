@@ -0,0 +1,95 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"serverlinks/iputils"
+	"spservers/common"
+	"spservers/spdb"
+	"strconv"
+)
+
+//the speed test is served from every colo on the same anycast address, a
+//traceroute towards it ends at the colo closest to the vm
+const CloudflareSpeedHost = "speed.cloudflare.com"
+const CloudflareLocations = "https://speed.cloudflare.com/locations"
+const CloudflareASN = "13335"
+
+type Colo struct {
+	Iata    string  `json:"iata"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	Country string  `json:"cca2"`
+	Region  string  `json:"region"`
+	City    string  `json:"city"`
+	IPv4    string  `json:"ipv4"`
+	IPv6    string  `json:"ipv6"`
+}
+
+func LoadColos(ctx context.Context) ([]Colo, error) {
+	colos := []Colo{}
+	if err := common.GetJSON(ctx, CloudflareLocations, &colos); err != nil {
+		return nil, err
+	}
+	return colos, nil
+}
+
+func LoadCloudflare(ctx context.Context, cfg *common.Config) ([]spdb.SpeedServer, error) {
+	filename := "cloudflare_" + strconv.FormatInt(cfg.StartTime.Unix(), 10) + ".json"
+	if len(cfg.CreateFilePrefix) > 0 {
+		filename = filepath.Join(cfg.CreateFilePrefix, filename)
+	}
+	colos, err := LoadColos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(colos) == 0 {
+		return nil, errors.New("cloudflare returned no colos")
+	}
+	iph, err := iputils.NewIPHandler("")
+	if err != nil {
+		return nil, err
+	}
+	res := iph.ResolveAll(CloudflareSpeedHost)
+	if len(res.IPv4) == 0 {
+		return nil, errors.New("resolve " + CloudflareSpeedHost + " failed")
+	}
+	for cidx := range colos {
+		colos[cidx].IPv4, colos[cidx].IPv6 = res.IPv4, res.IPv6
+	}
+	file, _ := json.MarshalIndent(colos, "", " ")
+	_ = ioutil.WriteFile(filename, file, 0644)
+	servers := ConvCloudflaretoDB(colos, cfg)
+	log.Println("Crawled", len(colos), "cloudflare colos on", len(servers), "anycast addresses")
+	return servers, nil
+}
+
+//one server per anycast address with the colos behind it. A server per colo
+//would share the address with all the others, and a traceroute can not tell
+//which colo it reached
+func ConvCloudflaretoDB(colos []Colo, cfg *common.Config) []spdb.SpeedServer {
+	s := []spdb.SpeedServer{}
+	byaddr := make(map[string]*spdb.CloudflareInfo)
+	for _, c := range colos {
+		if len(c.IPv4) == 0 && len(c.IPv6) == 0 {
+			continue
+		}
+		addr := c.IPv4 + "|" + c.IPv6
+		cfinfo, aexist := byaddr[addr]
+		if !aexist {
+			cfinfo = &spdb.CloudflareInfo{Url: "https://" + CloudflareSpeedHost, Colos: []spdb.CloudflareColo{}}
+			byaddr[addr] = cfinfo
+			id := c.IPv4
+			if len(id) == 0 {
+				id = c.IPv6
+			}
+			s = append(s, spdb.SpeedServer{Type: "cloudflare", Id: id, Identifier: CloudflareSpeedHost + " " + id, Host: CloudflareSpeedHost, IPv4: c.IPv4, IPv6: c.IPv6, Asnv4: CloudflareASN, Asnv6: CloudflareASN, Enabled: true, LastUpdated: cfg.StartTime, Additional: cfinfo})
+		}
+		cfinfo.Colos = append(cfinfo.Colos, spdb.CloudflareColo{Iata: c.Iata, City: c.City, Country: c.Country, Region: c.Region, Lat: c.Lat, Lon: c.Lon})
+	}
+	return s
+}
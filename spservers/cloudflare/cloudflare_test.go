@@ -0,0 +1,38 @@
+package cloudflare
+
+import (
+	"spservers/common"
+	"spservers/spdb"
+	"testing"
+	"time"
+)
+
+func TestConvCloudflaretoDB(t *testing.T) {
+	cfg := &common.Config{StartTime: time.Unix(1700000000, 0)}
+	colos := []Colo{
+		{Iata: "IAD", City: "Ashburn", Country: "US", Region: "North America", Lat: 38.94, Lon: -77.46, IPv4: "162.159.140.220", IPv6: "2606:4700::6812:1"},
+		{Iata: "FRA", City: "Frankfurt", Country: "DE", Region: "Europe", Lat: 50.03, Lon: 8.57, IPv4: "162.159.140.220", IPv6: "2606:4700::6812:1"},
+		{Iata: "NRT", City: "Tokyo", Country: "JP", Region: "Asia Pacific", Lat: 35.76, Lon: 140.39, IPv4: "162.159.140.220", IPv6: "2606:4700::6812:1"},
+		//not resolved
+		{Iata: "SIN", City: "Singapore", Country: "SG", Region: "Asia Pacific"},
+	}
+	servers := ConvCloudflaretoDB(colos, cfg)
+	//every colo answers on the anycast address, so there is one server for them
+	if len(servers) != 1 {
+		t.Fatalf("got %d servers, want 1: %+v", len(servers), servers)
+	}
+	s := servers[0]
+	if s.Type != "cloudflare" || s.Id != "162.159.140.220" || s.IPv4 != "162.159.140.220" || s.IPv6 != "2606:4700::6812:1" || s.Asnv4 != CloudflareASN || !s.Enabled {
+		t.Errorf("server = %+v", s)
+	}
+	if !s.Location.IsZero() || len(s.City) > 0 {
+		t.Errorf("anycast server has location %v city %q", s.Location, s.City)
+	}
+	cfinfo, iscf := s.Additional.(*spdb.CloudflareInfo)
+	if !iscf {
+		t.Fatalf("additional is %T", s.Additional)
+	}
+	if len(cfinfo.Colos) != 3 || cfinfo.Colos[0].Iata != "IAD" || cfinfo.Colos[1].Iata != "FRA" || cfinfo.Colos[2].City != "Tokyo" {
+		t.Errorf("colos = %+v", cfinfo.Colos)
+	}
+}
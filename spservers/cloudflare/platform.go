@@ -0,0 +1,33 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"spservers/common"
+	"spservers/platform"
+	"spservers/spdb"
+)
+
+//Platform is the Cloudflare speed test, one server per anycast address
+type Platform struct{}
+
+func init() {
+	platform.Register(Platform{})
+}
+
+func (Platform) Name() string    { return "cloudflare" }
+func (Platform) FileTag() string { return "cloudflare" }
+
+func (Platform) Crawl(ctx context.Context, cfg *common.Config) ([]spdb.SpeedServer, error) {
+	return LoadCloudflare(ctx, cfg)
+}
+
+func (Platform) Convert(cfg *common.Config, data []byte) ([]spdb.SpeedServer, error) {
+	var servers []Colo
+	if err := json.Unmarshal(data, &servers); err != nil {
+		return nil, err
+	}
+	return ConvCloudflaretoDB(servers, cfg), nil
+}
+
+func (Platform) NewAdditional() interface{} { return &spdb.CloudflareInfo{} }
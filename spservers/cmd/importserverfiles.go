@@ -3,18 +3,15 @@ package main
 import (
 	"log"
 	"os"
-	"spservers/comcast"
 	"spservers/common"
-	"spservers/mlab"
-	"spservers/ookla"
+	"spservers/crawl"
 	"spservers/spdb"
 	"strconv"
-	"strings"
 	"time"
 )
 
 func main() {
-	if len(os.Args) == 0 {
+	if len(os.Args) != 4 {
 		log.Fatal("importserverfiles <serverlist.json> <mongoconfig> <ts>")
 	}
 	cfg := &common.Config{}
@@ -22,24 +19,16 @@ func main() {
 	cfg.MongoConfigFile = os.Args[2]
 	ts, _ := strconv.ParseInt(os.Args[3], 10, 64)
 	cfg.StartTime = time.Unix(ts, 0)
-	db := spdb.NewMongoDB(cfg.MongoConfigFile, "speedtest")
-	if db.Client == nil {
-		log.Fatal("Connect mongodb error")
+	ctx, cancel := common.Context(0)
+	defer cancel()
+	db, err := spdb.OpenStore(cfg.MongoConfigFile, "speedtest")
+	if err != nil {
+		log.Fatal("Connect mongodb error ", err)
 	}
 	defer db.Close()
-	var servers []spdb.SpeedServer
-	if strings.Contains(serverlistfile, "ookla") {
-		servers = ookla.ImportOoklaServerfromFile(cfg, serverlistfile)
-		log.Println("loaded ", len(servers), "ookla servers")
+	n, err := crawl.ImportServerFile(ctx, cfg, db, serverlistfile)
+	if err != nil {
+		log.Println("Insert servers failed", err)
 	}
-	if strings.Contains(serverlistfile, "ndt") {
-		servers = mlab.ImportNdtServerfromFile(cfg, serverlistfile)
-		log.Println("loaded ", len(servers), "ookla servers")
-	}
-	if strings.Contains(serverlistfile, "comcast") {
-		servers = comcast.ImportComcastServerfromFile(cfg, serverlistfile)
-		log.Println("loaded ", len(servers), "comcast servers")
-	}
-	db.InsertServers(servers)
-
+	log.Println("Inserted", n, "servers")
 }
@@ -1,12 +1,11 @@
 package comcast
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
-	"os"
 	"path/filepath"
 	"spservers/common"
 	"spservers/spdb"
@@ -40,19 +39,11 @@ var wg sync.WaitGroup
 var allserver []ComcastServer
 var retry int = 10
 
-func ImportComcastServerfromFile(cfg *common.Config, filename string) []spdb.SpeedServer {
-	var allokserver []ComcastServer
-	jsonFile, err := os.Open(filename)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer jsonFile.Close()
-	serverstr, _ := ioutil.ReadAll(jsonFile)
-	json.Unmarshal(serverstr, &allokserver)
-	return ConvComcasttoDB(allokserver, cfg)
-}
+//first query error of the crawl
+var crawlmu sync.Mutex
+var crawlerr error
 
-func LoadComcastServer(cfg *common.Config) []spdb.SpeedServer {
+func LoadComcastServer(ctx context.Context, cfg *common.Config) ([]spdb.SpeedServer, error) {
 	/*cfg := &ComcastConfig{}
 	flag.StringVar(&cfg.CreateFile, "n", "comcast.json", "Crawl and create JSON file")
 	flag.StringVar(&cfg.OpenFile, "o", "", "Read existing json datafile")
@@ -78,21 +69,34 @@ func LoadComcastServer(cfg *common.Config) []spdb.SpeedServer {
 			json.Unmarshal(bvalue, &allserver)
 		} else {
 			retry = cfg.Retry*/
-	testplan := LoadTestPlan()
+	testplan, err := LoadTestPlan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	crawlerr = nil
 	chan_server := make(chan ComcastServer)
-	go ResultCollector(chan_server)
+	collected := make(chan bool)
+	go func() {
+		ResultCollector(chan_server)
+		close(collected)
+	}()
 	for _, location := range testplan.ServerLocation {
 		log.Println("Loading location", location)
 		wg.Add(1)
-		go LoadServers(location, chan_server)
+		go LoadServers(ctx, location, chan_server)
 	}
 	wg.Wait()
 	close(chan_server)
+	<-collected
+	if crawlerr != nil {
+		//an incomplete crawl would disable the servers it missed
+		return nil, crawlerr
+	}
 
 	file, _ := json.MarshalIndent(allserver, "", " ")
 	_ = ioutil.WriteFile(filename, file, 0644)
 	log.Println("Crawled", len(allserver))
-	return ConvComcasttoDB(allserver, cfg)
+	return ConvComcasttoDB(allserver, cfg), nil
 	//	}
 	/*
 		fip, err := os.Create(cfg.PrintList)
@@ -110,35 +114,32 @@ func LoadComcastServer(cfg *common.Config) []spdb.SpeedServer {
 	*/
 }
 
-func LoadTestPlan() *ComcastTestplan {
-	response, err := http.Get(ComcastTestplanUrl)
-	if err != nil {
-		log.Fatal(err)
-	}
-	respData, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Fatal(err)
-	}
+func LoadTestPlan(ctx context.Context) (*ComcastTestplan, error) {
 	testplan := &ComcastTestplan{}
-	json.Unmarshal(respData, testplan)
-	return testplan
+	if err := common.GetJSON(ctx, ComcastTestplanUrl, testplan); err != nil {
+		return nil, err
+	}
+	return testplan, nil
 }
 
-func LoadServers(site string, c_server chan ComcastServer) {
+func LoadServers(ctx context.Context, site string, c_server chan ComcastServer) {
+	defer wg.Done()
 	qstring := fmt.Sprintf(ComcastServerSelect, site)
 	for i := 0; i < retry; i++ {
-		response, err := http.Get(qstring)
-		if err != nil {
-			log.Fatal(err)
-		}
-		respData, err := ioutil.ReadAll(response.Body)
 		cs := []ComcastServer{}
-		json.Unmarshal(respData, &cs)
+		if err := common.GetJSON(ctx, qstring, &cs); err != nil {
+			log.Println("Query comcast servers failed", site, err)
+			crawlmu.Lock()
+			if crawlerr == nil {
+				crawlerr = err
+			}
+			crawlmu.Unlock()
+			return
+		}
 		if len(cs) == 1 {
 			c_server <- cs[0]
 		}
 	}
-	wg.Done()
 }
 
 func ResultCollector(c_server chan ComcastServer) {
@@ -153,10 +154,8 @@ func ResultCollector(c_server chan ComcastServer) {
 
 func ConvComcasttoDB(cs []ComcastServer, cfg *common.Config) []spdb.SpeedServer {
 	s := make([]spdb.SpeedServer, len(cs))
-	//invalid lat long
-	emptypoint := spdb.JSONPoint{Type: "Point", Coord: []float64{999, 999}}
 	for cidx, c := range cs {
-		tmps := spdb.SpeedServer{Type: "comcast", Id: strconv.Itoa(c.Id), Identifier: c.Name, Country: "US", Host: c.Host, City: c.Name, IPv4: c.IPv4, IPv6: c.IPv6, Asnv4: "7922", Enabled: true, LastUpdated: cfg.StartTime, Location: emptypoint}
+		tmps := spdb.SpeedServer{Type: "comcast", Id: strconv.Itoa(c.Id), Identifier: c.Name, Country: "US", Host: c.Host, City: c.Name, IPv4: c.IPv4, IPv6: c.IPv6, Asnv4: "7922", Enabled: true, LastUpdated: cfg.StartTime}
 		s[cidx] = tmps
 	}
 	return s
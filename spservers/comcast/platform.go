@@ -0,0 +1,33 @@
+package comcast
+
+import (
+	"context"
+	"encoding/json"
+	"spservers/common"
+	"spservers/platform"
+	"spservers/spdb"
+)
+
+type Platform struct{}
+
+func init() {
+	platform.Register(Platform{})
+}
+
+func (Platform) Name() string    { return "comcast" }
+func (Platform) FileTag() string { return "comcast" }
+
+func (Platform) Crawl(ctx context.Context, cfg *common.Config) ([]spdb.SpeedServer, error) {
+	return LoadComcastServer(ctx, cfg)
+}
+
+func (Platform) Convert(cfg *common.Config, data []byte) ([]spdb.SpeedServer, error) {
+	var cservers []ComcastServer
+	if err := json.Unmarshal(data, &cservers); err != nil {
+		return nil, err
+	}
+	return ConvComcasttoDB(cservers, cfg), nil
+}
+
+//comcast servers carry no metadata beyond the common fields
+func (Platform) NewAdditional() interface{} { return nil }
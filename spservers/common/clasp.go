@@ -0,0 +1,405 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"spservers/spdb"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+//DefaultProjectDir holds the binaries, configs, results and analysis files of the pipeline
+const DefaultProjectDir = "/scratch/cloudspeedtest"
+
+//EnvPrefix starts the environment variables that override the config file,
+//CLASP_<KEY> for the top level keys and CLASP_<SECTION>_<KEY> for the others,
+//e.g. CLASP_DB or CLASP_BDRMAP_RESULTDIR
+const EnvPrefix = "CLASP_"
+
+//ClaspConfig is the config file shared by the clasp commands. Relative paths
+//are under ProjectDir, except the output directories of select and export
+//which are relative to the working directory as before.
+type ClaspConfig struct {
+	ProjectDir string `yaml:"projectdir" toml:"projectdir"`
+	//path to the mongodb config file, or memory[:snapshot.json]
+	DB string `yaml:"db" toml:"db"`
+	//where to send notifications, see notify.Open
+	Notify string `yaml:"notify" toml:"notify"`
+	//stop the commands cleanly after this long, 0 for no deadline
+	Deadline time.Duration   `yaml:"deadline" toml:"deadline"`
+	Crawl    CrawlSection    `yaml:"crawl" toml:"crawl"`
+	Bdrmap   BdrmapSection   `yaml:"bdrmap" toml:"bdrmap"`
+	Trace    TraceSection    `yaml:"trace" toml:"trace"`
+	Select   SelectSection   `yaml:"select" toml:"select"`
+	Export   ExportSection   `yaml:"export" toml:"export"`
+	VM       VMSection       `yaml:"vm" toml:"vm"`
+	Download DownloadSection `yaml:"download" toml:"download"`
+	//File is the config file read, empty if only the defaults are used
+	File string `yaml:"-" toml:"-"`
+}
+
+type CrawlSection struct {
+	//directory the crawled server lists are written to, none if empty
+	OutputDir string `yaml:"outputdir" toml:"outputdir"`
+	Workers   int    `yaml:"workers" toml:"workers"`
+}
+
+type BdrmapSection struct {
+	ScamperBin    string `yaml:"scamperbin" toml:"scamperbin"`
+	ResultDir     string `yaml:"resultdir" toml:"resultdir"`
+	SiblingDir    string `yaml:"siblingdir" toml:"siblingdir"`
+	Prefix2ASDir  string `yaml:"prefix2asdir" toml:"prefix2asdir"`
+	PeeringDir    string `yaml:"peeringdir" toml:"peeringdir"`
+	DelegationDir string `yaml:"delegationdir" toml:"delegationdir"`
+	ASRelDir      string `yaml:"asreldir" toml:"asreldir"`
+}
+
+type TraceSection struct {
+	ScamperBin   string `yaml:"scamperbin" toml:"scamperbin"`
+	ResultDir    string `yaml:"resultdir" toml:"resultdir"`
+	Prefix2ASv4  string `yaml:"prefix2asv4" toml:"prefix2asv4"`
+	VMWorkers    int    `yaml:"vmworkers" toml:"vmworkers"`
+	TraceWorkers int    `yaml:"traceworkers" toml:"traceworkers"`
+	//reload the servers after this long during a run, 0 for never
+	ServerRefresh time.Duration `yaml:"serverrefresh" toml:"serverrefresh"`
+}
+
+type SelectSection struct {
+	OutputDir       string  `yaml:"outputdir" toml:"outputdir"`
+	Workers         int     `yaml:"workers" toml:"workers"`
+	TargetsPerVM    int     `yaml:"targetspervm" toml:"targetspervm"`
+	MaxVMsPerRegion int     `yaml:"maxvmsperregion" toml:"maxvmsperregion"`
+	MinTraceroutes  int     `yaml:"mintraceroutes" toml:"mintraceroutes"`
+	MaxRTT          float64 `yaml:"maxrtt" toml:"maxrtt"`
+	//farthest server from the region in km, 0 for no limit
+	MaxDistance float64 `yaml:"maxdistance" toml:"maxdistance"`
+	//interface, router or as
+	LinkGroup string `yaml:"linkgroup" toml:"linkgroup"`
+}
+
+type ExportSection struct {
+	OutputDir string `yaml:"outputdir" toml:"outputdir"`
+}
+
+type VMSection struct {
+	//gcp, aws or azure
+	Provider string `yaml:"provider" toml:"provider"`
+	//empty for the default region of the sdk environment
+	Region   string `yaml:"region" toml:"region"`
+	Project  string `yaml:"project" toml:"project"`
+	VMConfig string `yaml:"vmconfig" toml:"vmconfig"`
+	SSHKey   string `yaml:"sshkey" toml:"sshkey"`
+}
+
+type DownloadSection struct {
+	//s3, gcs, azure or local
+	Backend string `yaml:"backend" toml:"backend"`
+	//empty for the bucket of the backend
+	Bucket   string   `yaml:"bucket" toml:"bucket"`
+	Region   string   `yaml:"region" toml:"region"`
+	Endpoint string   `yaml:"endpoint" toml:"endpoint"`
+	DestDir  string   `yaml:"destdir" toml:"destdir"`
+	Types    []string `yaml:"types" toml:"types"`
+	Workers  int      `yaml:"workers" toml:"workers"`
+	//empty for <destdir>/manifest.jsonl
+	Manifest string `yaml:"manifest" toml:"manifest"`
+	Archive  bool   `yaml:"archive" toml:"archive"`
+}
+
+//DefaultClaspConfig returns the settings the commands used before there was a config file
+func DefaultClaspConfig() *ClaspConfig {
+	return &ClaspConfig{
+		ProjectDir: DefaultProjectDir,
+		DB:         "bin/beamermongosp.json",
+		Notify:     "bin/mattermostbot.json",
+		Crawl:      CrawlSection{Workers: 10},
+		Bdrmap: BdrmapSection{
+			ScamperBin:    "bin/scamper/bin/",
+			ResultDir:     "result/bdrmap",
+			SiblingDir:    "analysis/sibling",
+			Prefix2ASDir:  "analysis/prefix2as",
+			PeeringDir:    "analysis/peering",
+			DelegationDir: "analysis/delegation/",
+			ASRelDir:      "analysis/as-rel/",
+		},
+		Trace: TraceSection{
+			ScamperBin:    "bin/scamper/bin/",
+			ResultDir:     "result/trace",
+			VMWorkers:     5,
+			TraceWorkers:  100,
+			ServerRefresh: time.Hour,
+		},
+		Select: SelectSection{
+			OutputDir:       "./",
+			Workers:         10,
+			TargetsPerVM:    20,
+			MaxVMsPerRegion: 9,
+			MinTraceroutes:  10,
+			MaxRTT:          150.0,
+			LinkGroup:       spdb.LinkGroupInterface,
+		},
+		Export:   ExportSection{OutputDir: "./"},
+		VM:       VMSection{Project: "webspeedtest-caida"},
+		Download: DownloadSection{Backend: "s3", Region: "us-west-1", DestDir: "result", Types: []string{"bdrmap", "trace"}, Workers: 16, Archive: true},
+	}
+}
+
+//CrawlConfig returns the settings of the server crawl, starting now
+func (c *ClaspConfig) CrawlConfig() *Config {
+	return &Config{
+		CreateFilePrefix: c.Crawl.OutputDir,
+		NotifyConfig:     c.Notify,
+		MongoConfigFile:  c.DB,
+		StartTime:        time.Now(),
+		Workers:          c.Crawl.Workers,
+		EnableMM:         true,
+		Deadline:         c.Deadline,
+	}
+}
+
+//LoadClaspConfig reads the config file path over the defaults, a .toml file is
+//read as toml and anything else as yaml. With an empty path it reads
+//$CLASP_CONFIG, or bin/clasp.yaml or bin/clasp.toml of DefaultProjectDir if
+//one exists, and otherwise keeps the defaults. The CLASP_ environment variables
+//override the file, then relative paths are resolved.
+func LoadClaspConfig(path string) (*ClaspConfig, error) {
+	c := DefaultClaspConfig()
+	if len(path) == 0 {
+		path = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if len(path) == 0 {
+		for _, name := range []string{"bin/clasp.yaml", "bin/clasp.toml"} {
+			if _, err := os.Stat(filepath.Join(DefaultProjectDir, name)); err == nil {
+				path = filepath.Join(DefaultProjectDir, name)
+				break
+			}
+		}
+	}
+	if len(path) > 0 {
+		if err := c.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+	c.resolvePaths()
+	return c, nil
+}
+
+func (c *ClaspConfig) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		md, err := toml.NewDecoder(f).Decode(c)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for kidx, key := range undecoded {
+				keys[kidx] = key.String()
+			}
+			return fmt.Errorf("%s: unknown keys %s", path, strings.Join(keys, ", "))
+		}
+	} else {
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && err != io.EOF {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	c.File = path
+	return nil
+}
+
+//applyEnv sets the fields that have a CLASP_ environment variable
+func (c *ClaspConfig) applyEnv() error {
+	errs := []error{}
+	visitFields(reflect.ValueOf(c).Elem(), EnvPrefix, func(name string, v reflect.Value) {
+		str, set := os.LookupEnv(name)
+		if !set {
+			return
+		}
+		if err := setField(v, str); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+//EnvNames returns the environment variable of every setting, in file order
+func (c *ClaspConfig) EnvNames() []string {
+	names := []string{}
+	visitFields(reflect.ValueOf(c).Elem(), EnvPrefix, func(name string, v reflect.Value) {
+		names = append(names, name)
+	})
+	return names
+}
+
+//visitFields calls fn with the environment variable name of each setting of the struct v
+func visitFields(v reflect.Value, prefix string, fn func(name string, v reflect.Value)) {
+	t := v.Type()
+	for fidx := 0; fidx < t.NumField(); fidx++ {
+		tag := strings.Split(t.Field(fidx).Tag.Get("yaml"), ",")[0]
+		if tag == "-" || len(tag) == 0 {
+			continue
+		}
+		name := prefix + strings.ToUpper(tag)
+		if fv := v.Field(fidx); fv.Kind() == reflect.Struct {
+			visitFields(fv, name+"_", fn)
+		} else {
+			fn(name, fv)
+		}
+	}
+}
+
+func setField(v reflect.Value, str string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(str)
+	case reflect.Int:
+		n, err := strconv.Atoi(str)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		//comma separated list
+		items := []string{}
+		for _, item := range strings.Split(str, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+//resolvePaths makes the relative paths absolute under ProjectDir
+func (c *ClaspConfig) resolvePaths() {
+	under := func(path *string) {
+		if len(*path) > 0 && !filepath.IsAbs(*path) {
+			*path = filepath.Join(c.ProjectDir, *path)
+		}
+	}
+	if !spdb.IsMemoryStore(c.DB) {
+		under(&c.DB)
+	}
+	//only the notifiers configured by a file have a path
+	kind, arg, found := strings.Cut(c.Notify, ":")
+	switch {
+	case !found && kind != "none" && kind != "stdout":
+		under(&c.Notify)
+	case kind == "mattermost" || kind == "smtp":
+		under(&arg)
+		c.Notify = kind + ":" + arg
+	}
+	for _, path := range []*string{&c.Crawl.OutputDir,
+		&c.Bdrmap.ScamperBin, &c.Bdrmap.ResultDir, &c.Bdrmap.SiblingDir, &c.Bdrmap.Prefix2ASDir, &c.Bdrmap.PeeringDir, &c.Bdrmap.DelegationDir, &c.Bdrmap.ASRelDir,
+		&c.Trace.ScamperBin, &c.Trace.ResultDir, &c.Trace.Prefix2ASv4,
+		&c.VM.VMConfig, &c.VM.SSHKey,
+		&c.Download.DestDir, &c.Download.Manifest} {
+		under(path)
+	}
+}
+
+//Check reports the settings that can not work, all of them at once
+func (c *ClaspConfig) Check() error {
+	errs := []error{}
+	if !spdb.IsMemoryStore(c.DB) {
+		if _, err := os.Stat(c.DB); err != nil {
+			errs = append(errs, fmt.Errorf("db: %w", err))
+		}
+	}
+	if c.Deadline < 0 {
+		errs = append(errs, errors.New("deadline: must not be negative"))
+	}
+	if c.Trace.ServerRefresh < 0 {
+		errs = append(errs, errors.New("trace.serverrefresh: must not be negative"))
+	}
+	for name, n := range map[string]int{"crawl.workers": c.Crawl.Workers, "trace.vmworkers": c.Trace.VMWorkers, "trace.traceworkers": c.Trace.TraceWorkers,
+		"select.workers": c.Select.Workers, "select.targetspervm": c.Select.TargetsPerVM, "select.maxvmsperregion": c.Select.MaxVMsPerRegion,
+		"select.mintraceroutes": c.Select.MinTraceroutes, "download.workers": c.Download.Workers} {
+		if n <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be positive, got %d", name, n))
+		}
+	}
+	if c.Select.MaxRTT < 0 {
+		errs = append(errs, fmt.Errorf("select.maxrtt: must not be negative, got %g", c.Select.MaxRTT))
+	}
+	if c.Select.MaxDistance < 0 {
+		errs = append(errs, fmt.Errorf("select.maxdistance: must not be negative, got %g", c.Select.MaxDistance))
+	}
+	switch c.Select.LinkGroup {
+	case spdb.LinkGroupInterface, spdb.LinkGroupRouter, spdb.LinkGroupAS:
+	default:
+		errs = append(errs, fmt.Errorf("select.linkgroup: unknown link group %q", c.Select.LinkGroup))
+	}
+	switch c.VM.Provider {
+	case "", "gcp", "aws", "azure":
+	default:
+		errs = append(errs, fmt.Errorf("vm.provider: unknown provider %q", c.VM.Provider))
+	}
+	switch c.Download.Backend {
+	case "s3", "gcs", "azure", "local":
+	default:
+		errs = append(errs, fmt.Errorf("download.backend: unknown backend %q", c.Download.Backend))
+	}
+	for _, t := range c.Download.Types {
+		if t != "bdrmap" && t != "trace" {
+			errs = append(errs, fmt.Errorf("download.types: unknown data type %q", t))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+//Write prints the config as yaml, or as toml if format is toml
+func (c *ClaspConfig) Write(w io.Writer, format string) error {
+	switch format {
+	case "toml":
+		return toml.NewEncoder(w).Encode(c)
+	case "yaml", "":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	return fmt.Errorf("unknown config format %q", format)
+}
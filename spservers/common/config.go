@@ -4,9 +4,10 @@ import "time"
 
 type Config struct {
 	CreateFilePrefix string
-	BotConfigFile    string
+	NotifyConfig     string
 	MongoConfigFile  string
 	StartTime        time.Time
 	Workers          int
 	EnableMM         bool
+	Deadline         time.Duration
 }
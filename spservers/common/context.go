@@ -0,0 +1,24 @@
+package common
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+//Context returns the context of a command run. It is canceled on SIGINT or
+//SIGTERM and, if deadline is positive, once deadline elapsed, so a cron run can
+//stop between two files instead of being killed half way.
+func Context(deadline time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if deadline <= 0 {
+		return ctx, stop
+	}
+	tctx, cancel := context.WithTimeout(ctx, deadline)
+	return tctx, func() {
+		cancel()
+		stop()
+	}
+}
@@ -0,0 +1,129 @@
+package crawl
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"spservers/common"
+	"spservers/notify"
+	"spservers/platform"
+	_ "spservers/platform/all"
+	"spservers/runlog"
+	"spservers/serverhistory"
+	"spservers/spdb"
+	"strings"
+)
+
+//Run crawls the servers of every registered platform into the database of cfg, the
+//servers of a platform are only disabled once its crawl succeeded. What changed
+//since the previous crawl is recorded in the server history. The error joins the
+//failures of all platforms.
+func Run(cfg *common.Config) error {
+	ctx, cancel := common.Context(cfg.Deadline)
+	defer cancel()
+	notifyconfig := cfg.NotifyConfig
+	if !cfg.EnableMM {
+		notifyconfig = "none"
+	}
+	notifier, err := notify.Open(notifyconfig, "Speedserver Crawler")
+	if err != nil {
+		log.Println("Open notifier error", err)
+		return err
+	}
+	defer notifier.Close()
+	if len(cfg.CreateFilePrefix) > 0 {
+		if err := os.MkdirAll(cfg.CreateFilePrefix, 0744); err != nil {
+			log.Println("Create output directory error", err)
+			notify.Error(notifier, "create output directory error", notify.F("dir", cfg.CreateFilePrefix), notify.Err(err))
+			return err
+		}
+	}
+	db, err := spdb.OpenStore(cfg.MongoConfigFile, "speedtest")
+	if err != nil {
+		log.Println("Connect mongodb error", err)
+		notify.Error(notifier, "connect mongodb error", notify.F("config", cfg.MongoConfigFile), notify.Err(err))
+		return err
+	}
+	defer db.Close()
+	run := runlog.Start(ctx, db, "spservers")
+	crawled := make(map[string]int)
+	newser := make(map[string]int)
+	allchanges := []*spdb.ServerChange{}
+	//a platform that fails to crawl or store does not stop the others
+	for _, p := range platform.All() {
+		name := p.Name()
+		if ctx.Err() != nil {
+			run.VMStatus(name, runlog.StatusSkipped, ctx.Err().Error())
+			continue
+		}
+		//crawl before disabling, a failed crawl leaves the servers of the
+		//previous run enabled
+		servers, err := p.Crawl(ctx, cfg)
+		if err != nil {
+			log.Println("Crawl", name, "servers failed", err)
+			run.VMDone(name, err)
+			continue
+		}
+		//once started, reset and insert run to the end so a signal can not
+		//leave the platform with its servers disabled
+		dbctx := context.WithoutCancel(ctx)
+		old, err := db.QueryEnabledServersbyType(dbctx, name)
+		if err != nil {
+			log.Println("Query", name, "servers failed", err)
+			run.VMDone(name, err)
+			continue
+		}
+		if err := db.ResetEnable(dbctx, name); err != nil {
+			log.Println("Reset", name, "servers failed", err)
+			run.VMDone(name, err)
+			continue
+		}
+		n, err := db.InsertServers(dbctx, servers)
+		crawled[name], newser[name] = len(servers), n
+		run.VMCount(name, "crawled", len(servers))
+		run.VMCount(name, "new", n)
+		run.VMDone(name, err)
+		if err != nil {
+			log.Println("Insert", name, "servers failed", err)
+			continue
+		}
+		log.Println("Inserted new", name, n)
+		changes := serverhistory.Diff(lastCrawl(old), cfg.StartTime.Unix(), old, servers)
+		if _, err := db.InsertServerChanges(dbctx, changes); err != nil {
+			log.Println("Insert", name, "server changes failed", err)
+		}
+		run.VMCount(name, "changes", len(changes))
+		allchanges = append(allchanges, changes...)
+	}
+	//the geo queries of the selection need the index, a failure only slows them
+	if err := db.EnsureGeoIndex(context.WithoutCancel(ctx)); err != nil {
+		log.Println("Create server location index failed", err)
+	}
+	run.Finish(ctx.Err())
+	counts := []string{}
+	for _, p := range platform.All() {
+		counts = append(counts, fmt.Sprintf("%d (new: %d) %s servers", crawled[p.Name()], newser[p.Name()], p.Name()))
+	}
+	notify.Info(notifier, "I crawled "+strings.Join(counts, ", ")+". ")
+	if len(allchanges) > 0 {
+		notify.Report(notifier, notify.SeverityInfo, "Speed servers changed since the last crawl", serverhistory.Summary(allchanges)...)
+	}
+	if err := run.Err(); err != nil {
+		log.Println("Crawl servers failed", err)
+		notify.Error(notifier, "I got errors when crawling servers", notify.Err(err))
+		return err
+	}
+	return nil
+}
+
+//time of the crawl the servers were last seen in
+func lastCrawl(servers []spdb.SpeedServer) int64 {
+	var last int64
+	for _, s := range servers {
+		if ts := s.LastUpdated.Unix(); ts > last {
+			last = ts
+		}
+	}
+	return last
+}
@@ -0,0 +1,65 @@
+package crawl
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"spservers/common"
+	"spservers/platform"
+	_ "spservers/platform/all"
+	"spservers/spdb"
+	"strings"
+	"time"
+)
+
+//ImportServerFile inserts the servers of a server list saved by a previous
+//crawl, the platform is taken from the file name
+func ImportServerFile(ctx context.Context, cfg *common.Config, db spdb.Store, serverlistfile string) (int, error) {
+	servers, err := platform.ImportFile(cfg, serverlistfile)
+	if err != nil {
+		return 0, err
+	}
+	log.Println("loaded", len(servers), "servers from", filepath.Base(serverlistfile))
+	return db.InsertServers(ctx, servers)
+}
+
+//ImportAssignments inserts the assignments of a server list as written by
+//OutputServerlist, <vm>|<far ip>|<far as>|<type>|<identifier> per line, active
+//from start. Servers that are not in the database are skipped.
+func ImportAssignments(ctx context.Context, db spdb.Store, serverlist string, start time.Time) (int, error) {
+	serfile, err := os.Open(serverlist)
+	if err != nil {
+		return 0, err
+	}
+	defer serfile.Close()
+	servmeas := make([]*spdb.SpeedMeas, 0)
+	scanner := bufio.NewScanner(serfile)
+	for scanner.Scan() {
+		line := strings.Split(strings.TrimSpace(scanner.Text()), "|")
+
+		if len(line) == 5 {
+			method := platform.ServerType(line[3])
+			if spserver, err := db.QueryServerbyIdentifier(ctx, method, line[4]); err == nil {
+				if link, errl := db.QueryLinkbyFar(ctx, line[0], line[1]); errl == nil {
+					ts := spdb.TimePeriod{Start: start}
+					var smeas *spdb.SpeedMeas
+					if len(link) > 0 {
+						smeas = &spdb.SpeedMeas{Mon: line[0], SpeedServer: spserver.SpId, Link: link[0].LinkId, Enabled: true, Assigntype: "auto", Activeperiod: []spdb.TimePeriod{ts}}
+					} else {
+						log.Println("link not found", line[0], line[1])
+						smeas = &spdb.SpeedMeas{Mon: line[0], SpeedServer: spserver.SpId, Enabled: true, Assigntype: "auto", Activeperiod: []spdb.TimePeriod{ts}}
+					}
+					servmeas = append(servmeas, smeas)
+				}
+			} else {
+				log.Println("server not found", line[3], line[4])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return db.InsertManySpeedMeas(ctx, servmeas)
+}
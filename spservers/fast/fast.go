@@ -0,0 +1,202 @@
+package fast
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"serverlinks/iputils"
+	"sort"
+	"spservers/common"
+	"spservers/spdb"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//fast.com serves a script with the api token, the api hands out the Open
+//Connect appliances closest to the client
+var FastUrl = "https://fast.com"
+var FastApi = "https://api.fast.com/netflix/speedtest/v2?https=true&token=%s&urlCount=%d"
+
+//the api returns URLCount targets per call and picks them at random among the
+//close appliances, Rounds calls find most of them
+const URLCount = 5
+const Rounds = 50
+
+var scriptRe = regexp.MustCompile(`src="(/app-[0-9a-f]+\.js)"`)
+var tokenRe = regexp.MustCompile(`token:"([A-Za-z0-9]+)"`)
+
+//newIPHandler resolves the appliances, asn lookups fall back to cymru if the
+//prefix2as files are missing
+var newIPHandler = func() iputils.IPHandler {
+	iph, err := iputils.NewIPHandler()
+	if err != nil {
+		log.Println("Load prefix2as failed", err)
+		iph, _ = iputils.NewIPHandler("")
+	}
+	return iph
+}
+
+type FastLocation struct {
+	City    string `json:"city"`
+	Country string `json:"country"`
+}
+
+type FastTarget struct {
+	Name     string       `json:"name"`
+	Url      string       `json:"url"`
+	Location FastLocation `json:"location"`
+}
+
+type FastClient struct {
+	IP       string       `json:"ip"`
+	ASN      string       `json:"asn"`
+	ISP      string       `json:"isp"`
+	Location FastLocation `json:"location"`
+}
+
+type FastResponse struct {
+	Client  FastClient   `json:"client"`
+	Targets []FastTarget `json:"targets"`
+}
+
+//OCAServer is an appliance as saved by the crawl, with its resolved addresses
+type OCAServer struct {
+	Host      string `json:"host"`
+	Url       string `json:"url"`
+	City      string `json:"city"`
+	Country   string `json:"country"`
+	IPv4      string `json:"ipv4"`
+	Asnv4     string `json:"asnv4"`
+	IPv6      string `json:"ipv6"`
+	Asnv6     string `json:"asnv6"`
+	ClientASN string `json:"clientasn"`
+	ClientISP string `json:"clientisp"`
+}
+
+//LoadToken reads the api token from the fast.com script
+func LoadToken(ctx context.Context) (string, error) {
+	page, err := common.GetBody(ctx, FastUrl)
+	if err != nil {
+		return "", err
+	}
+	script := scriptRe.FindSubmatch(page)
+	if script == nil {
+		return "", errors.New("no app script in " + FastUrl)
+	}
+	js, err := common.GetBody(ctx, FastUrl+string(script[1]))
+	if err != nil {
+		return "", err
+	}
+	token := tokenRe.FindSubmatch(js)
+	if token == nil {
+		return "", errors.New("no api token in " + FastUrl + string(script[1]))
+	}
+	return string(token[1]), nil
+}
+
+func LoadTargets(ctx context.Context, token string) (*FastResponse, error) {
+	resp := &FastResponse{}
+	if err := common.GetJSON(ctx, fmt.Sprintf(FastApi, token, URLCount), resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+//ocaHost is the host of a target url, the signed query string expires
+func ocaHost(target FastTarget) (string, string) {
+	u, err := url.Parse(target.Url)
+	if err != nil || len(u.Host) == 0 {
+		return "", ""
+	}
+	return u.Hostname(), u.Scheme + "://" + u.Host + u.Path
+}
+
+//ocaId drops the address family of the host, ipv4-c001-lax001-ix.1.oca.nflxvideo.net
+//and ipv6-c001-lax001-ix.1.oca.nflxvideo.net are the same appliance
+func ocaId(host string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(host, "ipv4-"), "ipv6-")
+}
+
+func LoadFast(ctx context.Context, cfg *common.Config) ([]spdb.SpeedServer, error) {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 10
+	}
+	filename := "fast_" + strconv.FormatInt(cfg.StartTime.Unix(), 10) + ".json"
+	if len(cfg.CreateFilePrefix) > 0 {
+		filename = filepath.Join(cfg.CreateFilePrefix, filename)
+	}
+	token, err := LoadToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var crawlerr error
+	found := make(map[string]*OCAServer)
+	workerchan := make(chan int, cfg.Workers)
+	for r := 0; r < Rounds && ctx.Err() == nil; r++ {
+		wg.Add(1)
+		workerchan <- 1
+		go func() {
+			defer func() { <-workerchan; wg.Done() }()
+			resp, err := LoadTargets(ctx, token)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Println("Query fast.com targets failed", err)
+				if crawlerr == nil {
+					crawlerr = err
+				}
+				return
+			}
+			for _, target := range resp.Targets {
+				host, targeturl := ocaHost(target)
+				if len(host) == 0 {
+					continue
+				}
+				if _, oexist := found[ocaId(host)]; !oexist {
+					found[ocaId(host)] = &OCAServer{Host: host, Url: targeturl, City: target.Location.City, Country: target.Location.Country, ClientASN: resp.Client.ASN, ClientISP: resp.Client.ISP}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(found) == 0 {
+		//every call failed, or the api changed
+		if crawlerr == nil {
+			crawlerr = ctx.Err()
+		}
+		if crawlerr == nil {
+			crawlerr = errors.New("fast.com returned no targets")
+		}
+		return nil, crawlerr
+	}
+	iph := newIPHandler()
+	allservers := make([]OCAServer, 0, len(found))
+	for _, oca := range found {
+		res := iph.ResolveAll(oca.Host)
+		oca.IPv4, oca.Asnv4, oca.IPv6, oca.Asnv6 = res.IPv4, res.Asnv4, res.IPv6, res.Asnv6
+		allservers = append(allservers, *oca)
+	}
+	sort.Slice(allservers, func(i, j int) bool { return allservers[i].Host < allservers[j].Host })
+	file, _ := json.MarshalIndent(allservers, "", " ")
+	_ = ioutil.WriteFile(filename, file, 0644)
+	log.Println("Crawled", len(allservers), "fast.com appliances")
+	return ConvFasttoDB(allservers, cfg), nil
+}
+
+func ConvFasttoDB(ocas []OCAServer, cfg *common.Config) []spdb.SpeedServer {
+	s := make([]spdb.SpeedServer, len(ocas))
+	for oidx, o := range ocas {
+		fastinfo := spdb.FastInfo{Url: o.Url, ClientASN: o.ClientASN, ClientISP: o.ClientISP}
+		s[oidx] = spdb.SpeedServer{Type: "fast", Id: ocaId(o.Host), Identifier: ocaId(o.Host), City: o.City, Country: o.Country, Host: o.Host, IPv4: o.IPv4, IPv6: o.IPv6, Asnv4: o.Asnv4, Asnv6: o.Asnv6, Enabled: true, LastUpdated: cfg.StartTime, Additional: &fastinfo}
+	}
+	return s
+}
@@ -0,0 +1,148 @@
+package fast
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"serverlinks/iputils"
+	"spservers/common"
+	"spservers/spdb"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testToken = "YXNkZmFzZGxmbnNkYWZoYXNkZmhrYWxm"
+
+//fakeIPHandler resolves the appliances of the fixtures without dns
+type fakeIPHandler struct {
+	iputils.IPHandler
+	hosts map[string]iputils.Resolved
+}
+
+func (f *fakeIPHandler) ResolveAll(host string) *iputils.Resolved {
+	r := f.hosts[host]
+	return &r
+}
+
+//fastServer serves the recorded fast.com page, script and api answers. The api
+//answers alternate, as the real one picks its targets at random
+func fastServer(t *testing.T) *httptest.Server {
+	var calls int64
+	mux := http.NewServeMux()
+	serve := func(w http.ResponseWriter, name string) {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		serve(w, "index.html")
+	})
+	mux.HandleFunc("/app-8d3e1fb2.js", func(w http.ResponseWriter, r *http.Request) { serve(w, "app-8d3e1fb2.js") })
+	mux.HandleFunc("/netflix/speedtest/v2", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != testToken {
+			http.Error(w, "bad token", http.StatusForbidden)
+			return
+		}
+		if atomic.AddInt64(&calls, 1)%2 == 1 {
+			serve(w, "api1.json")
+		} else {
+			serve(w, "api2.json")
+		}
+	})
+	return httptest.NewServer(mux)
+}
+
+func useFastServer(t *testing.T) {
+	srv := fastServer(t)
+	oldurl, oldapi, oldiph := FastUrl, FastApi, newIPHandler
+	FastUrl = srv.URL
+	FastApi = srv.URL + "/netflix/speedtest/v2?https=true&token=%s&urlCount=%d"
+	newIPHandler = func() iputils.IPHandler {
+		return &fakeIPHandler{hosts: map[string]iputils.Resolved{
+			"ipv4-c001-iad001-ix.1.oca.nflxvideo.net": {IPv4: "198.51.100.1", Asnv4: "2906", IPv6: "2001:db8:2906::1", Asnv6: "2906"},
+			"ipv4-c002-iad001-ix.1.oca.nflxvideo.net": {IPv4: "198.51.100.2", Asnv4: "2906"},
+			"ipv4-c010-nyc005-ix.1.oca.nflxvideo.net": {IPv4: "203.0.113.10", Asnv4: "7922"},
+		}}
+	}
+	t.Cleanup(func() {
+		srv.Close()
+		FastUrl, FastApi, newIPHandler = oldurl, oldapi, oldiph
+	})
+}
+
+func TestLoadToken(t *testing.T) {
+	useFastServer(t)
+	token, err := LoadToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != testToken {
+		t.Errorf("token = %q, want %q", token, testToken)
+	}
+}
+
+func TestLoadFast(t *testing.T) {
+	useFastServer(t)
+	start := time.Unix(1700000000, 0)
+	//one worker asks the api in order, the first answer of an appliance wins
+	cfg := &common.Config{CreateFilePrefix: t.TempDir(), StartTime: start, Workers: 1}
+	servers, err := LoadFast(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []spdb.SpeedServer{
+		{Type: "fast", Id: "c001-iad001-ix.1.oca.nflxvideo.net", Identifier: "c001-iad001-ix.1.oca.nflxvideo.net", City: "Ashburn", Country: "US",
+			Host: "ipv4-c001-iad001-ix.1.oca.nflxvideo.net", IPv4: "198.51.100.1", Asnv4: "2906", IPv6: "2001:db8:2906::1", Asnv6: "2906", Enabled: true, LastUpdated: start,
+			Additional: &spdb.FastInfo{Url: "https://ipv4-c001-iad001-ix.1.oca.nflxvideo.net/speedtest", ClientASN: "16509", ClientISP: "Amazon.com"}},
+		{Type: "fast", Id: "c002-iad001-ix.1.oca.nflxvideo.net", Identifier: "c002-iad001-ix.1.oca.nflxvideo.net", City: "Ashburn", Country: "US",
+			Host: "ipv4-c002-iad001-ix.1.oca.nflxvideo.net", IPv4: "198.51.100.2", Asnv4: "2906", Enabled: true, LastUpdated: start,
+			Additional: &spdb.FastInfo{Url: "https://ipv4-c002-iad001-ix.1.oca.nflxvideo.net/speedtest", ClientASN: "16509", ClientISP: "Amazon.com"}},
+		{Type: "fast", Id: "c010-nyc005-ix.1.oca.nflxvideo.net", Identifier: "c010-nyc005-ix.1.oca.nflxvideo.net", City: "New York", Country: "US",
+			Host: "ipv4-c010-nyc005-ix.1.oca.nflxvideo.net", IPv4: "203.0.113.10", Asnv4: "7922", Enabled: true, LastUpdated: start,
+			Additional: &spdb.FastInfo{Url: "https://ipv4-c010-nyc005-ix.1.oca.nflxvideo.net/speedtest", ClientASN: "16509", ClientISP: "Amazon.com"}},
+	}
+	if len(servers) != len(want) {
+		t.Fatalf("got %d servers, want %d: %+v", len(servers), len(want), servers)
+	}
+	for sidx := range want {
+		if _, isfast := servers[sidx].Additional.(*spdb.FastInfo); !isfast {
+			t.Errorf("server %d additional is %T, want *spdb.FastInfo", sidx, servers[sidx].Additional)
+		}
+		if !reflect.DeepEqual(servers[sidx], want[sidx]) {
+			t.Errorf("server %d = %+v\nwant %+v", sidx, servers[sidx], want[sidx])
+		}
+	}
+	//the saved crawl converts to the same servers
+	saved, err := ioutil.ReadFile(filepath.Join(cfg.CreateFilePrefix, "fast_1700000000.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conv, err := Platform{}.Convert(cfg, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conv, servers) {
+		t.Errorf("converted servers = %+v\nwant %+v", conv, servers)
+	}
+}
+
+func TestLoadFastBadToken(t *testing.T) {
+	useFastServer(t)
+	FastApi = FastUrl + "/netflix/speedtest/v2?https=true&token=%s-expired&urlCount=%d"
+	cfg := &common.Config{CreateFilePrefix: t.TempDir(), StartTime: time.Unix(1700000000, 0), Workers: 4}
+	if servers, err := LoadFast(context.Background(), cfg); err == nil || len(servers) > 0 {
+		t.Errorf("LoadFast with a rejected token = %d servers, %v", len(servers), err)
+	}
+}
@@ -0,0 +1,33 @@
+package fast
+
+import (
+	"context"
+	"encoding/json"
+	"spservers/common"
+	"spservers/platform"
+	"spservers/spdb"
+)
+
+//Platform is Netflix fast.com, its servers are Open Connect appliances
+type Platform struct{}
+
+func init() {
+	platform.Register(Platform{})
+}
+
+func (Platform) Name() string    { return "fast" }
+func (Platform) FileTag() string { return "fast" }
+
+func (Platform) Crawl(ctx context.Context, cfg *common.Config) ([]spdb.SpeedServer, error) {
+	return LoadFast(ctx, cfg)
+}
+
+func (Platform) Convert(cfg *common.Config, data []byte) ([]spdb.SpeedServer, error) {
+	var ocas []OCAServer
+	if err := json.Unmarshal(data, &ocas); err != nil {
+		return nil, err
+	}
+	return ConvFasttoDB(ocas, cfg), nil
+}
+
+func (Platform) NewAdditional() interface{} { return &spdb.FastInfo{} }
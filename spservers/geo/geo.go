@@ -0,0 +1,38 @@
+package geo
+
+import "math"
+
+//mean radius of the earth, the one mongo uses for $nearSphere
+const EarthRadiusKm = 6378.1
+
+//Valid tells lon and lat are in range, crawlers that do not know where a server
+//is leave its location empty
+func Valid(lon, lat float64) bool {
+	return lon >= -180 && lon <= 180 && lat >= -90 && lat <= 90
+}
+
+//DistanceKm is the great circle distance between two points
+func DistanceKm(lon1, lat1, lon2, lat2 float64) float64 {
+	rad := math.Pi / 180
+	dlat := (lat2 - lat1) * rad
+	dlon := (lon2 - lon1) * rad
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * EarthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+//InPolygon tells the point is inside ring, a closed list of [lon, lat] points.
+//Edges are straight in lon/lat, close enough to mongo's geodesic edges for
+//polygons the size of a country
+func InPolygon(lon, lat float64, ring [][]float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		if len(ring[i]) < 2 || len(ring[j]) < 2 {
+			continue
+		}
+		xi, yi, xj, yj := ring[i][0], ring[i][1], ring[j][0], ring[j][1]
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
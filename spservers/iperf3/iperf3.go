@@ -0,0 +1,118 @@
+package iperf3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"serverlinks/iputils"
+	"spservers/common"
+	"spservers/spdb"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//community maintained list of public iperf3 servers, see https://iperf3serverlist.net
+const Iperf3ServerList = "https://export.iperf3serverlist.net/listed_iperf3_servers.json"
+
+type Iperf3Server struct {
+	Host      string `json:"IP/HOST"`
+	Port      string `json:"PORT"`
+	Speed     string `json:"GB/S"`
+	Country   string `json:"COUNTRY"`
+	Site      string `json:"SITE"`
+	Continent string `json:"CONTINENT"`
+	Provider  string `json:"PROVIDER"`
+	IPv4      string `json:"ipv4"`
+	Asnv4     string `json:"asnv4"`
+	IPv6      string `json:"ipv6"`
+	Asnv6     string `json:"asnv6"`
+}
+
+//hostname strips the options some entries carry, e.g. "iperf.example.net -p 5201"
+func hostname(entry string) string {
+	if fields := strings.Fields(entry); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+func LoadIperf3(ctx context.Context, cfg *common.Config) ([]spdb.SpeedServer, error) {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 10
+	}
+	filename := "iperf3_" + strconv.FormatInt(cfg.StartTime.Unix(), 10) + ".json"
+	if len(cfg.CreateFilePrefix) > 0 {
+		filename = filepath.Join(cfg.CreateFilePrefix, filename)
+	}
+	listed := []Iperf3Server{}
+	if err := common.GetJSON(ctx, Iperf3ServerList, &listed); err != nil {
+		return nil, err
+	}
+	if len(listed) == 0 {
+		return nil, errors.New("iperf3 server list is empty")
+	}
+	iph, err := iputils.NewIPHandler()
+	if err != nil {
+		//asn lookups fall back to cymru
+		log.Println("Load prefix2as failed", err)
+		iph, _ = iputils.NewIPHandler("")
+	}
+	//the list has one entry per port range, keep one server per host
+	seen := make(map[string]bool)
+	allservers := make([]Iperf3Server, 0, len(listed))
+	for _, s := range listed {
+		s.Host = hostname(s.Host)
+		if len(s.Host) > 0 && !seen[s.Host] {
+			seen[s.Host] = true
+			allservers = append(allservers, s)
+		}
+	}
+	var wg sync.WaitGroup
+	workerchan := make(chan int, cfg.Workers)
+	for sidx := range allservers {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		workerchan <- 1
+		go func(s *Iperf3Server) {
+			defer func() { <-workerchan; wg.Done() }()
+			if ip := net.ParseIP(s.Host); ip != nil {
+				if ip.To4() != nil {
+					s.IPv4, s.Asnv4 = ip.String(), iph.IPv4toASN(ip)
+				} else {
+					s.IPv6, s.Asnv6 = ip.String(), iph.IPv6toASN(ip)
+				}
+				return
+			}
+			res := iph.ResolveAll(s.Host)
+			s.IPv4, s.Asnv4, s.IPv6, s.Asnv6 = res.IPv4, res.Asnv4, res.IPv6, res.Asnv6
+		}(&allservers[sidx])
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	file, _ := json.MarshalIndent(allservers, "", " ")
+	_ = ioutil.WriteFile(filename, file, 0644)
+	log.Println("Crawled", len(allservers), "iperf3 servers")
+	return ConvIperf3toDB(allservers, cfg), nil
+}
+
+func ConvIperf3toDB(is []Iperf3Server, cfg *common.Config) []spdb.SpeedServer {
+	s := make([]spdb.SpeedServer, 0, len(is))
+	for _, i := range is {
+		//a host that does not resolve can not be traced
+		if len(i.IPv4) == 0 && len(i.IPv6) == 0 {
+			continue
+		}
+		iperfinfo := spdb.Iperf3Info{Port: i.Port, Speed: i.Speed, Provider: i.Provider, Continent: i.Continent}
+		s = append(s, spdb.SpeedServer{Type: "iperf3", Id: i.Host, Identifier: i.Host + " - " + i.Provider, City: i.Site, Country: i.Country, Host: i.Host, IPv4: i.IPv4, IPv6: i.IPv6, Asnv4: i.Asnv4, Asnv6: i.Asnv6, Enabled: true, LastUpdated: cfg.StartTime, Additional: &iperfinfo})
+	}
+	return s
+}
@@ -0,0 +1,33 @@
+package iperf3
+
+import (
+	"context"
+	"encoding/json"
+	"spservers/common"
+	"spservers/platform"
+	"spservers/spdb"
+)
+
+//Platform is the public iperf3 server list
+type Platform struct{}
+
+func init() {
+	platform.Register(Platform{})
+}
+
+func (Platform) Name() string    { return "iperf3" }
+func (Platform) FileTag() string { return "iperf3" }
+
+func (Platform) Crawl(ctx context.Context, cfg *common.Config) ([]spdb.SpeedServer, error) {
+	return LoadIperf3(ctx, cfg)
+}
+
+func (Platform) Convert(cfg *common.Config, data []byte) ([]spdb.SpeedServer, error) {
+	var servers []Iperf3Server
+	if err := json.Unmarshal(data, &servers); err != nil {
+		return nil, err
+	}
+	return ConvIperf3toDB(servers, cfg), nil
+}
+
+func (Platform) NewAdditional() interface{} { return &spdb.Iperf3Info{} }
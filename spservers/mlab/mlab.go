@@ -1,13 +1,12 @@
 package mlab
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
-	"net/http"
-	"os"
 	"path/filepath"
 	"serverlinks/iputils"
 	"spservers/common"
@@ -51,28 +50,21 @@ type ConfigNDT struct {
 
 func convmlabtomongo(cfg *common.Config, ndt []NDTServer) []spdb.SpeedServer {
 	spslice := make([]spdb.SpeedServer, len(ndt))
-	iph := iputils.NewIPHandler()
+	iph, err := iputils.NewIPHandler()
+	if err != nil {
+		//asn lookups fall back to cymru
+		log.Println("Load prefix2as failed", err)
+		iph, _ = iputils.NewIPHandler("")
+	}
 	for nidx, nserver := range ndt {
 		loc := spdb.JSONPoint{Type: "Point", Coord: []float64{nserver.Lon, nserver.Lat}}
 		mlabinfo := spdb.MlabInfo{Url: nserver.Url, Version: nserver.Version}
-		spslice[nidx] = spdb.SpeedServer{Type: "mlab", Id: nserver.Site, Identifier: nserver.Host, Location: loc, Country: nserver.Country, City: nserver.City, Host: nserver.Host, IPv4: nserver.IPv4, IPv6: nserver.IPv6, Asnv4: iph.IPv4toASN(net.ParseIP(nserver.IPv4)), Enabled: true, LastUpdated: cfg.StartTime, Additional: mlabinfo}
+		spslice[nidx] = spdb.SpeedServer{Type: "mlab", Id: nserver.Site, Identifier: nserver.Host, Location: loc, Country: nserver.Country, City: nserver.City, Host: nserver.Host, IPv4: nserver.IPv4, IPv6: nserver.IPv6, Asnv4: iph.IPv4toASN(net.ParseIP(nserver.IPv4)), Asnv6: iph.IPv6toASN(net.ParseIP(nserver.IPv6)), Enabled: true, LastUpdated: cfg.StartTime, Additional: &mlabinfo}
 	}
 	return spslice
 }
 
-func ImportNdtServerfromFile(cfg *common.Config, filename string) []spdb.SpeedServer {
-	var allokserver []NDTServer
-	jsonFile, err := os.Open(filename)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer jsonFile.Close()
-	serverstr, _ := ioutil.ReadAll(jsonFile)
-	json.Unmarshal(serverstr, &allokserver)
-	return convmlabtomongo(cfg, allokserver)
-}
-
-func LoadMlab(cfg *common.Config) []spdb.SpeedServer {
+func LoadMlab(ctx context.Context, cfg *common.Config) ([]spdb.SpeedServer, error) {
 	var allservers []NDTServer
 	/*	cfg := &ConfigNDT{}
 		flag.StringVar(&cfg.CreateNDTFile, "n", "", "Crawl and create NDT server json file")
@@ -88,11 +80,23 @@ func LoadMlab(cfg *common.Config) []spdb.SpeedServer {
 		filename = filepath.Join(cfg.CreateFilePrefix, filename)
 	}
 	//if len(cfg.CreateNDTFile) > 0 {
-	allservers = MergeNDTservers(LoadSiteMeta(), LoadNDTServers("ndt"), LoadNDTServers("ndt_ssl"), LoadNDTServers("ndt7"))
+	sitemeta, err := LoadSiteMeta(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ndts := [][]NDTServer{}
+	for _, version := range []string{"ndt", "ndt_ssl", "ndt7"} {
+		servers, err := LoadNDTServers(ctx, version)
+		if err != nil {
+			return nil, err
+		}
+		ndts = append(ndts, servers)
+	}
+	allservers = MergeNDTservers(sitemeta, ndts...)
 	file, _ := json.MarshalIndent(allservers, "", " ")
 	_ = ioutil.WriteFile(filename, file, 0644)
 	log.Println("loaded", len(allservers), "servers")
-	return convmlabtomongo(cfg, allservers)
+	return convmlabtomongo(cfg, allservers), nil
 	/*} else {
 		jsonFile, err := os.Open(cfg.OpenNDTFile)
 		if err != nil {
@@ -113,34 +117,22 @@ func LoadMlab(cfg *common.Config) []spdb.SpeedServer {
 	*/
 }
 
-func LoadSiteMeta() []NDTMeta {
+func LoadSiteMeta(ctx context.Context) ([]NDTMeta, error) {
 	log.Println("LoadSiteMeta")
-	resp, err := http.Get(MLABSITES)
-	if err != nil {
-		log.Fatal(err)
-	}
-	respData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal(err)
-	}
 	sitemeta := []NDTMeta{}
-	json.Unmarshal(respData, &sitemeta)
-	return sitemeta
+	if err := common.GetJSON(ctx, MLABSITES, &sitemeta); err != nil {
+		return nil, err
+	}
+	return sitemeta, nil
 }
 
-func LoadNDTServers(version string) []NDTServer {
+func LoadNDTServers(ctx context.Context, version string) ([]NDTServer, error) {
 	log.Println("LoadNDTServers", version)
 	qurl := fmt.Sprintf(NDTUrlTemplate, version)
-	resp, err := http.Get(qurl)
-	if err != nil {
-		log.Fatal(err)
-	}
-	respData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal(err)
-	}
 	servers := []NDTServer{}
-	json.Unmarshal(respData, &servers)
+	if err := common.GetJSON(ctx, qurl, &servers); err != nil {
+		return nil, err
+	}
 	for sidx, server := range servers {
 		servers[sidx].Version = []string{version}
 		for _, ip := range server.IPs {
@@ -151,7 +143,7 @@ func LoadNDTServers(version string) []NDTServer {
 			}
 		}
 	}
-	return servers
+	return servers, nil
 }
 
 func MergeNDTservers(metadata []NDTMeta, ndts ...[]NDTServer) []NDTServer {
@@ -0,0 +1,33 @@
+package mlab
+
+import (
+	"context"
+	"encoding/json"
+	"spservers/common"
+	"spservers/platform"
+	"spservers/spdb"
+)
+
+//Platform is M-Lab, its files are named after its ndt servers
+type Platform struct{}
+
+func init() {
+	platform.Register(Platform{})
+}
+
+func (Platform) Name() string    { return "mlab" }
+func (Platform) FileTag() string { return "ndt" }
+
+func (Platform) Crawl(ctx context.Context, cfg *common.Config) ([]spdb.SpeedServer, error) {
+	return LoadMlab(ctx, cfg)
+}
+
+func (Platform) Convert(cfg *common.Config, data []byte) ([]spdb.SpeedServer, error) {
+	var nservers []NDTServer
+	if err := json.Unmarshal(data, &nservers); err != nil {
+		return nil, err
+	}
+	return convmlabtomongo(cfg, nservers), nil
+}
+
+func (Platform) NewAdditional() interface{} { return &spdb.MlabInfo{} }
@@ -0,0 +1,177 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mmbot"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Noop drops all messages
+type Noop struct{}
+
+func (Noop) Notify(msg *Message) error { return nil }
+func (Noop) Close() error              { return nil }
+
+//Stdout prints one line per message, with the fields as key=value
+type Stdout struct {
+	Username string
+	mu       sync.Mutex
+	w        io.Writer
+}
+
+func NewStdout(username string) *Stdout {
+	return &Stdout{Username: username, w: os.Stdout}
+}
+
+func (s *Stdout) Notify(msg *Message) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s [%s] %s: %s", msg.Time.Format(time.RFC3339), msg.Severity, s.Username, msg.Title)
+	for _, f := range msg.Fields {
+		fmt.Fprintf(&b, " %s=%q", f.Key, f.Value)
+	}
+	b.WriteString("\n")
+	if len(msg.Text) > 0 {
+		b.WriteString(msg.Text + "\n")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := io.WriteString(s.w, b.String())
+	return err
+}
+
+func (s *Stdout) Close() error { return nil }
+
+//Mattermost posts through the mattermost bot, errors page the channel
+type Mattermost struct {
+	bot *mmbot.MMBot
+}
+
+func NewMattermost(botconfig string, username string) (*Mattermost, error) {
+	if _, err := os.Stat(botconfig); err != nil {
+		return nil, fmt.Errorf("mattermost bot config: %w", err)
+	}
+	bot := mmbot.NewMMBot(botconfig)
+	if len(username) > 0 {
+		bot.Username = username
+	}
+	return &Mattermost{bot: bot}, nil
+}
+
+func (m *Mattermost) Notify(msg *Message) error {
+	lines := msg.Lines()
+	switch msg.Severity {
+	case SeverityError:
+		m.bot.SendPanic(lines...)
+	case SeverityWarning:
+		m.bot.SendInfo(append([]string{"WARNING"}, lines...)...)
+	default:
+		m.bot.SendInfo(lines...)
+	}
+	return nil
+}
+
+func (m *Mattermost) Close() error { return nil }
+
+//Slack posts to a slack compatible incoming webhook, mattermost accepts the same payload
+type Slack struct {
+	URL      string
+	Username string
+	client   *http.Client
+}
+
+func NewSlack(url string, username string) (*Slack, error) {
+	if len(url) == 0 {
+		return nil, errors.New("slack notifier needs a webhook url")
+	}
+	return &Slack{URL: url, Username: username, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+func (s *Slack) Notify(msg *Message) error {
+	text := strings.Join(msg.Lines(), "\n")
+	switch msg.Severity {
+	case SeverityError:
+		text = ":rotating_light: " + text
+	case SeverityWarning:
+		text = ":warning: " + text
+	}
+	payload, err := json.Marshal(map[string]string{"username": s.Username, "text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("slack webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *Slack) Close() error { return nil }
+
+//SMTPConfig is the json config of the email notifier, Username and Password are
+//optional when the server does not ask for authentication
+type SMTPConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+//SMTP mails every message to the configured recipients
+type SMTP struct {
+	Config   SMTPConfig
+	Username string
+}
+
+func NewSMTP(configfile string, username string) (*SMTP, error) {
+	cfile, err := os.Open(configfile)
+	if err != nil {
+		return nil, err
+	}
+	defer cfile.Close()
+	s := &SMTP{Username: username}
+	if err := json.NewDecoder(cfile).Decode(&s.Config); err != nil {
+		return nil, fmt.Errorf("smtp config %s: %w", configfile, err)
+	}
+	if len(s.Config.Host) == 0 || len(s.Config.From) == 0 || len(s.Config.To) == 0 {
+		return nil, fmt.Errorf("smtp config %s needs host, from and to", configfile)
+	}
+	if s.Config.Port == 0 {
+		s.Config.Port = 25
+	}
+	return s, nil
+}
+
+func (s *SMTP) Notify(msg *Message) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.Config.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.Config.To, ", "))
+	fmt.Fprintf(&b, "Subject: [%s] %s: %s\r\n", msg.Severity, s.Username, msg.Title)
+	fmt.Fprintf(&b, "Date: %s\r\n", msg.Time.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, line := range msg.Lines() {
+		b.WriteString(line + "\r\n")
+	}
+	var auth smtp.Auth
+	if len(s.Config.Username) > 0 {
+		auth = smtp.PlainAuth("", s.Config.Username, s.Config.Password, s.Config.Host)
+	}
+	addr := fmt.Sprintf("%s:%d", s.Config.Host, s.Config.Port)
+	return smtp.SendMail(addr, auth, s.Config.From, s.Config.To, []byte(b.String()))
+}
+
+func (s *SMTP) Close() error { return nil }
@@ -0,0 +1,135 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//a bad night should page once per kind of failure, not once per file
+const (
+	DefaultBurst  = 3
+	DefaultWindow = time.Hour
+	//distinct values of a field listed in a digest
+	maxDigestValues = 20
+)
+
+//Limiter passes on at most Burst messages of the same severity and title per
+//Window. The others are held back and sent as one digest when the window is
+//over, checked on the next message, or on Close.
+type Limiter struct {
+	Next   Notifier
+	Burst  int
+	Window time.Duration
+	mu     sync.Mutex
+	groups map[string]*group
+}
+
+type group struct {
+	start time.Time
+	sent  int
+	held  []*Message
+}
+
+func NewLimiter(next Notifier, burst int, window time.Duration) *Limiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &Limiter{Next: next, Burst: burst, Window: window, groups: make(map[string]*group)}
+}
+
+func (l *Limiter) Notify(msg *Message) error {
+	key := msg.Severity.String() + "|" + msg.Title
+	now := time.Now()
+	l.mu.Lock()
+	var digests []*Message
+	for gkey, g := range l.groups {
+		if now.Sub(g.start) >= l.Window {
+			if len(g.held) > 0 {
+				digests = append(digests, Digest(g.held))
+			}
+			delete(l.groups, gkey)
+		}
+	}
+	g, gexist := l.groups[key]
+	if !gexist {
+		g = &group{start: now}
+		l.groups[key] = g
+	}
+	pass := g.sent < l.Burst
+	if pass {
+		g.sent++
+	} else {
+		g.held = append(g.held, msg)
+	}
+	l.mu.Unlock()
+
+	errs := []error{}
+	for _, d := range digests {
+		errs = append(errs, l.Next.Notify(d))
+	}
+	if pass {
+		errs = append(errs, l.Next.Notify(msg))
+	}
+	return errors.Join(errs...)
+}
+
+//Close sends the digests of all held back messages and closes the next notifier
+func (l *Limiter) Close() error {
+	l.mu.Lock()
+	keys := make([]string, 0, len(l.groups))
+	for key := range l.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	digests := []*Message{}
+	for _, key := range keys {
+		if held := l.groups[key].held; len(held) > 0 {
+			digests = append(digests, Digest(held))
+		}
+	}
+	l.groups = make(map[string]*group)
+	l.mu.Unlock()
+
+	errs := []error{}
+	for _, d := range digests {
+		errs = append(errs, l.Next.Notify(d))
+	}
+	errs = append(errs, l.Next.Close())
+	return errors.Join(errs...)
+}
+
+//Digest summarizes messages of the same severity and title into one, listing
+//the distinct values of each field
+func Digest(msgs []*Message) *Message {
+	first := msgs[0]
+	d := &Message{Severity: first.Severity, Title: fmt.Sprintf("%s (%d more held back)", first.Title, len(msgs)), Time: msgs[len(msgs)-1].Time}
+	keys := []string{}
+	values := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, m := range msgs {
+		for _, f := range m.Fields {
+			if _, kexist := values[f.Key]; !kexist {
+				keys = append(keys, f.Key)
+				values[f.Key] = []string{}
+			}
+			if seen[f.Key+"|"+f.Value] {
+				continue
+			}
+			seen[f.Key+"|"+f.Value] = true
+			values[f.Key] = append(values[f.Key], f.Value)
+		}
+	}
+	for _, key := range keys {
+		vals := values[key]
+		value := strings.Join(vals, ", ")
+		if len(vals) > maxDigestValues {
+			value = fmt.Sprintf("%s and %d more", strings.Join(vals[:maxDigestValues], ", "), len(vals)-maxDigestValues)
+		}
+		d.Fields = append(d.Fields, Field{Key: key, Value: value})
+	}
+	return d
+}
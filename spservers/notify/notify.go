@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Severity of a message, backends use it to pick how loud to be
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return "severity(" + strconv.Itoa(int(s)) + ")"
+}
+
+//Field is a structured detail of a message, e.g. the vm or the file it is about
+type Field struct {
+	Key   string
+	Value string
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: fmt.Sprint(value)}
+}
+
+func VM(vmname string) Field {
+	return Field{Key: "vm", Value: vmname}
+}
+
+//File is the base name of path, the directories are the same for all files of a run
+func File(path string) Field {
+	return Field{Key: "file", Value: filepath.Base(path)}
+}
+
+func Count(name string, n int) Field {
+	return Field{Key: name, Value: strconv.Itoa(n)}
+}
+
+func Err(err error) Field {
+	if err == nil {
+		return Field{Key: "error", Value: "<nil>"}
+	}
+	return Field{Key: "error", Value: err.Error()}
+}
+
+//Message is one notification. Title is a short summary, messages with the same
+//severity and title are rate limited together, Text is an optional longer body
+type Message struct {
+	Severity Severity
+	Title    string
+	Text     string
+	Fields   []Field
+	Time     time.Time
+}
+
+//Lines renders the message as title, text and one "key: value" line per field
+func (m *Message) Lines() []string {
+	lines := []string{m.Title}
+	if len(m.Text) > 0 {
+		lines = append(lines, m.Text)
+	}
+	for _, f := range m.Fields {
+		lines = append(lines, f.Key+": "+f.Value)
+	}
+	return lines
+}
+
+//Notifier sends messages to people. Close flushes what is held back, call it
+//before the command exits
+type Notifier interface {
+	Notify(msg *Message) error
+	Close() error
+}
+
+func send(n Notifier, sev Severity, title string, fields []Field) {
+	if n == nil {
+		return
+	}
+	msg := &Message{Severity: sev, Title: title, Fields: fields, Time: time.Now()}
+	if err := n.Notify(msg); err != nil {
+		log.Println("Notify failed", title, err)
+	}
+}
+
+//Info, Warn and Error send a message of their severity, a failure to send is
+//logged and never stops the command
+func Info(n Notifier, title string, fields ...Field) {
+	send(n, SeverityInfo, title, fields)
+}
+
+func Warn(n Notifier, title string, fields ...Field) {
+	send(n, SeverityWarning, title, fields)
+}
+
+func Error(n Notifier, title string, fields ...Field) {
+	send(n, SeverityError, title, fields)
+}
+
+//Report sends a message with a multi line body, e.g. the summary of a run
+func Report(n Notifier, sev Severity, title string, lines ...string) {
+	if n == nil {
+		return
+	}
+	msg := &Message{Severity: sev, Title: title, Text: strings.Join(lines, "\n"), Time: time.Now()}
+	if err := n.Notify(msg); err != nil {
+		log.Println("Notify failed", title, err)
+	}
+}
+
+//Open returns the notifier of spec, rate limited with DefaultBurst and
+//DefaultWindow. spec is one of
+//  none or ""                  drop all messages
+//  stdout                      print messages, for runs by hand
+//  mattermost:<bot.json>       the mattermost bot, a bare path is the same
+//  slack:<webhook url>         a slack compatible incoming webhook
+//  smtp:<smtp.json>            email, see SMTPConfig
+//username is who the messages are from, e.g. "Traceroute Updater"
+func Open(spec string, username string) (Notifier, error) {
+	kind, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+	var n Notifier
+	var err error
+	switch kind {
+	case "", "none":
+		return Noop{}, nil
+	case "stdout":
+		n = NewStdout(username)
+	case "mattermost":
+		n, err = NewMattermost(arg, username)
+	case "slack":
+		n, err = NewSlack(arg, username)
+	case "smtp":
+		n, err = NewSMTP(arg, username)
+	default:
+		if strings.Contains(spec, ":") {
+			return nil, fmt.Errorf("unknown notifier %q", kind)
+		}
+		n, err = NewMattermost(spec, username)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewLimiter(n, DefaultBurst, DefaultWindow), nil
+}
@@ -1,12 +1,11 @@
 package ookla
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
-	"os"
 	"path/filepath"
 	"serverlinks/iputils"
 	"spservers/common"
@@ -29,6 +28,8 @@ type OoklaServer struct {
 	Host        string `json:"host"`
 	IPv4        string `json:"ipv4"`
 	ASN         string `json:"asn"`
+	IPv6        string `json:"ipv6"`
+	ASNv6       string `json:"asnv6"`
 }
 
 type ResultsCarrier struct {
@@ -43,6 +44,10 @@ var wg sync.WaitGroup
 var allservers []OoklaServer
 var hintsmap map[int][]string
 
+//first query error of the crawl
+var crawlmu sync.Mutex
+var crawlerr error
+
 func ConvOoklatoDB(oservers []OoklaServer, cfg *common.Config) []spdb.SpeedServer {
 	ss := make([]spdb.SpeedServer, 0)
 	for _, s := range oservers {
@@ -50,25 +55,13 @@ func ConvOoklatoDB(oservers []OoklaServer, cfg *common.Config) []spdb.SpeedServe
 		longfloat, _ := strconv.ParseFloat(s.Lon, 64)
 		latfloat, _ := strconv.ParseFloat(s.Lat, 64)
 		location := spdb.JSONPoint{Type: "Point", Coord: []float64{longfloat, latfloat}}
-		server := spdb.SpeedServer{Type: "ookla", Id: s.Id, Identifier: s.Name + " - " + s.Sponsor, City: s.Name, Country: s.CountryCode, Host: s.Host, IPv4: s.IPv4, IPv6: "", Asnv4: s.ASN, Enabled: true, Additional: &ooklainfo, Location: location, LastUpdated: cfg.StartTime}
+		server := spdb.SpeedServer{Type: "ookla", Id: s.Id, Identifier: s.Name + " - " + s.Sponsor, City: s.Name, Country: s.CountryCode, Host: s.Host, IPv4: s.IPv4, IPv6: s.IPv6, Asnv4: s.ASN, Asnv6: s.ASNv6, Enabled: true, Additional: &ooklainfo, Location: location, LastUpdated: cfg.StartTime}
 		ss = append(ss, server)
 	}
 	return ss
 }
 
-func ImportOoklaServerfromFile(cfg *common.Config, filename string) []spdb.SpeedServer {
-	var allokserver []OoklaServer
-	jsonFile, err := os.Open(filename)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer jsonFile.Close()
-	serverstr, _ := ioutil.ReadAll(jsonFile)
-	json.Unmarshal(serverstr, &allokserver)
-	return ConvOoklatoDB(allokserver, cfg)
-}
-
-func LoadOokla(cfg *common.Config) []spdb.SpeedServer {
+func LoadOokla(ctx context.Context, cfg *common.Config) ([]spdb.SpeedServer, error) {
 	//	ip, asn := ResolveNet("okc-speedtest.onenet.net.prod.hosts.ooklaserver.net:8080")
 	//	fmt.Println(ip, asn)
 	/*	cfg = &Config{}
@@ -102,8 +95,14 @@ func LoadOokla(cfg *common.Config) []spdb.SpeedServer {
 		filename = filepath.Join(cfg.CreateFilePrefix, filename)
 	}
 	log.Println("Load Ookla", filename)
-	iphandler := iputils.NewIPHandler()
+	iphandler, err := iputils.NewIPHandler()
+	if err != nil {
+		//asn lookups fall back to cymru
+		log.Println("Load prefix2as failed", err)
+		iphandler, _ = iputils.NewIPHandler("")
+	}
 	hintsmap = make(map[int][]string)
+	crawlerr = nil
 	inithintlength := 2
 	maxhintlength := 6
 	hintsmap[inithintlength] = GenerateHints("", inithintlength)
@@ -116,13 +115,20 @@ func LoadOokla(cfg *common.Config) []spdb.SpeedServer {
 			log.Println("Hint round:", h, len(allhints))
 			for i := 1; i <= len(allhints); i++ {
 				wg.Add(1)
-				go QueryServers(allhints[i-1], rchan, workerchan)
+				go QueryServers(ctx, allhints[i-1], rchan, workerchan)
 				workerchan <- 1
 			}
 			wg.Wait()
 		}
+		if crawlerr != nil {
+			break
+		}
 	}
 	close(rchan)
+	if crawlerr != nil {
+		//an incomplete crawl would disable the servers it missed
+		return nil, crawlerr
+	}
 	//resolve all IP and Asn
 	for oidx, _ := range allservers {
 		wg.Add(1)
@@ -130,10 +136,13 @@ func LoadOokla(cfg *common.Config) []spdb.SpeedServer {
 		workerchan <- 1
 	}
 	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	file, _ := json.MarshalIndent(allservers, "", " ")
 	_ = ioutil.WriteFile(filename, file, 0644)
 	log.Println("Crawled", len(allservers))
-	return ConvOoklatoDB(allservers, cfg)
+	return ConvOoklatoDB(allservers, cfg), nil
 	/*	if len(cfg.SearchASN) > 0 && len(cfg.SearchProvider) > 0 && len(cfg.SearchCountry) > 0 {
 			log.Fatal("Only can search with one criteria")
 		} else if len(cfg.SearchASN) > 0 {
@@ -160,23 +169,28 @@ func LoadOokla(cfg *common.Config) []spdb.SpeedServer {
 	}*/
 }
 
-func QueryServers(hints string, results chan ResultsCarrier, wchan chan int) {
+func QueryServers(ctx context.Context, hints string, results chan ResultsCarrier, wchan chan int) {
+	defer func() {
+		wg.Done()
+		<-wchan
+	}()
 	qstring := fmt.Sprintf(OoklaQuery, hints)
-	response, err := http.Get(qstring)
-	if err != nil {
-		log.Fatal(err)
-	}
-	responseData, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Fatal(err)
-	}
 	rspobj := []OoklaServer{}
-	json.Unmarshal(responseData, &rspobj)
+	if err := common.GetJSON(ctx, qstring, &rspobj); err != nil {
+		log.Println("Query ookla servers failed", hints, err)
+		crawlmu.Lock()
+		if crawlerr == nil {
+			crawlerr = err
+		}
+		crawlmu.Unlock()
+		return
+	}
 	resultobj := ResultsCarrier{Hint: hints, Servers: rspobj}
 	results <- resultobj
-	time.Sleep(1 * time.Second)
-	wg.Done()
-	<-wchan
+	select {
+	case <-ctx.Done():
+	case <-time.After(1 * time.Second):
+	}
 }
 
 func ResultCollector(rchan chan ResultsCarrier) {
@@ -203,7 +217,9 @@ func ResultCollector(rchan chan ResultsCarrier) {
 
 }
 func ResolveIP(serveridx int, iph iputils.IPHandler, wchan chan int) {
-	allservers[serveridx].IPv4, allservers[serveridx].ASN = iph.ResolveV4(allservers[serveridx].Host)
+	r := iph.ResolveAll(allservers[serveridx].Host)
+	allservers[serveridx].IPv4, allservers[serveridx].ASN = r.IPv4, r.Asnv4
+	allservers[serveridx].IPv6, allservers[serveridx].ASNv6 = r.IPv6, r.Asnv6
 	wg.Done()
 	<-wchan
 }
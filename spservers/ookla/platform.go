@@ -0,0 +1,32 @@
+package ookla
+
+import (
+	"context"
+	"encoding/json"
+	"spservers/common"
+	"spservers/platform"
+	"spservers/spdb"
+)
+
+type Platform struct{}
+
+func init() {
+	platform.Register(Platform{})
+}
+
+func (Platform) Name() string    { return "ookla" }
+func (Platform) FileTag() string { return "ookla" }
+
+func (Platform) Crawl(ctx context.Context, cfg *common.Config) ([]spdb.SpeedServer, error) {
+	return LoadOokla(ctx, cfg)
+}
+
+func (Platform) Convert(cfg *common.Config, data []byte) ([]spdb.SpeedServer, error) {
+	var oservers []OoklaServer
+	if err := json.Unmarshal(data, &oservers); err != nil {
+		return nil, err
+	}
+	return ConvOoklatoDB(oservers, cfg), nil
+}
+
+func (Platform) NewAdditional() interface{} { return &spdb.OoklaInfo{} }
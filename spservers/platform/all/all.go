@@ -0,0 +1,11 @@
+//Package all registers every speed test platform, import it for its side effect
+package all
+
+import (
+	_ "spservers/cloudflare"
+	_ "spservers/comcast"
+	_ "spservers/fast"
+	_ "spservers/iperf3"
+	_ "spservers/mlab"
+	_ "spservers/ookla"
+)
@@ -0,0 +1,135 @@
+package platform
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"spservers/common"
+	"spservers/spdb"
+	"strings"
+	"sync"
+)
+
+//Platform is a speed test platform whose servers are crawled, traced and
+//assigned to links. A platform is a package that calls Register in its init,
+//spservers/platform/all imports every one of them
+type Platform interface {
+	//Name is the Type of its servers in the database, e.g. mlab
+	Name() string
+	//FileTag names its files, the crawled server lists <tag>_<unix ts>.json
+	//and the warts files of the bdrmap and traceroute results, e.g. ndt
+	FileTag() string
+	//Crawl fetches the current servers. The raw server list is saved to
+	//cfg.CreateFilePrefix, so Convert can import it again later
+	Crawl(ctx context.Context, cfg *common.Config) ([]spdb.SpeedServer, error)
+	//Convert decodes a raw server list saved by Crawl into servers
+	Convert(cfg *common.Config, data []byte) ([]spdb.SpeedServer, error)
+	//NewAdditional returns a pointer to a zero Additional of its servers, nil
+	//if its servers have no Additional
+	NewAdditional() interface{}
+}
+
+var mu sync.RWMutex
+var platforms = make(map[string]Platform)
+
+//Register adds p to the registry and makes the Additional of its servers decode
+//typed. It panics if the name or file tag is taken, like http.Handle does
+func Register(p Platform) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, q := range platforms {
+		if q.Name() == p.Name() || q.FileTag() == p.FileTag() {
+			panic("platform: " + p.Name() + " registered twice")
+		}
+	}
+	platforms[p.Name()] = p
+	if p.NewAdditional() != nil {
+		spdb.RegisterAdditional(p.Name(), p.NewAdditional)
+	}
+}
+
+//All returns the registered platforms sorted by name
+func All() []Platform {
+	mu.RLock()
+	defer mu.RUnlock()
+	all := make([]Platform, 0, len(platforms))
+	for _, p := range platforms {
+		all = append(all, p)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name() < all[j].Name() })
+	return all
+}
+
+//Get returns the platform of a server type or a file tag, so "ndt" and "mlab"
+//are both M-Lab
+func Get(name string) (Platform, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if p, pexist := platforms[name]; pexist {
+		return p, true
+	}
+	for _, p := range platforms {
+		if p.FileTag() == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+//ServerType is the server type of a platform name or file tag, name itself if
+//no platform has it
+func ServerType(name string) string {
+	if p, pexist := Get(name); pexist {
+		return p.Name()
+	}
+	return name
+}
+
+//FileTag is the file tag of a platform name or file tag, name itself if no
+//platform has it
+func FileTag(name string) string {
+	if p, pexist := Get(name); pexist {
+		return p.FileTag()
+	}
+	return name
+}
+
+//Tags returns the file tags of all platforms, sorted
+func Tags() []string {
+	all := All()
+	tags := make([]string, len(all))
+	for i, p := range all {
+		tags[i] = p.FileTag()
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+//ByFile returns the platform whose file tag is in the base name of filename,
+//the longest tag wins when several match
+func ByFile(filename string) (Platform, bool) {
+	name := filepath.Base(filename)
+	var found Platform
+	for _, p := range All() {
+		if strings.Contains(name, p.FileTag()) && (found == nil || len(p.FileTag()) > len(found.FileTag())) {
+			found = p
+		}
+	}
+	return found, found != nil
+}
+
+//ImportFile reads a server list saved by a crawl, the platform is taken from
+//the file name
+func ImportFile(cfg *common.Config, filename string) ([]spdb.SpeedServer, error) {
+	p, pexist := ByFile(filename)
+	if !pexist {
+		return nil, fmt.Errorf("%s: no platform tag (%s) in the file name", filename, strings.Join(Tags(), ", "))
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return p.Convert(cfg, data)
+}
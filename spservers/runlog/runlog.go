@@ -0,0 +1,199 @@
+package runlog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"spservers/spdb"
+	"sync"
+	"time"
+)
+
+//status of a run and of its vms
+const (
+	StatusRunning  = "running"
+	StatusOK       = "ok"
+	StatusPartial  = "partial"
+	StatusFailed   = "failed"
+	StatusSkipped  = "skipped"
+	StatusCanceled = "canceled"
+)
+
+//Recorder fills the run record of one command execution, it is safe for
+//concurrent use by the per vm workers. Database errors are logged and never
+//stop the command.
+type Recorder struct {
+	db     spdb.Store
+	mu     sync.Mutex
+	run    *spdb.Run
+	hashes map[string]spdb.RunInput
+	errs   []error
+}
+
+//Start records the start of command in db with the host, arguments and flag
+//values, call it after the flags are parsed
+func Start(ctx context.Context, db spdb.Store, command string) *Recorder {
+	host, _ := os.Hostname()
+	run := &spdb.Run{
+		Command: command,
+		Host:    host,
+		Args:    os.Args[1:],
+		Flags:   make(map[string]string),
+		Start:   time.Now().UTC(),
+		Status:  StatusRunning,
+		Inputs:  []spdb.RunInput{},
+		VMs:     []spdb.RunVM{},
+		Counts:  make(map[string]int),
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		run.Flags[f.Name] = f.Value.String()
+	})
+	r := &Recorder{db: db, run: run, hashes: make(map[string]spdb.RunInput)}
+	if db != nil {
+		if _, err := db.InsertRun(ctx, run); err != nil {
+			log.Println("Insert run record failed", command, err)
+		}
+	}
+	return r
+}
+
+//Id of the run record
+func (r *Recorder) Id() string {
+	return r.run.RunId.Hex()
+}
+
+//Input records a file read by the run, for vm if vm is not empty. Files are
+//hashed once per run.
+func (r *Recorder) Input(kind, vm, path string) {
+	r.mu.Lock()
+	in, hashed := r.hashes[path]
+	r.mu.Unlock()
+	if !hashed {
+		var err error
+		in, err = hashFile(path)
+		if err != nil {
+			log.Println("Hash input failed", path, err)
+		}
+		r.mu.Lock()
+		r.hashes[path] = in
+		r.mu.Unlock()
+	}
+	in.Kind, in.VM = kind, vm
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.Inputs = append(r.run.Inputs, in)
+}
+
+func hashFile(path string) (spdb.RunInput, error) {
+	in := spdb.RunInput{Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return in, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return in, err
+	}
+	in.Size = n
+	in.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return in, nil
+}
+
+//Count adds n to the counter name of the run
+func (r *Recorder) Count(name string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.Counts[name] += n
+}
+
+//VMCount adds n to the counter name of vm and of the run
+func (r *Recorder) VMCount(vm, name string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v := r.vm(vm)
+	v.Counts[name] += n
+	r.run.Counts[name] += n
+}
+
+//VMDone sets the outcome of vm, ok if err is nil and failed otherwise. The
+//errors of all vms are kept for Err
+func (r *Recorder) VMDone(vm string, err error) {
+	if err != nil {
+		r.mu.Lock()
+		r.errs = append(r.errs, fmt.Errorf("%s: %w", vm, err))
+		r.mu.Unlock()
+		r.VMStatus(vm, StatusFailed, err.Error())
+		return
+	}
+	r.VMStatus(vm, StatusOK, "")
+}
+
+//Err joins the errors the vms of the run failed with, nil if none failed
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return errors.Join(r.errs...)
+}
+
+//VMStatus sets the outcome of vm
+func (r *Recorder) VMStatus(vm, status, errmsg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v := r.vm(vm)
+	v.Status, v.Err = status, errmsg
+}
+
+//vm returns the entry of vm, added as running on first use, r.mu must be held
+func (r *Recorder) vm(vm string) *spdb.RunVM {
+	for vidx := range r.run.VMs {
+		if r.run.VMs[vidx].VM == vm {
+			return &r.run.VMs[vidx]
+		}
+	}
+	r.run.VMs = append(r.run.VMs, spdb.RunVM{VM: vm, Status: StatusRunning, Counts: make(map[string]int)})
+	return &r.run.VMs[len(r.run.VMs)-1]
+}
+
+//Finish records the end of the run. The run failed if err is not nil, it is
+//canceled if err is a context error and partial if any vm failed. The record is
+//written even if the context of the run is done.
+func (r *Recorder) Finish(err error) {
+	r.mu.Lock()
+	r.run.End = time.Now().UTC()
+	r.run.Status = StatusOK
+	for vidx := range r.run.VMs {
+		switch r.run.VMs[vidx].Status {
+		case StatusRunning:
+			//the worker of the vm never reported back
+			r.run.VMs[vidx].Status = StatusFailed
+			r.run.Status = StatusPartial
+		case StatusFailed:
+			r.run.Status = StatusPartial
+		}
+	}
+	if err != nil {
+		r.run.Status = StatusFailed
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			r.run.Status = StatusCanceled
+		}
+		r.run.Err = err.Error()
+	}
+	run := *r.run
+	r.mu.Unlock()
+	log.Println("Run", run.Command, r.Id(), run.Status, run.End.Sub(run.Start).Round(time.Second), run.Counts)
+	if r.db != nil && !run.RunId.IsZero() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := r.db.UpdateRun(ctx, &run); err != nil {
+			log.Println("Update run record failed", run.Command, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package serverhistory
+
+import (
+	"sort"
+	"spservers/spdb"
+)
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	//the IPv4 or IPv6 address changed
+	ChangeReaddressed = "readdressed"
+	//the ASN of the IPv4 or IPv6 address changed
+	ChangeRehomed = "rehomed"
+	//the city or the country changed
+	ChangeMoved = "moved"
+)
+
+//Diff compares the servers of one platform in the crawl at prevts, old, with the
+//servers of the crawl at ts, servers are matched by their platform id. A server
+//that changed address and network gives both a readdressed and a rehomed change.
+//The first crawl of a platform has nothing to compare with and gives no changes.
+func Diff(prevts, ts int64, old []spdb.SpeedServer, servers []spdb.SpeedServer) []*spdb.ServerChange {
+	changes := []*spdb.ServerChange{}
+	if len(old) == 0 {
+		return changes
+	}
+	oldmap := make(map[string]*spdb.SpeedServer)
+	for sidx := range old {
+		oldmap[old[sidx].Type+"|"+old[sidx].Id] = &old[sidx]
+	}
+	newmap := make(map[string]*spdb.SpeedServer)
+	for sidx := range servers {
+		newmap[servers[sidx].Type+"|"+servers[sidx].Id] = &servers[sidx]
+	}
+	for key, s := range newmap {
+		o, oexist := oldmap[key]
+		if !oexist {
+			changes = append(changes, newChange(ChangeAdded, prevts, ts, nil, s))
+			continue
+		}
+		if o.IPv4 != s.IPv4 || o.IPv6 != s.IPv6 {
+			changes = append(changes, newChange(ChangeReaddressed, prevts, ts, o, s))
+		}
+		if o.Asnv4 != s.Asnv4 || o.Asnv6 != s.Asnv6 {
+			changes = append(changes, newChange(ChangeRehomed, prevts, ts, o, s))
+		}
+		if o.City != s.City || o.Country != s.Country {
+			changes = append(changes, newChange(ChangeMoved, prevts, ts, o, s))
+		}
+	}
+	for key, o := range oldmap {
+		if _, sexist := newmap[key]; !sexist {
+			changes = append(changes, newChange(ChangeRemoved, prevts, ts, o, nil))
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Change != changes[j].Change {
+			return changes[i].Change < changes[j].Change
+		}
+		if changes[i].Type != changes[j].Type {
+			return changes[i].Type < changes[j].Type
+		}
+		return changes[i].Id < changes[j].Id
+	})
+	return changes
+}
+
+func newChange(change string, prevts, ts int64, old *spdb.SpeedServer, server *spdb.SpeedServer) *spdb.ServerChange {
+	sc := &spdb.ServerChange{Change: change, Ts: ts, PrevTs: prevts}
+	if old != nil {
+		sc.Type, sc.Id, sc.SpId, sc.Old = old.Type, old.Id, old.SpId, State(old)
+	}
+	if server != nil {
+		sc.Type, sc.Id, sc.New = server.Type, server.Id, State(server)
+		if sc.SpId.IsZero() {
+			sc.SpId = server.SpId
+		}
+	}
+	return sc
+}
+
+func State(s *spdb.SpeedServer) spdb.ServerState {
+	return spdb.ServerState{Host: s.Host, IPv4: s.IPv4, IPv6: s.IPv6, Asnv4: s.Asnv4, Asnv6: s.Asnv6, City: s.City, Country: s.Country}
+}
+
+//Affects tells the change can invalidate an assignment of the server, the link
+//it was selected for was found through its old address or network
+func Affects(sc *spdb.ServerChange) bool {
+	return sc.Change == ChangeReaddressed || sc.Change == ChangeRehomed
+}
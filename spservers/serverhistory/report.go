@@ -0,0 +1,65 @@
+package serverhistory
+
+import (
+	"fmt"
+	"sort"
+	"spservers/spdb"
+	"strings"
+)
+
+//Summary counts the changes of each platform by kind, e.g. ookla: added 3, removed 1
+func Summary(changes []*spdb.ServerChange) []string {
+	counts := make(map[string]map[string]int)
+	for _, sc := range changes {
+		if _, texist := counts[sc.Type]; !texist {
+			counts[sc.Type] = make(map[string]int)
+		}
+		counts[sc.Type][sc.Change]++
+	}
+	types := make([]string, 0, len(counts))
+	for stype := range counts {
+		types = append(types, stype)
+	}
+	sort.Strings(types)
+	lines := make([]string, 0, len(types))
+	for _, stype := range types {
+		kinds := []string{}
+		for _, change := range []string{ChangeAdded, ChangeRemoved, ChangeReaddressed, ChangeRehomed, ChangeMoved} {
+			if n := counts[stype][change]; n > 0 {
+				kinds = append(kinds, fmt.Sprintf("%s %d", change, n))
+			}
+		}
+		lines = append(lines, stype+": "+strings.Join(kinds, ", "))
+	}
+	return lines
+}
+
+//Line describes one change, with the old and the new value of what changed
+func Line(sc *spdb.ServerChange) string {
+	var detail string
+	switch sc.Change {
+	case ChangeAdded:
+		detail = describe(sc.New)
+	case ChangeRemoved:
+		detail = describe(sc.Old)
+	case ChangeReaddressed:
+		detail = pair(sc.Old.IPv4, sc.Old.IPv6) + " -> " + pair(sc.New.IPv4, sc.New.IPv6)
+	case ChangeRehomed:
+		detail = "AS" + pair(sc.Old.Asnv4, sc.Old.Asnv6) + " -> AS" + pair(sc.New.Asnv4, sc.New.Asnv6)
+	case ChangeMoved:
+		detail = fmt.Sprintf("%s, %s -> %s, %s", sc.Old.City, sc.Old.Country, sc.New.City, sc.New.Country)
+	}
+	return fmt.Sprintf("%-11s %-10s %s %s", sc.Change, sc.Type, sc.Id, detail)
+}
+
+func describe(st spdb.ServerState) string {
+	return fmt.Sprintf("%s %s AS%s %s, %s", st.Host, st.IPv4, st.Asnv4, st.City, st.Country)
+}
+
+//v4 and v6 value joined by /, the v6 one only if it is set
+func pair(v4, v6 string) string {
+	if len(v6) == 0 {
+		return v4
+	}
+	return v4 + "/" + v6
+}
@@ -0,0 +1,65 @@
+package spdb
+
+import (
+	"encoding/json"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+//constructors of the typed Additional of each server type
+var additionals sync.Map
+
+//RegisterAdditional makes servers of type stype decode their Additional into the
+//value newfn returns, a pointer to the metadata struct of the platform. Without
+//it Additional decodes as generic bson/json
+func RegisterAdditional(stype string, newfn func() interface{}) {
+	additionals.Store(stype, newfn)
+}
+
+func newAdditional(stype string) interface{} {
+	if newfn, nexist := additionals.Load(stype); nexist {
+		return newfn.(func() interface{})()
+	}
+	return nil
+}
+
+//SpeedServer without its methods, so decoding it does not recurse
+type speedServerDoc SpeedServer
+
+func (s *SpeedServer) UnmarshalBSON(data []byte) error {
+	if err := bson.Unmarshal(data, (*speedServerDoc)(s)); err != nil {
+		return err
+	}
+	raw, err := bson.Raw(data).LookupErr("additional")
+	if err != nil || raw.Type != bsontype.EmbeddedDocument {
+		return nil
+	}
+	if additional := newAdditional(s.Type); additional != nil {
+		if err := raw.Unmarshal(additional); err != nil {
+			return err
+		}
+		s.Additional = additional
+	}
+	return nil
+}
+
+func (s *SpeedServer) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*speedServerDoc)(s)); err != nil {
+		return err
+	}
+	var raw struct {
+		Additional json.RawMessage `json:"additional"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil || len(raw.Additional) == 0 || raw.Additional[0] != '{' {
+		return err
+	}
+	if additional := newAdditional(s.Type); additional != nil {
+		if err := json.Unmarshal(raw.Additional, additional); err != nil {
+			return err
+		}
+		s.Additional = additional
+	}
+	return nil
+}
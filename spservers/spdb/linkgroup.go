@@ -0,0 +1,47 @@
+package spdb
+
+import "context"
+
+//levels links can be grouped at
+const (
+	//every near/far interface pair is its own group
+	LinkGroupInterface = "interface"
+	//links into the same far router
+	LinkGroupRouter = "router"
+	//links into the same far AS
+	LinkGroupAS = "as"
+)
+
+//the key of the group link belongs to. links from bdrmap runs before routers
+//were recorded fall back to their far interface at router level
+func LinkGroupKey(link *Link, group string) string {
+	switch group {
+	case LinkGroupRouter:
+		if len(link.FarRtr) > 0 {
+			return link.FarRtr
+		}
+		return link.FarIP
+	case LinkGroupAS:
+		return link.FarAS
+	}
+	return link.Linkkey
+}
+
+//group the links of linkkeymap at the given level
+func GroupLinks(linkkeymap map[string]*Link, group string) map[string][]*Link {
+	groups := make(map[string][]*Link)
+	for _, link := range linkkeymap {
+		gkey := LinkGroupKey(link, group)
+		groups[gkey] = append(groups[gkey], link)
+	}
+	return groups
+}
+
+//the links of region grouped at the given level
+func CreateLinkGroups(ctx context.Context, db Store, region string, group string) (map[string][]*Link, error) {
+	linkkeymap, _, err := db.CreateLinkmap(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	return GroupLinks(linkkeymap, group), nil
+}
@@ -0,0 +1,979 @@
+package spdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"spservers/geo"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemStore is an in-memory Store. It follows the same matching, upsert and
+// aggregation rules as the mongo queries, and hands out copies so callers
+// cannot change stored records behind its back.
+type MemStore struct {
+	mu          sync.RWMutex
+	snapshot    string
+	servers     []*SpeedServer
+	serverhist  []*ServerChange
+	links       []*Link
+	routers     []*Router
+	linkevents  []*LinkEvent
+	traceroutes []*Traceroute
+	speedmeas   []*SpeedMeas
+	results     []*SpeedResult
+	congestion  []*LinkCongestion
+	fleet       []*FleetAction
+	runs        []*Run
+	datastatus  map[string]*VMDataStatus
+	vms         map[string][]VMInfo
+}
+
+//on-disk form of a MemStore
+type memSnapshot struct {
+	Servers     []*SpeedServer           `json:"speedserver"`
+	ServerHist  []*ServerChange          `json:"serverhistory"`
+	Links       []*Link                  `json:"links"`
+	Routers     []*Router                `json:"routers"`
+	LinkEvents  []*LinkEvent             `json:"linkevents"`
+	Traceroutes []*Traceroute            `json:"traceroute"`
+	SpeedMeas   []*SpeedMeas             `json:"speedmeas"`
+	Results     []*SpeedResult           `json:"speedmeasresult"`
+	Congestion  []*LinkCongestion        `json:"linkcongestion"`
+	Fleet       []*FleetAction           `json:"fleetaction"`
+	Runs        []*Run                   `json:"runs"`
+	DataStatus  map[string]*VMDataStatus `json:"datastatus"`
+	VMs         map[string][]VMInfo      `json:"vminfo"`
+	Schema      map[string]int           `json:"schema"`
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		datastatus: make(map[string]*VMDataStatus),
+		vms:        make(map[string][]VMInfo),
+	}
+}
+
+// LoadMemStore creates a MemStore backed by a JSON snapshot. A missing file
+// gives an empty store; Close writes the snapshot back.
+func LoadMemStore(snapshot string) (*MemStore, error) {
+	ms := NewMemStore()
+	ms.snapshot = snapshot
+	if snapshot == "" {
+		return ms, nil
+	}
+	data, err := ioutil.ReadFile(snapshot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ms, nil
+		}
+		return nil, err
+	}
+	//snapshots written before a migration get it applied as they load
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if err := migrateSnapshot(raw); err != nil {
+		return nil, fmt.Errorf("migrate %s: %w", snapshot, err)
+	}
+	if data, err = json.Marshal(raw); err != nil {
+		return nil, err
+	}
+	snap := memSnapshot{}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	ms.servers, ms.links, ms.traceroutes, ms.speedmeas, ms.results = snap.Servers, snap.Links, snap.Traceroutes, snap.SpeedMeas, snap.Results
+	ms.routers, ms.linkevents, ms.congestion, ms.fleet = snap.Routers, snap.LinkEvents, snap.Congestion, snap.Fleet
+	ms.runs, ms.serverhist = snap.Runs, snap.ServerHist
+	if snap.DataStatus != nil {
+		ms.datastatus = snap.DataStatus
+	}
+	if snap.VMs != nil {
+		ms.vms = snap.VMs
+	}
+	log.Println("Loaded memory store", snapshot)
+	return ms, nil
+}
+
+func (ms *MemStore) Close() {
+	if ms.snapshot == "" {
+		return
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	snap := memSnapshot{Servers: ms.servers, ServerHist: ms.serverhist, Links: ms.links, Routers: ms.routers, LinkEvents: ms.linkevents, Traceroutes: ms.traceroutes, SpeedMeas: ms.speedmeas, Results: ms.results, Congestion: ms.congestion, Fleet: ms.fleet, Runs: ms.runs, DataStatus: ms.datastatus, VMs: ms.vms, Schema: CurrentSchema()}
+	data, err := json.MarshalIndent(snap, "", " ")
+	if err != nil {
+		log.Println("Encode memory store error", err)
+		return
+	}
+	if err := ioutil.WriteFile(ms.snapshot, data, 0644); err != nil {
+		log.Println("Write memory store error", err)
+		return
+	}
+	log.Println("Memory store saved to", ms.snapshot)
+}
+
+/* speedserver */
+
+func (ms *MemStore) ResetEnable(ctx context.Context, servertype string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	cnt := 0
+	for _, s := range ms.servers {
+		if s.Type == servertype {
+			s.Enabled = false
+			cnt++
+		}
+	}
+	if cnt > 0 {
+		log.Println("Distabled", cnt, servertype, "servers")
+	}
+	return nil
+}
+
+func (ms *MemStore) InsertServers(ctx context.Context, servers []SpeedServer) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	nodoc := 0
+	for _, ser := range servers {
+		replaced := false
+		for sidx, s := range ms.servers {
+			if s.Type == ser.Type && s.Id == ser.Id {
+				newser := ser
+				newser.SpId = s.SpId
+				ms.servers[sidx] = &newser
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			nodoc++
+			newser := ser
+			if newser.SpId.IsZero() {
+				newser.SpId = primitive.NewObjectID()
+			}
+			ms.servers = append(ms.servers, &newser)
+		}
+	}
+	return nodoc, nil
+}
+
+func (ms *MemStore) findServers(match func(*SpeedServer) bool) []SpeedServer {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var found []SpeedServer
+	for _, s := range ms.servers {
+		if match(s) {
+			found = append(found, *s)
+		}
+	}
+	return found
+}
+
+func (ms *MemStore) QueryServerbyId(ctx context.Context, sid primitive.ObjectID) (*SpeedServer, error) {
+	found := ms.findServers(func(s *SpeedServer) bool { return s.SpId == sid })
+	if len(found) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &found[0], nil
+}
+
+func (ms *MemStore) QueryEnabledServersbyType(ctx context.Context, stype string) ([]SpeedServer, error) {
+	return ms.findServers(func(s *SpeedServer) bool { return s.Type == stype && s.Enabled }), nil
+}
+
+func (ms *MemStore) QueryEnabledServers(ctx context.Context) ([]SpeedServer, error) {
+	return ms.findServers(func(s *SpeedServer) bool { return s.Enabled }), nil
+}
+
+func (ms *MemStore) QueryServersbyIPv4(ctx context.Context, serverip net.IP) ([]SpeedServer, error) {
+	ipstr := serverip.String()
+	return ms.findServers(func(s *SpeedServer) bool { return s.IPv4 == ipstr }), nil
+}
+
+func (ms *MemStore) QueryServerbyIdentifier(ctx context.Context, stype, iden string) (SpeedServer, error) {
+	found := ms.findServers(func(s *SpeedServer) bool { return s.Type == stype && s.Identifier == iden })
+	if len(found) == 0 {
+		return SpeedServer{}, mongo.ErrNoDocuments
+	}
+	return found[0], nil
+}
+
+func (ms *MemStore) QueryServersNear(ctx context.Context, point JSONPoint, radiuskm float64, filter ServerFilter) ([]SpeedServer, error) {
+	if !point.Valid() {
+		return nil, fmt.Errorf("invalid point %v", point.Coord)
+	}
+	found := ms.findServers(func(s *SpeedServer) bool {
+		return filter.match(s) && s.Location.Valid() && (radiuskm <= 0 || point.DistanceKm(s.Location) <= radiuskm)
+	})
+	sort.SliceStable(found, func(i, j int) bool {
+		return point.DistanceKm(found[i].Location) < point.DistanceKm(found[j].Location)
+	})
+	return found, nil
+}
+
+func (ms *MemStore) QueryServersInPolygon(ctx context.Context, ring [][]float64, filter ServerFilter) ([]SpeedServer, error) {
+	ring = closeRing(ring)
+	if len(ring) < 4 {
+		return nil, fmt.Errorf("polygon needs at least 3 points, got %d", len(ring)-1)
+	}
+	return ms.findServers(func(s *SpeedServer) bool {
+		return filter.match(s) && s.Location.Valid() && geo.InPolygon(s.Location.Coord[0], s.Location.Coord[1], ring)
+	}), nil
+}
+
+//no index to build, invalid locations are cleared as the mongo store does
+func (ms *MemStore) EnsureGeoIndex(ctx context.Context) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, s := range ms.servers {
+		if !s.Location.IsZero() && !s.Location.Valid() {
+			s.Location = JSONPoint{}
+		}
+	}
+	return nil
+}
+
+/* schema */
+
+//the snapshot is migrated as it loads and there are no indexes, nothing is left to do
+func (ms *MemStore) Migrate(ctx context.Context, dryrun bool) ([]MigrationStep, error) {
+	return []MigrationStep{}, nil
+}
+
+func (ms *MemStore) SchemaVersions(ctx context.Context) (map[string]int, error) {
+	return CurrentSchema(), nil
+}
+
+/* links */
+
+func (ms *MemStore) UpdateLinkstoMongo(ctx context.Context, region string, seents int64, linkmap map[string]*Link) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, l := range ms.links {
+		if l.Region == region {
+			l.Current = false
+		}
+	}
+	for linkkey, link := range linkmap {
+		var stored *Link
+		for _, l := range ms.links {
+			if l.Region == region && l.Linkkey == linkkey {
+				stored = l
+				break
+			}
+		}
+		if stored == nil {
+			stored = &Link{LinkId: primitive.NewObjectID(), Region: region, Linkkey: linkkey}
+			ms.links = append(ms.links, stored)
+		}
+		stored.NearIP = link.NearIP
+		stored.FarIP = link.FarIP
+		stored.FarAS = link.FarAS
+		stored.NearRtr = link.NearRtr
+		stored.FarRtr = link.FarRtr
+		stored.Current = true
+		stored.Covered = false
+		seen := false
+		for _, ts := range stored.LastSeen {
+			if ts.Unix() == seents {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			stored.LastSeen = append(stored.LastSeen, time.Unix(seents, 0).UTC())
+		}
+	}
+	return nil
+}
+
+func copyLink(l *Link) *Link {
+	newlink := *l
+	newlink.LastSeen = append([]time.Time(nil), l.LastSeen...)
+	return &newlink
+}
+
+func (ms *MemStore) findLinks(match func(*Link) bool) []*Link {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var found []*Link
+	for _, l := range ms.links {
+		if match(l) {
+			found = append(found, copyLink(l))
+		}
+	}
+	return found
+}
+
+func (ms *MemStore) QueryLinkbyId(ctx context.Context, linkid primitive.ObjectID) (*Link, error) {
+	found := ms.findLinks(func(l *Link) bool { return l.LinkId == linkid })
+	if len(found) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return found[0], nil
+}
+
+func (ms *MemStore) QueryLinkbyKey(ctx context.Context, region string, linkkey string) (*Link, error) {
+	found := ms.findLinks(func(l *Link) bool { return l.Region == region && l.Linkkey == linkkey })
+	if len(found) == 0 {
+		return &Link{}, nil
+	}
+	return found[0], nil
+}
+
+func (ms *MemStore) QueryLinkbyFar(ctx context.Context, region string, farip string) ([]*Link, error) {
+	monreg := regexp.MustCompile(`(\w+-\w+-)\w+`)
+	regionarr := monreg.FindStringSubmatch(region)
+	if len(regionarr) > 1 {
+		regionre := regexp.MustCompile(regionarr[1] + "*")
+		return ms.findLinks(func(l *Link) bool { return regionre.MatchString(l.Region) && l.FarIP == farip }), nil
+	}
+	return nil, errors.New("Region format is incorrect")
+}
+
+func (ms *MemStore) CreateLinkmap(ctx context.Context, region string) (map[string]*Link, map[string][]*Link, error) {
+	alllinks := ms.findLinks(func(l *Link) bool { return l.Region == region })
+	linkkeymap := make(map[string]*Link)
+	faripmap := make(map[string][]*Link)
+	for _, link := range alllinks {
+		linkkeymap[link.Linkkey] = link
+		faripmap[link.FarIP] = append(faripmap[link.FarIP], link)
+	}
+	return linkkeymap, faripmap, nil
+}
+
+/* serverhistory */
+
+func (ms *MemStore) InsertServerChanges(ctx context.Context, changes []*ServerChange) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, sc := range changes {
+		newsc := *sc
+		if newsc.ScId.IsZero() {
+			newsc.ScId = primitive.NewObjectID()
+		}
+		ms.serverhist = append(ms.serverhist, &newsc)
+	}
+	return len(changes), nil
+}
+
+func (ms *MemStore) QueryServerChanges(ctx context.Context, stype string, startts, endts int64) ([]*ServerChange, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	changes := make([]*ServerChange, 0)
+	for _, sc := range ms.serverhist {
+		if (stype == "" || sc.Type == stype) && sc.Ts >= startts && sc.Ts < endts {
+			newsc := *sc
+			changes = append(changes, &newsc)
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Ts < changes[j].Ts })
+	return changes, nil
+}
+
+/* linkevents */
+
+func (ms *MemStore) InsertLinkEvents(ctx context.Context, events []*LinkEvent) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, le := range events {
+		newle := *le
+		if newle.LeId.IsZero() {
+			newle.LeId = primitive.NewObjectID()
+		}
+		ms.linkevents = append(ms.linkevents, &newle)
+	}
+	return len(events), nil
+}
+
+func (ms *MemStore) QueryLinkEvents(ctx context.Context, region string, startts, endts int64) ([]*LinkEvent, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	events := make([]*LinkEvent, 0)
+	for _, le := range ms.linkevents {
+		if (region == "" || le.Region == region) && le.Ts >= startts && le.Ts < endts {
+			newle := *le
+			events = append(events, &newle)
+		}
+	}
+	return events, nil
+}
+
+/* routers */
+
+func (ms *MemStore) InsertRouters(ctx context.Context, region string, ts int64, routers []*Router) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	kept := ms.routers[:0]
+	for _, r := range ms.routers {
+		if r.Region != region || r.Ts != ts {
+			kept = append(kept, r)
+		}
+	}
+	ms.routers = kept
+	for _, r := range routers {
+		newr := *r
+		newr.Region, newr.Ts = region, ts
+		newr.Addrs = append([]string(nil), r.Addrs...)
+		if newr.RtrId.IsZero() {
+			newr.RtrId = primitive.NewObjectID()
+		}
+		ms.routers = append(ms.routers, &newr)
+	}
+	return len(routers), nil
+}
+
+func (ms *MemStore) QueryRouters(ctx context.Context, region string, ts int64) ([]*Router, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if ts == 0 {
+		for _, r := range ms.routers {
+			if r.Region == region && r.Ts > ts {
+				ts = r.Ts
+			}
+		}
+	}
+	routers := make([]*Router, 0)
+	for _, r := range ms.routers {
+		if r.Region == region && r.Ts == ts {
+			newr := *r
+			newr.Addrs = append([]string(nil), r.Addrs...)
+			routers = append(routers, &newr)
+		}
+	}
+	return routers, nil
+}
+
+/* traceroute */
+
+func (ms *MemStore) InsertManyTraceroutes(ctx context.Context, trs []*Traceroute) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, tr := range trs {
+		newtr := *tr
+		newtr.Hops = append([]TrHop(nil), tr.Hops...)
+		if newtr.TrId.IsZero() {
+			newtr.TrId = primitive.NewObjectID()
+		}
+		ms.traceroutes = append(ms.traceroutes, &newtr)
+	}
+	return len(trs), nil
+}
+
+func (ms *MemStore) QueryLinksSpServerMatch(ctx context.Context, region string, startts int64, outputch chan *LinkSpAgg) error {
+	//build the groups first, the consumer queries the store while reading
+	ms.mu.RLock()
+	groups := make([]*LinkSpAgg, 0)
+	groupidx := make(map[primitive.ObjectID]int)
+	for _, tr := range ms.traceroutes {
+		if tr.Region != region || tr.LinkId.IsZero() || tr.Ts < startts {
+			continue
+		}
+		gidx, gexist := groupidx[tr.LinkId]
+		if !gexist {
+			agg := &LinkSpAgg{}
+			agg.Groupid.Linkid = tr.LinkId
+			for _, l := range ms.links {
+				if l.LinkId == tr.LinkId {
+					agg.LinkObj = append(agg.LinkObj, *copyLink(l))
+				}
+			}
+			gidx = len(groups)
+			groupidx[tr.LinkId] = gidx
+			groups = append(groups, agg)
+		}
+		groups[gidx].SpServerIds = append(groups[gidx].SpServerIds, tr.SpServerId)
+		groups[gidx].TrIds = append(groups[gidx].TrIds, tr.TrId)
+	}
+	ms.mu.RUnlock()
+	for _, agg := range groups {
+		outputch <- agg
+	}
+	return nil
+}
+
+func (ms *MemStore) ListRegions(ctx context.Context) ([]string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	seen := make(map[string]bool)
+	rg := make([]string, 0)
+	for _, tr := range ms.traceroutes {
+		if !seen[tr.Region] {
+			seen[tr.Region] = true
+			rg = append(rg, tr.Region)
+		}
+	}
+	return rg, nil
+}
+
+func (ms *MemStore) LinkServerStats(ctx context.Context, region string, linkid primitive.ObjectID, startts int64) ([]*ServerTraceStats, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	byserver := make(map[primitive.ObjectID]*ServerTraceStats)
+	rtts := make(map[primitive.ObjectID][]float64)
+	stats := make([]*ServerTraceStats, 0)
+	for _, tr := range ms.traceroutes {
+		if tr.Region != region || tr.LinkId != linkid || tr.Ts < startts {
+			continue
+		}
+		st, sexist := byserver[tr.SpServerId]
+		if !sexist {
+			st = &ServerTraceStats{SpServerId: tr.SpServerId}
+			byserver[tr.SpServerId] = st
+			stats = append(stats, st)
+		}
+		st.Traces++
+		if rtt, ok := trDestRtt(tr); ok {
+			rtts[tr.SpServerId] = append(rtts[tr.SpServerId], rtt)
+		}
+		aslen := trASPathLen(tr)
+		if aslen > 0 && (st.MinASPathLen == 0 || aslen < st.MinASPathLen) {
+			st.MinASPathLen = aslen
+		}
+		if aslen > st.MaxASPathLen {
+			st.MaxASPathLen = aslen
+		}
+	}
+	for _, st := range stats {
+		st.setRtts(rtts[st.SpServerId])
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].SpServerId.Hex() < stats[j].SpServerId.Hex() })
+	return stats, nil
+}
+
+func (ms *MemStore) QueryTraceroutesbyLink(ctx context.Context, linkids []primitive.ObjectID, startts, endts int64) ([]*Traceroute, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	trs := make([]*Traceroute, 0)
+	for _, tr := range ms.traceroutes {
+		if tr.Ts >= startts && tr.Ts < endts && containsId(linkids, tr.LinkId) {
+			newtr := *tr
+			newtr.Hops = append([]TrHop(nil), tr.Hops...)
+			trs = append(trs, &newtr)
+		}
+	}
+	return trs, nil
+}
+
+func (ms *MemStore) SpServersLinkChoice(ctx context.Context, region, spidhex string) (int, error) {
+	spid, _ := primitive.ObjectIDFromHex(spidhex)
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	linkset := make(map[primitive.ObjectID]bool)
+	for _, tr := range ms.traceroutes {
+		if tr.Region == region && tr.SpServerId == spid {
+			linkset[tr.LinkId] = true
+		}
+	}
+	return len(linkset), nil
+}
+
+/* speedmeas */
+
+func copySpeedMeas(sm *SpeedMeas) *SpeedMeas {
+	newsm := *sm
+	newsm.Activeperiod = append([]TimePeriod(nil), sm.Activeperiod...)
+	if newsm.SpMeasId.IsZero() {
+		newsm.SpMeasId = primitive.NewObjectID()
+	}
+	return &newsm
+}
+
+func (ms *MemStore) InsertManySpeedMeas(ctx context.Context, spmes []*SpeedMeas) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, sm := range spmes {
+		ms.speedmeas = append(ms.speedmeas, copySpeedMeas(sm))
+	}
+	return len(spmes), nil
+}
+
+func (ms *MemStore) QuerySpeedserverExist(ctx context.Context, region string, spid primitive.ObjectID) (int, error) {
+	monreg := regexp.MustCompile(`(\w+-\w+-)\w+`)
+	regionarr := monreg.FindStringSubmatch(region)
+	if len(regionarr) <= 1 {
+		return 0, errors.New("Monitor name patten does not match")
+	}
+	monre := regexp.MustCompile(regionarr[1] + "*")
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, sm := range ms.speedmeas {
+		if monre.MatchString(sm.Mon) && sm.SpeedServer == spid {
+			if sm.Enabled {
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return -1, nil
+}
+
+func (ms *MemStore) QueryAllEnabledSpeedMeas(ctx context.Context) ([]*SpeedMeasAgg, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	allsmeas := make([]*SpeedMeasAgg, 0)
+	for _, sm := range ms.speedmeas {
+		if !sm.Enabled {
+			continue
+		}
+		agg := &SpeedMeasAgg{Mon: sm.Mon, SpserverInfo: []SpeedServer{}, LinkInfo: []Link{}}
+		for _, s := range ms.servers {
+			if s.SpId == sm.SpeedServer {
+				agg.SpserverInfo = append(agg.SpserverInfo, *s)
+			}
+		}
+		for _, l := range ms.links {
+			if l.LinkId == sm.Link {
+				agg.LinkInfo = append(agg.LinkInfo, *copyLink(l))
+			}
+		}
+		allsmeas = append(allsmeas, agg)
+	}
+	return allsmeas, nil
+}
+
+func (ms *MemStore) QueryMapSpeedMeas(ctx context.Context) (map[string][]*SpeedMeas, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	monreg := regexp.MustCompile(`(\w+-\w+)-\w+`)
+	rmap := make(map[string][]*SpeedMeas)
+	for _, sm := range ms.speedmeas {
+		monarr := monreg.FindStringSubmatch(sm.Mon)
+		if len(monarr) < 2 {
+			continue
+		}
+		key := monarr[1] + ":" + sm.SpeedServer.Hex()
+		rmap[key] = append(rmap[key], copySpeedMeas(sm))
+	}
+	return rmap, nil
+}
+
+func (ms *MemStore) UpdateSpeedserver(ctx context.Context, spmeas *SpeedMeas) error {
+	if spmeas == nil {
+		return nil
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for smidx, sm := range ms.speedmeas {
+		if sm.Mon == spmeas.Mon && sm.SpeedServer == spmeas.SpeedServer && sm.Link == spmeas.Link {
+			newsm := copySpeedMeas(spmeas)
+			newsm.SpMeasId = sm.SpMeasId
+			ms.speedmeas[smidx] = newsm
+			return nil
+		}
+	}
+	ms.speedmeas = append(ms.speedmeas, copySpeedMeas(spmeas))
+	return nil
+}
+
+/* speedmeasresult */
+
+func (ms *MemStore) InsertManySpeedResults(ctx context.Context, results []*SpeedResult) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	nodoc := 0
+	for _, r := range results {
+		newr := *r
+		replaced := false
+		for ridx, old := range ms.results {
+			if old.Mon == r.Mon && old.Type == r.Type && old.File == r.File {
+				newr.ResultId = old.ResultId
+				ms.results[ridx] = &newr
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			nodoc++
+			if newr.ResultId.IsZero() {
+				newr.ResultId = primitive.NewObjectID()
+			}
+			ms.results = append(ms.results, &newr)
+		}
+	}
+	return nodoc, nil
+}
+
+func (ms *MemStore) QuerySpeedResultsbyLink(ctx context.Context, linkids []primitive.ObjectID, startts, endts int64) ([]*SpeedResult, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	results := make([]*SpeedResult, 0)
+	for _, r := range ms.results {
+		if r.Ts >= startts && r.Ts < endts && containsId(linkids, r.Link) {
+			newr := *r
+			results = append(results, &newr)
+		}
+	}
+	return results, nil
+}
+
+func containsId(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+/* linkcongestion */
+
+func (ms *MemStore) UpsertLinkCongestion(ctx context.Context, lcs []*LinkCongestion) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	nodoc := 0
+	for _, lc := range lcs {
+		newlc := *lc
+		replaced := false
+		for lidx, l := range ms.congestion {
+			if l.Link == lc.Link && l.Day.Equal(lc.Day) {
+				newlc.LcId = l.LcId
+				ms.congestion[lidx] = &newlc
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			nodoc++
+			if newlc.LcId.IsZero() {
+				newlc.LcId = primitive.NewObjectID()
+			}
+			ms.congestion = append(ms.congestion, &newlc)
+		}
+	}
+	return nodoc, nil
+}
+
+func (ms *MemStore) QueryLinkCongestion(ctx context.Context, region string, start, end time.Time) ([]*LinkCongestion, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	lcs := make([]*LinkCongestion, 0)
+	for _, lc := range ms.congestion {
+		if lc.Region == region && !lc.Day.Before(start) && lc.Day.Before(end) {
+			newlc := *lc
+			lcs = append(lcs, &newlc)
+		}
+	}
+	return lcs, nil
+}
+
+/* fleetaction */
+
+func (ms *MemStore) InsertFleetActions(ctx context.Context, actions []*FleetAction) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, fa := range actions {
+		newfa := *fa
+		if newfa.FaId.IsZero() {
+			newfa.FaId = primitive.NewObjectID()
+		}
+		ms.fleet = append(ms.fleet, &newfa)
+	}
+	return len(actions), nil
+}
+
+func (ms *MemStore) QueryFleetActions(ctx context.Context, region string, start, end time.Time) ([]*FleetAction, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	actions := make([]*FleetAction, 0)
+	for _, fa := range ms.fleet {
+		if (region == "" || fa.Region == region) && !fa.Ts.Before(start) && fa.Ts.Before(end) {
+			newfa := *fa
+			actions = append(actions, &newfa)
+		}
+	}
+	return actions, nil
+}
+
+/* runs */
+
+//copyRun copies the slices and maps of run too, they are filled while the run goes on
+func copyRun(run *Run) *Run {
+	newrun := *run
+	newrun.Args = append([]string(nil), run.Args...)
+	newrun.Inputs = append([]RunInput(nil), run.Inputs...)
+	newrun.Flags = make(map[string]string, len(run.Flags))
+	for k, v := range run.Flags {
+		newrun.Flags[k] = v
+	}
+	newrun.Counts = make(map[string]int, len(run.Counts))
+	for k, v := range run.Counts {
+		newrun.Counts[k] = v
+	}
+	newrun.VMs = make([]RunVM, len(run.VMs))
+	for vidx, vm := range run.VMs {
+		newrun.VMs[vidx] = vm
+		newrun.VMs[vidx].Counts = make(map[string]int, len(vm.Counts))
+		for k, v := range vm.Counts {
+			newrun.VMs[vidx].Counts[k] = v
+		}
+	}
+	return &newrun
+}
+
+func (ms *MemStore) InsertRun(ctx context.Context, run *Run) (primitive.ObjectID, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if run.RunId.IsZero() {
+		run.RunId = primitive.NewObjectID()
+	}
+	ms.runs = append(ms.runs, copyRun(run))
+	return run.RunId, nil
+}
+
+func (ms *MemStore) UpdateRun(ctx context.Context, run *Run) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for ridx, r := range ms.runs {
+		if r.RunId == run.RunId {
+			ms.runs[ridx] = copyRun(run)
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (ms *MemStore) QueryRuns(ctx context.Context, command string, start, end time.Time) ([]*Run, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	runs := make([]*Run, 0)
+	for ridx := len(ms.runs) - 1; ridx >= 0; ridx-- {
+		r := ms.runs[ridx]
+		if (command == "" || r.Command == command) && !r.Start.Before(start) && r.Start.Before(end) {
+			runs = append(runs, copyRun(r))
+		}
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Start.After(runs[j].Start) })
+	return runs, nil
+}
+
+func (ms *MemStore) QueryRunbyId(ctx context.Context, runid primitive.ObjectID) (*Run, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, r := range ms.runs {
+		if r.RunId == runid {
+			return copyRun(r), nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+/* datastatus */
+
+func (ms *MemStore) QueryDataStatus(ctx context.Context, mon string) (*VMDataStatus, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if status, sexist := ms.datastatus[mon]; sexist {
+		newstatus := *status
+		return &newstatus, nil
+	}
+	return &VMDataStatus{}, nil
+}
+
+func (ms *MemStore) UpdateDataStatus(ctx context.Context, vmstatus *VMDataStatus) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	newstatus := VMDataStatus{Mon: vmstatus.Mon}
+	if status, sexist := ms.datastatus[vmstatus.Mon]; sexist {
+		newstatus = *status
+	}
+	if len(vmstatus.BdrmapFile) > 0 {
+		newstatus.BdrmapFile = vmstatus.BdrmapFile
+	}
+	if len(vmstatus.TraceFile) > 0 {
+		newstatus.TraceFile = vmstatus.TraceFile
+	}
+	if len(vmstatus.SpeedFile) > 0 {
+		newstatus.SpeedFile = vmstatus.SpeedFile
+	}
+	ms.datastatus[vmstatus.Mon] = &newstatus
+	return nil
+}
+
+/* vminfo */
+
+func (ms *MemStore) UpsertVMs(ctx context.Context, collection string, VMs []VMInfo) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, vm := range VMs {
+		replaced := false
+		for vidx, v := range ms.vms[collection] {
+			if v.ID == vm.ID {
+				ms.vms[collection][vidx] = vm
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			ms.vms[collection] = append(ms.vms[collection], vm)
+		}
+	}
+	return nil
+}
+
+func (ms *MemStore) UpdateVMState(ctx context.Context, collection string, state string, instanceID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for vidx, v := range ms.vms[collection] {
+		if v.ID == instanceID {
+			ms.vms[collection][vidx].Status = state
+			break
+		}
+	}
+	return nil
+}
+
+func (ms *MemStore) QueryVMByName(ctx context.Context, collection string, name string) (*VMInfo, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, v := range ms.vms[collection] {
+		if v.Name == name {
+			vm := v
+			return &vm, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (ms *MemStore) QueryVMs(ctx context.Context, collection string) ([]VMInfo, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return append([]VMInfo(nil), ms.vms[collection]...), nil
+}
+
+func (ms *MemStore) DeleteVM(ctx context.Context, collection string, id string) error {
+	return ms.filterVMs(collection, func(v VMInfo) bool { return v.ID != id })
+}
+
+func (ms *MemStore) ClearVMs(ctx context.Context, collection string, vmtype string) error {
+	return ms.filterVMs(collection, func(v VMInfo) bool { return v.Type != vmtype })
+}
+
+//keep only the vms for which keep returns true
+func (ms *MemStore) filterVMs(collection string, keep func(VMInfo) bool) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	kept := make([]VMInfo, 0, len(ms.vms[collection]))
+	for _, v := range ms.vms[collection] {
+		if keep(v) {
+			kept = append(kept, v)
+		}
+	}
+	ms.vms[collection] = kept
+	return nil
+}
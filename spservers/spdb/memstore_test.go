@@ -0,0 +1,309 @@
+package spdb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//loading the files of a VM again replaces their records
+func TestInsertManySpeedResultsReload(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStore()
+	link := primitive.NewObjectID()
+	first := []*SpeedResult{
+		{Mon: "aws-useast-1", Type: "ookla", File: "ookla_1600000000.json", Ts: 1600000000, Link: link, Download: 90},
+		{Mon: "aws-useast-1", Type: "comcast", File: "comcast_1600000000.json", Ts: 1600000000, Link: link, Download: 80},
+	}
+	if n, err := ms.InsertManySpeedResults(ctx, first); n != 2 || err != nil {
+		t.Fatalf("first insert = %d, %v", n, err)
+	}
+	again := []*SpeedResult{
+		{Mon: "aws-useast-1", Type: "ookla", File: "ookla_1600000000.json", Ts: 1600000000, Link: link, Download: 95},
+		//same file on another VM
+		{Mon: "gcp-uscentral-1", Type: "ookla", File: "ookla_1600000000.json", Ts: 1600000000, Link: link, Download: 70},
+	}
+	if n, err := ms.InsertManySpeedResults(ctx, again); n != 1 || err != nil {
+		t.Fatalf("second insert = %d, %v, want 1 new record", n, err)
+	}
+	results, err := ms.QuerySpeedResultsbyLink(ctx, []primitive.ObjectID{link}, 0, 1700000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for _, r := range results {
+		if r.ResultId.IsZero() {
+			t.Errorf("result %s %s has no id", r.Mon, r.File)
+		}
+		if r.Mon == "aws-useast-1" && r.Type == "ookla" && r.Download != 95 {
+			t.Errorf("reloaded result download = %v, want 95", r.Download)
+		}
+	}
+}
+
+const testRegion = "aws-useast-1"
+
+func testId(t *testing.T, hex string) primitive.ObjectID {
+	t.Helper()
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+//testLinks stores a link of testRegion for every far ip and returns their ids
+func testLinks(t *testing.T, ms *MemStore, farips ...string) []primitive.ObjectID {
+	t.Helper()
+	ctx := context.Background()
+	linkmap := make(map[string]*Link)
+	for _, farip := range farips {
+		linkmap["10.0.0.1-"+farip] = &Link{NearIP: "10.0.0.1", FarIP: farip, FarAS: "64500"}
+	}
+	if err := ms.UpdateLinkstoMongo(ctx, testRegion, 1700000000, linkmap); err != nil {
+		t.Fatal(err)
+	}
+	ids := []primitive.ObjectID{}
+	for _, farip := range farips {
+		link, err := ms.QueryLinkbyKey(ctx, testRegion, "10.0.0.1-"+farip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, link.LinkId)
+	}
+	return ids
+}
+
+//a traceroute to the server that ends after len(rtts) hops, every hop in its own AS
+func testTrace(region string, linkid, spid primitive.ObjectID, ts int64, rtts ...float64) *Traceroute {
+	tr := &Traceroute{TrId: primitive.NewObjectID(), Region: region, LinkId: linkid, SpServerId: spid, Ts: ts}
+	for ridx, rtt := range rtts {
+		tr.Hops = append(tr.Hops, TrHop{Addr: "192.0.2.1", ProbeTTL: ridx + 1, Rtt: rtt, Asn: string(rune('a' + ridx))})
+	}
+	return tr
+}
+
+func TestQueryLinksSpServerMatch(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStore()
+	links := testLinks(t, ms, "198.51.100.1", "198.51.100.2")
+	//traceroutes of a link that is gone from the links collection
+	gone := primitive.NewObjectID()
+	s1, s2 := testId(t, "000000000000000000000001"), testId(t, "000000000000000000000002")
+	trs := []*Traceroute{
+		testTrace(testRegion, links[0], s1, 1600000000, 1, 5),
+		testTrace(testRegion, links[1], s2, 1600000000, 1, 5),
+		testTrace(testRegion, links[0], s2, 1600000100, 1, 5),
+		testTrace(testRegion, links[0], s1, 1600000200, 1, 5),
+		testTrace(testRegion, gone, s1, 1600000000, 1, 5),
+		//before startts, without a link and of another region
+		testTrace(testRegion, links[0], s2, 1500000000, 1, 5),
+		testTrace(testRegion, primitive.NilObjectID, s1, 1600000000, 1, 5),
+		testTrace("gcp-uscentral-1", links[0], s1, 1600000000, 1, 5),
+	}
+	if _, err := ms.InsertManyTraceroutes(ctx, trs); err != nil {
+		t.Fatal(err)
+	}
+	outputch := make(chan *LinkSpAgg)
+	go func() {
+		if err := ms.QueryLinksSpServerMatch(ctx, testRegion, 1600000000, outputch); err != nil {
+			t.Error(err)
+		}
+		close(outputch)
+	}()
+	groups := make(map[primitive.ObjectID]*LinkSpAgg)
+	for agg := range outputch {
+		if _, dup := groups[agg.Groupid.Linkid]; dup {
+			t.Errorf("link %s grouped twice", agg.Groupid.Linkid.Hex())
+		}
+		groups[agg.Groupid.Linkid] = agg
+	}
+	want := map[primitive.ObjectID]struct {
+		servers []primitive.ObjectID
+		trs     []primitive.ObjectID
+		farip   string
+	}{
+		links[0]: {[]primitive.ObjectID{s1, s2, s1}, []primitive.ObjectID{trs[0].TrId, trs[2].TrId, trs[3].TrId}, "198.51.100.1"},
+		links[1]: {[]primitive.ObjectID{s2}, []primitive.ObjectID{trs[1].TrId}, "198.51.100.2"},
+		gone:     {[]primitive.ObjectID{s1}, []primitive.ObjectID{trs[4].TrId}, ""},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("got %d groups, want %d", len(groups), len(want))
+	}
+	for linkid, w := range want {
+		agg := groups[linkid]
+		if agg == nil {
+			t.Errorf("no group of link %s", linkid.Hex())
+			continue
+		}
+		if !reflect.DeepEqual(agg.SpServerIds, w.servers) || !reflect.DeepEqual(agg.TrIds, w.trs) {
+			t.Errorf("link %s servers %v traceroutes %v, want %v %v", linkid.Hex(), agg.SpServerIds, agg.TrIds, w.servers, w.trs)
+		}
+		switch {
+		case len(w.farip) == 0 && len(agg.LinkObj) != 0:
+			t.Errorf("link %s that is gone has link %+v", linkid.Hex(), agg.LinkObj)
+		case len(w.farip) > 0 && (len(agg.LinkObj) != 1 || agg.LinkObj[0].FarIP != w.farip):
+			t.Errorf("link %s has link %+v, want far ip %s", linkid.Hex(), agg.LinkObj, w.farip)
+		}
+	}
+}
+
+func TestQueryAllEnabledSpeedMeas(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStore()
+	servers := []SpeedServer{
+		{SpId: testId(t, "000000000000000000000001"), Type: "ookla", Id: "1", IPv4: "192.0.2.1", Enabled: true},
+		{SpId: testId(t, "000000000000000000000002"), Type: "ookla", Id: "2", IPv4: "192.0.2.2", Enabled: true},
+	}
+	if _, err := ms.InsertServers(ctx, servers); err != nil {
+		t.Fatal(err)
+	}
+	links := testLinks(t, ms, "198.51.100.1")
+	smeas := []*SpeedMeas{
+		{Mon: "aws-useast-1", SpeedServer: servers[0].SpId, Link: links[0], Enabled: true},
+		{Mon: "aws-useast-2", SpeedServer: servers[1].SpId, Link: links[0], Enabled: false},
+		//the server and the link are gone
+		{Mon: "aws-useast-3", SpeedServer: primitive.NewObjectID(), Link: primitive.NewObjectID(), Enabled: true},
+		{Mon: "gcp-uscentral-1", SpeedServer: servers[1].SpId, Link: links[0], Enabled: true},
+	}
+	if _, err := ms.InsertManySpeedMeas(ctx, smeas); err != nil {
+		t.Fatal(err)
+	}
+	aggs, err := ms.QueryAllEnabledSpeedMeas(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		mon    string
+		server string
+		link   bool
+	}{{"aws-useast-1", "1", true}, {"aws-useast-3", "", false}, {"gcp-uscentral-1", "2", true}}
+	if len(aggs) != len(want) {
+		t.Fatalf("got %d assignments, want %d", len(aggs), len(want))
+	}
+	for aidx, w := range want {
+		agg := aggs[aidx]
+		if agg.Mon != w.mon {
+			t.Errorf("assignment %d mon %s, want %s", aidx, agg.Mon, w.mon)
+		}
+		//the lookups are never nil, as the ones of mongo
+		if agg.SpserverInfo == nil || agg.LinkInfo == nil {
+			t.Errorf("assignment %s lookups %v %v", agg.Mon, agg.SpserverInfo, agg.LinkInfo)
+		}
+		switch {
+		case len(w.server) == 0 && len(agg.SpserverInfo) != 0:
+			t.Errorf("assignment %s found server %+v of a gone server", agg.Mon, agg.SpserverInfo)
+		case len(w.server) > 0 && (len(agg.SpserverInfo) != 1 || agg.SpserverInfo[0].Id != w.server):
+			t.Errorf("assignment %s servers %+v, want %s", agg.Mon, agg.SpserverInfo, w.server)
+		}
+		if w.link != (len(agg.LinkInfo) == 1 && agg.LinkInfo[0].LinkId == links[0]) {
+			t.Errorf("assignment %s links %+v", agg.Mon, agg.LinkInfo)
+		}
+	}
+}
+
+func TestLinkServerStats(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStore()
+	links := testLinks(t, ms, "198.51.100.1", "198.51.100.2")
+	sa, sb := testId(t, "000000000000000000000002"), testId(t, "000000000000000000000001")
+	//the hops of a traceroute need not be in probe ttl order
+	unordered := testTrace(testRegion, links[0], sa, 1600000000, 1, 20)
+	unordered.Hops[0], unordered.Hops[1] = unordered.Hops[1], unordered.Hops[0]
+	noas := testTrace(testRegion, links[0], sa, 1600000000, 1, 2, 30)
+	for hidx := range noas.Hops {
+		noas.Hops[hidx].Asn = ""
+	}
+	trs := []*Traceroute{
+		testTrace(testRegion, links[0], sa, 1600000000, 1, 5, 40),
+		testTrace(testRegion, links[0], sa, 1600000000, 1, 10),
+		unordered,
+		noas,
+		//a single hop has no destination rtt but is a trace
+		testTrace(testRegion, links[0], sa, 1600000000, 1),
+		testTrace(testRegion, links[0], sb, 1600000000, 1, 5),
+		//another link, before startts and another region
+		testTrace(testRegion, links[1], sa, 1600000000, 1, 2),
+		testTrace(testRegion, links[0], sa, 1500000000, 1, 2),
+		testTrace("gcp-uscentral-1", links[0], sa, 1600000000, 1, 2),
+	}
+	if _, err := ms.InsertManyTraceroutes(ctx, trs); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := ms.LinkServerStats(ctx, testRegion, links[0], 1600000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//ordered by server id, percentiles are the empirical ones of rtts 10, 20, 30, 40
+	want := []ServerTraceStats{
+		{SpServerId: sb, Traces: 1, RttCount: 1, MinRtt: 5, MedianRtt: 5, P90Rtt: 5, MinASPathLen: 2, MaxASPathLen: 2},
+		{SpServerId: sa, Traces: 5, RttCount: 4, MinRtt: 10, MedianRtt: 20, P90Rtt: 40, MinASPathLen: 1, MaxASPathLen: 3},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("got %d servers, want %d", len(stats), len(want))
+	}
+	for sidx, w := range want {
+		if *stats[sidx] != w {
+			t.Errorf("server %d = %+v, want %+v", sidx, *stats[sidx], w)
+		}
+	}
+	if stats, err := ms.LinkServerStats(ctx, testRegion, primitive.NewObjectID(), 0); err != nil || len(stats) != 0 {
+		t.Errorf("stats of an unknown link = %v, %v", stats, err)
+	}
+}
+
+//updatebdrmap, updatetr and updatespeed of the same vm do not overwrite each other
+func TestUpdateDataStatus(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStore()
+	//updatespeed reads the status, updatetr stores a file meanwhile
+	if err := ms.UpdateDataStatus(ctx, &VMDataStatus{Mon: "aws-useast-1", TraceFile: "trace_1600000000.warts.tar.bz2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.UpdateDataStatus(ctx, &VMDataStatus{Mon: "aws-useast-1", SpeedFile: "ookla_1600000100.json"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.UpdateDataStatus(ctx, &VMDataStatus{Mon: "aws-useast-1", TraceFile: "trace_1600000200.warts.tar.bz2"}); err != nil {
+		t.Fatal(err)
+	}
+	status, err := ms.QueryDataStatus(ctx, "aws-useast-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := VMDataStatus{Mon: "aws-useast-1", TraceFile: "trace_1600000200.warts.tar.bz2", SpeedFile: "ookla_1600000100.json"}
+	if *status != want {
+		t.Errorf("status = %+v, want %+v", *status, want)
+	}
+}
+
+//assignments are grouped by region and server, a mon that is not a vm name is skipped
+func TestQueryMapSpeedMeas(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStore()
+	spid := testId(t, "000000000000000000000001")
+	smeas := []*SpeedMeas{
+		{Mon: "aws-useast-1", SpeedServer: spid, Enabled: true},
+		{Mon: "aws-useast-2", SpeedServer: spid, Enabled: true},
+		{Mon: "gcp-uscentral-1", SpeedServer: spid, Enabled: true},
+		{Mon: "localhost", SpeedServer: spid, Enabled: true},
+	}
+	if _, err := ms.InsertManySpeedMeas(ctx, smeas); err != nil {
+		t.Fatal(err)
+	}
+	smeasmap, err := ms.QueryMapSpeedMeas(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]int)
+	for key, sms := range smeasmap {
+		got[key] = len(sms)
+	}
+	want := map[string]int{"aws-useast:" + spid.Hex(): 2, "gcp-uscentral:" + spid.Hex(): 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryMapSpeedMeas = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,394 @@
+package spdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//version of the documents of each collection
+const Colschema = "schema"
+
+//documents replaced per bulk write of a backfill
+const backfillBatch = 500
+
+//an index the queries of a collection need
+type IndexSpec struct {
+	Collection string
+	Keys       bson.D
+}
+
+//Name is the name mongo gives the index by default, e.g. region_1_ts_-1
+func (is IndexSpec) Name() string {
+	parts := make([]string, 0, 2*len(is.Keys))
+	for _, k := range is.Keys {
+		parts = append(parts, k.Key, fmt.Sprint(k.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+//Indexes of the speedtest database, every entry follows the filter of a query
+var Indexes = []IndexSpec{
+	//InsertServers, ResetEnable, QueryEnabledServersbyType
+	{Colserver, bson.D{{"type", 1}, {"id", 1}}},
+	{Colserver, bson.D{{"type", 1}, {"enabled", 1}}},
+	//QueryServersbyIPv4, once per traceroute
+	{Colserver, bson.D{{"ipv4", 1}}},
+	//QueryServersNear, QueryServersInPolygon
+	{Colserver, bson.D{{"location", "2dsphere"}}},
+	//UpdateLinkstoMongo, QueryLinkbyKey, CreateLinkmap
+	{Collinks, bson.D{{"region", 1}, {"linkkey", 1}}},
+	//QueryLinkbyFar
+	{Collinks, bson.D{{"farip", 1}}},
+	{Colrouters, bson.D{{"region", 1}, {"ts", -1}}},
+	{Collinkevents, bson.D{{"ts", 1}, {"region", 1}}},
+	{Colserverhist, bson.D{{"ts", 1}, {"type", 1}}},
+	//QueryLinksSpServerMatch
+	{Coltraceroute, bson.D{{"region", 1}, {"ts", 1}, {"linkid", 1}}},
+	{Coltraceroute, bson.D{{"linkid", 1}, {"ts", 1}}},
+	{Coltraceroute, bson.D{{"region", 1}, {"spserverid", 1}}},
+	{Colspeedmeas, bson.D{{"mon", 1}, {"speedserver", 1}, {"link", 1}}},
+	{Colspeedmeas, bson.D{{"speedserver", 1}}},
+	{Colspeedmeas, bson.D{{"enabled", 1}}},
+	{Colspeedresult, bson.D{{"link", 1}, {"ts", 1}}},
+	//InsertManySpeedResults
+	{Colspeedresult, bson.D{{"mon", 1}, {"type", 1}, {"file", 1}}},
+	{Colcongestion, bson.D{{"link", 1}, {"day", 1}}},
+	{Colcongestion, bson.D{{"region", 1}, {"day", 1}}},
+	{Colfleetaction, bson.D{{"ts", 1}, {"region", 1}}},
+	{Colruns, bson.D{{"start", -1}, {"command", 1}}},
+	{Coldatastatus, bson.D{{"mon", 1}}},
+}
+
+//a change of the document shape of a collection. Apply changes one document in
+//place and tells whether it changed, it must leave a migrated document alone so a
+//backfill can be run again after it failed halfway. Documents come from mongo or
+//from a MemStore snapshot, so numbers may be int32, int64 or float64.
+type Migration struct {
+	Collection  string
+	Version     int
+	Description string
+	Apply       func(doc map[string]interface{}) bool
+}
+
+//Migrations of the speedtest database, in the order they are applied. Add new
+//ones at the end with the next version of their collection
+var Migrations = []Migration{
+	{Colserver, 1, "drop the 999,999 location crawlers stored for an unknown place", clearInvalidLocation},
+	{Collinks, 1, "lastseen from unix seconds to dates", lastSeenToTime},
+}
+
+func clearInvalidLocation(doc map[string]interface{}) bool {
+	loc, lexist := doc["location"]
+	if !lexist {
+		return false
+	}
+	coords, _ := docField(loc, "coordinates")
+	if c := docArray(coords); len(c) == 2 {
+		lon, lonok := docNumber(c[0])
+		lat, latok := docNumber(c[1])
+		if lonok && latok && NewPoint(lon, lat).Valid() {
+			return false
+		}
+	}
+	delete(doc, "location")
+	return true
+}
+
+func lastSeenToTime(doc map[string]interface{}) bool {
+	seen := docArray(doc["lastseen"])
+	changed := false
+	times := make([]interface{}, len(seen))
+	for sidx, ts := range seen {
+		times[sidx] = ts
+		if secs, isnum := docNumber(ts); isnum {
+			times[sidx] = time.Unix(int64(secs), 0).UTC()
+			changed = true
+		}
+	}
+	if changed {
+		doc["lastseen"] = times
+	}
+	return changed
+}
+
+func docField(v interface{}, key string) (interface{}, bool) {
+	switch d := v.(type) {
+	case map[string]interface{}:
+		f, fexist := d[key]
+		return f, fexist
+	case primitive.M:
+		f, fexist := d[key]
+		return f, fexist
+	case primitive.D:
+		for _, e := range d {
+			if e.Key == key {
+				return e.Value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func docArray(v interface{}) []interface{} {
+	switch a := v.(type) {
+	case []interface{}:
+		return a
+	case primitive.A:
+		return a
+	}
+	return nil
+}
+
+func docNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+//SchemaVersion is the version the documents of collection have once migrated
+func SchemaVersion(collection string) int {
+	version := 0
+	for _, m := range Migrations {
+		if m.Collection == collection && m.Version > version {
+			version = m.Version
+		}
+	}
+	return version
+}
+
+//CurrentSchema is SchemaVersion of every collection with a migration
+func CurrentSchema() map[string]int {
+	versions := make(map[string]int)
+	for _, m := range Migrations {
+		versions[m.Collection] = SchemaVersion(m.Collection)
+	}
+	return versions
+}
+
+//a step Migrate took, or would take in a dry run
+type MigrationStep struct {
+	Collection string
+	//index or backfill
+	Kind string
+	//the index name or the migration description
+	Name    string
+	Version int
+	//documents the backfill changed
+	Docs    int
+	Applied bool
+}
+
+func (step MigrationStep) String() string {
+	if step.Kind == "index" {
+		return fmt.Sprintf("%-16s index    %s", step.Collection, step.Name)
+	}
+	return fmt.Sprintf("%-16s backfill v%d %s, %d documents", step.Collection, step.Version, step.Name, step.Docs)
+}
+
+type schemaDoc struct {
+	Collection string    `bson:"_id"`
+	Version    int       `bson:"version"`
+	Updated    time.Time `bson:"updated"`
+}
+
+func (cm *SpeedtestMongo) SchemaVersions(ctx context.Context) (map[string]int, error) {
+	if cm.Database != nil {
+		cur, err := cm.Database.Collection(Colschema).Find(ctx, bson.D{})
+		if err != nil {
+			return nil, fmt.Errorf("%w: read schema versions: %v", ErrDBUnavailable, err)
+		}
+		var docs []schemaDoc
+		if err := cur.All(ctx, &docs); err != nil {
+			return nil, err
+		}
+		versions := make(map[string]int)
+		for _, d := range docs {
+			versions[d.Collection] = d.Version
+		}
+		return versions, nil
+	}
+	return nil, ErrDBUnavailable
+}
+
+//Migrate runs the backfills the collections have not had yet, then creates the
+//missing indexes, the backfills first so an index never sees an old document.
+//It is idempotent, a second run finds nothing to do. A dry run counts the
+//documents the backfills would change and lists the missing indexes.
+func (cm *SpeedtestMongo) Migrate(ctx context.Context, dryrun bool) ([]MigrationStep, error) {
+	versions, err := cm.SchemaVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	steps := []MigrationStep{}
+	for _, m := range Migrations {
+		if versions[m.Collection] >= m.Version {
+			continue
+		}
+		n, err := cm.backfill(ctx, m, dryrun)
+		steps = append(steps, MigrationStep{Collection: m.Collection, Kind: "backfill", Name: m.Description, Version: m.Version, Docs: n, Applied: !dryrun && err == nil})
+		if err != nil {
+			return steps, fmt.Errorf("backfill %s v%d: %w", m.Collection, m.Version, err)
+		}
+		if dryrun {
+			//later migrations of the collection expect this one applied
+			versions[m.Collection] = m.Version
+			continue
+		}
+		if err := cm.setSchemaVersion(ctx, m.Collection, m.Version); err != nil {
+			return steps, err
+		}
+		versions[m.Collection] = m.Version
+	}
+	existing := make(map[string]map[string]bool)
+	for _, is := range Indexes {
+		if _, cexist := existing[is.Collection]; !cexist {
+			names, err := cm.indexNames(ctx, is.Collection)
+			if err != nil {
+				return steps, err
+			}
+			existing[is.Collection] = names
+		}
+		if existing[is.Collection][is.Name()] {
+			continue
+		}
+		step := MigrationStep{Collection: is.Collection, Kind: "index", Name: is.Name()}
+		if !dryrun {
+			model := mongo.IndexModel{Keys: is.Keys, Options: options.Index().SetName(is.Name())}
+			if _, err := cm.Database.Collection(is.Collection).Indexes().CreateOne(ctx, model); err != nil {
+				return append(steps, step), fmt.Errorf("create index %s on %s: %w", is.Name(), is.Collection, err)
+			}
+			step.Applied = true
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func (cm *SpeedtestMongo) backfill(ctx context.Context, m Migration, dryrun bool) (int, error) {
+	coll := cm.Database.Collection(m.Collection)
+	cur, err := coll.Find(ctx, bson.D{})
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+	n := 0
+	models := []mongo.WriteModel{}
+	flush := func() error {
+		if len(models) == 0 || dryrun {
+			return nil
+		}
+		_, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		models = models[:0]
+		return err
+	}
+	for cur.Next(ctx) {
+		doc := make(map[string]interface{})
+		if err := cur.Decode(&doc); err != nil {
+			return n, err
+		}
+		if !m.Apply(doc) {
+			continue
+		}
+		n++
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.D{{"_id", doc["_id"]}}).SetReplacement(doc))
+		if len(models) >= backfillBatch {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return n, err
+	}
+	return n, flush()
+}
+
+func (cm *SpeedtestMongo) setSchemaVersion(ctx context.Context, collection string, version int) error {
+	csch := cm.Database.Collection(Colschema)
+	doc := schemaDoc{Collection: collection, Version: version, Updated: time.Now().UTC()}
+	_, err := csch.ReplaceOne(ctx, bson.D{{"_id", collection}}, doc, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("%w: set schema version of %s: %v", ErrDBUnavailable, collection, err)
+	}
+	return nil
+}
+
+func (cm *SpeedtestMongo) indexNames(ctx context.Context, collection string) (map[string]bool, error) {
+	cur, err := cm.Database.Collection(collection).Indexes().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: list indexes of %s: %v", ErrDBUnavailable, collection, err)
+	}
+	var specs []bson.M
+	if err := cur.All(ctx, &specs); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, spec := range specs {
+		if name, isstr := spec["name"].(string); isstr {
+			names[name] = true
+		}
+	}
+	return names, nil
+}
+
+//migrateSnapshot applies the migrations a MemStore snapshot has not had to its
+//collections, raw is the snapshot file by collection
+func migrateSnapshot(raw map[string]json.RawMessage) error {
+	versions := make(map[string]int)
+	if schema, sexist := raw["schema"]; sexist {
+		if err := json.Unmarshal(schema, &versions); err != nil {
+			return fmt.Errorf("schema: %w", err)
+		}
+	}
+	applied := []string{}
+	for _, m := range Migrations {
+		if versions[m.Collection] >= m.Version {
+			continue
+		}
+		versions[m.Collection] = m.Version
+		coll, cexist := raw[m.Collection]
+		if !cexist {
+			continue
+		}
+		var docs []map[string]interface{}
+		if err := json.Unmarshal(coll, &docs); err != nil {
+			return fmt.Errorf("%s: %w", m.Collection, err)
+		}
+		n := 0
+		for _, doc := range docs {
+			if doc != nil && m.Apply(doc) {
+				n++
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		data, err := json.Marshal(docs)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.Collection, err)
+		}
+		raw[m.Collection] = data
+		applied = append(applied, fmt.Sprintf("%s v%d (%d documents)", m.Collection, m.Version, n))
+	}
+	if len(applied) > 0 {
+		log.Println("Migrated memory store", strings.Join(applied, ", "))
+	}
+	return nil
+}
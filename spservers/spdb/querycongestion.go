@@ -0,0 +1,48 @@
+package spdb
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//one record per link and day, reruns replace the previous scores
+func (cm *SpeedtestMongo) UpsertLinkCongestion(ctx context.Context, lcs []*LinkCongestion) (int, error) {
+	if cm.Database != nil {
+		ccg := cm.Database.Collection(Colcongestion)
+		opts := options.FindOneAndReplace().SetUpsert(true)
+		nodoc := 0
+		for _, lc := range lcs {
+			filter := bson.D{{"link", lc.Link}, {"day", lc.Day}}
+			err := ccg.FindOneAndReplace(ctx, filter, lc, opts).Err()
+			if err != nil {
+				if err != mongo.ErrNoDocuments {
+					return nodoc, err
+				}
+				nodoc++
+			}
+		}
+		return nodoc, nil
+	}
+	return -1, ErrDBUnavailable
+}
+
+func (cm *SpeedtestMongo) QueryLinkCongestion(ctx context.Context, region string, start, end time.Time) ([]*LinkCongestion, error) {
+	if cm.Database != nil {
+		ccg := cm.Database.Collection(Colcongestion)
+		filter := bson.D{{"region", region}, {"day", bson.D{{"$gte", start}, {"$lt", end}}}}
+		var lcs []*LinkCongestion
+		cur, err := ccg.Find(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		if err = cur.All(ctx, &lcs); err != nil {
+			return nil, err
+		}
+		return lcs, nil
+	}
+	return nil, ErrDBUnavailable
+}
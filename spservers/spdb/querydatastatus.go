@@ -2,21 +2,20 @@ package spdb
 
 import (
 	"context"
-	"errors"
-	"log"
+	"fmt"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func (cm *SpeedtestMongo) QueryDataStatus(mon string) (*VMDataStatus, error) {
+func (cm *SpeedtestMongo) QueryDataStatus(ctx context.Context, mon string) (*VMDataStatus, error) {
 	if cm.Database != nil {
 		cdata := cm.Database.Collection(Coldatastatus)
 		filter := bson.D{{"mon", mon}}
 		var status VMDataStatus
 		var err error
-		res := cdata.FindOne(context.TODO(), filter)
+		res := cdata.FindOne(ctx, filter)
 		if res.Err() == mongo.ErrNoDocuments {
 			return &VMDataStatus{}, nil
 		} else {
@@ -24,20 +23,42 @@ func (cm *SpeedtestMongo) QueryDataStatus(mon string) (*VMDataStatus, error) {
 		}
 		return &status, err
 	} else {
-		return nil, errors.New("Database is nil")
+		return nil, ErrDBUnavailable
 	}
 }
 
-func (cm *SpeedtestMongo) UpdateDataStatus(vmstatus *VMDataStatus) {
-	cdata := cm.Database.Collection(Coldatastatus)
-	opt := options.FindOneAndReplace().SetUpsert(true)
-	filter := bson.D{{"mon", vmstatus.Mon}}
-	res := cdata.FindOneAndReplace(context.TODO(), filter, vmstatus, opt)
-	if res.Err() != nil {
-		if res.Err() != mongo.ErrNoDocuments {
-			//	log.Println("Server not found", ser.Type, ser.Id)
-			log.Fatal(res.Err())
-		}
+//the fields of vmstatus that are set, updatebdrmap, updatetr and updatespeed
+//each record their own file of the vm
+func dataStatusFields(vmstatus *VMDataStatus) bson.D {
+	fields := bson.D{}
+	if len(vmstatus.BdrmapFile) > 0 {
+		fields = append(fields, bson.E{"bdrmapfile", vmstatus.BdrmapFile})
+	}
+	if len(vmstatus.TraceFile) > 0 {
+		fields = append(fields, bson.E{"tracefile", vmstatus.TraceFile})
+	}
+	if len(vmstatus.SpeedFile) > 0 {
+		fields = append(fields, bson.E{"speedfile", vmstatus.SpeedFile})
 	}
+	return fields
+}
 
+//UpdateDataStatus sets the files of vmstatus that are not empty and leaves the
+//others alone, so a command does not overwrite what another one stored since
+//it read the status
+func (cm *SpeedtestMongo) UpdateDataStatus(ctx context.Context, vmstatus *VMDataStatus) error {
+	if cm.Database != nil {
+		fields := dataStatusFields(vmstatus)
+		if len(fields) == 0 {
+			return nil
+		}
+		cdata := cm.Database.Collection(Coldatastatus)
+		opt := options.Update().SetUpsert(true)
+		filter := bson.D{{"mon", vmstatus.Mon}}
+		if _, err := cdata.UpdateOne(ctx, filter, bson.D{{"$set", fields}}, opt); err != nil {
+			return fmt.Errorf("%w: update data status of %s: %v", ErrDBUnavailable, vmstatus.Mon, err)
+		}
+		return nil
+	}
+	return ErrDBUnavailable
 }
@@ -0,0 +1,52 @@
+package spdb
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (cm *SpeedtestMongo) InsertFleetActions(ctx context.Context, actions []*FleetAction) (int, error) {
+	if cm.Database != nil {
+		if len(actions) > 0 {
+			cfa := cm.Database.Collection(Colfleetaction)
+			opts := options.InsertMany().SetOrdered(false)
+			islice := make([]interface{}, len(actions))
+			for aidx, _ := range actions {
+				islice[aidx] = actions[aidx]
+			}
+			res, err := cfa.InsertMany(ctx, islice, opts)
+			if err != nil {
+				log.Println(err)
+				return 0, err
+			}
+			return len(res.InsertedIDs), nil
+		}
+		return 0, nil
+	}
+	return -1, ErrDBUnavailable
+}
+
+//actions between start and end, of all regions if region is empty
+func (cm *SpeedtestMongo) QueryFleetActions(ctx context.Context, region string, start, end time.Time) ([]*FleetAction, error) {
+	if cm.Database != nil {
+		cfa := cm.Database.Collection(Colfleetaction)
+		filter := bson.D{{"ts", bson.D{{"$gte", start}, {"$lt", end}}}}
+		if len(region) > 0 {
+			filter = append(filter, bson.E{"region", region})
+		}
+		var actions []*FleetAction
+		cur, err := cfa.Find(ctx, filter, options.Find().SetSort(bson.D{{"ts", 1}}))
+		if err != nil {
+			return nil, err
+		}
+		if err = cur.All(ctx, &actions); err != nil {
+			return nil, err
+		}
+		return actions, nil
+	}
+	return nil, ErrDBUnavailable
+}
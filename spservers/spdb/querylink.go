@@ -3,8 +3,9 @@ package spdb
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
 	"regexp"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,8 +13,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func (cm *SpeedtestMongo) UpdateLinkstoMongo(region string, seents int64, linkmap map[string]*Link) {
-	if cm != nil {
+//mark the links of region not current, then upsert linkmap as the current links seen at seents
+func (cm *SpeedtestMongo) UpdateLinkstoMongo(ctx context.Context, region string, seents int64, linkmap map[string]*Link) error {
+	if cm.Database != nil {
 		//		basename := filepath.Base(LinkFile)
 		//		namere := regexp.MustCompile(`(\S+)\.\d+\.links\.out`)
 		//		namesplit := namere.FindStringSubmatch(LinkFile)
@@ -21,7 +23,9 @@ func (cm *SpeedtestMongo) UpdateLinkstoMongo(region string, seents int64, linkma
 		clink := cm.Database.Collection(Collinks)
 		upfilter := bson.D{{"region", region}}
 		disablecurrent := bson.D{{"$set", bson.D{{"current", false}}}}
-		clink.UpdateMany(context.TODO(), upfilter, disablecurrent)
+		if _, err := clink.UpdateMany(ctx, upfilter, disablecurrent); err != nil {
+			return fmt.Errorf("%w: reset links of %s: %v", ErrDBUnavailable, region, err)
+		}
 		for linkkey, link := range linkmap {
 			filter := bson.D{{"region", region}, {"linkkey", linkkey}}
 			lnkupdate := bson.D{{"$set",
@@ -31,13 +35,15 @@ func (cm *SpeedtestMongo) UpdateLinkstoMongo(region string, seents int64, linkma
 					{"nearip", link.NearIP},
 					{"farip", link.FarIP},
 					{"faras", link.FarAS},
+					{"nearrtr", link.NearRtr},
+					{"farrtr", link.FarRtr},
 					{"current", true},
 					{"covered", false},
 				},
 			},
 				{"$addToSet",
 					bson.D{
-						{"lastseen", seents},
+						{"lastseen", time.Unix(seents, 0).UTC()},
 					},
 				}}
 			/*			link.Region = region
@@ -46,33 +52,35 @@ func (cm *SpeedtestMongo) UpdateLinkstoMongo(region string, seents int64, linkma
 						link.Current = true
 						link.Covered = false*/
 			opt := options.Update().SetUpsert(true)
-			_, err := clink.UpdateOne(context.TODO(), filter, lnkupdate, opt)
+			_, err := clink.UpdateOne(ctx, filter, lnkupdate, opt)
 			if err != nil {
-				log.Panic(err)
+				return fmt.Errorf("%w: update link %s of %s: %v", ErrDBUnavailable, linkkey, region, err)
 			}
 		}
+		return nil
 	}
+	return ErrDBUnavailable
 }
 
-func (cm *SpeedtestMongo) QueryLinkbyId(linkid primitive.ObjectID) (*Link, error) {
+func (cm *SpeedtestMongo) QueryLinkbyId(ctx context.Context, linkid primitive.ObjectID) (*Link, error) {
 	if cm.Database != nil {
 		ldata := cm.Database.Collection(Collinks)
 		linkfilter := bson.D{{"_id", linkid}}
 		var linkobj Link
-		err := ldata.FindOne(context.TODO(), linkfilter).Decode(&linkobj)
+		err := ldata.FindOne(ctx, linkfilter).Decode(&linkobj)
 		if err != nil {
 			return nil, err
 		}
 		return &linkobj, nil
 	}
-	return nil, errors.New("Database is nil")
+	return nil, ErrDBUnavailable
 }
 
-func (cm *SpeedtestMongo) QueryLinkbyKey(region string, linkkey string) (*Link, error) {
+func (cm *SpeedtestMongo) QueryLinkbyKey(ctx context.Context, region string, linkkey string) (*Link, error) {
 	if cm.Database != nil {
 		ldata := cm.Database.Collection(Collinks)
 		linkfilter := bson.D{{"region", region}, {"linkkey", linkkey}}
-		res := ldata.FindOne(context.TODO(), linkfilter)
+		res := ldata.FindOne(ctx, linkfilter)
 		var err error
 		var linkobj Link
 		if res.Err() == mongo.ErrNoDocuments {
@@ -82,11 +90,11 @@ func (cm *SpeedtestMongo) QueryLinkbyKey(region string, linkkey string) (*Link,
 		}
 		return &linkobj, err
 	} else {
-		return nil, errors.New("Database is nil")
+		return nil, ErrDBUnavailable
 	}
 }
 
-func (cm *SpeedtestMongo) QueryLinkbyFar(region string, farip string) ([]*Link, error) {
+func (cm *SpeedtestMongo) QueryLinkbyFar(ctx context.Context, region string, farip string) ([]*Link, error) {
 	var alllinks []*Link
 	if cm.Database != nil {
 		ldata := cm.Database.Collection(Collinks)
@@ -94,41 +102,41 @@ func (cm *SpeedtestMongo) QueryLinkbyFar(region string, farip string) ([]*Link,
 		regionarr := monreg.FindStringSubmatch(region)
 		if len(regionarr) > 1 {
 			linkfilter := bson.D{{"region", bson.D{{"$regex", regionarr[1] + "*"}}}, {"farip", farip}}
-			cur, err := ldata.Find(context.TODO(), linkfilter)
-			err = cur.All(context.TODO(), &alllinks)
-			return alllinks, err
+			cur, err := ldata.Find(ctx, linkfilter)
+			if err != nil {
+				return nil, fmt.Errorf("%w: links of %s with far ip %s: %v", ErrDBUnavailable, region, farip, err)
+			}
+			if err = cur.All(ctx, &alllinks); err != nil {
+				return nil, fmt.Errorf("%w: links of %s with far ip %s: %v", ErrDBUnavailable, region, farip, err)
+			}
+			return alllinks, nil
 		}
 		return nil, errors.New("Region format is incorrect")
 	}
-	return nil, errors.New("Database is nil")
+	return nil, ErrDBUnavailable
 
 }
 
-func (cm *SpeedtestMongo) CreateLinkmap(region string) (map[string]*Link, map[string][]*Link, error) {
+func (cm *SpeedtestMongo) CreateLinkmap(ctx context.Context, region string) (map[string]*Link, map[string][]*Link, error) {
 	var alllinks []*Link
 	if cm.Database != nil {
 		lnkdata := cm.Database.Collection(Collinks)
 		linkfilter := bson.D{{"region", region}}
-		cur, err := lnkdata.Find(context.TODO(), linkfilter)
-		if cur != nil {
-			err = cur.All(context.TODO(), &alllinks)
-			if err != nil {
-				return nil, nil, err
-			}
-			linkkeymap := make(map[string]*Link)
-			faripmap := make(map[string][]*Link)
-			for linkidx, link := range alllinks {
-				linkkeymap[link.Linkkey] = alllinks[linkidx]
-				if _, lexist := faripmap[link.FarIP]; !lexist {
-					faripmap[link.FarIP] = make([]*Link, 0)
-				}
-				faripmap[link.FarIP] = append(faripmap[link.FarIP], alllinks[linkidx])
-			}
-			return linkkeymap, faripmap, nil
-		} else {
-			//no link found. empty map
-			return make(map[string]*Link), make(map[string][]*Link), nil
+		cur, err := lnkdata.Find(ctx, linkfilter)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: links of %s: %v", ErrDBUnavailable, region, err)
+		}
+		if err = cur.All(ctx, &alllinks); err != nil {
+			return nil, nil, fmt.Errorf("%w: links of %s: %v", ErrDBUnavailable, region, err)
+		}
+		//no link found gives empty maps
+		linkkeymap := make(map[string]*Link)
+		faripmap := make(map[string][]*Link)
+		for linkidx, link := range alllinks {
+			linkkeymap[link.Linkkey] = alllinks[linkidx]
+			faripmap[link.FarIP] = append(faripmap[link.FarIP], alllinks[linkidx])
 		}
+		return linkkeymap, faripmap, nil
 	}
-	return nil, nil, errors.New("Database is nil")
+	return nil, nil, ErrDBUnavailable
 }
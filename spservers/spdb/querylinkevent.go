@@ -0,0 +1,51 @@
+package spdb
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (cm *SpeedtestMongo) InsertLinkEvents(ctx context.Context, events []*LinkEvent) (int, error) {
+	if cm.Database != nil {
+		if len(events) > 0 {
+			cle := cm.Database.Collection(Collinkevents)
+			opts := options.InsertMany().SetOrdered(false)
+			islice := make([]interface{}, len(events))
+			for eidx, _ := range events {
+				islice[eidx] = events[eidx]
+			}
+			res, err := cle.InsertMany(ctx, islice, opts)
+			if err != nil {
+				log.Println(err)
+				return 0, err
+			}
+			return len(res.InsertedIDs), nil
+		}
+		return 0, nil
+	}
+	return -1, ErrDBUnavailable
+}
+
+//events of bdrmap runs between startts and endts, of all vms if region is empty
+func (cm *SpeedtestMongo) QueryLinkEvents(ctx context.Context, region string, startts, endts int64) ([]*LinkEvent, error) {
+	if cm.Database != nil {
+		cle := cm.Database.Collection(Collinkevents)
+		filter := bson.D{{"ts", bson.D{{"$gte", startts}, {"$lt", endts}}}}
+		if len(region) > 0 {
+			filter = append(filter, bson.E{"region", region})
+		}
+		var events []*LinkEvent
+		cur, err := cle.Find(ctx, filter, options.Find().SetSort(bson.D{{"ts", 1}}))
+		if err != nil {
+			return nil, err
+		}
+		if err = cur.All(ctx, &events); err != nil {
+			return nil, err
+		}
+		return events, nil
+	}
+	return nil, ErrDBUnavailable
+}
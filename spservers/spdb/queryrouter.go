@@ -0,0 +1,66 @@
+package spdb
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//replace the routers of the bdrmap run of region at ts
+func (cm *SpeedtestMongo) InsertRouters(ctx context.Context, region string, ts int64, routers []*Router) (int, error) {
+	if cm.Database != nil {
+		crtr := cm.Database.Collection(Colrouters)
+		filter := bson.D{{"region", region}, {"ts", ts}}
+		if _, err := crtr.DeleteMany(ctx, filter); err != nil {
+			return 0, err
+		}
+		if len(routers) > 0 {
+			opts := options.InsertMany().SetOrdered(false)
+			islice := make([]interface{}, len(routers))
+			for ridx, _ := range routers {
+				routers[ridx].Region = region
+				routers[ridx].Ts = ts
+				islice[ridx] = routers[ridx]
+			}
+			res, err := crtr.InsertMany(ctx, islice, opts)
+			if err != nil {
+				log.Println(err)
+				return 0, err
+			}
+			return len(res.InsertedIDs), nil
+		}
+		return 0, nil
+	}
+	return -1, ErrDBUnavailable
+}
+
+//routers of the bdrmap run of region at ts, ts 0 selects the latest run
+func (cm *SpeedtestMongo) QueryRouters(ctx context.Context, region string, ts int64) ([]*Router, error) {
+	if cm.Database != nil {
+		crtr := cm.Database.Collection(Colrouters)
+		if ts == 0 {
+			var latest Router
+			opts := options.FindOne().SetSort(bson.D{{"ts", -1}})
+			err := crtr.FindOne(ctx, bson.D{{"region", region}}, opts).Decode(&latest)
+			if err == mongo.ErrNoDocuments {
+				return []*Router{}, nil
+			} else if err != nil {
+				return nil, err
+			}
+			ts = latest.Ts
+		}
+		var routers []*Router
+		cur, err := crtr.Find(ctx, bson.D{{"region", region}, {"ts", ts}})
+		if err != nil {
+			return nil, err
+		}
+		if err = cur.All(ctx, &routers); err != nil {
+			return nil, err
+		}
+		return routers, nil
+	}
+	return nil, ErrDBUnavailable
+}
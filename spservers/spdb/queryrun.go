@@ -0,0 +1,67 @@
+package spdb
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (cm *SpeedtestMongo) InsertRun(ctx context.Context, run *Run) (primitive.ObjectID, error) {
+	if cm.Database != nil {
+		crun := cm.Database.Collection(Colruns)
+		if run.RunId.IsZero() {
+			run.RunId = primitive.NewObjectID()
+		}
+		if _, err := crun.InsertOne(ctx, run); err != nil {
+			return primitive.NilObjectID, err
+		}
+		return run.RunId, nil
+	}
+	return primitive.NilObjectID, ErrDBUnavailable
+}
+
+//replace the stored record of run, matched by its id
+func (cm *SpeedtestMongo) UpdateRun(ctx context.Context, run *Run) error {
+	if cm.Database != nil {
+		crun := cm.Database.Collection(Colruns)
+		_, err := crun.ReplaceOne(ctx, bson.D{{"_id", run.RunId}}, run)
+		return err
+	}
+	return ErrDBUnavailable
+}
+
+//runs started between start and end, newest first, of all commands if command is empty
+func (cm *SpeedtestMongo) QueryRuns(ctx context.Context, command string, start, end time.Time) ([]*Run, error) {
+	if cm.Database != nil {
+		crun := cm.Database.Collection(Colruns)
+		filter := bson.D{{"start", bson.D{{"$gte", start}, {"$lt", end}}}}
+		if len(command) > 0 {
+			filter = append(filter, bson.E{"command", command})
+		}
+		var runs []*Run
+		cur, err := crun.Find(ctx, filter, options.Find().SetSort(bson.D{{"start", -1}}))
+		if err != nil {
+			return nil, err
+		}
+		if err = cur.All(ctx, &runs); err != nil {
+			return nil, err
+		}
+		return runs, nil
+	}
+	return nil, ErrDBUnavailable
+}
+
+func (cm *SpeedtestMongo) QueryRunbyId(ctx context.Context, runid primitive.ObjectID) (*Run, error) {
+	if cm.Database != nil {
+		crun := cm.Database.Collection(Colruns)
+		var run Run
+		if err := crun.FindOne(ctx, bson.D{{"_id", runid}}).Decode(&run); err != nil {
+			return nil, err
+		}
+		return &run, nil
+	}
+	return nil, ErrDBUnavailable
+}
@@ -0,0 +1,52 @@
+package spdb
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (cm *SpeedtestMongo) InsertServerChanges(ctx context.Context, changes []*ServerChange) (int, error) {
+	if cm.Database != nil {
+		if len(changes) > 0 {
+			csh := cm.Database.Collection(Colserverhist)
+			opts := options.InsertMany().SetOrdered(false)
+			islice := make([]interface{}, len(changes))
+			for cidx := range changes {
+				islice[cidx] = changes[cidx]
+			}
+			res, err := csh.InsertMany(ctx, islice, opts)
+			if err != nil {
+				log.Println(err)
+				return 0, err
+			}
+			return len(res.InsertedIDs), nil
+		}
+		return 0, nil
+	}
+	return -1, ErrDBUnavailable
+}
+
+//changes found by the crawls between startts and endts, oldest first, of all
+//platforms if stype is empty
+func (cm *SpeedtestMongo) QueryServerChanges(ctx context.Context, stype string, startts, endts int64) ([]*ServerChange, error) {
+	if cm.Database != nil {
+		csh := cm.Database.Collection(Colserverhist)
+		filter := bson.D{{"ts", bson.D{{"$gte", startts}, {"$lt", endts}}}}
+		if len(stype) > 0 {
+			filter = append(filter, bson.E{"type", stype})
+		}
+		var changes []*ServerChange
+		cur, err := csh.Find(ctx, filter, options.Find().SetSort(bson.D{{"ts", 1}}))
+		if err != nil {
+			return nil, err
+		}
+		if err = cur.All(ctx, &changes); err != nil {
+			return nil, err
+		}
+		return changes, nil
+	}
+	return nil, ErrDBUnavailable
+}
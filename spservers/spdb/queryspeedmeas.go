@@ -3,6 +3,7 @@ package spdb
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"regexp"
 
@@ -12,7 +13,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func (cm *SpeedtestMongo) InsertManySpeedMeas(spmes []*SpeedMeas) (int, error) {
+func (cm *SpeedtestMongo) InsertManySpeedMeas(ctx context.Context, spmes []*SpeedMeas) (int, error) {
 	if cm.Database != nil {
 		if len(spmes) > 0 {
 			csm := cm.Database.Collection(Colspeedmeas)
@@ -21,7 +22,7 @@ func (cm *SpeedtestMongo) InsertManySpeedMeas(spmes []*SpeedMeas) (int, error) {
 			for smidx, _ := range spmes {
 				islice[smidx] = spmes[smidx]
 			}
-			res, err := csm.InsertMany(context.TODO(), islice, opts)
+			res, err := csm.InsertMany(ctx, islice, opts)
 			if err != nil {
 				log.Println(err)
 				return 0, err
@@ -29,10 +30,10 @@ func (cm *SpeedtestMongo) InsertManySpeedMeas(spmes []*SpeedMeas) (int, error) {
 			return len(res.InsertedIDs), nil
 		}
 	}
-	return -1, errors.New("Database is nil")
+	return -1, ErrDBUnavailable
 }
 
-func (cm *SpeedtestMongo) QuerySpeedserverExist(region string, spid primitive.ObjectID) (int, error) {
+func (cm *SpeedtestMongo) QuerySpeedserverExist(ctx context.Context, region string, spid primitive.ObjectID) (int, error) {
 	if cm.Database != nil {
 		csm := cm.Database.Collection(Colspeedmeas)
 		monreg := regexp.MustCompile(`(\w+-\w+-)\w+`)
@@ -40,7 +41,7 @@ func (cm *SpeedtestMongo) QuerySpeedserverExist(region string, spid primitive.Ob
 		if len(regionarr) > 1 {
 			var spmeas SpeedMeas
 			filter := bson.D{{"mon", bson.D{{"$regex", regionarr[1] + "*"}}}, {"speedserver", spid}}
-			err := csm.FindOne(context.TODO(), filter).Decode(&spmeas)
+			err := csm.FindOne(ctx, filter).Decode(&spmeas)
 			if err != nil {
 				if err == mongo.ErrNoDocuments {
 					return -1, nil
@@ -58,7 +59,7 @@ func (cm *SpeedtestMongo) QuerySpeedserverExist(region string, spid primitive.Ob
 		}
 
 	}
-	return -99, errors.New("Database is nil")
+	return -99, ErrDBUnavailable
 }
 
 type SpeedMeasAgg struct {
@@ -67,58 +68,63 @@ type SpeedMeasAgg struct {
 	LinkInfo     []Link        `bson:"link"`
 }
 
-func (cm *SpeedtestMongo) QueryAllEnabledSpeedMeas() []*SpeedMeasAgg {
-	if cm.Database != nil {
-		csm := cm.Database.Collection(Colspeedmeas)
-		pipeline := bson.A{
-			bson.D{{"$match", bson.D{{"enabled", true}}}},
-			bson.D{{"$lookup", bson.D{{"from", "speedserver"}, {"localField", "speedserver"}, {"foreignField", "_id"}, {"as", "spserver"}}}},
-			bson.D{{"$lookup", bson.D{{"from", "links"}, {"localField", "link"}, {"foreignField", "_id"}, {"as", "link"}}}},
-		}
-		var allsmeas []*SpeedMeasAgg
-		if cur, err := csm.Aggregate(context.TODO(), pipeline); err == nil {
-			errc := cur.All(context.TODO(), &allsmeas)
-			if errc == nil {
-				return allsmeas
-			}
-		}
+func (cm *SpeedtestMongo) QueryAllEnabledSpeedMeas(ctx context.Context) ([]*SpeedMeasAgg, error) {
+	if cm.Database == nil {
+		return nil, ErrDBUnavailable
 	}
-	return nil
-
+	csm := cm.Database.Collection(Colspeedmeas)
+	pipeline := bson.A{
+		bson.D{{"$match", bson.D{{"enabled", true}}}},
+		bson.D{{"$lookup", bson.D{{"from", "speedserver"}, {"localField", "speedserver"}, {"foreignField", "_id"}, {"as", "spserver"}}}},
+		bson.D{{"$lookup", bson.D{{"from", "links"}, {"localField", "link"}, {"foreignField", "_id"}, {"as", "link"}}}},
+	}
+	cur, err := csm.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("%w: aggregate enabled speedmeas: %v", ErrDBUnavailable, err)
+	}
+	var allsmeas []*SpeedMeasAgg
+	if err := cur.All(ctx, &allsmeas); err != nil {
+		return nil, fmt.Errorf("%w: decode enabled speedmeas: %v", ErrDBUnavailable, err)
+	}
+	return allsmeas, nil
 }
 
-func (cm *SpeedtestMongo) QueryMapSpeedMeas() map[string][]*SpeedMeas {
-	if cm.Database != nil {
-		csm := cm.Database.Collection(Colspeedmeas)
-		filter := bson.D{{}}
-		var allsmeas []*SpeedMeas
-		monreg := regexp.MustCompile(`(\w+-\w+)-\w+`)
-		if cur, err := csm.Find(context.TODO(), filter); err == nil {
-			errc := cur.All(context.TODO(), &allsmeas)
-			if errc == nil {
-				rmap := make(map[string][]*SpeedMeas)
-				for smeasidx, smeas := range allsmeas {
-					monarr := monreg.FindStringSubmatch(smeas.Mon)
-					key := monarr[1] + ":" + smeas.SpeedServer.Hex()
-					if _, rexist := rmap[key]; !rexist {
-						rmap[key] = []*SpeedMeas{allsmeas[smeasidx]}
-					} else {
-						rmap[key] = append(rmap[key], allsmeas[smeasidx])
-					}
-				}
-				return rmap
-			}
+//QueryMapSpeedMeas groups the speedmeas by "<region>:<speedserver hex>",
+//skipping the ones whose mon is not a <provider>-<region>-<n> vm name
+func (cm *SpeedtestMongo) QueryMapSpeedMeas(ctx context.Context) (map[string][]*SpeedMeas, error) {
+	if cm.Database == nil {
+		return nil, ErrDBUnavailable
+	}
+	csm := cm.Database.Collection(Colspeedmeas)
+	filter := bson.D{{}}
+	monreg := regexp.MustCompile(`(\w+-\w+)-\w+`)
+	cur, err := csm.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("%w: find speedmeas: %v", ErrDBUnavailable, err)
+	}
+	var allsmeas []*SpeedMeas
+	if err := cur.All(ctx, &allsmeas); err != nil {
+		return nil, fmt.Errorf("%w: decode speedmeas: %v", ErrDBUnavailable, err)
+	}
+	rmap := make(map[string][]*SpeedMeas)
+	for smeasidx, smeas := range allsmeas {
+		monarr := monreg.FindStringSubmatch(smeas.Mon)
+		if len(monarr) < 2 {
+			log.Println("Skip speedmeas with unexpected mon", smeas.Mon)
+			continue
 		}
+		key := monarr[1] + ":" + smeas.SpeedServer.Hex()
+		rmap[key] = append(rmap[key], allsmeas[smeasidx])
 	}
-	return nil
+	return rmap, nil
 }
 
-func (cm *SpeedtestMongo) UpdateSpeedserver(spmeas *SpeedMeas) error {
+func (cm *SpeedtestMongo) UpdateSpeedserver(ctx context.Context, spmeas *SpeedMeas) error {
 	if cm.Database != nil && spmeas != nil {
 		csm := cm.Database.Collection(Colspeedmeas)
 		opts := options.FindOneAndReplace().SetUpsert(true)
 		filter := bson.D{{"mon", spmeas.Mon}, {"speedserver", spmeas.SpeedServer}, {"link", spmeas.Link}}
-		err := csm.FindOneAndReplace(context.TODO(), filter, spmeas, opts).Err()
+		err := csm.FindOneAndReplace(ctx, filter, spmeas, opts).Err()
 		if err != nil && err != mongo.ErrNoDocuments {
 			return err
 		}
@@ -0,0 +1,53 @@
+package spdb
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//one record per test, keyed by mon, type and file. Loading a file again replaces
+//its record, the count is of the new records
+func (cm *SpeedtestMongo) InsertManySpeedResults(ctx context.Context, results []*SpeedResult) (int, error) {
+	if cm.Database != nil {
+		if len(results) > 0 {
+			csr := cm.Database.Collection(Colspeedresult)
+			//insert others even on failed
+			opts := options.BulkWrite().SetOrdered(false)
+			models := make([]mongo.WriteModel, len(results))
+			for ridx, r := range results {
+				filter := bson.D{{"mon", r.Mon}, {"type", r.Type}, {"file", r.File}}
+				models[ridx] = mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(r).SetUpsert(true)
+			}
+			res, err := csr.BulkWrite(ctx, models, opts)
+			if err != nil {
+				log.Println(err)
+				return 0, err
+			}
+			return int(res.UpsertedCount), nil
+		}
+		return 0, nil
+	}
+	return -1, ErrDBUnavailable
+}
+
+func (cm *SpeedtestMongo) QuerySpeedResultsbyLink(ctx context.Context, linkids []primitive.ObjectID, startts, endts int64) ([]*SpeedResult, error) {
+	if cm.Database != nil {
+		csr := cm.Database.Collection(Colspeedresult)
+		filter := bson.D{{"link", bson.D{{"$in", linkids}}}, {"ts", bson.D{{"$gte", startts}, {"$lt", endts}}}}
+		var results []*SpeedResult
+		cur, err := csr.Find(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		if err = cur.All(ctx, &results); err != nil {
+			return nil, err
+		}
+		return results, nil
+	}
+	return nil, ErrDBUnavailable
+}
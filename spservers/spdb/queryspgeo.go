@@ -0,0 +1,125 @@
+package spdb
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+//ServerFilter narrows the geospatial server queries, a zero field matches every server
+type ServerFilter struct {
+	Types       []string
+	Country     string
+	Asn         string
+	EnabledOnly bool
+}
+
+func (f ServerFilter) bson() bson.D {
+	filter := bson.D{}
+	if len(f.Types) > 0 {
+		filter = append(filter, bson.E{"type", bson.D{{"$in", f.Types}}})
+	}
+	if len(f.Country) > 0 {
+		filter = append(filter, bson.E{"country", f.Country})
+	}
+	if len(f.Asn) > 0 {
+		filter = append(filter, bson.E{"$or", bson.A{bson.D{{"asnv4", f.Asn}}, bson.D{{"asnv6", f.Asn}}}})
+	}
+	if f.EnabledOnly {
+		filter = append(filter, bson.E{"enabled", true})
+	}
+	return filter
+}
+
+func (f ServerFilter) match(s *SpeedServer) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, stype := range f.Types {
+			found = found || stype == s.Type
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Country) > 0 && f.Country != s.Country {
+		return false
+	}
+	if len(f.Asn) > 0 && f.Asn != s.Asnv4 && f.Asn != s.Asnv6 {
+		return false
+	}
+	return !f.EnabledOnly || s.Enabled
+}
+
+//closed ring for a GeoJSON polygon, the first point repeated at the end
+func closeRing(ring [][]float64) [][]float64 {
+	if len(ring) == 0 {
+		return ring
+	}
+	first, last := ring[0], ring[len(ring)-1]
+	if len(first) == 2 && len(last) == 2 && first[0] == last[0] && first[1] == last[1] {
+		return ring
+	}
+	return append(append([][]float64{}, ring...), first)
+}
+
+//servers within radiuskm of point, nearest first, needs the 2dsphere index
+func (cm *SpeedtestMongo) QueryServersNear(ctx context.Context, point JSONPoint, radiuskm float64, filter ServerFilter) ([]SpeedServer, error) {
+	if !point.Valid() {
+		return nil, fmt.Errorf("invalid point %v", point.Coord)
+	}
+	var allservers []SpeedServer
+	near := bson.D{{"$geometry", NewPoint(point.Coord[0], point.Coord[1])}}
+	if radiuskm > 0 {
+		near = append(near, bson.E{"$maxDistance", radiuskm * 1000})
+	}
+	query := append(filter.bson(), bson.E{"location", bson.D{{"$nearSphere", near}}})
+	cursor, err := cm.QueryServersRaw(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(ctx, &allservers)
+	return allservers, err
+}
+
+//servers inside the polygon of ring, a list of [lon, lat]
+func (cm *SpeedtestMongo) QueryServersInPolygon(ctx context.Context, ring [][]float64, filter ServerFilter) ([]SpeedServer, error) {
+	ring = closeRing(ring)
+	if len(ring) < 4 {
+		return nil, fmt.Errorf("polygon needs at least 3 points, got %d", len(ring)-1)
+	}
+	var allservers []SpeedServer
+	polygon := bson.D{{"type", "Polygon"}, {"coordinates", bson.A{ring}}}
+	query := append(filter.bson(), bson.E{"location", bson.D{{"$geoWithin", bson.D{{"$geometry", polygon}}}}})
+	cursor, err := cm.QueryServersRaw(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(ctx, &allservers)
+	return allservers, err
+}
+
+//EnsureGeoIndex creates the 2dsphere index on the server location. Older crawls
+//stored 999,999 for an unknown location, the index rejects those so they are
+//removed first
+func (cm *SpeedtestMongo) EnsureGeoIndex(ctx context.Context) error {
+	if cm.Database != nil {
+		cspeed := cm.Database.Collection(Colserver)
+		invalid := bson.D{{"location", bson.D{{"$exists", true}}}, {"$or", bson.A{
+			bson.D{{"location.coordinates.0", bson.D{{"$not", bson.D{{"$gte", -180}, {"$lte", 180}}}}}},
+			bson.D{{"location.coordinates.1", bson.D{{"$not", bson.D{{"$gte", -90}, {"$lte", 90}}}}}},
+		}}}
+		res, err := cspeed.UpdateMany(ctx, invalid, bson.D{{"$unset", bson.D{{"location", ""}}}})
+		if err != nil {
+			return fmt.Errorf("%w: clear invalid locations: %v", ErrDBUnavailable, err)
+		}
+		if res.ModifiedCount > 0 {
+			log.Println("Cleared", res.ModifiedCount, "invalid server locations")
+		}
+		_, err = cspeed.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{"location", "2dsphere"}}})
+		return err
+	}
+	return ErrDBUnavailable
+}
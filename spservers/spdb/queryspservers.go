@@ -2,7 +2,7 @@ package spdb
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"log"
 	"net"
 
@@ -12,35 +12,37 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func (cm *SpeedtestMongo) ResetEnable(servertype string) {
+func (cm *SpeedtestMongo) ResetEnable(ctx context.Context, servertype string) error {
 	if cm.Database != nil {
 		cspeed := cm.Database.Collection(Colserver)
 		filter := bson.D{{"type", servertype}}
 		update := bson.D{{"$set", bson.D{{"enabled", false}}}}
-		res, err := cspeed.UpdateMany(context.TODO(), filter, update)
+		res, err := cspeed.UpdateMany(ctx, filter, update)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("%w: disable %s servers: %v", ErrDBUnavailable, servertype, err)
 		}
 		if res.MatchedCount > 0 {
 			log.Println("Distabled", res.MatchedCount, servertype, "servers")
 		}
+		return nil
 	}
+	return ErrDBUnavailable
 }
 
-func (cm *SpeedtestMongo) InsertServers(servers []SpeedServer) (int, error) {
+func (cm *SpeedtestMongo) InsertServers(ctx context.Context, servers []SpeedServer) (int, error) {
 	if cm.Database != nil && len(servers) > 0 {
 		nodoc := 0
 		cspeed := cm.Database.Collection(Colserver)
 		opt := options.FindOneAndReplace().SetUpsert(true)
 		for _, ser := range servers {
 			filter := bson.D{{"type", ser.Type}, {"id", ser.Id}}
-			res := cspeed.FindOneAndReplace(context.TODO(), filter, ser, opt)
+			res := cspeed.FindOneAndReplace(ctx, filter, ser, opt)
 			if res.Err() != nil {
 				if res.Err() == mongo.ErrNoDocuments {
 					nodoc++
 					//	log.Println("Server not found", ser.Type, ser.Id)
 				} else {
-					log.Fatal(res.Err())
+					return nodoc, fmt.Errorf("%w: upsert %s server %s: %v", ErrDBUnavailable, ser.Type, ser.Id, res.Err())
 				}
 			}
 		}
@@ -48,63 +50,72 @@ func (cm *SpeedtestMongo) InsertServers(servers []SpeedServer) (int, error) {
 		return nodoc, nil
 	}
 	if cm.Database == nil {
-		return 0, errors.New("Database is nil")
+		return 0, ErrDBUnavailable
 	}
 	return 0, nil
 }
-func (cm *SpeedtestMongo) QueryServersRaw(filters interface{}) (*mongo.Cursor, error) {
+func (cm *SpeedtestMongo) QueryServersRaw(ctx context.Context, filters interface{}) (*mongo.Cursor, error) {
 	if cm.Database != nil {
 		cspeed := cm.Database.Collection(Colserver)
-		return cspeed.Find(context.TODO(), filters)
+		return cspeed.Find(ctx, filters)
 	} else {
-		return nil, errors.New("Database is nil")
+		return nil, ErrDBUnavailable
 	}
 }
 
-func (cm *SpeedtestMongo) QueryServerbyId(sid primitive.ObjectID) (*SpeedServer, error) {
+func (cm *SpeedtestMongo) QueryServerbyId(ctx context.Context, sid primitive.ObjectID) (*SpeedServer, error) {
 	if cm.Database != nil {
 		filter := bson.D{{"_id", sid}}
 		cspeed := cm.Database.Collection(Colserver)
 		var server SpeedServer
-		err := cspeed.FindOne(context.TODO(), filter).Decode(&server)
+		err := cspeed.FindOne(ctx, filter).Decode(&server)
 		if err != nil {
 			return nil, err
 		}
 		return &server, nil
 	}
-	return nil, errors.New("Database is nil")
+	return nil, ErrDBUnavailable
 }
 
-func (cm *SpeedtestMongo) QueryEnabledServersbyType(stype string) ([]SpeedServer, error) {
+func (cm *SpeedtestMongo) QueryEnabledServersbyType(ctx context.Context, stype string) ([]SpeedServer, error) {
 	var allservers []SpeedServer
 	filter := bson.D{{"type", stype}, {"enabled", true}}
-	cursor, err := cm.QueryServersRaw(filter)
-	err = cursor.All(context.TODO(), &allservers)
+	cursor, err := cm.QueryServersRaw(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(ctx, &allservers)
 	return allservers, err
 }
 
-func (cm *SpeedtestMongo) QueryEnabledServers() ([]SpeedServer, error) {
+func (cm *SpeedtestMongo) QueryEnabledServers(ctx context.Context) ([]SpeedServer, error) {
 	var allservers []SpeedServer
 	filter := bson.D{{"enabled", true}}
-	cursor, err := cm.QueryServersRaw(filter)
-	err = cursor.All(context.TODO(), &allservers)
+	cursor, err := cm.QueryServersRaw(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(ctx, &allservers)
 	return allservers, err
 }
 
-func (cm *SpeedtestMongo) QueryServersbyIPv4(serverip net.IP) ([]SpeedServer, error) {
+func (cm *SpeedtestMongo) QueryServersbyIPv4(ctx context.Context, serverip net.IP) ([]SpeedServer, error) {
 	var allservers []SpeedServer
 	filter := bson.D{{"ipv4", serverip.String()}}
-	cursor, err := cm.QueryServersRaw(filter)
-	err = cursor.All(context.TODO(), &allservers)
+	cursor, err := cm.QueryServersRaw(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(ctx, &allservers)
 	return allservers, err
 }
 
-func (cm *SpeedtestMongo) QueryServerbyIdentifier(stype, iden string) (SpeedServer, error) {
+func (cm *SpeedtestMongo) QueryServerbyIdentifier(ctx context.Context, stype, iden string) (SpeedServer, error) {
 	spserver := SpeedServer{}
 	if cm.Database != nil {
 		cspeed := cm.Database.Collection(Colserver)
 		filter := bson.D{{"type", stype}, {"identifier", iden}}
-		err := cspeed.FindOne(context.TODO(), filter).Decode(&spserver)
+		err := cspeed.FindOne(ctx, filter).Decode(&spserver)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				return spserver, err
@@ -112,5 +123,5 @@ func (cm *SpeedtestMongo) QueryServerbyIdentifier(stype, iden string) (SpeedServ
 		}
 		return spserver, nil
 	}
-	return spserver, errors.New("Database is nil")
+	return spserver, ErrDBUnavailable
 }
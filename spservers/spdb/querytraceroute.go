@@ -2,13 +2,13 @@ package spdb
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"log"
+	"math"
 	"sort"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	//	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -22,7 +22,7 @@ type LinkSpAgg struct {
 	LinkObj     []Link               `bson:"links"`
 }
 
-func (cm *SpeedtestMongo) InsertManyTraceroutes(trs []*Traceroute) (int, error) {
+func (cm *SpeedtestMongo) InsertManyTraceroutes(ctx context.Context, trs []*Traceroute) (int, error) {
 	if cm.Database != nil {
 		if len(trs) > 0 {
 			ctr := cm.Database.Collection(Coltraceroute)
@@ -32,7 +32,7 @@ func (cm *SpeedtestMongo) InsertManyTraceroutes(trs []*Traceroute) (int, error)
 			for tridx, _ := range trs {
 				islice[tridx] = trs[tridx]
 			}
-			res, err := ctr.InsertMany(context.TODO(), islice, opts)
+			res, err := ctr.InsertMany(ctx, islice, opts)
 			if err != nil {
 				log.Println(err)
 				return 0, err
@@ -40,10 +40,10 @@ func (cm *SpeedtestMongo) InsertManyTraceroutes(trs []*Traceroute) (int, error)
 			return len(res.InsertedIDs), nil
 		}
 	}
-	return -1, errors.New("Database is nil")
+	return -1, ErrDBUnavailable
 }
 
-func (cm *SpeedtestMongo) QueryLinksSpServerMatch(region string, startts int64, outputch chan *LinkSpAgg) error {
+func (cm *SpeedtestMongo) QueryLinksSpServerMatch(ctx context.Context, region string, startts int64, outputch chan *LinkSpAgg) error {
 	if cm.Database != nil {
 		ctr := cm.Database.Collection(Coltraceroute)
 		pipeline := bson.A{
@@ -72,13 +72,13 @@ func (cm *SpeedtestMongo) QueryLinksSpServerMatch(region string, startts int64,
 				{"as", "links"},
 			}}},
 		}
-		cursor, err := ctr.Aggregate(context.TODO(), pipeline)
+		cursor, err := ctr.Aggregate(ctx, pipeline)
 		if err != nil {
 			log.Println(err)
 			return err
 		}
-		defer cursor.Close(context.TODO())
-		for cursor.Next(context.TODO()) {
+		defer cursor.Close(ctx)
+		for cursor.Next(ctx) {
 			result := &LinkSpAgg{}
 			if err := cursor.Decode(result); err != nil {
 				log.Println("Decode error", err)
@@ -88,14 +88,14 @@ func (cm *SpeedtestMongo) QueryLinksSpServerMatch(region string, startts int64,
 		}
 		return nil
 	}
-	return errors.New("Database is nil")
+	return ErrDBUnavailable
 }
 
-func (cm *SpeedtestMongo) ListRegions() ([]string, error) {
+func (cm *SpeedtestMongo) ListRegions(ctx context.Context) ([]string, error) {
 	if cm.Database != nil {
 		ctr := cm.Database.Collection(Coltraceroute)
 		filter := bson.D{}
-		values, err := ctr.Distinct(context.TODO(), "region", filter)
+		values, err := ctr.Distinct(ctx, "region", filter)
 		if err != nil {
 			log.Println("List region error", err)
 			return nil, err
@@ -106,95 +106,201 @@ func (cm *SpeedtestMongo) ListRegions() ([]string, error) {
 		}
 		return rg, nil
 	}
-	return nil, errors.New("Database is nil")
+	return nil, ErrDBUnavailable
 }
 
-func (cm *SpeedtestMongo) TracerouteDestRtt(trids []primitive.ObjectID) (map[string][]float64, map[string][]primitive.ObjectID, error) {
+//traceroutes of a region towards one server that crossed a link, see LinkServerStats
+type ServerTraceStats struct {
+	SpServerId primitive.ObjectID `bson:"_id"`
+	Traces     int                `bson:"traces"`
+	//traceroutes with more than one hop, the rtts are of their destination
+	RttCount  int     `bson:"rttcount"`
+	MinRtt    float64 `bson:"minrtt"`
+	MedianRtt float64 `bson:"medianrtt"`
+	P90Rtt    float64 `bson:"p90rtt"`
+	//distinct ASes along the traceroutes, the shortest path leaves out the
+	//traceroutes without any AS
+	MinASPathLen int `bson:"minaspathlen"`
+	MaxASPathLen int `bson:"maxaspathlen"`
+}
+
+//setRtts fills the rtt fields of st from the destination rtts, the percentiles
+//are the empirical ones
+func (st *ServerTraceStats) setRtts(rtts []float64) {
+	sort.Float64s(rtts)
+	st.RttCount = len(rtts)
+	if len(rtts) > 0 {
+		st.MinRtt = rtts[0]
+		st.MedianRtt, st.P90Rtt = rttPercentile(rtts, 0.5), rttPercentile(rtts, 0.9)
+	}
+}
+
+//empirical p quantile of the ascending rtts
+func rttPercentile(rtts []float64, p float64) float64 {
+	idx := int(math.Ceil(p*float64(len(rtts)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(rtts) {
+		idx = len(rtts) - 1
+	}
+	return rtts[idx]
+}
+
+//serverTraceAgg is a ServerTraceStats as aggregated by a server without
+//$percentile, with the destination rtts to compute the percentiles from
+type serverTraceAgg struct {
+	ServerTraceStats `bson:",inline"`
+	Rtts             []float64 `bson:"rtts"`
+}
+
+//serverPercentiles tells whether the server has $percentile, from mongo 7.0 on.
+//The version is read once, a failed read falls back to the percentiles of the client
+func (cm *SpeedtestMongo) serverPercentiles(ctx context.Context) bool {
+	cm.versionOnce.Do(func() {
+		var info struct {
+			VersionArray []int32 `bson:"versionArray"`
+		}
+		if err := cm.Database.RunCommand(ctx, bson.D{{"buildInfo", 1}}).Decode(&info); err != nil {
+			log.Println("Query server version failed", err)
+			return
+		}
+		cm.percentiles = len(info.VersionArray) > 0 && info.VersionArray[0] >= 7
+	})
+	return cm.percentiles
+}
+
+//LinkServerStats sums up the traceroutes of region since startts that crossed the
+//link by destination server, ordered by server id. From mongo 7.0 on the server
+//computes the median and p90 rtt, approximated. Older servers return the rtts of
+//every server and the client computes the exact percentiles from them
+func (cm *SpeedtestMongo) LinkServerStats(ctx context.Context, region string, linkid primitive.ObjectID, startts int64) ([]*ServerTraceStats, error) {
 	if cm.Database != nil {
 		ctr := cm.Database.Collection(Coltraceroute)
-		rttmap := make(map[string][]float64)
-		tracemapid := make(map[string][]primitive.ObjectID)
-		for _, trid := range trids {
-			var trdata Traceroute
-			filter := bson.D{{"_id", trid}}
-			err := ctr.FindOne(context.TODO(), filter).Decode(&trdata)
-			if err != nil {
-				if err == mongo.ErrNoDocuments {
-					continue
-				}
-				log.Println("traceroute rtt error", err)
-				return nil, nil, err
-			}
-			if _, sexist := tracemapid[trdata.SpServerId.Hex()]; !sexist {
-				tracemapid[trdata.SpServerId.Hex()] = []primitive.ObjectID{trid}
-			} else {
-				tracemapid[trdata.SpServerId.Hex()] = append(tracemapid[trdata.SpServerId.Hex()], trid)
-			}
-			if len(trdata.Hops) > 1 {
-				sort.Slice(trdata.Hops, func(i, j int) bool {
-					return trdata.Hops[j].ProbeTTL < trdata.Hops[i].ProbeTTL
-				})
-				trspid := trdata.SpServerId.Hex()
-				if _, rexist := rttmap[trspid]; !rexist {
-					rttmap[trspid] = []float64{trdata.Hops[0].Rtt}
-				} else {
-					rttmap[trspid] = append(rttmap[trspid], trdata.Hops[0].Rtt)
-				}
+		hops := bson.D{{"$ifNull", bson.A{"$hops", bson.A{}}}}
+		//the hop with the highest probe ttl is the destination
+		lastrtt := bson.D{{"$let", bson.D{
+			{"vars", bson.D{{"maxttl", bson.D{{"$max", "$hops.probettl"}}}}},
+			{"in", bson.D{{"$arrayElemAt", bson.A{
+				bson.D{{"$map", bson.D{
+					{"input", bson.D{{"$filter", bson.D{{"input", "$hops"}, {"cond", bson.D{{"$eq", bson.A{"$$this.probettl", "$$maxttl"}}}}}}}},
+					{"in", "$$this.rtt"},
+				}}},
+				0,
+			}}}},
+		}}}
+		//null and missing sort before the numbers
+		hasrtt := func(v string) bson.D { return bson.D{{"$gt", bson.A{v, nil}}} }
+		group := bson.D{
+			{"_id", "$spserverid"},
+			{"traces", bson.D{{"$sum", 1}}},
+			{"rttcount", bson.D{{"$sum", bson.D{{"$cond", bson.A{hasrtt("$destrtt"), 1, 0}}}}}},
+			{"minrtt", bson.D{{"$min", "$destrtt"}}},
+			{"minaspathlen", bson.D{{"$min", bson.D{{"$cond", bson.A{bson.D{{"$gt", bson.A{"$aspathlen", 0}}}, "$aspathlen", nil}}}}}},
+			{"maxaspathlen", bson.D{{"$max", "$aspathlen"}}},
+		}
+		project := bson.D{
+			{"traces", 1},
+			{"rttcount", 1},
+			{"minrtt", bson.D{{"$ifNull", bson.A{"$minrtt", 0}}}},
+			{"minaspathlen", bson.D{{"$ifNull", bson.A{"$minaspathlen", 0}}}},
+			{"maxaspathlen", bson.D{{"$ifNull", bson.A{"$maxaspathlen", 0}}}},
+		}
+		serverpct := cm.serverPercentiles(ctx)
+		if serverpct {
+			group = append(group, bson.E{"rttpct", bson.D{{"$percentile", bson.D{{"input", "$destrtt"}, {"p", bson.A{0.5, 0.9}}, {"method", "approximate"}}}}})
+			project = append(project,
+				bson.E{"medianrtt", bson.D{{"$ifNull", bson.A{bson.D{{"$arrayElemAt", bson.A{"$rttpct", 0}}}, 0}}}},
+				bson.E{"p90rtt", bson.D{{"$ifNull", bson.A{bson.D{{"$arrayElemAt", bson.A{"$rttpct", 1}}}, 0}}}})
+		} else {
+			group = append(group, bson.E{"rtts", bson.D{{"$push", "$destrtt"}}})
+			project = append(project, bson.E{"rtts", bson.D{{"$filter", bson.D{{"input", "$rtts"}, {"cond", hasrtt("$$this")}}}}})
+		}
+		pipeline := bson.A{
+			bson.D{{"$match", bson.D{{"region", region}, {"linkid", linkid}, {"ts", bson.D{{"$gte", startts}}}}}},
+			bson.D{{"$project", bson.D{
+				{"spserverid", 1},
+				//traceroutes with less than 2 hops have no destination rtt
+				{"destrtt", bson.D{{"$cond", bson.A{bson.D{{"$gt", bson.A{bson.D{{"$size", hops}}, 1}}}, lastrtt, nil}}}},
+				{"aspathlen", bson.D{{"$size", bson.D{{"$setUnion", bson.A{
+					bson.D{{"$filter", bson.D{{"input", bson.D{{"$ifNull", bson.A{"$hops.asn", bson.A{}}}}}, {"cond", bson.D{{"$ne", bson.A{"$$this", ""}}}}}}},
+					bson.A{},
+				}}}}}},
+			}}},
+			bson.D{{"$group", group}},
+			bson.D{{"$project", project}},
+			bson.D{{"$sort", bson.D{{"_id", 1}}}},
+		}
+		cursor, err := ctr.Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, fmt.Errorf("%w: server stats of link %s: %v", ErrDBUnavailable, linkid.Hex(), err)
+		}
+		var aggs []*serverTraceAgg
+		if err := cursor.All(ctx, &aggs); err != nil {
+			return nil, err
+		}
+		stats := make([]*ServerTraceStats, len(aggs))
+		for aidx, agg := range aggs {
+			if !serverpct {
+				agg.setRtts(agg.Rtts)
 			}
+			stats[aidx] = &agg.ServerTraceStats
 		}
-		return rttmap, tracemapid, nil
+		return stats, nil
 	}
-	return nil, nil, errors.New("Database is nil")
+	return nil, ErrDBUnavailable
 }
 
-func (cm *SpeedtestMongo) TracerouteASPathLen(trids []primitive.ObjectID) ([]int, error) {
-	if cm.Database != nil {
-		ctr := cm.Database.Collection(Coltraceroute)
-		aslen := make([]int, len(trids))
-		for tridx, trid := range trids {
-			var trdata Traceroute
-			filter := bson.D{{"_id", trid}}
-			err := ctr.FindOne(context.TODO(), filter).Decode(&trdata)
-			if err != nil {
-				if err == mongo.ErrNoDocuments {
-					continue
-				}
-				log.Println("traceroute not found", trid, err)
-				return nil, err
-			}
-			asseen := make([]string, 0)
-			for _, hop := range trdata.Hops {
-				if hop.Asn != "" {
-					insertidx := sort.SearchStrings(asseen, hop.Asn)
-					if insertidx == len(asseen) {
-						//does not exist, append to the end
-						asseen = append(asseen, hop.Asn)
-					} else {
-						//does not exist, insert
-						if asseen[insertidx] != hop.Asn {
-							newasseen := make([]string, len(asseen)+1)
-							copy(newasseen, asseen[:insertidx])
-							newasseen[insertidx] = hop.Asn
-							copy(newasseen[insertidx+1:], asseen[insertidx:])
-							asseen = newasseen
-						}
-					}
-				}
-			}
-			aslen[tridx] = len(asseen)
+//rtt of the last hop (highest probe ttl). traceroute with less than 2 hops
+//does not count
+func trDestRtt(trdata *Traceroute) (float64, bool) {
+	if len(trdata.Hops) < 2 {
+		return 0, false
+	}
+	last := trdata.Hops[0]
+	for _, hop := range trdata.Hops[1:] {
+		if hop.ProbeTTL > last.ProbeTTL {
+			last = hop
+		}
+	}
+	return last.Rtt, true
+}
+
+//number of distinct ASes seen along the traceroute
+func trASPathLen(trdata *Traceroute) int {
+	asseen := make(map[string]bool)
+	for _, hop := range trdata.Hops {
+		if hop.Asn != "" {
+			asseen[hop.Asn] = true
 		}
-		return aslen, nil
 	}
-	return nil, errors.New("Database is nil")
+	return len(asseen)
 }
 
-func (cm *SpeedtestMongo) SpServersLinkChoice(region, spidhex string) (int, error) {
+func (cm *SpeedtestMongo) SpServersLinkChoice(ctx context.Context, region, spidhex string) (int, error) {
 	if cm.Database != nil {
 		ctr := cm.Database.Collection(Coltraceroute)
 		spid, _ := primitive.ObjectIDFromHex(spidhex)
 		filter := bson.D{{"region", region}, {"spserverid", spid}}
-		values, err := ctr.Distinct(context.TODO(), "linkid", filter)
+		values, err := ctr.Distinct(ctx, "linkid", filter)
 		return len(values), err
 	}
-	return 0, errors.New("Database is nil")
+	return 0, ErrDBUnavailable
+}
+
+func (cm *SpeedtestMongo) QueryTraceroutesbyLink(ctx context.Context, linkids []primitive.ObjectID, startts, endts int64) ([]*Traceroute, error) {
+	if cm.Database != nil {
+		ctr := cm.Database.Collection(Coltraceroute)
+		filter := bson.D{{"linkid", bson.D{{"$in", linkids}}}, {"ts", bson.D{{"$gte", startts}, {"$lt", endts}}}}
+		var trs []*Traceroute
+		cur, err := ctr.Find(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		if err = cur.All(ctx, &trs); err != nil {
+			return nil, err
+		}
+		return trs, nil
+	}
+	return nil, ErrDBUnavailable
 }
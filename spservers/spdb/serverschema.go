@@ -1,16 +1,41 @@
 package spdb
 
 import (
+	"math"
+	"spservers/geo"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+//GeoJSON point, Coord is [lon, lat]. A zero JSONPoint is an unknown location and
+//is left out of the stored server
 type JSONPoint struct {
 	Type  string    `json:"type"`
 	Coord []float64 `json:"coordinates" bson:"coordinates"`
 }
 
+func NewPoint(lon, lat float64) JSONPoint {
+	return JSONPoint{Type: "Point", Coord: []float64{lon, lat}}
+}
+
+func (p JSONPoint) IsZero() bool {
+	return len(p.Coord) == 0
+}
+
+//Valid tells the point has a longitude and latitude in range
+func (p JSONPoint) Valid() bool {
+	return len(p.Coord) == 2 && geo.Valid(p.Coord[0], p.Coord[1])
+}
+
+//DistanceKm to o, NaN if either point is not valid
+func (p JSONPoint) DistanceKm(o JSONPoint) float64 {
+	if !p.Valid() || !o.Valid() {
+		return math.NaN()
+	}
+	return geo.DistanceKm(p.Coord[0], p.Coord[1], o.Coord[0], o.Coord[1])
+}
+
 type OoklaInfo struct {
 	CountryCode string `json:"cc"`
 	Sponsor     string `json:"sponsor"`
@@ -23,18 +48,50 @@ type MlabInfo struct {
 	Version []string `json:"version"`
 }
 
+//a Netflix Open Connect appliance found through fast.com
+type FastInfo struct {
+	Url       string `json:"url"`
+	ClientASN string `json:"clientasn"`
+	ClientISP string `json:"clientisp"`
+}
+
+//the Cloudflare speed test on one anycast address. Every colo answers on it, so
+//the server has no location and the colo a vm reaches is not known
+type CloudflareInfo struct {
+	Url   string           `json:"url"`
+	Colos []CloudflareColo `json:"colos"`
+}
+
+type CloudflareColo struct {
+	Iata    string  `json:"iata"`
+	City    string  `json:"city"`
+	Country string  `json:"country"`
+	Region  string  `json:"region"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+}
+
+//a server of the public iperf3 server list
+type Iperf3Info struct {
+	Port      string `json:"port"`
+	Speed     string `json:"speed"`
+	Provider  string `json:"provider"`
+	Continent string `json:"continent"`
+}
+
 type SpeedServer struct {
 	SpId        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
 	Type        string             `json:"type"`
 	Id          string             `json:"id"`
 	Identifier  string             `json:"identifier"`
-	Location    JSONPoint          `json:"location"`
+	Location    JSONPoint          `json:"location,omitempty" bson:"location,omitempty"`
 	Country     string             `json:"country"`
 	Host        string             `json:"host"`
 	City        string             `json:"city"`
 	IPv4        string             `json:"ipv4"`
 	IPv6        string             `json:"ipv6"`
 	Asnv4       string             `json:"asn"`
+	Asnv6       string             `json:"asnv6"`
 	Enabled     bool               `json:"enabled"`
 	LastUpdated time.Time          `json:"lastupdated"`
 	Additional  interface{}        `json:"additional"`
@@ -44,6 +101,7 @@ type VMDataStatus struct {
 	Mon        string `json:"mon"`
 	BdrmapFile string `json:"bdrmapfile"`
 	TraceFile  string `json:"trfile"`
+	SpeedFile  string `json:"speedfile"`
 }
 
 //struct for storing interdomain link
@@ -54,12 +112,70 @@ type Link struct {
 	NearIP   string             `json:"nearip"`
 	FarIP    string             `json:"farip"`
 	FarAS    string             `json:"faras"`
-	LastSeen []int64            `json:"lastseen"`
+	NearRtr  string             `json:"nearrtr"`
+	FarRtr   string             `json:"farrtr"`
+	LastSeen []time.Time        `json:"lastseen"`
 	Current  bool               `json:"current"`
 	Covered  bool               `json:"covered"`
 }
 
+//a router inferred by the bdrmap run of a region at ts, Addrs are its aliased interfaces.
+//RouterKey is the lowest address, so the same router keeps its key across runs
+type Router struct {
+	RtrId     primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Region    string             `json:"region"`
+	Ts        int64              `json:"ts"`
+	RouterKey string             `json:"routerkey"`
+	Owner     string             `json:"owner"`
+	Reason    string             `json:"reason"`
+	Addrs     []string           `json:"addrs"`
+}
+
+//a change of a link between two consecutive bdrmap runs of a vm. FirstSeen and LastSeen
+//are the first and last run the link was seen in, PeerChange tells the far AS had no
+//link before the link (re)appeared, or has none left after it disappeared
+type LinkEvent struct {
+	LeId       primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Region     string             `json:"region" bson:"region"`
+	Linkkey    string             `json:"linkkey" bson:"linkkey"`
+	NearIP     string             `json:"nearip" bson:"nearip"`
+	FarIP      string             `json:"farip" bson:"farip"`
+	FarAS      string             `json:"faras" bson:"faras"`
+	Event      string             `json:"event" bson:"event"`
+	Ts         int64              `json:"ts" bson:"ts"`
+	PrevTs     int64              `json:"prevts" bson:"prevts"`
+	FirstSeen  int64              `json:"firstseen" bson:"firstseen"`
+	LastSeen   int64              `json:"lastseen" bson:"lastseen"`
+	PeerChange bool               `json:"peerchange" bson:"peerchange"`
+}
+
+//address, network and place of a speed test server, the part of it a change is about
+type ServerState struct {
+	Host    string `json:"host" bson:"host"`
+	IPv4    string `json:"ipv4" bson:"ipv4"`
+	IPv6    string `json:"ipv6" bson:"ipv6"`
+	Asnv4   string `json:"asn" bson:"asnv4"`
+	Asnv6   string `json:"asnv6" bson:"asnv6"`
+	City    string `json:"city" bson:"city"`
+	Country string `json:"country" bson:"country"`
+}
+
+//a change of a speed test server between two crawls of its platform. SpId is the
+//server in the speedserver collection, zero for servers that were added
+type ServerChange struct {
+	ScId   primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Type   string             `json:"type" bson:"type"`
+	Id     string             `json:"id" bson:"id"`
+	SpId   primitive.ObjectID `json:"speedserver" bson:"speedserver"`
+	Change string             `json:"change" bson:"change"`
+	Ts     int64              `json:"ts" bson:"ts"`
+	PrevTs int64              `json:"prevts" bson:"prevts"`
+	Old    ServerState        `json:"old" bson:"old"`
+	New    ServerState        `json:"new" bson:"new"`
+}
+
 type Traceroute struct {
+	TrId       primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
 	Region     string             `json:"region"`
 	DstIP      string             `json:"dstip"`
 	DstAS      string             `json:"dstas"`
@@ -82,6 +198,7 @@ type TimePeriod struct {
 }
 
 type SpeedMeas struct {
+	SpMeasId     primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
 	Mon          string             `json: "mon" bson:"mon"`
 	SpeedServer  primitive.ObjectID `json:"speedserver" bson:"speedserver"`
 	Link         primitive.ObjectID `json:"link" bson:"link"`
@@ -90,3 +207,119 @@ type SpeedMeas struct {
 	Assigntype   string             `json:"assigntype" bson:"assigntype"`
 	Reason       int                `json:"reason" bson:"reason"`
 }
+
+//result of a single speed test, linked to the assignment that scheduled it
+type SpeedResult struct {
+	ResultId    primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Mon         string             `json:"mon" bson:"mon"`
+	Type        string             `json:"type" bson:"type"`
+	File        string             `json:"file" bson:"file"`
+	Ts          int64              `json:"ts" bson:"ts"`
+	Start       time.Time          `json:"start" bson:"start"`
+	End         time.Time          `json:"end" bson:"end"`
+	SpeedMeas   primitive.ObjectID `json:"speedmeas" bson:"speedmeas"`
+	SpeedServer primitive.ObjectID `json:"speedserver" bson:"speedserver"`
+	Link        primitive.ObjectID `json:"link" bson:"link"`
+	ServerIP    string             `json:"serverip" bson:"serverip"`
+	ServerHost  string             `json:"serverhost" bson:"serverhost"`
+	ReturnCode  int                `json:"returncode" bson:"returncode"`
+	//Mbps
+	Download float64 `json:"download" bson:"download"`
+	Upload   float64 `json:"upload" bson:"upload"`
+	//ms
+	Latency float64     `json:"latency" bson:"latency"`
+	Monitor MonitorMeta `json:"monitor" bson:"monitor"`
+	//why the output of the client could not be parsed, the test has no throughput
+	ParseError string `json:"parseerror,omitempty" bson:"parseerror,omitempty"`
+}
+
+//summary of the metameasurement monitors running during a test
+type MonitorMeta struct {
+	CpuIdleMean   float64 `json:"cpuidlemean" bson:"cpuidlemean"`
+	CpuIdleMin    float64 `json:"cpuidlemin" bson:"cpuidlemin"`
+	MemAvailMean  float64 `json:"memavailmean" bson:"memavailmean"`
+	MemAvailMin   float64 `json:"memavailmin" bson:"memavailmin"`
+	NetstatDrops  int64   `json:"netstatdrops" bson:"netstatdrops"`
+	NetstatErrors int64   `json:"netstaterrors" bson:"netstaterrors"`
+	//tcp rtt reported by ss, ms
+	SsSamples   int     `json:"sssamples" bson:"sssamples"`
+	SsRttMedian float64 `json:"ssrttmedian" bson:"ssrttmedian"`
+	//probes sent by the rtt monitor, ms
+	RttDest   string  `json:"rttdest" bson:"rttdest"`
+	RttProbes int     `json:"rttprobes" bson:"rttprobes"`
+	RttLost   int     `json:"rttlost" bson:"rttlost"`
+	RttMin    float64 `json:"rttmin" bson:"rttmin"`
+	RttMedian float64 `json:"rttmedian" bson:"rttmedian"`
+	RttMean   float64 `json:"rttmean" bson:"rttmean"`
+}
+
+//congestion signals of an interdomain link over one day
+type LinkCongestion struct {
+	LcId   primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Link   primitive.ObjectID `json:"link" bson:"link"`
+	Region string             `json:"region" bson:"region"`
+	FarAS  string             `json:"faras" bson:"faras"`
+	//local midnight of the day
+	Day time.Time `json:"day" bson:"day"`
+	//median download during peak and off-peak hours, Mbps
+	PeakDownload    float64 `json:"peakdownload" bson:"peakdownload"`
+	OffpeakDownload float64 `json:"offpeakdownload" bson:"offpeakdownload"`
+	ThroughputDrop  float64 `json:"throughputdrop" bson:"throughputdrop"`
+	//far minus near hop rtt, ms
+	PeakRttDelta    float64 `json:"peakrttdelta" bson:"peakrttdelta"`
+	OffpeakRttDelta float64 `json:"offpeakrttdelta" bson:"offpeakrttdelta"`
+	RttElevation    float64 `json:"rttelevation" bson:"rttelevation"`
+	SpeedSamples    int     `json:"speedsamples" bson:"speedsamples"`
+	TraceSamples    int     `json:"tracesamples" bson:"tracesamples"`
+	Score           float64 `json:"score" bson:"score"`
+	Congested       bool    `json:"congested" bson:"congested"`
+}
+
+//one change the fleet reconciler made, or failed to make, to the vms of a region
+type FleetAction struct {
+	FaId     primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Region   string             `json:"region" bson:"region"`
+	Provider string             `json:"provider" bson:"provider"`
+	VM       string             `json:"vm" bson:"vm"`
+	VMID     string             `json:"vmid" bson:"vmid"`
+	//create, start, stop, delete change the vm. record and forget only bring vmInfo in line
+	Op     string    `json:"op" bson:"op"`
+	Reason string    `json:"reason" bson:"reason"`
+	Ts     time.Time `json:"ts" bson:"ts"`
+	Err    string    `json:"err,omitempty" bson:"err,omitempty"`
+}
+
+//one execution of a pipeline command, Status is running until the command finishes
+//and then ok, partial when some vms failed, or failed
+type Run struct {
+	RunId   primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Command string             `json:"command" bson:"command"`
+	Host    string             `json:"host" bson:"host"`
+	Args    []string           `json:"args" bson:"args"`
+	//value of every flag of the command, defaults included
+	Flags  map[string]string `json:"flags" bson:"flags"`
+	Start  time.Time         `json:"start" bson:"start"`
+	End    time.Time         `json:"end" bson:"end"`
+	Status string            `json:"status" bson:"status"`
+	Err    string            `json:"err,omitempty" bson:"err,omitempty"`
+	Inputs []RunInput        `json:"inputs" bson:"inputs"`
+	VMs    []RunVM           `json:"vms" bson:"vms"`
+	Counts map[string]int    `json:"counts" bson:"counts"`
+}
+
+//a file read by a run, Kind is e.g. bdrmap, prefix2as, as-rel, sibling, delegation
+type RunInput struct {
+	Kind   string `json:"kind" bson:"kind"`
+	VM     string `json:"vm,omitempty" bson:"vm,omitempty"`
+	Path   string `json:"path" bson:"path"`
+	Size   int64  `json:"size" bson:"size"`
+	SHA256 string `json:"sha256" bson:"sha256"`
+}
+
+//outcome of a run on one vm, or one region for commands working per region
+type RunVM struct {
+	VM     string         `json:"vm" bson:"vm"`
+	Status string         `json:"status" bson:"status"`
+	Err    string         `json:"err,omitempty" bson:"err,omitempty"`
+	Counts map[string]int `json:"counts" bson:"counts"`
+}
@@ -3,8 +3,11 @@ package spdb
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
@@ -15,15 +18,29 @@ const (
 	speedtestmongo = "mongodb://localhost:27017"
 	Colserver      = "speedserver"
 	Collinks       = "links"
+	Colrouters     = "routers"
+	Collinkevents  = "linkevents"
+	Colserverhist  = "serverhistory"
 	Coldatastatus  = "datastatus"
 	Coltraceroute  = "traceroute"
 	Colspeedmeas   = "speedmeas"
+	Colspeedresult = "speedmeasresult"
+	Colcongestion  = "linkcongestion"
+	Colfleetaction = "fleetaction"
+	Colruns        = "runs"
 )
 
+//ErrDBUnavailable is returned, possibly wrapped, when the database is not connected or a
+//query or write to it fails
+var ErrDBUnavailable = errors.New("database unavailable")
+
 type SpeedtestMongo struct {
 	config   *DBConfig
 	Client   *mongo.Client
 	Database *mongo.Database
+	//the server computes percentiles itself, see LinkServerStats
+	versionOnce sync.Once
+	percentiles bool
 }
 
 type DBConfig struct {
@@ -33,27 +50,30 @@ type DBConfig struct {
 	DB       string
 }
 
-func connectmongo(mongopath, dbname string) (*mongo.Client, *mongo.Database) {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+func connectmongo(mongopath, dbname string) (*mongo.Client, *mongo.Database, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongopath))
 	if err != nil {
-		log.Panic(err)
-		return nil, nil
+		return nil, nil, fmt.Errorf("%w: connect: %v", ErrDBUnavailable, err)
 	}
-	err = client.Ping(context.TODO(), nil)
+	err = client.Ping(ctx, nil)
 	if err != nil {
-		log.Panic(err)
-		return nil, nil
+		_ = client.Disconnect(ctx)
+		return nil, nil, fmt.Errorf("%w: ping: %v", ErrDBUnavailable, err)
 	}
 	log.Println("Database connected")
-	return client, client.Database(dbname)
+	return client, client.Database(dbname), nil
 }
 
 func (cm *SpeedtestMongo) Close() {
 	if cm.Client != nil {
-		err := cm.Client.Disconnect(context.TODO())
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := cm.Client.Disconnect(ctx)
 		if err != nil {
-			log.Fatal(err)
+			log.Println("Disconnect database failed", err)
+			return
 		}
 		log.Println("Database connection ends")
 	} else {
@@ -61,24 +81,24 @@ func (cm *SpeedtestMongo) Close() {
 	}
 }
 
-func NewMongoDB(config string, dbname string) *SpeedtestMongo {
+//NewMongoDB connects to the database of the mongo config file. The error wraps
+//ErrDBUnavailable when the server can not be reached
+func NewMongoDB(config string, dbname string) (*SpeedtestMongo, error) {
 	cfile, err := os.Open(config)
-	defer cfile.Close()
 	if err != nil {
-		log.Panic(err)
-		return nil
+		return nil, fmt.Errorf("read mongodb config: %w", err)
 	}
+	defer cfile.Close()
 	c := &SpeedtestMongo{}
 	decoder := json.NewDecoder(cfile)
 	c.config = &DBConfig{}
 	err = decoder.Decode(c.config)
 	if err != nil {
-		log.Panic(err)
-		return nil
+		return nil, fmt.Errorf("decode mongodb config %s: %w", config, err)
 	}
 	mongostr := "mongodb://"
 	if c.config.DB == "" {
-		log.Fatal("no database was selected")
+		return nil, fmt.Errorf("mongodb config %s selects no database", config)
 	}
 	if c.config.Username != "" && c.config.Password != "" {
 		mongostr = mongostr + c.config.Username + ":" + c.config.Password + "@" + c.config.DB
@@ -86,6 +106,9 @@ func NewMongoDB(config string, dbname string) *SpeedtestMongo {
 			mongostr = mongostr + "/?authSource=" + c.config.AuthDB
 		}
 	}
-	c.Client, c.Database = connectmongo(mongostr, dbname)
-	return c
+	c.Client, c.Database, err = connectmongo(mongostr, dbname)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
 }
@@ -0,0 +1,159 @@
+package spdb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore selects the in-memory backend in OpenStore
+const MemoryStore = "memory"
+
+// MigrateTimeout bounds the migration OpenStore runs, backfills of large
+// collections may need clasp db migrate with a longer deadline
+const MigrateTimeout = 10 * time.Minute
+
+// Store is everything the pipeline needs from the speedtest database.
+// SpeedtestMongo is the production backend. MemStore keeps the same data in
+// memory so the pipeline can run in tests or on a laptop without mongo.
+type Store interface {
+	Close()
+
+	//schema
+	Migrate(ctx context.Context, dryrun bool) ([]MigrationStep, error)
+	SchemaVersions(ctx context.Context) (map[string]int, error)
+
+	//speedserver
+	ResetEnable(ctx context.Context, servertype string) error
+	InsertServers(ctx context.Context, servers []SpeedServer) (int, error)
+	QueryServerbyId(ctx context.Context, sid primitive.ObjectID) (*SpeedServer, error)
+	QueryEnabledServersbyType(ctx context.Context, stype string) ([]SpeedServer, error)
+	QueryEnabledServers(ctx context.Context) ([]SpeedServer, error)
+	QueryServersbyIPv4(ctx context.Context, serverip net.IP) ([]SpeedServer, error)
+	QueryServerbyIdentifier(ctx context.Context, stype, iden string) (SpeedServer, error)
+	QueryServersNear(ctx context.Context, point JSONPoint, radiuskm float64, filter ServerFilter) ([]SpeedServer, error)
+	QueryServersInPolygon(ctx context.Context, ring [][]float64, filter ServerFilter) ([]SpeedServer, error)
+	EnsureGeoIndex(ctx context.Context) error
+	InsertServerChanges(ctx context.Context, changes []*ServerChange) (int, error)
+	QueryServerChanges(ctx context.Context, stype string, startts, endts int64) ([]*ServerChange, error)
+
+	//links
+	UpdateLinkstoMongo(ctx context.Context, region string, seents int64, linkmap map[string]*Link) error
+	QueryLinkbyId(ctx context.Context, linkid primitive.ObjectID) (*Link, error)
+	QueryLinkbyKey(ctx context.Context, region string, linkkey string) (*Link, error)
+	QueryLinkbyFar(ctx context.Context, region string, farip string) ([]*Link, error)
+	CreateLinkmap(ctx context.Context, region string) (map[string]*Link, map[string][]*Link, error)
+
+	//linkevents
+	InsertLinkEvents(ctx context.Context, events []*LinkEvent) (int, error)
+	QueryLinkEvents(ctx context.Context, region string, startts, endts int64) ([]*LinkEvent, error)
+
+	//routers
+	InsertRouters(ctx context.Context, region string, ts int64, routers []*Router) (int, error)
+	QueryRouters(ctx context.Context, region string, ts int64) ([]*Router, error)
+
+	//traceroute
+	InsertManyTraceroutes(ctx context.Context, trs []*Traceroute) (int, error)
+	QueryLinksSpServerMatch(ctx context.Context, region string, startts int64, outputch chan *LinkSpAgg) error
+	ListRegions(ctx context.Context) ([]string, error)
+	//median and p90 rtt are computed by mongo 7.0 and later, by the client before
+	LinkServerStats(ctx context.Context, region string, linkid primitive.ObjectID, startts int64) ([]*ServerTraceStats, error)
+	SpServersLinkChoice(ctx context.Context, region, spidhex string) (int, error)
+	QueryTraceroutesbyLink(ctx context.Context, linkids []primitive.ObjectID, startts, endts int64) ([]*Traceroute, error)
+
+	//speedmeas
+	InsertManySpeedMeas(ctx context.Context, spmes []*SpeedMeas) (int, error)
+	QuerySpeedserverExist(ctx context.Context, region string, spid primitive.ObjectID) (int, error)
+	QueryAllEnabledSpeedMeas(ctx context.Context) ([]*SpeedMeasAgg, error)
+	QueryMapSpeedMeas(ctx context.Context) (map[string][]*SpeedMeas, error)
+	UpdateSpeedserver(ctx context.Context, spmeas *SpeedMeas) error
+
+	//speedmeasresult
+	InsertManySpeedResults(ctx context.Context, results []*SpeedResult) (int, error)
+	QuerySpeedResultsbyLink(ctx context.Context, linkids []primitive.ObjectID, startts, endts int64) ([]*SpeedResult, error)
+
+	//linkcongestion
+	UpsertLinkCongestion(ctx context.Context, lcs []*LinkCongestion) (int, error)
+	QueryLinkCongestion(ctx context.Context, region string, start, end time.Time) ([]*LinkCongestion, error)
+
+	//fleetaction
+	InsertFleetActions(ctx context.Context, actions []*FleetAction) (int, error)
+	QueryFleetActions(ctx context.Context, region string, start, end time.Time) ([]*FleetAction, error)
+
+	//runs
+	InsertRun(ctx context.Context, run *Run) (primitive.ObjectID, error)
+	UpdateRun(ctx context.Context, run *Run) error
+	QueryRuns(ctx context.Context, command string, start, end time.Time) ([]*Run, error)
+	QueryRunbyId(ctx context.Context, runid primitive.ObjectID) (*Run, error)
+
+	//datastatus
+	QueryDataStatus(ctx context.Context, mon string) (*VMDataStatus, error)
+	//sets the files of vmstatus that are not empty
+	UpdateDataStatus(ctx context.Context, vmstatus *VMDataStatus) error
+
+	//vminfo
+	UpsertVMs(ctx context.Context, collection string, VMs []VMInfo) error
+	UpdateVMState(ctx context.Context, collection string, state string, instanceID string) error
+	QueryVMByName(ctx context.Context, collection string, name string) (*VMInfo, error)
+	QueryVMs(ctx context.Context, collection string) ([]VMInfo, error)
+	DeleteVM(ctx context.Context, collection string, id string) error
+	ClearVMs(ctx context.Context, collection string, vmtype string) error
+}
+
+var (
+	_ Store = (*SpeedtestMongo)(nil)
+	_ Store = (*MemStore)(nil)
+)
+
+// IsMemoryStore tells if config selects the in-memory backend
+func IsMemoryStore(config string) bool {
+	return config == MemoryStore || strings.HasPrefix(config, MemoryStore+":")
+}
+
+// OpenStore opens the database described by config. "memory" gives an empty
+// in-memory store, "memory:<file>" an in-memory store loaded from and saved
+// back to a JSON snapshot. Anything else is a path to a mongo config file. An
+// unreachable mongo gives an error wrapping ErrDBUnavailable.
+func OpenStore(config string, dbname string) (Store, error) {
+	if IsMemoryStore(config) {
+		snapshot := strings.TrimPrefix(strings.TrimPrefix(config, MemoryStore), ":")
+		mem, err := LoadMemStore(snapshot)
+		if err != nil {
+			return nil, fmt.Errorf("load memory store: %w", err)
+		}
+		return mem, nil
+	}
+	cm, err := NewMongoDB(config, dbname)
+	if err != nil {
+		return nil, err
+	}
+	//a failed migration leaves the database as usable as it was before
+	ctx, cancel := context.WithTimeout(context.Background(), MigrateTimeout)
+	defer cancel()
+	steps, err := cm.Migrate(ctx, false)
+	for _, step := range steps {
+		log.Println("Migrated", step)
+	}
+	if err != nil {
+		log.Println("Migrate database failed", err)
+	}
+	return cm, nil
+}
+
+// OpenStoreNoMigrate opens the store like OpenStore without migrating it, for
+// the commands that inspect or migrate the schema themselves.
+func OpenStoreNoMigrate(config string, dbname string) (Store, error) {
+	if IsMemoryStore(config) {
+		return OpenStore(config, dbname)
+	}
+	cm, err := NewMongoDB(config, dbname)
+	if err != nil {
+		return nil, err
+	}
+	return cm, nil
+}
@@ -2,6 +2,7 @@ package spdb
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"sync"
 
@@ -13,12 +14,6 @@ import (
 
 var wg1 sync.WaitGroup
 
-func handleError(err error) {
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-}
-
 // VMCollection name of the vm collection
 var VMCollection string = "vmInfo"
 
@@ -46,24 +41,27 @@ type VMInfo struct {
 /* Mongo utils */
 
 // InitMongoDB initialize a mongodb client
-func InitMongoDB(mongoConfigFile string) *SpeedtestMongo {
-	db := NewMongoDB(mongoConfigFile, "speedtest")
-	if db.Client == nil {
-		log.Fatal("Connect to mongodb err")
+func InitMongoDB(mongoConfigFile string) (*SpeedtestMongo, error) {
+	db, err := NewMongoDB(mongoConfigFile, "speedtest")
+	if err != nil {
+		return nil, fmt.Errorf("%w: connect to mongodb: %v", ErrDBUnavailable, err)
 	}
-	return db
+	return db, nil
 }
 
-// CreateIndex ensure index for collection
-func CreateIndex(collection mongo.Collection, field string) {
+// CreateIndex ensure a unique index on field for collection
+func CreateIndex(ctx context.Context, collection mongo.Collection, field string) error {
 	_, err := collection.Indexes().CreateOne(
-		context.Background(),
+		ctx,
 		mongo.IndexModel{
 			Keys:    bsonx.Doc{{field, bsonx.Int32(1)}},
 			Options: options.Index().SetUnique(true),
 		},
 	)
-	handleError(err)
+	if err != nil {
+		return fmt.Errorf("%w: create index %s on %s: %v", ErrDBUnavailable, field, collection.Name(), err)
+	}
+	return nil
 }
 
 // GetCollection get collection by name, if not exists, create one
@@ -72,86 +70,153 @@ func GetCollection(cm *SpeedtestMongo, collection string) *mongo.Collection {
 }
 
 // InsertInstanceIDName insert id and name of vm as a record to db (for aws ec2 instances)
-func InsertInstanceIDName(cm *SpeedtestMongo, VMs []VMInfo, collection string) {
-	if cm.Database != nil && len(VMs) > 0 {
-		collection := GetCollection(cm, collection)
-
-		// insert all VM in {name, instanceID} format
-		interfaceSlice := make([]interface{}, len(VMs))
-		for i, d := range VMs {
-			interfaceSlice[i] = d
-		}
-
-		opt := options.Update().SetUpsert(true)
-		for _, ele := range VMs {
-			wg1.Add(1)
-			go func(ele VMInfo) {
-				defer wg1.Done()
-				update := bson.D{{"$set", bson.D{
-					{"type", ele.Type},
-					{"id", ele.ID},
-					{"name", ele.Name},
-					{"ipv4", ele.Ipv4},
-					{"dns", ele.DNS},
-					{"zone", ele.Zone},
-					{"status", ele.Status},
-				}}}
-				filter := bson.D{{"id", ele.ID}}
-				res, err := collection.UpdateOne(context.Background(), filter, update, opt)
-				handleError(err)
-				log.Println("inserted ids:", res.UpsertedID)
-				log.Println("Updated count:", res.ModifiedCount)
-			}(ele)
-		}
-		wg1.Wait()
-	}
+func InsertInstanceIDName(ctx context.Context, cm Store, VMs []VMInfo, collection string) error {
+	return cm.UpsertVMs(ctx, collection, VMs)
 }
 
 // UpdateState update state of vm
-func UpdateState(cm *SpeedtestMongo, collection string, state string, instanceID string) {
-	if cm.Database != nil {
-		collection := GetCollection(cm, collection)
-
-		// insert all VM in {name, instanceID} format
-		opt := options.Update()
-		update := bson.D{{"$set", bson.D{
-			{"status", state},
-		}}}
-		filter := bson.D{{"id", instanceID}}
-		res, err := collection.UpdateOne(context.Background(), filter, update, opt)
-		handleError(err)
-		log.Println("update count:", res.ModifiedCount)
-	}
+func UpdateState(ctx context.Context, cm Store, collection string, state string, instanceID string) error {
+	return cm.UpdateVMState(ctx, collection, state, instanceID)
 }
 
 // QueryVMIDByName query VM by its name, returning its id (for vm instances)
-func QueryVMIDByName(cm *SpeedtestMongo, name string) string {
-	// create a value into which the result can be decoded
-	var id string
+func QueryVMIDByName(ctx context.Context, cm Store, name string) (string, error) {
+	vm, err := cm.QueryVMByName(ctx, VMCollection, name)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Found a single document: %+v\n", vm.ID)
+	return vm.ID, nil
+}
+
+// DeleteVMByID delete an vm by id
+func DeleteVMByID(ctx context.Context, cm Store, collectionName, id string) (string, error) {
+	if err := cm.DeleteVM(ctx, collectionName, id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// UpsertVMs insert or update vms, keyed by instance id
+func (cm *SpeedtestMongo) UpsertVMs(ctx context.Context, collection string, VMs []VMInfo) error {
+	if cm.Database == nil {
+		return ErrDBUnavailable
+	}
+	if len(VMs) == 0 {
+		return nil
+	}
+	vmcol := GetCollection(cm, collection)
+	opt := options.Update().SetUpsert(true)
+	errch := make(chan error, len(VMs))
+	for _, ele := range VMs {
+		wg1.Add(1)
+		go func(ele VMInfo) {
+			defer wg1.Done()
+			update := bson.D{{"$set", bson.D{
+				{"type", ele.Type},
+				{"id", ele.ID},
+				{"name", ele.Name},
+				{"ipv4", ele.Ipv4},
+				{"dns", ele.DNS},
+				{"zone", ele.Zone},
+				{"status", ele.Status},
+			}}}
+			filter := bson.D{{"id", ele.ID}}
+			res, err := vmcol.UpdateOne(ctx, filter, update, opt)
+			if err != nil {
+				errch <- err
+				return
+			}
+			log.Println("inserted ids:", res.UpsertedID)
+			log.Println("Updated count:", res.ModifiedCount)
+		}(ele)
+	}
+	wg1.Wait()
+	close(errch)
+	return <-errch
+}
+
+// UpdateVMState update the status field of a vm
+func (cm *SpeedtestMongo) UpdateVMState(ctx context.Context, collection string, state string, instanceID string) error {
+	if cm.Database == nil {
+		return ErrDBUnavailable
+	}
+	vmcol := GetCollection(cm, collection)
+	update := bson.D{{"$set", bson.D{
+		{"status", state},
+	}}}
+	filter := bson.D{{"id", instanceID}}
+	res, err := vmcol.UpdateOne(ctx, filter, update, options.Update())
+	if err != nil {
+		return err
+	}
+	log.Println("update count:", res.ModifiedCount)
+	return nil
+}
 
-	collection := cm.Database.Collection(VMCollection)
+// QueryVMByName find a vm by its name
+func (cm *SpeedtestMongo) QueryVMByName(ctx context.Context, collection string, name string) (*VMInfo, error) {
+	if cm.Database == nil {
+		return nil, ErrDBUnavailable
+	}
+	var vm VMInfo
 	filter := bson.D{{"name", name}}
-	err := collection.FindOne(context.TODO(), filter).Decode(&id)
-	handleError(err)
+	err := GetCollection(cm, collection).FindOne(ctx, filter).Decode(&vm)
+	if err != nil {
+		return nil, err
+	}
+	return &vm, nil
+}
 
-	log.Printf("Found a single document: %+v\n", id)
-	return id
+// QueryVMs list all vms in the collection
+func (cm *SpeedtestMongo) QueryVMs(ctx context.Context, collection string) ([]VMInfo, error) {
+	if cm.Database == nil {
+		return nil, ErrDBUnavailable
+	}
+	var vms []VMInfo
+	cur, err := GetCollection(cm, collection).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	err = cur.All(ctx, &vms)
+	return vms, err
 }
 
-// DeleteVMByID delete an vm by id
-func DeleteVMByID(cm *SpeedtestMongo, collectionName, id string) string {
-	collection := cm.Database.Collection(collectionName)
+// DeleteVM delete a vm by instance id
+func (cm *SpeedtestMongo) DeleteVM(ctx context.Context, collection string, id string) error {
+	if cm.Database == nil {
+		return ErrDBUnavailable
+	}
 	filter := bson.D{{"id", id}}
-	res, err := collection.DeleteOne(context.TODO(), filter)
-	handleError(err)
+	res, err := GetCollection(cm, collection).DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
 	log.Println("Successfully deleted", res.DeletedCount, "documents")
-	return id
+	return nil
+}
+
+// ClearVMs delete all vms of a provider type
+func (cm *SpeedtestMongo) ClearVMs(ctx context.Context, collection string, vmtype string) error {
+	if cm.Database == nil {
+		return ErrDBUnavailable
+	}
+	res, err := GetCollection(cm, collection).DeleteMany(ctx, bson.D{{"type", vmtype}})
+	if err != nil {
+		return err
+	}
+	log.Println("Delete Result: ", res.DeletedCount)
+	return nil
 }
 
 // ClearCollection delete things inside a collection matching filter
-func ClearCollection(collection mongo.Collection, filter bson.D) {
+func ClearCollection(ctx context.Context, collection mongo.Collection, filter bson.D) error {
 	//filter := bson.D{{}}
-	res, err := collection.DeleteMany(context.Background(), filter)
-	handleError(err)
+	res, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("%w: clear %s: %v", ErrDBUnavailable, collection.Name(), err)
+	}
 	log.Println("Delete Result: ", res.DeletedCount)
+	return nil
 }